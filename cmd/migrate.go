@@ -0,0 +1,149 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-kit/kit/log"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/cobra"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/migration"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/service"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/utils/logging"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+)
+
+// migrateOptions are the flags of the migrate command
+type migrateOptions struct {
+	space, org     string
+	from, to       string
+	checkpointPath string
+	dryRun         bool
+	cutOver        bool
+}
+
+var migrateFlags migrateOptions
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "migrate key material between keystore backends",
+	Long: `Copies every key of a space in the ID translation table from one keystore backend to another. Keys keep
+their KP ID. The source is left as it was, and keeps serving the keys. A line of JSON is written for each key. Keys
+recorded in the checkpoint are skipped, so a failed migration is resumed by running it again. Once every key is
+migrated, route the space to the target backend and run again with --cut-over, which updates the translation rows
+to name the target. Without --space, every space of the org with keys is migrated in turn, and is cut over only if
+every space is routed to the target. Both backends are called without the token of a user, so they must
+authenticate as the service.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		database, err := db.NewDBInstance()
+		if err != nil {
+			return err
+		}
+		return runMigrate(migrateFlags, database, os.Stdout)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+
+	migrateCmd.Flags().StringVar(&migrateFlags.space, "space", "", "Bluemix space whose keys are migrated, every space of the org if empty")
+	migrateCmd.Flags().StringVar(&migrateFlags.org, "org", "", "Bluemix org of the space")
+	migrateCmd.Flags().StringVar(&migrateFlags.from, "from", keystore.Barbican, "Keystore backend the keys are read from")
+	migrateCmd.Flags().StringVar(&migrateFlags.to, "to", "", "Keystore backend the keys are written to")
+	migrateCmd.Flags().StringVar(&migrateFlags.checkpointPath, "checkpoint", "", "File recording migrated keys, to resume a migration from")
+	migrateCmd.Flags().BoolVar(&migrateFlags.dryRun, "dry-run", false, "Read every key from the source without writing anything")
+	migrateCmd.Flags().BoolVar(&migrateFlags.cutOver, "cut-over", false, "Update the translation rows of migrated keys to name the target, once the space is routed to it")
+}
+
+func runMigrate(options migrateOptions, database db.DB, report io.Writer) error {
+	if options.org == "" || options.to == "" {
+		return errors.New("--org and --to are required")
+	}
+	if options.from == options.to {
+		return errors.New("--from and --to must name different backends")
+	}
+	for _, backend := range []string{options.from, options.to} {
+		if err := keystore.CheckServiceAuth(backend); err != nil {
+			return err
+		}
+	}
+
+	spaces := []string{options.space}
+	if options.space == "" {
+		var err error
+		if spaces, err = database.ListSpaces(options.org); err != nil {
+			return err
+		}
+	}
+
+	if options.cutOver {
+		for _, space := range spaces {
+			if service.Backend(space, options.org) != options.to {
+				return errors.New("Space " + space + " must be routed to " + options.to + " before it is cut over")
+			}
+		}
+	}
+
+	logger := log.With(logging.GlobalLogger(), "component", "migrate")
+
+	var checkpoint *migration.Checkpoint
+	if options.checkpointPath != "" && !options.dryRun {
+		var err error
+		checkpoint, err = migration.OpenCheckpoint(options.checkpointPath)
+		if err != nil {
+			return err
+		}
+		defer checkpoint.Close()
+	}
+
+	// Spaces are migrated in turn, stopping at the first that fails, so the run is resumed from it
+	for _, space := range spaces {
+		if err := migrateSpace(options, space, database, checkpoint, logger, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSpace migrates, or cuts over, the keys of a space of the org. The checkpoint may be nil.
+func migrateSpace(options migrateOptions, space string, database db.DB, checkpoint *migration.Checkpoint, logger log.Logger, report io.Writer) error {
+	headers := &communications.Headers{
+		BluemixSpace:  space,
+		BluemixOrg:    options.org,
+		CorrelationID: uuid.NewV4().String(),
+	}
+
+	source, err := keystore.NewKeystore(options.from, headers, logger)
+	if err != nil {
+		return err
+	}
+
+	target, err := keystore.NewKeystore(options.to, headers, logger)
+	if err != nil {
+		return err
+	}
+
+	migrator, err := migration.NewMigrator(source, target, options.to, database, logger)
+	if err != nil {
+		return err
+	}
+	migrator.DryRun = options.dryRun
+
+	var summary migration.Summary
+	if options.cutOver {
+		summary, err = migrator.CutOver(context.Background(), space, options.org, report)
+	} else {
+		summary, err = migrator.Run(context.Background(), space, options.org, checkpoint, report)
+	}
+	logger.Log("space", space, "from", options.from, "to", options.to, "dry_run", options.dryRun, "cut_over", options.cutOver, "summary", fmt.Sprint(summary))
+	return err
+}
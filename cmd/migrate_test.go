@@ -0,0 +1,147 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/migration"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+// Backends registered by the tests, which keep the versions of keys in memory by space
+const (
+	testMigrateSource = "test-migrate-source"
+	testMigrateTarget = "test-migrate-target"
+)
+
+var testMigrateKeys = map[string]map[string]map[string][]string{
+	testMigrateSource: {},
+	testMigrateTarget: {},
+}
+
+func init() {
+	for _, name := range []string{testMigrateSource, testMigrateTarget} {
+		keys := testMigrateKeys[name]
+		keystore.Register(name, keystore.Backend{
+			NewKeystore: func(auth *communications.Headers, logger log.Logger) (definitions.Keystore, error) {
+				if keys[auth.BluemixSpace] == nil {
+					keys[auth.BluemixSpace] = make(map[string][]string)
+				}
+				return &testMigrateKeystore{keys: keys[auth.BluemixSpace]}, nil
+			},
+			NewPolicyDB: func() (db.PolicyDB, error) { return db.NewMemoryDB(), nil },
+		})
+	}
+}
+
+// testMigrateKeystore holds the versions of the keys of a space
+type testMigrateKeystore struct {
+	definitions.Keystore
+	keys map[string][]string
+}
+
+func (s *testMigrateKeystore) GetPayloadVersion(ctx context.Context, id string, version int) (string, int, secrets.KeyStates, error) {
+	versions := s.keys[id]
+	if version == definitions.CurrentVersion {
+		version = len(versions)
+	}
+	if version < 1 || version > len(versions) {
+		return "", version, secrets.Destroyed, errors.New(http.StatusText(http.StatusNotFound) + ": Key not found")
+	}
+	return versions[version-1], version, secrets.Activation, nil
+}
+
+func (s *testMigrateKeystore) ImportVersion(ctx context.Context, id string, version int, secret *secrets.Secret, tx *transactions.Transaction) error {
+	s.keys[id] = append(s.keys[id], secret.Payload)
+	return nil
+}
+
+func TestMigrateFlags(t *testing.T) {
+	invalid := []migrateOptions{
+		{from: keystore.Mock, to: keystore.Local},
+		{space: "test-space", from: keystore.Mock, to: keystore.Local},
+		{space: "test-space", org: "test-org", from: keystore.Mock},
+		{space: "test-space", org: "test-org", from: keystore.Mock, to: keystore.Mock},
+		{space: "test-space", org: "test-org", from: keystore.Mock, to: "unregistered"},
+		// Barbican forwards the token of a user by default, which the command does not have
+		{space: "test-space", org: "test-org", from: keystore.Barbican, to: keystore.Mock},
+		// the space is not routed to the target
+		{space: "test-space", org: "test-org", from: keystore.Mock, to: keystore.Local, cutOver: true},
+	}
+
+	for _, options := range invalid {
+		if err := runMigrate(options, nil, new(bytes.Buffer)); err == nil {
+			t.Errorf("Expected Error for %+v", options)
+		}
+	}
+}
+
+func TestMigrateCMD(t *testing.T) {
+	for _, flag := range []string{"space", "org", "from", "to", "checkpoint", "dry-run", "cut-over"} {
+		if migrateCmd.Flags().Lookup(flag) == nil {
+			t.Errorf("Expected the --%s flag", flag)
+		}
+	}
+}
+
+func TestMigrateOrg(t *testing.T) {
+	database := db.NewMemoryDB()
+	source := testMigrateKeys[testMigrateSource]
+	for space, id := range map[string]string{"space-a": "key-a", "space-b": "key-b", "space-other": "key-other"} {
+		org := "test-org"
+		if space == "space-other" {
+			org = "test-other-org"
+		}
+		if err := database.Add(space, org, &db.BarbicanRefs{KpID: id, SecretID: "secret-" + id}); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		source[space] = map[string][]string{id: {"payload-" + id}}
+	}
+
+	// Without a space, every space of the org is migrated
+	report := new(bytes.Buffer)
+	if err := runMigrate(migrateOptions{org: "test-org", from: testMigrateSource, to: testMigrateTarget}, database, report); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	decoder := json.NewDecoder(report)
+	for _, expected := range []migration.Result{
+		{Space: "space-a", KpID: "key-a", Status: migration.StatusMigrated, Versions: 1},
+		{Space: "space-b", KpID: "key-b", Status: migration.StatusMigrated, Versions: 1},
+	} {
+		var result migration.Result
+		if err := decoder.Decode(&result); err != nil || result != expected {
+			t.Errorf("Expected %+v, received %+v, %+v", expected, result, err)
+		}
+	}
+	if decoder.More() {
+		t.Error("Expected only the keys of the org to be migrated")
+	}
+
+	target := testMigrateKeys[testMigrateTarget]
+	if target["space-a"]["key-a"][0] != "payload-key-a" || target["space-b"]["key-b"][0] != "payload-key-b" {
+		t.Errorf("Expected the keys in the target, received %v", target)
+	}
+	if len(target["space-other"]) != 0 {
+		t.Errorf("Expected the keys of other orgs to be left alone, received %v", target["space-other"])
+	}
+
+	// Bad Path: the spaces of the org are not routed to the target
+	options := migrateOptions{org: "test-org", from: testMigrateSource, to: testMigrateTarget, cutOver: true}
+	if err := runMigrate(options, database, new(bytes.Buffer)); err == nil {
+		t.Error("Expected an error cutting over spaces that are not routed to the target")
+	}
+}
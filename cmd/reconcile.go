@@ -0,0 +1,99 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+
+	"github.ibm.com/Alchemy-Key-Protect/go-db-service/services/metadata/client"
+	dbDef "github.ibm.com/Alchemy-Key-Protect/go-db-service/services/metadata/service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/reconciliation"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/utils/logging"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+)
+
+// reconcileOptions are the flags of the reconcile command
+type reconcileOptions struct {
+	space, org string
+	backend    string
+	dryRun     bool
+}
+
+var reconcileFlags reconcileOptions
+
+// reconcileCmd represents the reconcile command
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "rebuild key metadata from the keystore",
+	Long: `Restores the metadata of every key of a space in the ID translation table that is missing from the metadata
+database, from the copy mirrored in the keystore backend next to the key material. Keys with metadata are left as
+they are, so a failed reconciliation is resumed by running it again. A line of JSON is written for each key. The
+backend is called without the token of a user, so it must authenticate as the service.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		database, err := db.NewDBInstance()
+		if err != nil {
+			return err
+		}
+
+		dbServerPath := config.GetString("dbService.ipv4_address") + ":" + config.GetString("dbService.port")
+		conn, err := grpc.Dial(dbServerPath, grpc.WithInsecure(), grpc.WithTimeout(time.Second*time.Duration(config.GetInt("timeouts.grpcTimeout"))))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		return runReconcile(reconcileFlags, database, client.NewClient(conn, log.NewNopLogger()), os.Stdout)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reconcileCmd)
+
+	reconcileCmd.Flags().StringVar(&reconcileFlags.space, "space", "", "Bluemix space whose keys are reconciled")
+	reconcileCmd.Flags().StringVar(&reconcileFlags.org, "org", "", "Bluemix org of the space")
+	reconcileCmd.Flags().StringVar(&reconcileFlags.backend, "backend", keystore.Barbican, "Keystore backend the metadata is mirrored in")
+	reconcileCmd.Flags().BoolVar(&reconcileFlags.dryRun, "dry-run", false, "Read the mirrored metadata of every missing key without writing anything")
+}
+
+func runReconcile(options reconcileOptions, database db.DB, metadata dbDef.Service, report io.Writer) error {
+	if options.space == "" || options.org == "" {
+		return errors.New("--space and --org are required")
+	}
+	if err := keystore.CheckServiceAuth(options.backend); err != nil {
+		return err
+	}
+
+	logger := log.With(logging.GlobalLogger(), "component", "reconcile")
+	headers := &communications.Headers{
+		BluemixSpace:  options.space,
+		BluemixOrg:    options.org,
+		CorrelationID: uuid.NewV4().String(),
+	}
+
+	mirror, err := keystore.NewKeystore(options.backend, headers, logger)
+	if err != nil {
+		return err
+	}
+
+	reconciler, err := reconciliation.NewReconciler(mirror, database, metadata, headers, logger)
+	if err != nil {
+		return err
+	}
+	reconciler.DryRun = options.dryRun
+
+	summary, err := reconciler.Run(context.Background(), options.space, options.org, report)
+	logger.Log("space", options.space, "backend", options.backend, "dry_run", options.dryRun, "summary", fmt.Sprint(summary))
+	return err
+}
@@ -0,0 +1,36 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+)
+
+func TestReconcileFlags(t *testing.T) {
+	invalid := []reconcileOptions{
+		{org: "test-org", backend: keystore.Mock},
+		{space: "test-space", backend: keystore.Mock},
+		{space: "test-space", org: "test-org", backend: "unregistered"},
+		// Barbican forwards the token of a user by default, which the command does not have
+		{space: "test-space", org: "test-org", backend: keystore.Barbican},
+		// the mock keystore mirrors no metadata
+		{space: "test-space", org: "test-org", backend: keystore.Mock},
+	}
+
+	for _, options := range invalid {
+		if err := runReconcile(options, nil, nil, new(bytes.Buffer)); err == nil {
+			t.Errorf("Expected Error for %+v", options)
+		}
+	}
+}
+
+func TestReconcileCMD(t *testing.T) {
+	for _, flag := range []string{"space", "org", "backend", "dry-run"} {
+		if reconcileCmd.Flags().Lookup(flag) == nil {
+			t.Errorf("Expected the --%s flag", flag)
+		}
+	}
+}
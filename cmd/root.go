@@ -10,6 +10,7 @@ import (
 	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/service"
@@ -163,6 +164,22 @@ func getTracerAndCollector(logger log.Logger) (stdopentracing.Tracer, zipkin.Col
 	return tracer, collector, nil
 }
 
+// serviceAuthChecker reports an error if a background job cannot call every keystore without the token of a user
+type serviceAuthChecker interface {
+	CheckServiceAuth() error
+}
+
+// warnOrderPolling logs a warning if the order poller cannot poll every keystore keys are routed to, and reports
+// whether it did. Keys it cannot poll stay in Preactivation until their next Get or List.
+func warnOrderPolling(logger log.Logger, orders serviceAuthChecker) bool {
+	errAuth := orders.CheckServiceAuth()
+	if errAuth == nil {
+		return false
+	}
+	logger.Log("err", errAuth, "msg", "Order poller has no service credentials, so keys generated in keystores requiring the token of a user stay in Preactivation until their next Get or List")
+	return true
+}
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "key-management-core",
@@ -187,11 +204,34 @@ var rootCmd = &cobra.Command{
 
 		errc := make(chan error, 2)
 
-		keyService := service.NewBasicService()
+		// Generated keys are activated in the background. A poll interval of zero disables the poller, which cannot
+		// poll keystores that can only be called with the token of a user either.
+		orders := service.NewOrderPoller(log.With(logger, "component", "orders"), time.Second*time.Duration(config.GetInt("orders.pollInterval")))
+		warnOrderPolling(rootLogger, orders)
+		orders.Start()
+		defer orders.Stop()
+
+		keyService := service.NewBasicService(orders)
 		keyService = service.NewLoggingService(log.With(logger, "component", "secrets", "caller", log.DefaultCaller), keyService)
 		keyService = setAnalyticsService(keyService)
 		keyService = service.NewInstrumentingService(keyService)
 
+		// Keys with a rotation policy are rotated in the background. A check interval of zero disables the scheduler,
+		// and so do keystores that can only be called with the token of a user.
+		if rotationInterval := time.Minute * time.Duration(config.GetInt("rotation.checkInterval")); rotationInterval > 0 {
+			if errAuth := service.CheckServiceAuth(); errAuth != nil {
+				rootLogger.Log("err", errAuth, "msg", "Rotation scheduler not started, as it has no service credentials")
+			} else {
+				scheduler, errScheduler := service.NewRotationScheduler(log.With(logger, "component", "rotation"), rotationInterval)
+				if errScheduler != nil {
+					rootLogger.Log("err", errScheduler)
+					panic("cannot build rotation scheduler")
+				}
+				scheduler.Start()
+				defer scheduler.Stop()
+			}
+		}
+
 		httpLogger := log.With(logger, "transport", "http")
 
 		mux := http.NewServeMux()
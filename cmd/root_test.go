@@ -3,9 +3,14 @@
 package cmd
 
 import (
+	"bytes"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-kit/kit/log"
+
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/service/tester"
 )
 
@@ -47,6 +52,32 @@ func TestSetAnalyticsService(t *testing.T) {
 	}
 }
 
+// testServiceAuth reports err as the keystores of a background job
+type testServiceAuth struct {
+	err error
+}
+
+func (c testServiceAuth) CheckServiceAuth() error {
+	return c.err
+}
+
+func TestWarnOrderPolling(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	logger := log.NewLogfmtLogger(buffer)
+
+	if warnOrderPolling(logger, testServiceAuth{}) || buffer.Len() != 0 {
+		t.Errorf("Expected no warning, received %s", buffer)
+	}
+
+	// Bad Path: keys routed to Barbican forwarding the token of the user cannot be polled
+	if !warnOrderPolling(logger, testServiceAuth{errors.New("Internal Server Error: Barbican requires the token of a user")}) {
+		t.Error("Expected a warning")
+	}
+	if !strings.Contains(buffer.String(), "Barbican requires the token of a user") {
+		t.Errorf("Expected the error to be logged, received %s", buffer)
+	}
+}
+
 func TestSetVersion(t *testing.T) {
 	SetVersion("", "")
 	if mainSemver != "0.0.0" {
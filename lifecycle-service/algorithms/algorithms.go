@@ -0,0 +1,187 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package algorithms
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+// Keys of the AlgorithmMetadata of a secret that set how its key is generated
+const (
+	ModeKey      = "mode"
+	BitLengthKey = "bitLength"
+)
+
+// Algorithms of keys generated by a keystore, as recorded in the AlgorithmType of a key
+const (
+	AES        = "AES"
+	HMACSHA256 = "HMAC-SHA256"
+	HMACSHA384 = "HMAC-SHA384"
+	HMACSHA512 = "HMAC-SHA512"
+)
+
+// Spec is how a key is generated once the defaults of its algorithm are applied
+type Spec struct {
+	// Algorithm is the name recorded in the metadata of the key, OrderAlgorithm the name keystores order it by
+	Algorithm      string
+	OrderAlgorithm string
+	BitLength      int
+	// Mode is empty for algorithms that have none, such as HMAC
+	Mode string
+}
+
+// allowed lists the bit lengths and modes supported for an algorithm, along with its defaults
+type allowed struct {
+	orderAlgorithm   string
+	bitLengths       []int
+	modes            []string
+	defaultBitLength int
+	defaultMode      string
+}
+
+var supported = map[string]allowed{
+	AES: {
+		orderAlgorithm:   "aes",
+		bitLengths:       []int{128, 192, 256},
+		modes:            []string{"CBC", "CTR", "GCM"},
+		defaultBitLength: 256,
+		defaultMode:      "GCM",
+	},
+	HMACSHA256: {orderAlgorithm: "hmacsha256", bitLengths: []int{256}, defaultBitLength: 256},
+	HMACSHA384: {orderAlgorithm: "hmacsha384", bitLengths: []int{384}, defaultBitLength: 384},
+	HMACSHA512: {orderAlgorithm: "hmacsha512", bitLengths: []int{512}, defaultBitLength: 512},
+}
+
+// Resolve validates the algorithm, mode and bit length requested for a new key against the allow-list and fills in
+// the defaults of any that are missing. No algorithm type resolves to AES-256-GCM.
+func Resolve(algorithmType string, metadata map[string]string) (*Spec, error) {
+	algorithm := strings.ToUpper(algorithmType)
+	if algorithm == "" {
+		algorithm = AES
+	}
+
+	allow, ok := supported[algorithm]
+	if !ok {
+		return nil, fmt.Errorf(http.StatusText(http.StatusBadRequest)+": Algorithm %s is not supported. Supported algorithms are %s",
+			algorithmType, strings.Join(names(), ", "))
+	}
+
+	spec := &Spec{Algorithm: algorithm, OrderAlgorithm: allow.orderAlgorithm, BitLength: allow.defaultBitLength, Mode: allow.defaultMode}
+
+	if value, ok := metadata[BitLengthKey]; ok {
+		bitLength, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.New(http.StatusText(http.StatusBadRequest) + ": Algorithm metadata bitLength must be a number")
+		}
+		if !containsInt(allow.bitLengths, bitLength) {
+			return nil, fmt.Errorf(http.StatusText(http.StatusBadRequest)+": %s keys must have a bitLength of %s",
+				algorithm, joinInts(allow.bitLengths))
+		}
+		spec.BitLength = bitLength
+	}
+
+	if value, ok := metadata[ModeKey]; ok {
+		mode := strings.ToUpper(value)
+		if len(allow.modes) == 0 {
+			return nil, fmt.Errorf(http.StatusText(http.StatusBadRequest)+": %s keys have no mode", algorithm)
+		}
+		if !containsString(allow.modes, mode) {
+			return nil, fmt.Errorf(http.StatusText(http.StatusBadRequest)+": %s keys must have a mode of %s",
+				algorithm, strings.Join(allow.modes, ", "))
+		}
+		spec.Mode = mode
+	}
+
+	return spec, nil
+}
+
+// ResolveSecret resolves the algorithm requested for a new key, and records it with its defaults in the secret so
+// that they are stored in the metadata table
+func ResolveSecret(secret *secrets.Secret) (*Spec, error) {
+	spec, err := Resolve(secret.AlgorithmType, secret.AlgorithmMetadata)
+	if err != nil {
+		return nil, err
+	}
+	spec.Apply(secret)
+	return spec, nil
+}
+
+// Generate returns random key material of the resolved bit length, base64 encoded as Barbican does for generated
+// keys. Keystores that cannot generate keys of the algorithm themselves generate them with it.
+func (spec *Spec) Generate() (string, error) {
+	material := make([]byte, spec.BitLength/8)
+	if _, err := rand.Read(material); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(material), nil
+}
+
+// Apply records the resolved algorithm in the secret, so it is stored along with the metadata of the key
+func (spec *Spec) Apply(secret *secrets.Secret) {
+	if secret.AlgorithmMetadata == nil {
+		secret.AlgorithmMetadata = make(map[string]string)
+	}
+
+	secret.AlgorithmType = spec.Algorithm
+	secret.AlgorithmMetadata[BitLengthKey] = strconv.Itoa(spec.BitLength)
+	if spec.Mode != "" {
+		secret.AlgorithmMetadata[ModeKey] = spec.Mode
+	}
+}
+
+// WrapsKeys reports whether a key with the algorithm and metadata can wrap, unwrap and rewrap keys, and so be
+// rotated. Keys are always wrapped with AES-GCM, so only AES keys of the GCM mode can. Keys without an algorithm or
+// a mode were created before they were recorded, when every key was AES-256-GCM.
+func WrapsKeys(algorithmType string, metadata map[string]string) bool {
+	algorithm := strings.ToUpper(algorithmType)
+	if algorithm != "" && algorithm != AES {
+		return false
+	}
+
+	mode, ok := metadata[ModeKey]
+	return !ok || strings.ToUpper(mode) == supported[AES].defaultMode
+}
+
+func names() []string {
+	algorithms := make([]string, 0, len(supported))
+	for name := range supported {
+		algorithms = append(algorithms, name)
+	}
+	sort.Strings(algorithms)
+	return algorithms
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func joinInts(values []int) string {
+	joined := make([]string, len(values))
+	for i, v := range values {
+		joined[i] = strconv.Itoa(v)
+	}
+	return strings.Join(joined, ", ")
+}
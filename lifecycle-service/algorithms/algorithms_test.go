@@ -0,0 +1,132 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package algorithms
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		algorithmType string
+		metadata      map[string]string
+		expected      Spec
+	}{
+		{expected: Spec{Algorithm: AES, OrderAlgorithm: "aes", BitLength: 256, Mode: "GCM"}},
+		{algorithmType: "aes", metadata: map[string]string{BitLengthKey: "192"}, expected: Spec{Algorithm: AES, OrderAlgorithm: "aes", BitLength: 192, Mode: "GCM"}},
+		{algorithmType: AES, metadata: map[string]string{ModeKey: "ctr", "label": "ignored"}, expected: Spec{Algorithm: AES, OrderAlgorithm: "aes", BitLength: 256, Mode: "CTR"}},
+		{algorithmType: "hmac-sha512", expected: Spec{Algorithm: HMACSHA512, OrderAlgorithm: "hmacsha512", BitLength: 512}},
+	}
+
+	for _, test := range tests {
+		spec, err := Resolve(test.algorithmType, test.metadata)
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+
+		if *spec != test.expected {
+			t.Errorf("Expected %+v, received %+v", test.expected, *spec)
+		}
+	}
+}
+
+func TestResolveUnsupported(t *testing.T) {
+	tests := []struct {
+		algorithmType string
+		metadata      map[string]string
+	}{
+		{algorithmType: "DES"},
+		{algorithmType: AES, metadata: map[string]string{BitLengthKey: "512"}},
+		{algorithmType: AES, metadata: map[string]string{BitLengthKey: "large"}},
+		{algorithmType: AES, metadata: map[string]string{ModeKey: "ECB"}},
+		{algorithmType: HMACSHA256, metadata: map[string]string{ModeKey: "GCM"}},
+		{algorithmType: HMACSHA256, metadata: map[string]string{BitLengthKey: "128"}},
+	}
+
+	for _, test := range tests {
+		if _, err := Resolve(test.algorithmType, test.metadata); err == nil || !strings.HasPrefix(err.Error(), http.StatusText(http.StatusBadRequest)) {
+			t.Errorf("%s %+v: Expected a bad request, received %+v", test.algorithmType, test.metadata, err)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	spec, err := Resolve(HMACSHA256, nil)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	secret := secrets.NewSecret()
+	spec.Apply(secret)
+
+	if secret.AlgorithmType != HMACSHA256 || secret.AlgorithmMetadata[BitLengthKey] != "256" {
+		t.Errorf("Expected %s with a bitLength of 256, received %s %+v", HMACSHA256, secret.AlgorithmType, secret.AlgorithmMetadata)
+	}
+
+	if _, ok := secret.AlgorithmMetadata[ModeKey]; ok {
+		t.Errorf("Expected no mode, received %+v", secret.AlgorithmMetadata)
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	secret := secrets.NewSecret()
+	secret.AlgorithmMetadata = map[string]string{BitLengthKey: "128"}
+
+	spec, err := ResolveSecret(secret)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if spec.BitLength != 128 || secret.AlgorithmType != AES || secret.AlgorithmMetadata[ModeKey] != "GCM" {
+		t.Errorf("Expected AES-128-GCM to be recorded, received %+v, %s %+v", spec, secret.AlgorithmType, secret.AlgorithmMetadata)
+	}
+
+	// Bad Path: unsupported algorithms are not recorded
+	secret = secrets.NewSecret()
+	secret.AlgorithmType = "DES"
+	if _, err := ResolveSecret(secret); err == nil || secret.AlgorithmType != "DES" {
+		t.Errorf("Expected an error leaving the secret as it was, received %+v, %s", err, secret.AlgorithmType)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	spec, _ := Resolve(HMACSHA384, nil)
+
+	payload, err := spec.Generate()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if material, err := base64.StdEncoding.DecodeString(payload); err != nil || len(material) != 48 {
+		t.Errorf("Expected 48 bytes of material, received %d, %+v", len(material), err)
+	}
+
+	if other, _ := spec.Generate(); other == payload {
+		t.Error("Expected material to differ between keys")
+	}
+}
+
+func TestWrapsKeys(t *testing.T) {
+	tests := []struct {
+		algorithmType string
+		metadata      map[string]string
+		expected      bool
+	}{
+		{expected: true},
+		{algorithmType: AES, metadata: map[string]string{BitLengthKey: "128"}, expected: true},
+		{algorithmType: "aes", metadata: map[string]string{ModeKey: "gcm"}, expected: true},
+		{algorithmType: AES, metadata: map[string]string{ModeKey: "CBC"}},
+		{algorithmType: AES, metadata: map[string]string{ModeKey: "CTR"}},
+		{algorithmType: HMACSHA256},
+		{algorithmType: "RSA"},
+	}
+
+	for _, test := range tests {
+		if WrapsKeys(test.algorithmType, test.metadata) != test.expected {
+			t.Errorf("%s %v: Expected %t", test.algorithmType, test.metadata, test.expected)
+		}
+	}
+}
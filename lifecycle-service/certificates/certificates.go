@@ -0,0 +1,145 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+// Package certificates parses the PEM bundles of certificate secrets. A bundle holds a certificate, the private key
+// of the certificate, and optionally the chain of intermediate certificates that issued it.
+package certificates
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// SecretType is the type of secrets whose payload is a certificate bundle
+const SecretType = "certificate"
+
+// PEM block types of the parts of a bundle
+const (
+	CertificateBlock   = "CERTIFICATE"
+	PrivateKeyBlock    = "PRIVATE KEY"
+	RSAPrivateKeyBlock = "RSA PRIVATE KEY"
+	ECPrivateKeyBlock  = "EC PRIVATE KEY"
+)
+
+var (
+	// ErrInvalidBundle is returned when the payload of a certificate secret is not a PEM bundle
+	ErrInvalidBundle = errors.New(http.StatusText(http.StatusBadRequest) + ": Certificate payload must be a PEM certificate and private key, optionally followed by the chain")
+
+	// ErrKeyMismatch is returned when the private key of a bundle is not the key of its certificate
+	ErrKeyMismatch = errors.New(http.StatusText(http.StatusBadRequest) + ": Private key does not match the certificate")
+
+	// ErrEncryptedKey is returned when the private key of a bundle is encrypted, as no passphrase is kept
+	ErrEncryptedKey = errors.New(http.StatusText(http.StatusBadRequest) + ": Private key must not be encrypted")
+)
+
+// Bundle is the PEM encoded parts of a certificate secret
+type Bundle struct {
+	Certificate string
+	PrivateKey  string
+	// Intermediates is the chain of the certificate, from its issuer up. It is empty if the chain was not given.
+	Intermediates string
+}
+
+// IsCertificate reports whether secrets of the type hold a certificate bundle
+func IsCertificate(secretType string) bool {
+	return strings.ToLower(secretType) == SecretType
+}
+
+// Parse returns the bundle of the payload, which holds PEM blocks in any order. The first certificate is the
+// certificate of the bundle, and any other certificates its chain.
+func Parse(payload string) (*Bundle, error) {
+	var certificates []*pem.Block
+	var privateKey *pem.Block
+
+	rest := []byte(payload)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case CertificateBlock:
+			certificates = append(certificates, block)
+		case PrivateKeyBlock, RSAPrivateKeyBlock, ECPrivateKeyBlock:
+			if privateKey != nil {
+				return nil, ErrInvalidBundle
+			}
+			if _, encrypted := block.Headers["Proc-Type"]; encrypted {
+				return nil, ErrEncryptedKey
+			}
+			privateKey = block
+		default:
+			return nil, ErrInvalidBundle
+		}
+	}
+
+	if len(bytes.TrimSpace(rest)) != 0 || len(certificates) == 0 || privateKey == nil {
+		return nil, ErrInvalidBundle
+	}
+
+	if err := checkKeyPair(certificates[0], privateKey); err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{
+		Certificate: string(pem.EncodeToMemory(certificates[0])),
+		PrivateKey:  string(pem.EncodeToMemory(privateKey)),
+	}
+	for _, intermediate := range certificates[1:] {
+		if _, err := x509.ParseCertificate(intermediate.Bytes); err != nil {
+			return nil, ErrInvalidBundle
+		}
+		bundle.Intermediates += string(pem.EncodeToMemory(intermediate))
+	}
+	return bundle, nil
+}
+
+// checkKeyPair ensures the private key is the key of the certificate
+func checkKeyPair(certificateBlock, privateKeyBlock *pem.Block) error {
+	certificate, err := x509.ParseCertificate(certificateBlock.Bytes)
+	if err != nil {
+		return ErrInvalidBundle
+	}
+
+	privateKey, err := parsePrivateKey(privateKeyBlock)
+	if err != nil {
+		return ErrInvalidBundle
+	}
+
+	switch public := certificate.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if private, ok := privateKey.(*rsa.PrivateKey); ok && private.PublicKey.N.Cmp(public.N) == 0 && private.PublicKey.E == public.E {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if private, ok := privateKey.(*ecdsa.PrivateKey); ok && private.PublicKey.X.Cmp(public.X) == 0 && private.PublicKey.Y.Cmp(public.Y) == 0 {
+			return nil
+		}
+	default:
+		return ErrInvalidBundle
+	}
+	return ErrKeyMismatch
+}
+
+func parsePrivateKey(block *pem.Block) (interface{}, error) {
+	switch block.Type {
+	case RSAPrivateKeyBlock:
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case ECPrivateKeyBlock:
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+}
+
+// String returns the PEM bundle of the certificate, its chain and its private key, in the order TLS servers load them
+func (bundle *Bundle) String() string {
+	return bundle.Certificate + bundle.Intermediates + bundle.PrivateKey
+}
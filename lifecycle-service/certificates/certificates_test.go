@@ -0,0 +1,95 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package certificates
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCertificate returns a self-signed PEM certificate and its PEM private key
+func newTestCertificate(t *testing.T, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: CertificateBlock, Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: ECPrivateKeyBlock, Bytes: keyDER}))
+}
+
+func TestParse(t *testing.T) {
+	certificate, privateKey := newTestCertificate(t, "test.example.com")
+	intermediate, _ := newTestCertificate(t, "Test Intermediate CA")
+
+	// The parts may come in any order
+	bundle, err := Parse(privateKey + "\n" + certificate + intermediate)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if bundle.Certificate != certificate || bundle.PrivateKey != privateKey || bundle.Intermediates != intermediate {
+		t.Errorf("Expected the parts of the bundle, received %+v", bundle)
+	}
+	if bundle.String() != certificate+intermediate+privateKey {
+		t.Errorf("Expected the certificate, chain and key, received %s", bundle)
+	}
+
+	// The chain is optional
+	bundle, err = Parse(certificate + privateKey)
+	if err != nil || bundle.Intermediates != "" {
+		t.Errorf("Expected no chain, received %+v, %+v", bundle, err)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	certificate, privateKey := newTestCertificate(t, "test.example.com")
+	_, otherKey := newTestCertificate(t, "other.example.com")
+	encrypted := strings.Replace(privateKey, "-----\n", "-----\nProc-Type: 4,ENCRYPTED\nDEK-Info: AES-256-CBC,00000000000000000000000000000000\n\n", 1)
+
+	invalid := map[string]error{
+		"":                                    ErrInvalidBundle,
+		"not a bundle":                        ErrInvalidBundle,
+		certificate:                           ErrInvalidBundle,
+		privateKey:                            ErrInvalidBundle,
+		certificate + privateKey + otherKey:   ErrInvalidBundle,
+		certificate + privateKey + "trailing": ErrInvalidBundle,
+		certificate + otherKey:                ErrKeyMismatch,
+		certificate + encrypted:               ErrEncryptedKey,
+	}
+
+	for payload, expected := range invalid {
+		if _, err := Parse(payload); err != expected {
+			t.Errorf("Expected %s, received %+v", expected, err)
+		}
+	}
+}
+
+func TestIsCertificate(t *testing.T) {
+	if !IsCertificate("Certificate") || IsCertificate("key") {
+		t.Error("Expected only certificate secrets to hold bundles")
+	}
+}
@@ -3,14 +3,97 @@
 package definitions
 
 import (
+	"context"
+	"errors"
+	"net/http"
+
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
 )
 
+// CurrentVersion requests the latest active version of a key's material from GetPayloadVersion
+const CurrentVersion = 0
+
+var (
+	// ErrVersionNotFound notifies callers when the requested version of a secret does not exist
+	ErrVersionNotFound = errors.New(http.StatusText(http.StatusNotFound) + ": Unable to find secret version")
+
+	// ErrVersionConflict notifies callers when an imported version does not follow the latest version of the secret
+	ErrVersionConflict = errors.New(http.StatusText(http.StatusConflict) + ": Imported version must follow the latest version of the secret")
+
+	// ErrNoPayload notifies callers when a version is imported without key material
+	ErrNoPayload = errors.New(http.StatusText(http.StatusBadRequest) + ": Imported versions require a payload")
+
+	// ErrMetadataIncomplete notifies callers when the mirrored metadata of a key lacks fields it cannot be restored without
+	ErrMetadataIncomplete = errors.New(http.StatusText(http.StatusConflict) + ": Mirrored metadata lacks the algorithm, extractability or state of the key")
+)
+
+// BluemixOrgSpace returns the org and space of the request a keystore was created for, which every key it holds
+// is scoped to
+func BluemixOrgSpace(headers *communications.Headers) (org, space string, err error) {
+	if headers == nil {
+		err = errors.New(http.StatusText(http.StatusBadRequest) + ": Headers required")
+		return
+	}
+
+	space = headers.BluemixSpace
+	if space == "" {
+		err = errors.New(http.StatusText(http.StatusBadRequest) + ": Header Bluemix-Space required")
+		return
+	}
+
+	org = headers.BluemixOrg
+	if org == "" {
+		err = errors.New(http.StatusText(http.StatusBadRequest) + ": Header Bluemix-Org required")
+		return
+	}
+
+	return
+}
+
 // Keystore is the interface for all keystore plugins
 type Keystore interface {
-	GetPayload(keyprotectID string) (string, secrets.KeyStates, error)
-	CheckSecret(keyprotectID string) (secrets.KeyStates, error)
-	CreateSecret(secret *secrets.Secret, createTx *transactions.Transaction) (string, error)
-	DeleteSecret(keyprotectID string, createTx *transactions.Transaction) error
+	GetPayload(ctx context.Context, keyprotectID string) (string, secrets.KeyStates, error)
+	GetPayloadVersion(ctx context.Context, keyprotectID string, version int) (string, int, secrets.KeyStates, error)
+	CheckSecret(ctx context.Context, keyprotectID string) (secrets.KeyStates, error)
+	CreateSecret(ctx context.Context, secret *secrets.Secret, createTx *transactions.Transaction) (string, error)
+	RotateSecret(ctx context.Context, keyprotectID string, secret *secrets.Secret, rotateTx *transactions.Transaction) (int, error)
+	DeleteSecret(ctx context.Context, keyprotectID string, createTx *transactions.Transaction) error
+}
+
+// KeyWrapper is implemented by keystores whose root key material cannot leave them, so wrap and unwrap run inside
+// the keystore instead of the service. Ciphertexts are opaque to the service, but must record the key version.
+type KeyWrapper interface {
+	WrapKey(ctx context.Context, keyprotectID string, plaintext, aad []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, keyprotectID string, ciphertext, aad []byte) ([]byte, error)
+}
+
+// KeyImporter is implemented by keystores that can hold a key under the ID it has in another keystore, so keys can
+// be moved between keystores without changing their ID. The payload of the secret is the material of the version.
+// Version 1 creates the key, and every later version must follow the latest version the keystore holds.
+type KeyImporter interface {
+	ImportVersion(ctx context.Context, keyprotectID string, version int, secret *secrets.Secret, importTx *transactions.Transaction) error
+}
+
+// PayloadRotator is implemented by keystores that can rotate a key to material generated by the service rather than
+// by themselves, so the new version can be copied to another keystore as soon as the key is rotated. The payload is
+// the base64 encoded material of the new version, which the keystore numbers as RotateSecret would.
+type PayloadRotator interface {
+	RotateSecretPayload(ctx context.Context, keyprotectID string, secret *secrets.Secret, payload string, rotateTx *transactions.Transaction) (int, error)
+}
+
+// AsyncGenerator is implemented by keystores that generate keys by placing an order, so the keys they generate and
+// the versions they add by rotation have no material until the order completes
+type AsyncGenerator interface {
+	GeneratesAsync() bool
+}
+
+// MetadataMirror is implemented by keystores that keep a copy of the metadata of every key, so the metadata
+// database can be rebuilt from the keystore if it is lost. Only the fields the keystore is configured to mirror
+// are kept, and GetMetadata returns a key with only those fields set. The algorithm, extractability and state of
+// every key are always mirrored, and GetMetadata returns ErrMetadataIncomplete for keys mirrored without them.
+type MetadataMirror interface {
+	PutMetadata(ctx context.Context, keyprotectID string, secret *secrets.Secret) error
+	GetMetadata(ctx context.Context, keyprotectID string) (*secrets.Secret, error)
 }
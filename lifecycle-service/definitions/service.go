@@ -12,6 +12,8 @@ import (
 // Service is the main interface for Secret Service
 type Service interface {
 	Post(context.Context, *communications.SecretRequest) (*communications.SecretsResponse, error)
+	Import(context.Context, *communications.SecretRequest) (*communications.SecretsResponse, error)
+	ImportToken(context.Context, *communications.BaseRequest) (*corecomms.ImportTokenResponse, error)
 	Actions(context.Context, *corecomms.SecretActionRequest) (*corecomms.SecretActionResponse, error)
 	Get(context.Context, *communications.IDRequest) (*communications.SecretsResponse, error)
 	Head(context.Context, *communications.BaseRequest) (*communications.NumberResponse, error)
@@ -6,10 +6,12 @@ import "github.com/go-kit/kit/endpoint"
 
 // Endpoints contains all endpoints to enable factory
 type Endpoints struct {
-	PostEndpoint    endpoint.Endpoint
-	ActionsEndpoint endpoint.Endpoint
-	GetEndpoint     endpoint.Endpoint
-	ListEndpoint    endpoint.Endpoint
-	HeadEndpoint    endpoint.Endpoint
-	DeleteEndpoint  endpoint.Endpoint
+	PostEndpoint        endpoint.Endpoint
+	ImportEndpoint      endpoint.Endpoint
+	ImportTokenEndpoint endpoint.Endpoint
+	ActionsEndpoint     endpoint.Endpoint
+	GetEndpoint         endpoint.Endpoint
+	ListEndpoint        endpoint.Endpoint
+	HeadEndpoint        endpoint.Endpoint
+	DeleteEndpoint      endpoint.Endpoint
 }
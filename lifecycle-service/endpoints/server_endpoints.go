@@ -27,6 +27,26 @@ func MakePostEndpoint(svc definitions.Service) endpoint.Endpoint {
 	}
 }
 
+// MakeImportEndpoint generates an Endpoint for the creation of a key from material encrypted under an import token
+func MakeImportEndpoint(svc definitions.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		if req, ok := request.(*communications.SecretRequest); ok {
+			return svc.Import(ctx, req)
+		}
+		return nil, fmt.Errorf("Requires type *communications.SecretRequest, received %T", request)
+	}
+}
+
+// MakeImportTokenEndpoint generates an Endpoint for the creation of an import token
+func MakeImportTokenEndpoint(svc definitions.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		if req, ok := request.(*communications.BaseRequest); ok {
+			return svc.ImportToken(ctx, req)
+		}
+		return nil, fmt.Errorf("Requires type *communications.BaseRequest, received %T", request)
+	}
+}
+
 // MakeActionsEndpoint generates an Endpoint for actions by a secrets using the post methods
 func MakeActionsEndpoint(svc definitions.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
@@ -40,6 +40,50 @@ func TestPostEndpoint(t *testing.T) {
 	}
 }
 
+func TestImportEndpoint(t *testing.T) {
+	ctx := context.Background()
+
+	endpoint := MakeImportEndpoint(service)
+
+	if endpoint == nil {
+		t.Errorf("ImportEndpoint is not defined")
+	}
+
+	req := communications.NewSecretRequest()
+	_, errEndpoint := endpoint(ctx, req)
+	if errEndpoint != nil {
+		t.Errorf("ImportEndpoint is not defined")
+	}
+
+	badReq := communications.NewIDRequest()
+	_, errEndpoint = endpoint(ctx, badReq)
+	if errEndpoint == nil {
+		t.Error("Expected Error")
+	}
+}
+
+func TestImportTokenEndpoint(t *testing.T) {
+	ctx := context.Background()
+
+	endpoint := MakeImportTokenEndpoint(service)
+
+	if endpoint == nil {
+		t.Errorf("ImportTokenEndpoint is not defined")
+	}
+
+	req := communications.NewBaseRequest()
+	_, errEndpoint := endpoint(ctx, req)
+	if errEndpoint != nil {
+		t.Errorf("ImportTokenEndpoint is not defined")
+	}
+
+	badReq := communications.NewIDRequest()
+	_, errEndpoint = endpoint(ctx, badReq)
+	if errEndpoint == nil {
+		t.Error("Expected Error")
+	}
+}
+
 func TestActionsEndpoint(t *testing.T) {
 	ctx := context.Background()
 
@@ -0,0 +1,249 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package importtoken
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keywrap"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-config"
+)
+
+const (
+	// EncryptionAlgorithm is the algorithm clients must use to encrypt key material under the public key of a token
+	EncryptionAlgorithm = "RSAES_OAEP_SHA_256"
+
+	// SealKeyEnv holds the base64 encoded seal key, and takes precedence over the seal key file
+	SealKeyEnv = "KP_IMPORT_TOKEN_SEAL_KEY"
+
+	// SealKeyLength is the length in bytes of the AES-256 key that private keys are sealed under
+	SealKeyLength = 32
+
+	// sealKeyVersion is recorded in sealed private keys, so the seal key can be rotated later
+	sealKeyVersion = 1
+
+	// createAttempts bounds how often Create starts over after another replica added a token for the space first
+	createAttempts = 3
+)
+
+var (
+	// ErrTokenNotFound is returned when the space has no import token, or it has expired or been used
+	ErrTokenNotFound = errors.New(http.StatusText(http.StatusNotFound) + ": No valid import token found for the space. Please create a new import token")
+
+	// ErrInvalidPayload is returned when the payload was not encrypted under the public key of the space's token
+	ErrInvalidPayload = errors.New(http.StatusText(http.StatusBadRequest) + ": Unable to decrypt payload with the import token")
+
+	// ErrSealKey notifies callers when the seal key is missing or is not a base64 encoded 256 bit key
+	ErrSealKey = errors.New(http.StatusText(http.StatusInternalServerError) + ": Unable to load the seal key of import tokens")
+
+	// ErrTokenConflict is returned when the token of the space kept changing while it was created
+	ErrTokenConflict = errors.New(http.StatusText(http.StatusConflict) + ": The import token of the space is being replaced. Please try again")
+
+	sealKeyLock sync.Mutex
+	sealKey     []byte
+)
+
+// Token is the public half of an import keypair
+type Token struct {
+	// PublicKey is the DER encoded PKIX public key
+	PublicKey      []byte
+	ExpirationDate time.Time
+}
+
+// Store holds at most one import keypair per space, in the database shared by every replica of the service.
+// Private keys are sealed under the seal key before they are stored, so they never leave the service in the clear.
+// A keypair opens a single payload, and is discarded once it has or it expires.
+type Store struct {
+	open     func() (db.ImportTokenDB, error)
+	key      func() ([]byte, error)
+	lifetime time.Duration
+	bits     int
+}
+
+// NewStore returns a Store keeping keypairs in the database returned by open, sealed under the key returned by key.
+// Keypairs are RSA keys of the given size which expire after lifetime.
+func NewStore(open func() (db.ImportTokenDB, error), key func() ([]byte, error), lifetime time.Duration, bits int) *Store {
+	return &Store{
+		open:     open,
+		key:      key,
+		lifetime: lifetime,
+		bits:     bits,
+	}
+}
+
+// LoadSealKey reads the seal key from the environment or else from the file set in the configuration.
+// The key is kept once it is read, but a key that fails to be read is read again by the next call.
+func LoadSealKey() ([]byte, error) {
+	sealKeyLock.Lock()
+	defer sealKeyLock.Unlock()
+
+	if sealKey == nil {
+		key, err := readSealKey()
+		if err != nil {
+			return nil, err
+		}
+		sealKey = key
+	}
+	return sealKey, nil
+}
+
+func readSealKey() ([]byte, error) {
+	encoded, ok := os.LookupEnv(SealKeyEnv)
+	if !ok {
+		file := configuration.Get().GetString("importToken.sealKeyFile")
+		if file == "" {
+			return nil, ErrSealKey
+		}
+
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, ErrSealKey
+		}
+		encoded = string(contents)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != SealKeyLength {
+		return nil, ErrSealKey
+	}
+	return key, nil
+}
+
+// Create returns the token of the space, generating a keypair only if the space has no valid one.
+// Replicas creating a token for the same space at once all return the keypair that was stored first.
+func (s *Store) Create(space, org string) (*Token, error) {
+	tokens, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < createAttempts; attempt++ {
+		existing, err := tokens.GetImportToken(space, org)
+		switch {
+		case err == nil && time.Now().Before(existing.Expiration):
+			return &Token{PublicKey: existing.PublicKey, ExpirationDate: existing.Expiration}, nil
+		case err == nil:
+			if _, err := tokens.ClaimImportToken(space, org, existing.ID); err != nil {
+				return nil, err
+			}
+		case err != db.ErrNotFound:
+			return nil, err
+		}
+
+		token, err := s.generate(space, org)
+		if err != nil {
+			return nil, err
+		}
+
+		added, err := tokens.AddImportToken(token)
+		if err != nil {
+			return nil, err
+		}
+		if added {
+			return &Token{PublicKey: token.PublicKey, ExpirationDate: token.Expiration}, nil
+		}
+	}
+	return nil, ErrTokenConflict
+}
+
+// generate creates a keypair for the space, sealing its private key to the token
+func (s *Store) generate(space, org string) (*db.ImportToken, error) {
+	sealKey, err := s.key()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, s.bits)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &db.ImportToken{
+		Space:     space,
+		Org:       org,
+		ID:        uuid.NewV4().String(),
+		PublicKey: publicKey,
+		// expirations are stored in seconds
+		Expiration: time.Now().UTC().Add(s.lifetime).Truncate(time.Second),
+	}
+
+	token.SealedPrivateKey, err = keywrap.Wrap(sealKey, sealKeyVersion, x509.MarshalPKCS1PrivateKey(key), sealedData(token))
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Decrypt opens key material that was encrypted with RSA-OAEP under the public key of the space's token.
+// The token is claimed before the payload is decrypted, so it opens a single payload even when imports race,
+// whether or not the payload decrypts.
+func (s *Store) Decrypt(space, org string, ciphertext []byte) ([]byte, error) {
+	tokens, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := tokens.GetImportToken(space, org)
+	if err == db.ErrNotFound {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(token.Expiration) {
+		return nil, ErrTokenNotFound
+	}
+
+	sealKey, err := s.key()
+	if err != nil {
+		return nil, err
+	}
+
+	claimed, err := tokens.ClaimImportToken(space, org, token.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrTokenNotFound
+	}
+
+	der, err := keywrap.Unwrap(sealKey, token.SealedPrivateKey, sealedData(token))
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, ciphertext, nil)
+	if err != nil {
+		return nil, ErrInvalidPayload
+	}
+	return plaintext, nil
+}
+
+// sealedData binds a sealed private key to its space and token, so it cannot be moved to another
+func sealedData(token *db.ImportToken) []byte {
+	return []byte(token.Space + "/" + token.ID)
+}
@@ -0,0 +1,207 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package importtoken
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"os"
+	"testing"
+	"time"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+)
+
+const testBits = 1024
+
+var testSealKey = bytes.Repeat([]byte{0x24}, SealKeyLength)
+
+// newTestStore returns a Store keeping its keypairs in the database, as a replica of the service would
+func newTestStore(database db.ImportTokenDB, lifetime time.Duration) *Store {
+	return NewStore(
+		func() (db.ImportTokenDB, error) { return database, nil },
+		func() ([]byte, error) { return testSealKey, nil },
+		lifetime, testBits)
+}
+
+// encrypt encrypts plaintext the way a client would with the public key of a token
+func encrypt(t *testing.T, token *Token, plaintext []byte) []byte {
+	publicKey, err := x509.ParsePKIXPublicKey(token.PublicKey)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey.(*rsa.PublicKey), plaintext, nil)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return ciphertext
+}
+
+func TestCreateDecrypt(t *testing.T) {
+	database := db.NewMemoryDB()
+	store := newTestStore(database, time.Minute)
+	material := bytes.Repeat([]byte{0x42}, 32)
+
+	token, err := store.Create("space-1234", "org-1234")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if !token.ExpirationDate.After(time.Now()) {
+		t.Errorf("Expected an expiration in the future, received %s", token.ExpirationDate)
+	}
+
+	// the private key is only stored sealed
+	stored, err := database.GetImportToken("space-1234", "org-1234")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(stored.SealedPrivateKey); err == nil {
+		t.Error("Expected the private key to be sealed")
+	}
+
+	// Bad Path: tokens belong to a single space
+	if _, err := store.Decrypt("space-5678", "org-1234", encrypt(t, token, material)); err != ErrTokenNotFound {
+		t.Errorf("Expected %s, received %+v", ErrTokenNotFound, err)
+	}
+
+	decrypted, err := store.Decrypt("space-1234", "org-1234", encrypt(t, token, material))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if !bytes.Equal(material, decrypted) {
+		t.Errorf("Expected %x, received %x", material, decrypted)
+	}
+
+	// Bad Path: tokens are used once
+	if _, err := store.Decrypt("space-1234", "org-1234", encrypt(t, token, material)); err != ErrTokenNotFound {
+		t.Errorf("Expected %s, received %+v", ErrTokenNotFound, err)
+	}
+}
+
+func TestDecryptInvalidPayload(t *testing.T) {
+	store := newTestStore(db.NewMemoryDB(), time.Minute)
+
+	token, err := store.Create("space-1234", "org-1234")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// Bad Path: not encrypted under the token
+	if _, err := store.Decrypt("space-1234", "org-1234", []byte("key-material")); err != ErrInvalidPayload {
+		t.Errorf("Expected %s, received %+v", ErrInvalidPayload, err)
+	}
+
+	// the token is used by a payload that does not decrypt as well
+	if _, err := store.Decrypt("space-1234", "org-1234", encrypt(t, token, []byte("key-material"))); err != ErrTokenNotFound {
+		t.Errorf("Expected %s, received %+v", ErrTokenNotFound, err)
+	}
+}
+
+func TestCreateSharesToken(t *testing.T) {
+	database := db.NewMemoryDB()
+	replica := newTestStore(database, time.Minute)
+	other := newTestStore(database, time.Minute)
+	material := bytes.Repeat([]byte{0x42}, 32)
+
+	token, err := replica.Create("space-1234", "org-1234")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// a valid token is returned again rather than replaced, by any replica
+	again, err := other.Create("space-1234", "org-1234")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if !bytes.Equal(token.PublicKey, again.PublicKey) || !token.ExpirationDate.Equal(again.ExpirationDate) {
+		t.Errorf("Expected %+v, received %+v", token, again)
+	}
+
+	// the token created by one replica is opened by another
+	decrypted, err := other.Decrypt("space-1234", "org-1234", encrypt(t, token, material))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if !bytes.Equal(material, decrypted) {
+		t.Errorf("Expected %x, received %x", material, decrypted)
+	}
+
+	// a used token is replaced by the next one created
+	next, err := replica.Create("space-1234", "org-1234")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if bytes.Equal(token.PublicKey, next.PublicKey) {
+		t.Error("Expected a new keypair once the token was used")
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	database := db.NewMemoryDB()
+	store := newTestStore(database, -time.Second)
+
+	token, err := store.Create("space-1234", "org-1234")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := store.Decrypt("space-1234", "org-1234", encrypt(t, token, []byte("key-material"))); err != ErrTokenNotFound {
+		t.Errorf("Expected %s, received %+v", ErrTokenNotFound, err)
+	}
+
+	// expired keypairs are replaced when the next token is created
+	next, err := store.Create("space-1234", "org-1234")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if bytes.Equal(token.PublicKey, next.PublicKey) {
+		t.Error("Expected a new keypair once the token expired")
+	}
+}
+
+func TestSealKey(t *testing.T) {
+	store := NewStore(
+		func() (db.ImportTokenDB, error) { return db.NewMemoryDB(), nil },
+		func() ([]byte, error) { return nil, ErrSealKey },
+		time.Minute, testBits)
+
+	// Bad Path: no keypair is stored without a seal key
+	if _, err := store.Create("space-1234", "org-1234"); err != ErrSealKey {
+		t.Errorf("Expected %s, received %+v", ErrSealKey, err)
+	}
+
+	defer func() { sealKey = nil }()
+	sealKey = nil
+
+	// Bad Path: a seal key that cannot be read is not kept
+	os.Unsetenv(SealKeyEnv)
+	if _, err := LoadSealKey(); err != ErrSealKey {
+		t.Errorf("Expected %s, received %+v", ErrSealKey, err)
+	}
+
+	defer os.Unsetenv(SealKeyEnv)
+	os.Setenv(SealKeyEnv, base64.StdEncoding.EncodeToString(testSealKey))
+	if key, err := LoadSealKey(); err != nil || !bytes.Equal(key, testSealKey) {
+		t.Errorf("Expected %x, received %x, %+v", testSealKey, key, err)
+	}
+	if key, err := readSealKey(); err != nil || !bytes.Equal(key, testSealKey) {
+		t.Errorf("Expected %x, received %x, %+v", testSealKey, key, err)
+	}
+
+	// Bad Path: the seal key is a 256 bit key
+	os.Setenv(SealKeyEnv, base64.StdEncoding.EncodeToString(testSealKey[:16]))
+	if _, err := readSealKey(); err != ErrSealKey {
+		t.Errorf("Expected %s, received %+v", ErrSealKey, err)
+	}
+}
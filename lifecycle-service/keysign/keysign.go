@@ -0,0 +1,180 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package keysign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+// Algorithm types of signing keys, as recorded in the AlgorithmType of a key
+const (
+	RSA = "RSA"
+	EC  = "EC"
+)
+
+// Signatures are made over SHA-256 digests. RSA keys sign with RSASSA-PKCS1-v1_5, while EC keys
+// produce ASN.1 DER encoded ECDSA signatures.
+const (
+	RSASignatureAlgorithm = "RSASSA_PKCS1_V1_5_SHA_256"
+	ECSignatureAlgorithm  = "ECDSA_SHA_256"
+)
+
+// DigestLength is the length in bytes of the digests that are signed
+const DigestLength = sha256.Size
+
+var (
+	// ErrInvalidKey is returned when the key material cannot be used as a private key
+	ErrInvalidKey = errors.New(http.StatusText(http.StatusInternalServerError) + ": Key material is not a valid private key")
+
+	// ErrInvalidBitLength is returned when a signing key of an unsupported size is requested
+	ErrInvalidBitLength = errors.New(http.StatusText(http.StatusBadRequest) + ": RSA keys must be 2048, 3072 or 4096 bits and EC keys 256, 384 or 521 bits")
+
+	// ErrInvalidDigest is returned when the digest to sign or verify is not a SHA-256 digest
+	ErrInvalidDigest = errors.New(http.StatusText(http.StatusBadRequest) + ": Digest must be a SHA-256 digest")
+)
+
+var (
+	rsaBitLengths = map[int]bool{2048: true, 3072: true, 4096: true}
+	ecCurves      = map[int]elliptic.Curve{256: elliptic.P256(), 384: elliptic.P384(), 521: elliptic.P521()}
+)
+
+// ecdsaSignature is the ASN.1 structure of an ECDSA signature
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// IsSigningAlgorithm reports whether keys of the algorithm type are signing keys
+func IsSigningAlgorithm(algorithmType string) bool {
+	switch strings.ToUpper(algorithmType) {
+	case RSA, EC:
+		return true
+	default:
+		return false
+	}
+}
+
+// DefaultBitLength returns the size of signing keys of the algorithm type when none is requested
+func DefaultBitLength(algorithmType string) int {
+	if strings.ToUpper(algorithmType) == EC {
+		return 256
+	}
+	return 2048
+}
+
+// GenerateKey generates a private key of the algorithm type and size. RSA keys are returned PKCS#1 DER
+// encoded and EC keys SEC 1 DER encoded, which is how Sign and Verify expect them.
+func GenerateKey(algorithmType string, bitLength int) ([]byte, error) {
+	switch strings.ToUpper(algorithmType) {
+	case RSA:
+		if !rsaBitLengths[bitLength] {
+			return nil, ErrInvalidBitLength
+		}
+
+		key, err := rsa.GenerateKey(rand.Reader, bitLength)
+		if err != nil {
+			return nil, err
+		}
+		return x509.MarshalPKCS1PrivateKey(key), nil
+	case EC:
+		curve, ok := ecCurves[bitLength]
+		if !ok {
+			return nil, ErrInvalidBitLength
+		}
+
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return x509.MarshalECPrivateKey(key)
+	default:
+		return nil, errors.New(http.StatusText(http.StatusBadRequest) + ": " + algorithmType + " is not a signing algorithm")
+	}
+}
+
+// parsePrivateKey parses key material created by GenerateKey
+func parsePrivateKey(material []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(material); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(material); err == nil {
+		return key, nil
+	}
+
+	return nil, ErrInvalidKey
+}
+
+// SignatureAlgorithm returns the signature scheme used by the private key
+func SignatureAlgorithm(material []byte) (string, error) {
+	key, err := parsePrivateKey(material)
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		return RSASignatureAlgorithm, nil
+	}
+	return ECSignatureAlgorithm, nil
+}
+
+// Sign signs a SHA-256 digest with the private key
+func Sign(material, digest []byte) ([]byte, error) {
+	if len(digest) != DigestLength {
+		return nil, ErrInvalidDigest
+	}
+
+	key, err := parsePrivateKey(material)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(ecdsaSignature{R: r, S: s})
+	default:
+		return nil, ErrInvalidKey
+	}
+}
+
+// Verify reports whether the signature of a SHA-256 digest was made by the private key
+func Verify(material, digest, signature []byte) (bool, error) {
+	if len(digest) != DigestLength {
+		return false, ErrInvalidDigest
+	}
+
+	key, err := parsePrivateKey(material)
+	if err != nil {
+		return false, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest, signature) == nil, nil
+	case *ecdsa.PrivateKey:
+		var parsed ecdsaSignature
+		rest, err := asn1.Unmarshal(signature, &parsed)
+		if err != nil || len(rest) != 0 || parsed.R == nil || parsed.S == nil {
+			return false, nil
+		}
+		return ecdsa.Verify(&key.PublicKey, digest, parsed.R, parsed.S), nil
+	default:
+		return false, ErrInvalidKey
+	}
+}
@@ -0,0 +1,94 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package keysign
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+var testDigest = sha256.Sum256([]byte("artifact"))
+
+func TestSignVerify(t *testing.T) {
+	tests := []struct {
+		algorithmType string
+		bitLength     int
+		scheme        string
+	}{
+		{algorithmType: RSA, bitLength: 2048, scheme: RSASignatureAlgorithm},
+		{algorithmType: "ec", bitLength: 256, scheme: ECSignatureAlgorithm},
+		{algorithmType: EC, bitLength: 384, scheme: ECSignatureAlgorithm},
+	}
+
+	for _, test := range tests {
+		key, err := GenerateKey(test.algorithmType, test.bitLength)
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+
+		if scheme, err := SignatureAlgorithm(key); err != nil || scheme != test.scheme {
+			t.Errorf("Expected %s, received %s, %+v", test.scheme, scheme, err)
+		}
+
+		signature, err := Sign(key, testDigest[:])
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+
+		if verified, err := Verify(key, testDigest[:], signature); err != nil || !verified {
+			t.Errorf("Expected the %s signature to verify, received %t, %+v", test.algorithmType, verified, err)
+		}
+
+		otherDigest := sha256.Sum256([]byte("other-artifact"))
+		if verified, err := Verify(key, otherDigest[:], signature); err != nil || verified {
+			t.Errorf("Expected the %s signature not to verify another digest, received %t, %+v", test.algorithmType, verified, err)
+		}
+
+		if verified, err := Verify(key, testDigest[:], []byte("not-a-signature")); err != nil || verified {
+			t.Errorf("Expected a malformed %s signature not to verify, received %t, %+v", test.algorithmType, verified, err)
+		}
+	}
+}
+
+func TestGenerateKeyValidation(t *testing.T) {
+	if _, err := GenerateKey(RSA, 1024); err != ErrInvalidBitLength {
+		t.Errorf("Expected %s, received %+v", ErrInvalidBitLength, err)
+	}
+
+	if _, err := GenerateKey(EC, 2048); err != ErrInvalidBitLength {
+		t.Errorf("Expected %s, received %+v", ErrInvalidBitLength, err)
+	}
+
+	if _, err := GenerateKey("AES", 256); err == nil {
+		t.Error("Expected Error")
+	}
+
+	if !IsSigningAlgorithm("rsa") || IsSigningAlgorithm("AES") {
+		t.Error("Expected only RSA and EC to be signing algorithms")
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	if _, err := Sign([]byte("not-a-key"), testDigest[:]); err != ErrInvalidKey {
+		t.Errorf("Expected %s, received %+v", ErrInvalidKey, err)
+	}
+
+	if _, err := Verify([]byte("not-a-key"), testDigest[:], []byte("signature")); err != ErrInvalidKey {
+		t.Errorf("Expected %s, received %+v", ErrInvalidKey, err)
+	}
+}
+
+func TestInvalidDigest(t *testing.T) {
+	key, err := GenerateKey(EC, 256)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := Sign(key, []byte("not-a-digest")); err != ErrInvalidDigest {
+		t.Errorf("Expected %s, received %+v", ErrInvalidDigest, err)
+	}
+
+	if _, err := Verify(key, []byte("not-a-digest"), []byte("signature")); err != ErrInvalidDigest {
+		t.Errorf("Expected %s, received %+v", ErrInvalidDigest, err)
+	}
+}
@@ -0,0 +1,19 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+// Package backends registers the keystore backends and databases built into the service. Importing it makes them
+// available by name through the keystore and db packages. Backends that need cgo are only built in with their build
+// tag:
+//
+//	pkcs11	the PKCS#11 keystore, which needs the miekg/pkcs11 bindings
+//	sqlite	the SQLite database of database.backend, which needs the mattn/go-sqlite3 driver
+package backends
+
+import (
+	// Backends register themselves when imported
+	_ "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican"
+	_ "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/kmip"
+	_ "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/local"
+	_ "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/mirror"
+	_ "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/mock"
+	_ "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/vault"
+)
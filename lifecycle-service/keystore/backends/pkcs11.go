@@ -0,0 +1,11 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+//go:build pkcs11
+// +build pkcs11
+
+package backends
+
+import (
+	// Registers the PKCS#11 keystore, which needs cgo
+	_ "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/pkcs11"
+)
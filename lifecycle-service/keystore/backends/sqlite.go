@@ -0,0 +1,11 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+//go:build sqlite
+// +build sqlite
+
+package backends
+
+import (
+	// Registers the SQLite database, which needs cgo
+	_ "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db/sqlite"
+)
@@ -3,16 +3,21 @@
 package barbican
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
+	"os"
+	"sync"
 
 	"github.com/go-kit/kit/log"
 	"github.com/satori/go.uuid"
 
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/algorithms"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/certificates"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/client"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/keystone"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-config"
@@ -21,16 +26,46 @@ import (
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
 )
 
+// Values of openstack.barbican.auth
 const (
-	//MaxRetries matches the total number of Barbican nodes
+	// UserAuth forwards the token of the user to Barbican, and is the default
+	UserAuth = "user"
+
+	// KeystoneAuth authenticates to Barbican as the service, with the credentials of openstack.keystone
+	KeystoneAuth = "keystone"
+)
+
+// ErrUserAuth is returned when Barbican is called without a request while openstack.barbican.auth forwards the token of the user
+var ErrUserAuth = errors.New(http.StatusText(http.StatusInternalServerError) + ": Barbican can only be called without the token of a user when openstack.barbican.auth is " + KeystoneAuth)
+
+// CheckServiceAuth returns ErrUserAuth unless calls to Barbican authenticate as the service
+func CheckServiceAuth() error {
+	if configuration.Get().GetString("openstack.barbican.auth") != KeystoneAuth {
+		return ErrUserAuth
+	}
+	return nil
+}
+
+// KeystonePasswordEnv holds the Keystone password of the service, which takes precedence over the configuration
+const KeystonePasswordEnv = "OS_PASSWORD"
+
+const (
+	//MaxRetries is the number of times a lookup in the ID translation table is attempted. Calls to Barbican
+	//are retried by the client under its retry policy.
 	MaxRetries = 4
 )
 
+var (
+	sessionLock   sync.Mutex
+	sharedSession *keystone.Session
+)
+
 type keystore struct {
 	barbicanClient client.Client
 	logger         log.Logger
 	headers        *communications.Headers
 	database       db.DB
+	metadataFields []string
 }
 
 // translateID is a helper function for GetPayload and Delete
@@ -48,6 +83,42 @@ func translateID(keyprotectID, space, org string, s *keystore) (*db.BarbicanRefs
 	return data, nil
 }
 
+// translateVersion will translate a key protect id and version into the refs of that version's material
+func translateVersion(keyprotectID string, version int, space, org string, s *keystore) (*db.BarbicanRefs, error) {
+	data, err := s.database.GetVersion(space, org, keyprotectID, version)
+	if err != nil && err != db.ErrNotFound {
+		return nil, err
+	}
+
+	if data == nil || (data.SecretID == "" && data.OrderID == "") {
+		return nil, errors.New(http.StatusText(http.StatusNotFound) + ": Key version not found")
+	}
+
+	return data, nil
+}
+
+// listVersions returns the refs of every version of the key, from the first to the latest
+func listVersions(keyprotectID, space, org string, s *keystore) ([]*db.BarbicanRefs, error) {
+	var versions []*db.BarbicanRefs
+	var err error
+	for i := 0; i < MaxRetries; i++ {
+		versions, err = s.database.ListVersions(space, org, keyprotectID)
+		if err == nil || err == db.ErrNotFound {
+			break
+		}
+	}
+
+	if err != nil && err != db.ErrNotFound {
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		return nil, errors.New(http.StatusText(http.StatusNotFound) + ": Key not found")
+	}
+
+	return versions, nil
+}
+
 func extractBluemixSpace(s *keystore) (string, error) {
 	headers := s.headers
 	if headers == nil {
@@ -74,13 +145,13 @@ func extractBluemixOrg(s *keystore) (string, error) {
 	return bluemixOrg, nil
 }
 
-func retrievePayload(s *keystore, secretRef string, isOrder bool) (string, error) {
+func retrievePayload(ctx context.Context, s *keystore, secretRef string, isOrder bool) (string, error) {
 	var payload string
 	var err error
 	if isOrder {
-		payload, err = s.barbicanClient.GetPayload(secretRef, constants.OctetStreamMime)
+		payload, err = s.barbicanClient.GetPayload(ctx, secretRef, constants.OctetStreamMime)
 	} else {
-		payload, err = s.barbicanClient.GetPayload(secretRef, constants.TextPlainMime)
+		payload, err = s.barbicanClient.GetPayload(ctx, secretRef, constants.TextPlainMime)
 	}
 
 	if err != nil {
@@ -91,7 +162,7 @@ func retrievePayload(s *keystore, secretRef string, isOrder bool) (string, error
 }
 
 //GetPayload : Get a payload with the given keyprotect ID
-func (s *keystore) GetPayload(keyprotectID string) (string, secrets.KeyStates, error) {
+func (s *keystore) GetPayload(ctx context.Context, keyprotectID string) (string, secrets.KeyStates, error) {
 	space, extractErr := extractBluemixSpace(s)
 	if extractErr != nil {
 		return "", secrets.Destroyed, extractErr
@@ -115,19 +186,23 @@ func (s *keystore) GetPayload(keyprotectID string) (string, secrets.KeyStates, e
 		return "", secrets.Destroyed, errTranslate
 	}
 
+	return resolvePayload(ctx, s, ref)
+}
+
+// resolvePayload retrieves the payload the refs point to. Refs that only hold an order are
+// updated with the order's secret ref once the order has completed.
+func resolvePayload(ctx context.Context, s *keystore, ref *db.BarbicanRefs) (string, secrets.KeyStates, error) {
+	// Certificates are stored whole, so their container is complete from the start
+	if containerID := ref.ContainerID(); containerID != "" {
+		payload, err := retrieveBundle(ctx, s, containerID)
+		return payload, secrets.Activation, err
+	}
+
 	//Need to retrieve the secret ref from the order.
 	//If the order is not active yet we simply let the caller know that
 	//the secret is still pending.
 	if len(ref.OrderID) > 0 && len(ref.SecretID) == 0 {
-		var check *client.CheckOrderResponse
-		var err error
-		for i := 0; i < MaxRetries; i++ {
-			check, err = s.barbicanClient.CheckOrder(ref.OrderID)
-			if err == nil {
-				break
-			}
-		}
-
+		check, err := s.barbicanClient.CheckOrder(ctx, ref.OrderID)
 		if err != nil {
 			return "", secrets.Destroyed, err
 		}
@@ -144,22 +219,152 @@ func (s *keystore) GetPayload(keyprotectID string) (string, secrets.KeyStates, e
 				}
 			}
 			if err != nil {
-				s.barbicanClient.DeleteOrder(ref.OrderID)
+				s.barbicanClient.DeleteOrder(ctx, ref.OrderID)
 			}
 		} else {
 			return "", check.KeyStatus, nil
 		}
 	}
 
-	var payload string
-	var err error
-	for i := 0; i < MaxRetries; i++ {
-		payload, err = retrievePayload(s, ref.SecretID, len(ref.OrderID) != 0)
-		if err == nil {
-			break
+	payload, err := retrievePayload(ctx, s, ref.SecretID, len(ref.OrderID) != 0)
+	return payload, secrets.Activation, err
+}
+
+// GetPayloadVersion gets the payload of a version of the key. definitions.CurrentVersion resolves to the
+// latest version whose material is active, so a rotation still being generated does not interrupt the key.
+func (s *keystore) GetPayloadVersion(ctx context.Context, keyprotectID string, version int) (string, int, secrets.KeyStates, error) {
+	space, extractErr := extractBluemixSpace(s)
+	if extractErr != nil {
+		return "", version, secrets.Destroyed, extractErr
+	}
+
+	org, extractErr := extractBluemixOrg(s)
+	if extractErr != nil {
+		return "", version, secrets.Destroyed, extractErr
+	}
+
+	if version != definitions.CurrentVersion {
+		ref, errTranslate := translateVersion(keyprotectID, version, space, org, s)
+		if errTranslate != nil {
+			return "", version, secrets.Destroyed, errTranslate
 		}
+
+		payload, state, err := resolvePayload(ctx, s, ref)
+		return payload, version, state, err
 	}
-	return payload, secrets.Activation, err
+
+	versions, errList := listVersions(keyprotectID, space, org, s)
+	if errList != nil {
+		return "", version, secrets.Destroyed, errList
+	}
+
+	// Newer versions that cannot be resolved are skipped like inactive ones, so a failing rotation does not
+	// interrupt the key while an earlier version is active
+	for i := len(versions) - 1; i > 0; i-- {
+		payload, state, err := resolvePayload(ctx, s, versions[i])
+		if err != nil {
+			s.logger.Log("msg", "Skipping key version that cannot be resolved", "version", versions[i].Version, "err", err, "correlation_id", s.headers.CorrelationID)
+			continue
+		}
+
+		if state == secrets.Activation {
+			return payload, versions[i].Version, state, nil
+		}
+		s.logger.Log("msg", "Skipping key version that is not active", "version", versions[i].Version, "state", state, "correlation_id", s.headers.CorrelationID)
+	}
+
+	payload, state, err := resolvePayload(ctx, s, versions[0])
+	return payload, versions[0].Version, state, err
+}
+
+// RotateSecret orders new material for the key and records it as the key's next version.
+// Earlier versions are kept so ciphertexts created under them can still be unwrapped.
+func (s *keystore) RotateSecret(ctx context.Context, keyprotectID string, secret *secrets.Secret, rotateTx *transactions.Transaction) (int, error) {
+	space, org, version, err := nextVersion(keyprotectID, s)
+	if err != nil {
+		return 0, err
+	}
+
+	// Imported keys carry no algorithm, so their new versions are generated with the defaults
+	spec, err := algorithms.Resolve(secret.AlgorithmType, secret.AlgorithmMetadata)
+	if err != nil {
+		return 0, err
+	}
+
+	orderID, err := postKeyOrder(ctx, s, secret.Name, spec)
+	if err != nil {
+		return 0, err
+	}
+
+	rbDeleteOrder := transactions.NewHsmCreateSecretRollback(s.rollbackDeleteOrder, orderID)
+	rotateTx.Add(rbDeleteOrder)
+
+	err = s.database.Add(space, org, &db.BarbicanRefs{KpID: keyprotectID, OrderID: orderID, Version: version})
+	if err != nil {
+		s.logger.Log("err", err, "correlation_id", s.headers.CorrelationID)
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// RotateSecretPayload stores material generated by the service as the key's next version, which is active at once
+// rather than once an order completes
+func (s *keystore) RotateSecretPayload(ctx context.Context, keyprotectID string, secret *secrets.Secret, payload string, rotateTx *transactions.Transaction) (int, error) {
+	space, org, version, err := nextVersion(keyprotectID, s)
+	if err != nil {
+		return 0, err
+	}
+
+	secretID, err := s.barbicanClient.PostSecret(ctx, &client.PostSecretRequest{
+		Name:               secret.Name,
+		Payload:            payload,
+		PayloadContentType: constants.TextPlainMime,
+	})
+	if err != nil {
+		s.logger.Log("err", err, "correlation_id", s.headers.CorrelationID)
+		return 0, err
+	}
+
+	rbDeleteSecret := transactions.NewHsmCreateSecretRollback(s.rollbackDeleteSecret, secretID)
+	rotateTx.Add(rbDeleteSecret)
+
+	err = s.database.Add(space, org, &db.BarbicanRefs{KpID: keyprotectID, SecretID: secretID, Version: version})
+	if err != nil {
+		s.logger.Log("err", err, "correlation_id", s.headers.CorrelationID)
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// GeneratesAsync reports that keys generated by Barbican have no material until their order completes
+func (s *keystore) GeneratesAsync() bool {
+	return true
+}
+
+// nextVersion returns the space and org of the request, and the version the next rotation of the key records
+func nextVersion(keyprotectID string, s *keystore) (string, string, int, error) {
+	space, extractErr := extractBluemixSpace(s)
+	if extractErr != nil {
+		return "", "", 0, extractErr
+	}
+
+	org, extractErr := extractBluemixOrg(s)
+	if extractErr != nil {
+		return "", "", 0, extractErr
+	}
+
+	versions, errList := listVersions(keyprotectID, space, org, s)
+	if errList != nil {
+		return "", "", 0, errList
+	}
+
+	if versions[0].ContainerID() != "" {
+		return "", "", 0, ErrCertificateRotation
+	}
+
+	return space, org, versions[len(versions)-1].Version + 1, nil
 }
 
 func createID(refs *db.BarbicanRefs, space string, org string, s *keystore) (string, error) {
@@ -189,8 +394,8 @@ func createID(refs *db.BarbicanRefs, space string, org string, s *keystore) (str
 	return keyprotectID, nil
 }
 
-func storeSecret(s *keystore, secret *secrets.Secret, createTx *transactions.Transaction) (string, error) {
-	secretID, err := s.barbicanClient.PostSecret(&client.PostSecretRequest{
+func storeSecret(ctx context.Context, s *keystore, secret *secrets.Secret, createTx *transactions.Transaction) (string, error) {
+	secretID, err := s.barbicanClient.PostSecret(ctx, &client.PostSecretRequest{
 		Name:               secret.Name,
 		Payload:            secret.Payload,
 		PayloadContentType: constants.TextPlainMime,
@@ -200,7 +405,7 @@ func storeSecret(s *keystore, secret *secrets.Secret, createTx *transactions.Tra
 		return "", err
 	}
 
-	rbDeleteSecret := transactions.NewHsmCreateSecretRollback(s.barbicanClient.DeleteSecret, secretID)
+	rbDeleteSecret := transactions.NewHsmCreateSecretRollback(s.rollbackDeleteSecret, secretID)
 	createTx.Add(rbDeleteSecret)
 	space, extractErr := extractBluemixSpace(s)
 	if extractErr != nil {
@@ -224,40 +429,14 @@ func storeSecret(s *keystore, secret *secrets.Secret, createTx *transactions.Tra
 	return id, nil
 }
 
-func generateSecret(s *keystore, secret *secrets.Secret) (string, error) {
-	// TODO: this is temporary code until we have code put in place for to check for defaults, bad values, etc in the transport layer. TSC. Dec 14th, 2016
-
-	// AES default. Need so that we can put this into metadata table.
-	secret.AlgorithmType = "AES"
-
-	// just in case this doesn't exist
-	if secret.AlgorithmMetadata == nil {
-		secret.AlgorithmMetadata = make(map[string]string)
+func generateSecret(ctx context.Context, s *keystore, secret *secrets.Secret) (string, error) {
+	spec, err := algorithms.ResolveSecret(secret)
+	if err != nil {
+		return "", err
 	}
 
-	// Mode GCM default. Need so that we can put this into metadata table.
-	secret.AlgorithmMetadata["mode"] = "GCM"
-	// bitLength 256 default. Need so that we can put this into metadata table.
-	secret.AlgorithmMetadata["bitLength"] = "256"
-
-	algorithmType := secret.AlgorithmType
-	mode := secret.AlgorithmMetadata["mode"]
-
-	// TODO: should be no error as this is defaulted for now. will need to be changed in the future where there is possiblity of errors. TSC. Dec 14th, 2016
-	bitLength, _ := strconv.Atoi(secret.AlgorithmMetadata["bitLength"])
-
-	orderID, err := s.barbicanClient.PostOrder(&client.PostOrderRequest{
-		Type: "key",
-		Meta: &client.OrderMeta{
-			Name:               secret.Name,
-			Algorithm:          algorithmType,    //Should be found AlgorithmType
-			BitLength:          int32(bitLength), //Should be found in algorithm metadata (not yet there)
-			Mode:               mode,             // Should be in algorithm metadata (not yet there)
-			PayloadContentType: constants.OctetStreamMime,
-		},
-	})
+	orderID, err := postKeyOrder(ctx, s, secret.Name, spec)
 	if err != nil {
-		s.logger.Log("err", err, "correlation_id", s.headers.CorrelationID)
 		return "", err
 	}
 
@@ -275,12 +454,46 @@ func generateSecret(s *keystore, secret *secrets.Secret) (string, error) {
 	return createID(&db.BarbicanRefs{OrderID: orderID}, space, org, s)
 }
 
+// postKeyOrder orders new key material from barbican with the resolved algorithm of a key
+func postKeyOrder(ctx context.Context, s *keystore, name string, spec *algorithms.Spec) (string, error) {
+	orderID, err := s.barbicanClient.PostOrder(ctx, &client.PostOrderRequest{
+		Type: "key",
+		Meta: &client.OrderMeta{
+			Name:               name,
+			Algorithm:          spec.OrderAlgorithm,
+			BitLength:          int32(spec.BitLength),
+			Mode:               spec.Mode,
+			PayloadContentType: constants.OctetStreamMime,
+		},
+	})
+	if err != nil {
+		s.logger.Log("err", err, "correlation_id", s.headers.CorrelationID)
+		return "", err
+	}
+
+	return orderID, nil
+}
+
+// rollbackDeleteOrder deletes an order on rollback. Rollbacks run once the request has failed, which may be
+// because it was cancelled, so they do not use the context of the request.
+func (s *keystore) rollbackDeleteOrder(orderRef string) error {
+	return s.barbicanClient.DeleteOrder(context.Background(), orderRef)
+}
+
+// rollbackDeleteSecret deletes a secret on rollback, without the context of the request
+func (s *keystore) rollbackDeleteSecret(secretRef string) error {
+	return s.barbicanClient.DeleteSecret(context.Background(), secretRef)
+}
+
 // CreateSecret creates a secret using inside the barbican user defined metadata table
-func (s *keystore) CreateSecret(secret *secrets.Secret, createTx *transactions.Transaction) (string, error) {
+func (s *keystore) CreateSecret(ctx context.Context, secret *secrets.Secret, createTx *transactions.Transaction) (string, error) {
+	if certificates.IsCertificate(secret.SecretType) {
+		return storeCertificate(ctx, s, secret, createTx)
+	}
 	if len(secret.Payload) > 0 {
-		return storeSecret(s, secret, createTx)
+		return storeSecret(ctx, s, secret, createTx)
 	}
-	return generateSecret(s, secret)
+	return generateSecret(ctx, s, secret)
 }
 
 //deleteID takes an interface so that we can perform rollbacks using this function. The interface
@@ -292,8 +505,45 @@ func deleteID(keyprotectID string, space string, org string, i interface{}) erro
 	return fmt.Errorf("Requires type *keystore, received %T", i)
 }
 
+// deleteRotatedVersions deletes the material of every version that was added to the key by rotation
+func deleteRotatedVersions(ctx context.Context, keyprotectID, space, org string, s *keystore) error {
+	versions, err := listVersions(keyprotectID, space, org, s)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range versions[1:] {
+		secretRef := ref.SecretID
+		if len(secretRef) == 0 {
+			check, err := s.barbicanClient.CheckOrder(ctx, ref.OrderID)
+			if err != nil {
+				return err
+			}
+
+			if check.KeyStatus == secrets.Preactivation {
+				return errors.New(http.StatusText(http.StatusConflict) + ": Key rotation still in progress. Please try again later.")
+			}
+			secretRef = check.SecretRef
+		}
+
+		if len(secretRef) > 0 {
+			if err := s.barbicanClient.DeleteSecret(ctx, secretRef); err != nil {
+				return err
+			}
+		}
+
+		if len(ref.OrderID) > 0 {
+			if err := s.barbicanClient.DeleteOrder(ctx, ref.OrderID); err != nil {
+				s.logger.Log("correlation_id", s.headers.CorrelationID, "order_ref", ref.OrderID, "err", "CRITICAL - Cannot delete order ID")
+			}
+		}
+	}
+
+	return nil
+}
+
 // DeleteSecret Given a keyprotect ID. Delete the user's secret.
-func (s *keystore) DeleteSecret(keyprotectID string, deleteTx *transactions.Transaction) error {
+func (s *keystore) DeleteSecret(ctx context.Context, keyprotectID string, deleteTx *transactions.Transaction) error {
 	space, extractErr := extractBluemixSpace(s)
 	if extractErr != nil {
 		return extractErr
@@ -309,8 +559,12 @@ func (s *keystore) DeleteSecret(keyprotectID string, deleteTx *transactions.Tran
 		return errTranslate
 	}
 
+	if containerID := refs.ContainerID(); containerID != "" {
+		return deleteCertificate(ctx, s, keyprotectID, space, org, containerID)
+	}
+
 	if len(refs.SecretID) == 0 {
-		check, err := s.barbicanClient.CheckOrder(refs.OrderID)
+		check, err := s.barbicanClient.CheckOrder(ctx, refs.OrderID)
 		if err != nil {
 			return err
 		}
@@ -322,10 +576,16 @@ func (s *keystore) DeleteSecret(keyprotectID string, deleteTx *transactions.Tran
 		}
 	}
 
+	errVersions := deleteRotatedVersions(ctx, keyprotectID, space, org, s)
+	if errVersions != nil {
+		s.logger.Log("err", errVersions.Error(), "correlation_id", s.headers.CorrelationID)
+		return errVersions
+	}
+
 	var errBarbicanDelete error
 
 	//TODO: csolis 11/10/2016 - Add CreateID function call to be used for rollbacks
-	errBarbicanDelete = s.barbicanClient.DeleteSecret(refs.SecretID)
+	errBarbicanDelete = s.barbicanClient.DeleteSecret(ctx, refs.SecretID)
 	if errBarbicanDelete != nil {
 		s.logger.Log("err", errBarbicanDelete.Error(), "correlation_id", s.headers.CorrelationID)
 		return errBarbicanDelete
@@ -340,14 +600,8 @@ func (s *keystore) DeleteSecret(keyprotectID string, deleteTx *transactions.Tran
 	if refs.OrderID != "" {
 		//This function doesn't return an error. It doesn't really matter as this
 		//will eventually be cleaned out by a background script.
-		retries := 0
-		for retries < MaxRetries {
-			if err := s.barbicanClient.DeleteOrder(refs.OrderID); err == nil {
-				break
-			}
-			retries++
-		}
-		if retries == MaxRetries {
+		//The secret is already deleted, so the order is cleaned up even if the request is cancelled.
+		if err := s.rollbackDeleteOrder(refs.OrderID); err != nil {
 			s.logger.Log("correlation_id", s.headers.CorrelationID, "order_ref", refs.OrderID, "err", "CRITICAL - Cannot delete order ID")
 		}
 	}
@@ -356,7 +610,7 @@ func (s *keystore) DeleteSecret(keyprotectID string, deleteTx *transactions.Tran
 }
 
 // CheckSecret: Given a keyprotect id, is the secret active?
-func (s *keystore) CheckSecret(keyprotectID string) (secrets.KeyStates, error) {
+func (s *keystore) CheckSecret(ctx context.Context, keyprotectID string) (secrets.KeyStates, error) {
 	space, extractErr := extractBluemixSpace(s)
 	if extractErr != nil {
 		return secrets.Destroyed, extractErr
@@ -376,7 +630,7 @@ func (s *keystore) CheckSecret(keyprotectID string) (secrets.KeyStates, error) {
 		return secrets.Activation, nil
 	}
 
-	check, err := s.barbicanClient.CheckOrder(refs.OrderID)
+	check, err := s.barbicanClient.CheckOrder(ctx, refs.OrderID)
 	if err != nil {
 		return secrets.Destroyed, err
 	}
@@ -390,7 +644,7 @@ func (s *keystore) CheckSecret(keyprotectID string) (secrets.KeyStates, error) {
 		refs.SecretID = check.SecretRef
 		if err = s.database.Update(s.headers.BluemixSpace, s.headers.BluemixOrg, refs); err == nil {
 			// if successful on update, delete order from barbican table
-			s.barbicanClient.DeleteOrder(refs.OrderID)
+			s.barbicanClient.DeleteOrder(ctx, refs.OrderID)
 		}
 		return secrets.Activation, nil
 	}
@@ -398,14 +652,97 @@ func (s *keystore) CheckSecret(keyprotectID string) (secrets.KeyStates, error) {
 	return secrets.Preactivation, nil
 }
 
-// NewBarbicanKeystore will return a new barbican Keystore
-func NewBarbicanKeystore(auth *communications.Headers, logger log.Logger) definitions.Keystore {
+// newSession returns the Keystone session of the service from openstack.keystone
+func newSession() (*keystone.Session, error) {
 	config := configuration.Get()
-	cli := client.NewClient(config.GetString("openstack.barbican.url"), auth)
+	keystoneConfig := keystone.Config{
+		URL:           config.GetString("openstack.keystone.url"),
+		Username:      config.GetString("openstack.keystone.username"),
+		Password:      config.GetString("openstack.keystone.password"),
+		UserDomain:    config.GetString("openstack.keystone.userDomain"),
+		Project:       config.GetString("openstack.keystone.project"),
+		ProjectDomain: config.GetString("openstack.keystone.projectDomain"),
+		Region:        config.GetString("openstack.keystone.region"),
+		Interface:     config.GetString("openstack.keystone.interface"),
+		CACert:        config.GetString("openstack.keystone.caCert"),
+	}
+
+	if password, ok := os.LookupEnv(KeystonePasswordEnv); ok {
+		keystoneConfig.Password = password
+	}
+
+	return keystone.NewSession(keystoneConfig)
+}
+
+// newServiceClient returns a client authenticated by the shared Keystone session. openstack.barbican.url overrides
+// the endpoint of Barbican in the catalog.
+func newServiceClient(barbicanURL string, auth *communications.Headers) (client.Client, error) {
+	session, err := serviceSession(newSession)
+	if err != nil {
+		return nil, err
+	}
+
+	if barbicanURL == "" {
+		endpoint, err := session.Endpoint(context.Background(), keystone.KeyManager)
+		if err != nil {
+			return nil, err
+		}
+		barbicanURL = endpoint
+	}
+
+	return client.NewServiceClient(barbicanURL, session, auth), nil
+}
+
+// serviceSession returns the Keystone session shared by every request, opening it on first use. A session that fails
+// to open is not kept, so the next request opens it again rather than failing until the service restarts.
+func serviceSession(open func() (*keystone.Session, error)) (*keystone.Session, error) {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	if sharedSession == nil {
+		session, err := open()
+		if err != nil {
+			return nil, err
+		}
+		sharedSession = session
+	}
+	return sharedSession, nil
+}
+
+// NewBarbicanKeystore will return a new barbican Keystore. Calls to Barbican forward the token of the user, unless
+// openstack.barbican.auth is keystone, which authenticates them as the service. The fields of keys named in
+// openstack.barbican.metadata are mirrored into the user metadata of their secrets.
+func NewBarbicanKeystore(auth *communications.Headers, logger log.Logger) (definitions.Keystore, error) {
+	config := configuration.Get()
+	barbicanURL := config.GetString("openstack.barbican.url")
+
+	metadataFields, err := parseMetadataFields(config.GetString("openstack.barbican.metadata"))
+	if err != nil {
+		return nil, err
+	}
+
+	var cli client.Client
+	switch authMethod := config.GetString("openstack.barbican.auth"); authMethod {
+	case "", UserAuth:
+		cli = client.NewClient(barbicanURL, auth)
+	case KeystoneAuth:
+		if cli, err = newServiceClient(barbicanURL, auth); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New(http.StatusText(http.StatusInternalServerError) + ": Unknown Barbican authentication " + authMethod)
+	}
+
+	database, err := db.NewDBInstance()
+	if err != nil {
+		return nil, err
+	}
+
 	s := new(keystore)
 	s.barbicanClient = cli
 	s.headers = auth
 	s.logger = logger
-	s.database = db.NewDBInstance()
-	return s
+	s.database = database
+	s.metadataFields = metadataFields
+	return s, nil
 }
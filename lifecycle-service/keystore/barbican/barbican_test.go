@@ -3,14 +3,23 @@
 package barbican
 
 import (
+	"context"
+	"encoding/base64"
 	"errors"
 	"net/http"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/go-kit/kit/log"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/algorithms"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/barbicantest"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/client"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/keystone"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-consts"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
 )
@@ -33,11 +42,14 @@ func init() {
 }
 
 type fDB struct {
-	err        error
-	returnRefs *db.BarbicanRefs
+	err            error
+	returnRefs     *db.BarbicanRefs
+	returnVersions []*db.BarbicanRefs
+	addedRefs      *db.BarbicanRefs
 }
 
 func (fdb *fDB) Add(space string, org string, refs *db.BarbicanRefs) error {
+	fdb.addedRefs = refs
 	err := fdb.err
 	if nilOverwriteForAdd == true {
 		err = nil
@@ -57,6 +69,37 @@ func (fdb *fDB) Delete(space string, org string, kpID string) error {
 	return fdb.err
 }
 
+func (fdb *fDB) GetVersion(space string, org string, kpID string, version int) (*db.BarbicanRefs, error) {
+	if version <= db.FirstVersion {
+		return fdb.returnRefs, fdb.err
+	}
+
+	for _, refs := range fdb.returnVersions {
+		if refs.Version == version {
+			return refs, fdb.err
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (fdb *fDB) ListVersions(space string, org string, kpID string) ([]*db.BarbicanRefs, error) {
+	if fdb.returnRefs == nil {
+		return nil, fdb.err
+	}
+	return append([]*db.BarbicanRefs{fdb.returnRefs}, fdb.returnVersions...), fdb.err
+}
+
+func (fdb *fDB) ListSpaces(org string) ([]string, error) {
+	return nil, fdb.err
+}
+
+func (fdb *fDB) ListKeys(space string, org string) ([]*db.BarbicanRefs, error) {
+	if fdb.returnRefs == nil {
+		return nil, fdb.err
+	}
+	return []*db.BarbicanRefs{fdb.returnRefs}, fdb.err
+}
+
 func (fdb *fDB) InjectError(err error) {
 	fdb.err = err
 }
@@ -71,35 +114,74 @@ func (fdb *fDB) InjectRefs(refs *db.BarbicanRefs) {
 
 func (fdb *fDB) RemoveRefs() {
 	fdb.returnRefs = nil
+	fdb.addedRefs = nil
+}
+
+func (fdb *fDB) InjectVersions(versions ...*db.BarbicanRefs) {
+	fdb.returnVersions = versions
+}
+
+func (fdb *fDB) RemoveVersions() {
+	fdb.returnVersions = nil
 }
 
 type fBC struct {
 	err                error
 	stringResponse     string
 	checkOrderResponse *client.CheckOrderResponse
+	postedOrder        *client.PostOrderRequest
+	metadata           map[string]map[string]string
 }
 
-func (fb *fBC) PostSecret(secret *client.PostSecretRequest) (string, error) {
+func (fb *fBC) PostSecret(ctx context.Context, secret *client.PostSecretRequest) (string, error) {
 	return fb.stringResponse, fb.err
 }
 
-func (fb *fBC) PostOrder(order *client.PostOrderRequest) (string, error) {
+func (fb *fBC) PostOrder(ctx context.Context, order *client.PostOrderRequest) (string, error) {
+	fb.postedOrder = order
 	return fb.stringResponse, fb.err
 }
 
-func (fb *fBC) CheckOrder(orderRef string) (*client.CheckOrderResponse, error) {
+func (fb *fBC) CheckOrder(ctx context.Context, orderRef string) (*client.CheckOrderResponse, error) {
 	return fb.checkOrderResponse, fb.err
 }
 
-func (fb *fBC) GetPayload(secretID string, accept string) (string, error) {
+func (fb *fBC) GetPayload(ctx context.Context, secretID string, accept string) (string, error) {
 	return fb.stringResponse, fb.err
 }
 
-func (fb *fBC) DeleteOrder(orderRef string) error {
+func (fb *fBC) DeleteOrder(ctx context.Context, orderRef string) error {
 	return fb.err
 }
 
-func (fb *fBC) DeleteSecret(ID string) error {
+func (fb *fBC) DeleteSecret(ctx context.Context, ID string) error {
+	return fb.err
+}
+
+func (fb *fBC) PutMetadata(ctx context.Context, secretID string, metadata map[string]string) error {
+	if fb.err != nil {
+		return fb.err
+	}
+	if fb.metadata == nil {
+		fb.metadata = make(map[string]map[string]string)
+	}
+	fb.metadata[secretID] = metadata
+	return nil
+}
+
+func (fb *fBC) GetMetadata(ctx context.Context, secretID string) (map[string]string, error) {
+	return fb.metadata[secretID], fb.err
+}
+
+func (fb *fBC) PostContainer(ctx context.Context, container *client.PostContainerRequest) (string, error) {
+	return fb.stringResponse, fb.err
+}
+
+func (fb *fBC) GetContainer(ctx context.Context, containerID string) (*client.GetContainerResponse, error) {
+	return nil, fb.err
+}
+
+func (fb *fBC) DeleteContainer(ctx context.Context, containerID string) error {
 	return fb.err
 }
 
@@ -134,7 +216,14 @@ func cleanUp() {
 
 	// clean up injected refs
 	fDatabase.RemoveRefs()
-
+	fDatabase.RemoveVersions()
+
+	// clean up injected responses
+	fBarbicanClient.stringResponse = ""
+	fBarbicanClient.checkOrderResponse = nil
+	fBarbicanClient.postedOrder = nil
+	fBarbicanClient.metadata = nil
+	testKeystore.metadataFields = nil
 }
 
 func TestTranslateIDErrorDB(t *testing.T) {
@@ -269,7 +358,7 @@ func TestRetrievePayloadOrder(t *testing.T) {
 	testError := errors.New("test-payloadResponse-error")
 	fBarbicanClient.InjectError(testError)
 
-	_, errPayloadResponseIsOrder := retrievePayload(testKeystore, "", true)
+	_, errPayloadResponseIsOrder := retrievePayload(context.Background(), testKeystore, "", true)
 	if errPayloadResponseIsOrder != testError {
 		t.Errorf("Expected %s, received %+v", testError.Error(), errPayloadResponseIsOrder)
 	}
@@ -281,7 +370,7 @@ func TestRetrievePayloadSecret(t *testing.T) {
 	testError := errors.New("test-payloadResponse-error")
 	fBarbicanClient.InjectError(testError)
 
-	_, errPayloadResponseNotOrder := retrievePayload(testKeystore, "", false)
+	_, errPayloadResponseNotOrder := retrievePayload(context.Background(), testKeystore, "", false)
 	if errPayloadResponseNotOrder != testError {
 		t.Errorf("Expected %s, received %+v", testError.Error(), errPayloadResponseNotOrder)
 	}
@@ -295,7 +384,7 @@ func TestGetPayloadErrorBluemixSpaceRequired(t *testing.T) {
 	// test no Bluemix-Space header
 	errMsgBluemixSpaceRequired := http.StatusText(http.StatusBadRequest) + ": Header Bluemix-Space required"
 
-	_, _, errBluemixSpaceRequired := testKeystore.GetPayload("")
+	_, _, errBluemixSpaceRequired := testKeystore.GetPayload(context.Background(), "")
 	if errBluemixSpaceRequired == nil || errBluemixSpaceRequired.Error() != errMsgBluemixSpaceRequired {
 		t.Errorf("Expected %s, received %+v", errMsgBluemixSpaceRequired, errBluemixSpaceRequired)
 	}
@@ -312,7 +401,7 @@ func TestGetPayloadErrorBluemixOrgRequired(t *testing.T) {
 	// test no Bluemix-Org header
 	errMsgBluemixOrgRequired := http.StatusText(http.StatusBadRequest) + ": Header Bluemix-Org required"
 
-	_, _, errBluemixOrgRequired := testKeystore.GetPayload("")
+	_, _, errBluemixOrgRequired := testKeystore.GetPayload(context.Background(), "")
 	if errBluemixOrgRequired == nil || errBluemixOrgRequired.Error() != errMsgBluemixOrgRequired {
 		t.Errorf("Expected %s, received %+v", errMsgBluemixOrgRequired, errBluemixOrgRequired)
 	}
@@ -325,7 +414,7 @@ func TestGetPayloadErrorKeyNotFound(t *testing.T) {
 
 	errMsgKeyNotFound := http.StatusText(http.StatusNotFound) + ": Key not found"
 
-	_, _, errKeyNotFound := testKeystore.GetPayload("")
+	_, _, errKeyNotFound := testKeystore.GetPayload(context.Background(), "")
 	if errKeyNotFound == nil || errKeyNotFound.Error() != errMsgKeyNotFound {
 		t.Errorf("Expected %s, received %+v", errMsgKeyNotFound, errKeyNotFound)
 	}
@@ -345,7 +434,7 @@ func TestGetPayloadOrderErrorCheckOrder(t *testing.T) {
 	}
 	fDatabase.InjectRefs(testOrderRef)
 
-	_, _, errCheckOrder := testKeystore.GetPayload("")
+	_, _, errCheckOrder := testKeystore.GetPayload(context.Background(), "")
 	if errCheckOrder != testErrorCheckOrder {
 		t.Errorf("Expected %s, received %+v", testErrorCheckOrder.Error(), errCheckOrder)
 	}
@@ -365,7 +454,7 @@ func TestGetPayloadSecretErrorRetrievePayload(t *testing.T) {
 	fDatabase.InjectRefs(testSecretRef)
 
 	// retrievePayload
-	_, _, errRetrievePayload := testKeystore.GetPayload("")
+	_, _, errRetrievePayload := testKeystore.GetPayload(context.Background(), "")
 	if errRetrievePayload != testErrorRetrievePayload {
 		t.Errorf("Expected %s, received %+v", testErrorRetrievePayload.Error(), errRetrievePayload)
 	}
@@ -373,6 +462,158 @@ func TestGetPayloadSecretErrorRetrievePayload(t *testing.T) {
 	cleanUp()
 }
 
+func TestGetPayloadVersionCurrent(t *testing.T) {
+	headerSetup()
+
+	fBarbicanClient.stringResponse = "test-payload"
+	fDatabase.InjectRefs(&db.BarbicanRefs{SecretID: "test-secret-id", Version: db.FirstVersion})
+	fDatabase.InjectVersions(&db.BarbicanRefs{SecretID: "test-rotated-secret-id", Version: 2})
+
+	payload, version, state, err := testKeystore.GetPayloadVersion(context.Background(), "", definitions.CurrentVersion)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if payload != "test-payload" || version != 2 || state != secrets.Activation {
+		t.Errorf("Expected version 2 to be active, received version %d in state %d", version, state)
+	}
+
+	cleanUp()
+}
+
+func TestGetPayloadVersionCurrentPendingRotation(t *testing.T) {
+	headerSetup()
+
+	fBarbicanClient.stringResponse = "test-payload"
+	fBarbicanClient.checkOrderResponse = &client.CheckOrderResponse{KeyStatus: secrets.Preactivation}
+	fDatabase.InjectRefs(&db.BarbicanRefs{SecretID: "test-secret-id", Version: db.FirstVersion})
+	fDatabase.InjectVersions(&db.BarbicanRefs{OrderID: "test-order-id", Version: 2})
+
+	// the previous version stays current until the rotation order completes
+	_, version, state, err := testKeystore.GetPayloadVersion(context.Background(), "", definitions.CurrentVersion)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if version != db.FirstVersion || state != secrets.Activation {
+		t.Errorf("Expected version %d to be active, received version %d in state %d", db.FirstVersion, version, state)
+	}
+
+	cleanUp()
+}
+
+func TestGetPayloadVersionCurrentFailedRotation(t *testing.T) {
+	server := barbicantest.NewServer()
+	defer server.Close()
+
+	headers := &communications.Headers{BluemixSpace: "test-space", BluemixOrg: "test-org"}
+	s := &keystore{
+		barbicanClient: client.NewClient(server.URL, headers),
+		logger:         log.NewNopLogger(),
+		database:       db.NewMemoryDB(),
+		headers:        headers,
+	}
+
+	secretID, err := s.barbicanClient.PostSecret(context.Background(), &client.PostSecretRequest{Name: "test-key", Payload: "test-payload", PayloadContentType: constants.TextPlainMime})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	s.database.Add("test-space", "test-org", &db.BarbicanRefs{KpID: "test-key-id", SecretID: secretID})
+
+	// The order of the latest rotation was deleted from Barbican
+	s.database.Add("test-space", "test-org", &db.BarbicanRefs{KpID: "test-key-id", OrderID: "deleted-order-id", Version: 2})
+
+	payload, version, state, err := s.GetPayloadVersion(context.Background(), "test-key-id", definitions.CurrentVersion)
+	if err != nil || payload != "test-payload" || version != db.FirstVersion || state != secrets.Activation {
+		t.Errorf("Expected version %d to be active, received version %d in state %d, %+v", db.FirstVersion, version, state, err)
+	}
+
+	// Bad Path: no version resolves
+	server.InjectFault(barbicantest.Fault{Method: "GET", Path: "/v1/secrets", Status: http.StatusNotFound})
+	if _, _, _, err := s.GetPayloadVersion(context.Background(), "test-key-id", definitions.CurrentVersion); err == nil {
+		t.Error("Expected an error when no version resolves")
+	}
+}
+
+func TestGetPayloadVersionErrorVersionNotFound(t *testing.T) {
+	headerSetup()
+
+	fDatabase.InjectRefs(&db.BarbicanRefs{SecretID: "test-secret-id", Version: db.FirstVersion})
+
+	errMsgVersionNotFound := http.StatusText(http.StatusNotFound) + ": Key version not found"
+
+	_, _, _, errVersionNotFound := testKeystore.GetPayloadVersion(context.Background(), "", 3)
+	if errVersionNotFound == nil || errVersionNotFound.Error() != errMsgVersionNotFound {
+		t.Errorf("Expected %s, received %+v", errMsgVersionNotFound, errVersionNotFound)
+	}
+
+	cleanUp()
+}
+
+func TestRotateSecretErrorKeyNotFound(t *testing.T) {
+	headerSetup()
+
+	rotateTx := transactions.NewTransaction()
+	errMsgKeyNotFound := http.StatusText(http.StatusNotFound) + ": Key not found"
+
+	_, errKeyNotFound := testKeystore.RotateSecret(context.Background(), "", secrets.NewSecret(), &rotateTx)
+	if errKeyNotFound == nil || errKeyNotFound.Error() != errMsgKeyNotFound {
+		t.Errorf("Expected %s, received %+v", errMsgKeyNotFound, errKeyNotFound)
+	}
+
+	cleanUp()
+}
+
+func TestRotateSecretGoodPath(t *testing.T) {
+	headerSetup()
+
+	fBarbicanClient.stringResponse = "test-order-id"
+	fDatabase.InjectRefs(&db.BarbicanRefs{SecretID: "test-secret-id", Version: db.FirstVersion})
+	fDatabase.InjectVersions(&db.BarbicanRefs{SecretID: "test-rotated-secret-id", Version: 2})
+
+	rotateTx := transactions.NewTransaction()
+	version, err := testKeystore.RotateSecret(context.Background(), "test-id", secrets.NewSecret(), &rotateTx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	expectedRefs := &db.BarbicanRefs{KpID: "test-id", OrderID: "test-order-id", Version: 3}
+	if version != 3 || !reflect.DeepEqual(expectedRefs, fDatabase.addedRefs) {
+		t.Errorf("Expected %+v, received version %d with %+v", expectedRefs, version, fDatabase.addedRefs)
+	}
+
+	if len(rotateTx.RollbackOperations) != 1 {
+		t.Errorf("Expected 1 rollback operation, received %d", len(rotateTx.RollbackOperations))
+	}
+
+	cleanUp()
+}
+
+func TestRotateSecretPayload(t *testing.T) {
+	headerSetup()
+
+	fBarbicanClient.stringResponse = "test-rotated-secret-id"
+	fDatabase.InjectRefs(&db.BarbicanRefs{SecretID: "test-secret-id", Version: db.FirstVersion})
+
+	rotateTx := transactions.NewTransaction()
+	version, err := testKeystore.RotateSecretPayload(context.Background(), "test-id", secrets.NewSecret(), "test-payload", &rotateTx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// the version holds the secret rather than an order, so it is active at once
+	expectedRefs := &db.BarbicanRefs{KpID: "test-id", SecretID: "test-rotated-secret-id", Version: 2}
+	if version != 2 || !reflect.DeepEqual(expectedRefs, fDatabase.addedRefs) {
+		t.Errorf("Expected %+v, received version %d with %+v", expectedRefs, version, fDatabase.addedRefs)
+	}
+
+	if len(rotateTx.RollbackOperations) != 1 {
+		t.Errorf("Expected 1 rollback operation, received %d", len(rotateTx.RollbackOperations))
+	}
+
+	cleanUp()
+}
+
 func TestCeateIDErrorNoRefs(t *testing.T) {
 	errMsgNoRefs := http.StatusText(http.StatusInternalServerError) + ": Request requires translation references"
 
@@ -431,7 +672,7 @@ func TestGenerateSecretErrorPost(t *testing.T) {
 	postErr := errors.New("test-post-error")
 	fBarbicanClient.InjectError(postErr)
 
-	_, errPostErr := generateSecret(testKeystore, testSecret)
+	_, errPostErr := generateSecret(context.Background(), testKeystore, testSecret)
 	if errPostErr != postErr {
 		t.Errorf("Expected %s, received %+v", postErr.Error(), errPostErr)
 	}
@@ -447,7 +688,7 @@ func TestGenerateSecretErrorBluemixSpaceRequired(t *testing.T) {
 	// test no Bluemix-Space header
 	errMsgBluemixSpaceRequired := http.StatusText(http.StatusBadRequest) + ": Header Bluemix-Space required"
 
-	_, errBluemixSpaceRequired := generateSecret(testKeystore, testSecret)
+	_, errBluemixSpaceRequired := generateSecret(context.Background(), testKeystore, testSecret)
 	if errBluemixSpaceRequired == nil || errBluemixSpaceRequired.Error() != errMsgBluemixSpaceRequired {
 		t.Errorf("Expected %s, received %+v", errMsgBluemixSpaceRequired, errBluemixSpaceRequired)
 	}
@@ -466,7 +707,7 @@ func TestGenerateSecretErrorBluemixOrgRequired(t *testing.T) {
 	// test no Bluemix-Org header
 	errMsgBluemixOrgRequired := http.StatusText(http.StatusBadRequest) + ": Header Bluemix-Org required"
 
-	_, errBluemixOrgRequired := generateSecret(testKeystore, testSecret)
+	_, errBluemixOrgRequired := generateSecret(context.Background(), testKeystore, testSecret)
 	if errBluemixOrgRequired == nil || errBluemixOrgRequired.Error() != errMsgBluemixOrgRequired {
 		t.Errorf("Expected %s, received %+v", errMsgBluemixOrgRequired, errBluemixOrgRequired)
 	}
@@ -484,7 +725,7 @@ func TestGenerateSecret(t *testing.T) {
 
 	nilOverwriteForAdd = true
 
-	_, errGoodPath := generateSecret(testKeystore, testSecret)
+	_, errGoodPath := generateSecret(context.Background(), testKeystore, testSecret)
 	if errGoodPath != nil {
 		t.Error("Unexpected Error")
 	}
@@ -492,6 +733,53 @@ func TestGenerateSecret(t *testing.T) {
 	cleanUp()
 }
 
+func TestGenerateSecretAlgorithm(t *testing.T) {
+	testSecret := secrets.NewSecret()
+	testSecret.AlgorithmType = "aes"
+	testSecret.AlgorithmMetadata = map[string]string{"bitLength": "128", "mode": "cbc"}
+
+	headerSetup()
+
+	// used to break out of loop
+	fDatabase.InjectError(db.ErrNotFound)
+
+	nilOverwriteForAdd = true
+
+	if _, err := generateSecret(context.Background(), testKeystore, testSecret); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	meta := fBarbicanClient.postedOrder.Meta
+	if meta.Algorithm != "aes" || meta.BitLength != 128 || meta.Mode != "CBC" {
+		t.Errorf("Expected an order for aes 128 CBC, received %+v", meta)
+	}
+
+	// the resolved algorithm is recorded for the metadata table
+	if testSecret.AlgorithmType != "AES" || testSecret.AlgorithmMetadata["mode"] != "CBC" {
+		t.Errorf("Expected AES CBC, received %s %+v", testSecret.AlgorithmType, testSecret.AlgorithmMetadata)
+	}
+
+	cleanUp()
+}
+
+func TestGenerateSecretErrorUnsupportedAlgorithm(t *testing.T) {
+	testSecret := secrets.NewSecret()
+	testSecret.AlgorithmType = "AES"
+	testSecret.AlgorithmMetadata = map[string]string{"bitLength": "512"}
+
+	headerSetup()
+
+	if _, err := generateSecret(context.Background(), testKeystore, testSecret); err == nil || !strings.HasPrefix(err.Error(), http.StatusText(http.StatusBadRequest)) {
+		t.Errorf("Expected a bad request, received %+v", err)
+	}
+
+	if fBarbicanClient.postedOrder != nil {
+		t.Error("Expected no order to be posted")
+	}
+
+	cleanUp()
+}
+
 func TestDeleteIDErrorBadType(t *testing.T) {
 	// test incorrect interface type
 	errMsg := "Requires type *keystore, received string"
@@ -514,7 +802,7 @@ func TestDeleteSecretErrorBluemixSpaceRequired(t *testing.T) {
 	// test no Bluemix-Space header
 	errMsgBluemixSpaceRequired := http.StatusText(http.StatusBadRequest) + ": Header Bluemix-Space required"
 
-	errBluemixSpaceRequired := testKeystore.DeleteSecret("", nil)
+	errBluemixSpaceRequired := testKeystore.DeleteSecret(context.Background(), "", nil)
 	if errBluemixSpaceRequired == nil || errBluemixSpaceRequired.Error() != errMsgBluemixSpaceRequired {
 		t.Errorf("Expected %s, received %+v", errMsgBluemixSpaceRequired, errBluemixSpaceRequired)
 	}
@@ -531,7 +819,7 @@ func TestDeleteSecretErrorBluemixOrgRequired(t *testing.T) {
 	// test no Bluemix-Org header
 	errMsgBluemixOrgRequired := http.StatusText(http.StatusBadRequest) + ": Header Bluemix-Org required"
 
-	errBluemixOrgRequired := testKeystore.DeleteSecret("", nil)
+	errBluemixOrgRequired := testKeystore.DeleteSecret(context.Background(), "", nil)
 	if errBluemixOrgRequired == nil || errBluemixOrgRequired.Error() != errMsgBluemixOrgRequired {
 		t.Errorf("Expected %s, received %+v", errMsgBluemixOrgRequired, errBluemixOrgRequired)
 	}
@@ -544,7 +832,7 @@ func TestDeleteSecretErrorKeyNotFound(t *testing.T) {
 
 	errMsgKeyNotFound := http.StatusText(http.StatusNotFound) + ": Key not found"
 
-	errKeyNotFound := testKeystore.DeleteSecret("", nil)
+	errKeyNotFound := testKeystore.DeleteSecret(context.Background(), "", nil)
 	if errKeyNotFound == nil || errKeyNotFound.Error() != errMsgKeyNotFound {
 		t.Errorf("Expected %s, received %+v", errMsgKeyNotFound, errKeyNotFound)
 	}
@@ -564,7 +852,7 @@ func TestDeleteSecretErrorOrderCheckOrder(t *testing.T) {
 	}
 	fDatabase.InjectRefs(testOrderRefs)
 
-	errCheckOrder := testKeystore.DeleteSecret("", nil)
+	errCheckOrder := testKeystore.DeleteSecret(context.Background(), "", nil)
 	if errCheckOrder == nil || errCheckOrder.Error() != testErrorCheckOrder.Error() {
 		t.Errorf("Expected %s, received %+v", testErrorCheckOrder.Error(), errCheckOrder)
 	}
@@ -583,7 +871,7 @@ func TestDeleteSecretErrorDelete(t *testing.T) {
 	}
 	fDatabase.InjectRefs(testSecretRefs)
 
-	errDelete := testKeystore.DeleteSecret("", nil)
+	errDelete := testKeystore.DeleteSecret(context.Background(), "", nil)
 	if errDelete != testErrorDelete {
 		t.Errorf("Expected %s, received %+v", testErrorDelete.Error(), errDelete)
 	}
@@ -597,7 +885,7 @@ func TestCheckSecretErrorBluemixSpaceRequired(t *testing.T) {
 	// test no Bluemix-Space header
 	errMsgBluemixSpaceRequired := http.StatusText(http.StatusBadRequest) + ": Header Bluemix-Space required"
 
-	_, errBluemixSpaceRequired := testKeystore.CheckSecret("")
+	_, errBluemixSpaceRequired := testKeystore.CheckSecret(context.Background(), "")
 	if errBluemixSpaceRequired == nil || errBluemixSpaceRequired.Error() != errMsgBluemixSpaceRequired {
 		t.Errorf("Expected %s, received %+v", errMsgBluemixSpaceRequired, errBluemixSpaceRequired)
 	}
@@ -614,7 +902,7 @@ func TestCheckSecretErrorBluemixOrgRequired(t *testing.T) {
 	// test no Bluemix-Org header
 	errMsgBluemixOrgRequired := http.StatusText(http.StatusBadRequest) + ": Header Bluemix-Org required"
 
-	_, errBluemixOrgRequired := testKeystore.CheckSecret("")
+	_, errBluemixOrgRequired := testKeystore.CheckSecret(context.Background(), "")
 	if errBluemixOrgRequired == nil || errBluemixOrgRequired.Error() != errMsgBluemixOrgRequired {
 		t.Errorf("Expected %s, received %+v", errMsgBluemixOrgRequired, errBluemixOrgRequired)
 	}
@@ -627,7 +915,7 @@ func TestCheckSecretErrorKeyNotFound(t *testing.T) {
 
 	errMsgKeyNotFound := http.StatusText(http.StatusNotFound) + ": Key not found"
 
-	_, errKeyNotFound := testKeystore.CheckSecret("")
+	_, errKeyNotFound := testKeystore.CheckSecret(context.Background(), "")
 	if errKeyNotFound == nil || errKeyNotFound.Error() != errMsgKeyNotFound {
 		t.Errorf("Expected %s, received %+v", errMsgKeyNotFound, errKeyNotFound)
 	}
@@ -647,7 +935,7 @@ func TestDeleteSecretErrorCheckOrder(t *testing.T) {
 	}
 	fDatabase.InjectRefs(testOrderRefs)
 
-	_, errCheckOrder := testKeystore.CheckSecret("")
+	_, errCheckOrder := testKeystore.CheckSecret(context.Background(), "")
 	if errCheckOrder == nil || errCheckOrder.Error() != testErrorCheckOrder.Error() {
 		t.Errorf("Expected %s, received %+v", testErrorCheckOrder.Error(), errCheckOrder)
 	}
@@ -664,7 +952,7 @@ func TestCheckSecretGoodPathSecret(t *testing.T) {
 	fDatabase.InjectRefs(testSecretRefs)
 
 	// Good
-	_, errCheck := testKeystore.CheckSecret("")
+	_, errCheck := testKeystore.CheckSecret(context.Background(), "")
 	if errCheck != nil {
 		t.Error("Unexpected Error")
 	}
@@ -674,8 +962,126 @@ func TestCheckSecretGoodPathSecret(t *testing.T) {
 
 func TestNewBarbicanKeystore(t *testing.T) {
 	// internal tests used as a safety gaurd for panics
-	keystore := NewBarbicanKeystore(nil, nil)
-	if keystore == nil {
+	keystore, err := NewBarbicanKeystore(nil, nil)
+	if err != nil || keystore == nil {
 		t.Fail()
 	}
 }
+
+func TestResolvePayloadBarbicanServer(t *testing.T) {
+	server := barbicantest.NewServer()
+	defer server.Close()
+	server.SetOrderPolls(1)
+
+	headers := &communications.Headers{BluemixSpace: "test-space", BluemixOrg: "test-org"}
+	s := &keystore{
+		barbicanClient: client.NewClient(server.URL, headers),
+		logger:         log.NewNopLogger(),
+		database:       new(fDB),
+		headers:        headers,
+	}
+
+	spec, err := algorithms.Resolve("aes", map[string]string{"bitLength": "256"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	orderID, err := postKeyOrder(context.Background(), s, "test-key", spec)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// The key is pending until Barbican completes the order
+	refs := &db.BarbicanRefs{OrderID: orderID}
+	if _, state, err := resolvePayload(context.Background(), s, refs); err != nil || state != secrets.Preactivation {
+		t.Fatalf("Expected a pending key, received %d, %+v", state, err)
+	}
+
+	payload, state, err := resolvePayload(context.Background(), s, refs)
+	if err != nil || state != secrets.Activation || refs.SecretID == "" {
+		t.Fatalf("Expected an active key, received %d, %+v", state, err)
+	}
+
+	material, _ := server.Payload(refs.SecretID)
+	if payload != base64.StdEncoding.EncodeToString(material) {
+		t.Errorf("Expected the material Barbican generated, received %s", payload)
+	}
+}
+
+func TestKeystoreBarbicanServer(t *testing.T) {
+	server := barbicantest.NewServer()
+	defer server.Close()
+
+	headers := &communications.Headers{BluemixSpace: "test-space", BluemixOrg: "test-org"}
+	database := db.NewMemoryDB()
+	s := &keystore{
+		barbicanClient: client.NewClient(server.URL, headers),
+		logger:         log.NewNopLogger(),
+		database:       database,
+		headers:        headers,
+	}
+
+	testSecret := secrets.NewSecret()
+	testSecret.Name = "test-secret"
+	testSecret.Payload = "test-payload"
+	createTx := transactions.NewTransaction()
+	importedID, err := s.CreateSecret(context.Background(), testSecret, &createTx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	generatedID, err := s.CreateSecret(context.Background(), secrets.NewSecret(), &createTx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if payload, state, err := s.GetPayload(context.Background(), importedID); err != nil || state != secrets.Activation || payload != "test-payload" {
+		t.Errorf("Expected test-payload, received %s, %d, %+v", payload, state, err)
+	}
+
+	// The secret of the completed order is recorded in the database
+	if _, state, err := s.GetPayload(context.Background(), generatedID); err != nil || state != secrets.Activation {
+		t.Errorf("Expected an active key, received %d, %+v", state, err)
+	}
+	if refs, _ := database.Get("test-space", "test-org", generatedID); refs == nil || refs.SecretID == "" {
+		t.Errorf("Expected the secret of the order, received %+v", refs)
+	}
+
+	if err := s.DeleteSecret(context.Background(), importedID, &createTx); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if server.Secrets() != 1 {
+		t.Errorf("Expected only the generated secret to remain, received %d secrets", server.Secrets())
+	}
+	if _, _, err := s.GetPayload(context.Background(), importedID); err == nil {
+		t.Error("Expected an error getting a deleted key")
+	}
+}
+
+func TestServiceSession(t *testing.T) {
+	defer func() { sharedSession = nil }()
+	sharedSession = nil
+
+	errOpen := errors.New("keystone unavailable")
+	opened := 0
+	session := new(keystone.Session)
+
+	// Bad Path: a session that fails to open is not kept
+	if _, err := serviceSession(func() (*keystone.Session, error) { opened++; return nil, errOpen }); err != errOpen {
+		t.Errorf("Expected %s, received %+v", errOpen, err)
+	}
+
+	// the next request opens it again, and the session is then shared
+	for i := 0; i < 2; i++ {
+		received, err := serviceSession(func() (*keystone.Session, error) { opened++; return session, nil })
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		if received != session {
+			t.Errorf("Expected %p, received %p", session, received)
+		}
+	}
+
+	if opened != 2 {
+		t.Errorf("Expected the session to be opened 2 times, opened %d", opened)
+	}
+}
@@ -0,0 +1,547 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+// Package barbicantest runs an in-process fake of the Barbican API, so the Barbican client and keystore can be
+// tested over HTTP without an OpenStack deployment. The server keeps secrets, orders, containers and secret
+// metadata in memory, completes orders after they are checked a configurable number of times, and fails requests
+// on demand.
+package barbicantest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/keystone"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-consts"
+)
+
+// Order states, as Barbican reports them
+const (
+	StatePending = "PENDING"
+	StateActive  = "ACTIVE"
+	StateError   = "ERROR"
+)
+
+// Paths of the API, as the client calls them
+const (
+	secretsPath    = "secrets"
+	ordersPath     = "orders"
+	containersPath = "containers"
+	payloadPath    = "payload"
+	metadataPath   = "metadata"
+)
+
+// Fault makes the server fail matching requests with Status instead of serving them, after holding them for Delay
+type Fault struct {
+	// Method matches requests of the method, or of any method if it is empty
+	Method string
+	// Path matches requests whose path starts with it, or any path if it is empty
+	Path   string
+	Status int
+	Delay  time.Duration
+	// Times is how many requests fail before the fault is cleared, or 0 to fail every matching request
+	Times int
+}
+
+type secret struct {
+	name        string
+	contentType string
+	secretType  string
+	payload     []byte
+	metadata    map[string]string
+}
+
+type orderMeta struct {
+	Name               string `json:"name"`
+	Algorithm          string `json:"algorithm"`
+	BitLength          int    `json:"bit_length"`
+	Mode               string `json:"mode"`
+	PayloadContentType string `json:"payload_content_type"`
+}
+
+type order struct {
+	Type  string    `json:"type"`
+	Meta  orderMeta `json:"meta"`
+	polls int
+
+	status      string
+	secretID    string
+	errorReason string
+	errorCode   int
+}
+
+type secretRef struct {
+	Name string `json:"name"`
+	Ref  string `json:"secret_ref"`
+}
+
+type container struct {
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	SecretRefs []secretRef `json:"secret_refs"`
+}
+
+// Server is a fake Barbican. Its URL is the host a client is created with.
+type Server struct {
+	*httptest.Server
+
+	lock       sync.Mutex
+	token      string
+	orderPolls int
+	secrets    map[string]*secret
+	orders     map[string]*order
+	containers map[string]*container
+	faults     []*Fault
+	requests   []string
+}
+
+// NewServer starts a fake Barbican with no secrets, whose orders complete the first time they are checked
+func NewServer() *Server {
+	s := &Server{
+		secrets:    make(map[string]*secret),
+		orders:     make(map[string]*order),
+		containers: make(map[string]*container),
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// RequireToken makes the server refuse requests that do not carry the token as a Keystone token or as the bearer
+// token of the Authorization header. An empty token accepts every request.
+func (s *Server) RequireToken(token string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.token = token
+}
+
+// SetOrderPolls sets how many times new orders are checked as PENDING before they complete. Orders with a negative
+// number of polls are left PENDING until CompleteOrder or FailOrder is called.
+func (s *Server) SetOrderPolls(polls int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.orderPolls = polls
+}
+
+// InjectFault fails matching requests until the fault runs out or ClearFaults is called. Faults are matched in
+// the order they were injected.
+func (s *Server) InjectFault(fault Fault) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+// ClearFaults serves every request again
+func (s *Server) ClearFaults() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults = nil
+}
+
+// Requests returns the method and path of every request served so far, faulted or not
+func (s *Server) Requests() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// CompleteOrder generates the secret of a pending order
+func (s *Server) CompleteOrder(orderID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pending, ok := s.orders[orderID]
+	if !ok || pending.status != StatePending {
+		return fmt.Errorf("No pending order %s", orderID)
+	}
+	return s.completeOrder(pending)
+}
+
+// FailOrder moves a pending order to the ERROR state with the status code and reason
+func (s *Server) FailOrder(orderID string, status int, reason string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pending, ok := s.orders[orderID]
+	if !ok || pending.status != StatePending {
+		return fmt.Errorf("No pending order %s", orderID)
+	}
+	pending.status = StateError
+	pending.errorCode = status
+	pending.errorReason = reason
+	return nil
+}
+
+// Payload returns the payload of a secret as it is stored, decoded from base64 for binary secrets
+func (s *Server) Payload(secretID string) ([]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stored, ok := s.secrets[secretID]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), stored.payload...), true
+}
+
+// Secrets returns how many secrets the server holds
+func (s *Server) Secrets() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.secrets)
+}
+
+// Orders returns how many orders the server holds
+func (s *Server) Orders() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.orders)
+}
+
+// Containers returns how many containers the server holds
+func (s *Server) Containers() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.containers)
+}
+
+// newID returns a random UUID, as Barbican names its entities
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// ref returns the full ref of an entity, as Barbican returns it
+func (s *Server) ref(collection, id string) string {
+	return s.URL + "/v1/" + collection + "/" + id
+}
+
+// writeError writes an error in the format of Barbican
+func writeError(w http.ResponseWriter, status int, description string) {
+	writeJSON(w, status, map[string]interface{}{
+		"code":        status,
+		"title":       http.StatusText(status),
+		"description": description,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set(constants.ContentTypeHeader, constants.AppJSONMime)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// fault returns the fault matching the request, using up one of its times. The lock must be held.
+func (s *Server) fault(r *http.Request) *Fault {
+	for i, fault := range s.faults {
+		if (fault.Method != "" && fault.Method != r.Method) || !strings.HasPrefix(r.URL.Path, fault.Path) {
+			continue
+		}
+
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return fault
+	}
+	return nil
+}
+
+// authorized reports whether the request carries the required token. The lock must be held.
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	return r.Header.Get(keystone.TokenHeader) == s.token || r.Header.Get(constants.AuthorizationHeader) == "Bearer "+s.token
+}
+
+// ServeHTTP serves the API of Barbican under /v1
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	fault := s.fault(r)
+	authorized := s.authorized(r)
+	s.lock.Unlock()
+
+	if fault != nil {
+		select {
+		case <-time.After(fault.Delay):
+		case <-r.Context().Done():
+			return
+		}
+		if fault.Status != 0 {
+			writeError(w, fault.Status, "Injected fault")
+			return
+		}
+	}
+
+	if !authorized {
+		writeError(w, http.StatusUnauthorized, "The request you have made requires authentication.")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v1" {
+		writeError(w, http.StatusNotFound, "The resource could not be found.")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch parts[1] {
+	case secretsPath:
+		s.serveSecrets(w, r, parts[2:])
+	case ordersPath:
+		s.serveOrders(w, r, parts[2:])
+	case containersPath:
+		s.serveContainers(w, r, parts[2:])
+	default:
+		writeError(w, http.StatusNotFound, "The resource could not be found.")
+	}
+}
+
+func (s *Server) serveSecrets(w http.ResponseWriter, r *http.Request, parts []string) {
+	switch {
+	case len(parts) == 0 && r.Method == "POST":
+		s.postSecret(w, r)
+	case len(parts) == 0:
+		writeError(w, http.StatusMethodNotAllowed, "The method is not allowed for this resource.")
+	case s.secrets[parts[0]] == nil:
+		writeError(w, http.StatusNotFound, "Not Found. Sorry but your secret is in another castle.")
+	case len(parts) == 1 && r.Method == "DELETE":
+		delete(s.secrets, parts[0])
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && parts[1] == payloadPath && r.Method == "GET":
+		s.getPayload(w, r, s.secrets[parts[0]])
+	case len(parts) == 2 && parts[1] == metadataPath && r.Method == "PUT":
+		s.putMetadata(w, r, s.secrets[parts[0]])
+	case len(parts) == 2 && parts[1] == metadataPath && r.Method == "GET":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"metadata": s.secrets[parts[0]].metadata})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "The method is not allowed for this resource.")
+	}
+}
+
+func (s *Server) postSecret(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name                   string `json:"name"`
+		Payload                string `json:"payload"`
+		PayloadContentType     string `json:"payload_content_type"`
+		PayloadContentEncoding string `json:"payload_content_encoding"`
+		SecretType             string `json:"secret_type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Provided object does not match schema 'Secret': "+err.Error())
+		return
+	}
+
+	if body.Payload == "" {
+		writeError(w, http.StatusBadRequest, "Provided object does not match schema 'Secret': If 'payload' specified, must be non empty")
+		return
+	}
+
+	stored := &secret{name: body.Name, contentType: body.PayloadContentType, secretType: body.SecretType, metadata: make(map[string]string)}
+	switch body.PayloadContentType {
+	case constants.TextPlainMime:
+		stored.payload = []byte(body.Payload)
+	case constants.OctetStreamMime:
+		payload, err := base64.StdEncoding.DecodeString(body.Payload)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Problem decoding payload")
+			return
+		}
+		stored.payload = payload
+	default:
+		writeError(w, http.StatusBadRequest, "Provided object does not match schema 'Secret': payload_content_type is not one of the supported types")
+		return
+	}
+	if stored.secretType == "" {
+		stored.secretType = "opaque"
+	}
+
+	id := newID()
+	s.secrets[id] = stored
+	writeJSON(w, http.StatusCreated, map[string]string{"secret_ref": s.ref(secretsPath, id)})
+}
+
+func (s *Server) getPayload(w http.ResponseWriter, r *http.Request, stored *secret) {
+	if accept := r.Header.Get(constants.AcceptHeader); accept != stored.contentType {
+		writeError(w, http.StatusNotAcceptable, "Wrong payload content-type")
+		return
+	}
+
+	w.Header().Set(constants.ContentTypeHeader, stored.contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(stored.payload)
+}
+
+func (s *Server) putMetadata(w http.ResponseWriter, r *http.Request, stored *secret) {
+	var body struct {
+		Metadata map[string]string `json:"metadata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Metadata == nil {
+		writeError(w, http.StatusBadRequest, "Provided object does not match schema 'Metadata'")
+		return
+	}
+
+	stored.metadata = body.Metadata
+	writeJSON(w, http.StatusCreated, map[string]string{"metadata_ref": r.URL.Path})
+}
+
+func (s *Server) serveOrders(w http.ResponseWriter, r *http.Request, parts []string) {
+	switch {
+	case len(parts) == 0 && r.Method == "POST":
+		s.postOrder(w, r)
+	case len(parts) != 1:
+		writeError(w, http.StatusNotFound, "The resource could not be found.")
+	case s.orders[parts[0]] == nil:
+		writeError(w, http.StatusNotFound, "Not Found. Sorry but your order is in another castle.")
+	case r.Method == "GET":
+		s.getOrder(w, parts[0], s.orders[parts[0]])
+	case r.Method == "DELETE":
+		delete(s.orders, parts[0])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "The method is not allowed for this resource.")
+	}
+}
+
+func (s *Server) postOrder(w http.ResponseWriter, r *http.Request) {
+	posted := new(order)
+	if err := json.NewDecoder(r.Body).Decode(posted); err != nil {
+		writeError(w, http.StatusBadRequest, "Provided object does not match schema 'Order': "+err.Error())
+		return
+	}
+
+	if posted.Type != "key" {
+		writeError(w, http.StatusBadRequest, "Provided object does not match schema 'Order': 'type' must be 'key'")
+		return
+	}
+	if posted.Meta.Algorithm == "" || posted.Meta.BitLength <= 0 || posted.Meta.BitLength%8 != 0 {
+		writeError(w, http.StatusBadRequest, "Provided object does not match schema 'Order': Must have non-zero positive bit_length to generate secret")
+		return
+	}
+
+	posted.status = StatePending
+	posted.polls = s.orderPolls
+	id := newID()
+	s.orders[id] = posted
+	writeJSON(w, http.StatusAccepted, map[string]string{"order_ref": s.ref(ordersPath, id)})
+}
+
+// completeOrder generates the secret of the order. The lock must be held.
+func (s *Server) completeOrder(pending *order) error {
+	material := make([]byte, pending.Meta.BitLength/8)
+	if _, err := rand.Read(material); err != nil {
+		return err
+	}
+
+	contentType := pending.Meta.PayloadContentType
+	if contentType == "" {
+		contentType = constants.OctetStreamMime
+	}
+
+	id := newID()
+	s.secrets[id] = &secret{name: pending.Meta.Name, contentType: contentType, secretType: "symmetric", payload: material, metadata: make(map[string]string)}
+	pending.status = StateActive
+	pending.secretID = id
+	return nil
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, id string, checked *order) {
+	if checked.status == StatePending {
+		switch {
+		case checked.polls == 0:
+			if err := s.completeOrder(checked); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		case checked.polls > 0:
+			checked.polls--
+		}
+	}
+
+	body := map[string]interface{}{
+		"order_ref": s.ref(ordersPath, id),
+		"type":      checked.Type,
+		"meta":      checked.Meta,
+		"status":    checked.status,
+	}
+	if checked.secretID != "" {
+		body["secret_ref"] = s.ref(secretsPath, checked.secretID)
+	}
+	if checked.status == StateError {
+		body["error_status_code"] = fmt.Sprint(checked.errorCode)
+		body["error_reason"] = checked.errorReason
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (s *Server) serveContainers(w http.ResponseWriter, r *http.Request, parts []string) {
+	switch {
+	case len(parts) == 0 && r.Method == "POST":
+		s.postContainer(w, r)
+	case len(parts) != 1:
+		writeError(w, http.StatusNotFound, "The resource could not be found.")
+	case s.containers[parts[0]] == nil:
+		writeError(w, http.StatusNotFound, "Not Found. Sorry but your container is in another castle.")
+	case r.Method == "GET":
+		body := *s.containers[parts[0]]
+		writeJSON(w, http.StatusOK, &struct {
+			container
+			Ref    string `json:"container_ref"`
+			Status string `json:"status"`
+		}{body, s.ref(containersPath, parts[0]), StateActive})
+	case r.Method == "DELETE":
+		delete(s.containers, parts[0])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "The method is not allowed for this resource.")
+	}
+}
+
+func (s *Server) postContainer(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	posted := new(container)
+	if err := json.Unmarshal(body, posted); err != nil {
+		writeError(w, http.StatusBadRequest, "Provided object does not match schema 'Container': "+err.Error())
+		return
+	}
+
+	switch posted.Type {
+	case "generic", "rsa", "certificate":
+	default:
+		writeError(w, http.StatusBadRequest, "Provided object does not match schema 'Container': 'type' is not one of generic, rsa or certificate")
+		return
+	}
+
+	// Every secret must be held by the server, under the same host
+	for _, ref := range posted.SecretRefs {
+		prefix := s.ref(secretsPath, "")
+		if !strings.HasPrefix(ref.Ref, prefix) || s.secrets[strings.TrimPrefix(ref.Ref, prefix)] == nil {
+			writeError(w, http.StatusNotFound, "Secret provided for '"+ref.Name+"' doesn't exist.")
+			return
+		}
+	}
+
+	id := newID()
+	s.containers[id] = posted
+	writeJSON(w, http.StatusCreated, map[string]string{"container_ref": s.ref(containersPath, id)})
+}
@@ -0,0 +1,263 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package barbicantest_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/barbicantest"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/client"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-consts"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+// newTestOrder returns an order for an AES key of the bit length
+func newTestOrder(bitLength int32) *client.PostOrderRequest {
+	return &client.PostOrderRequest{
+		Type: "key",
+		Meta: &client.OrderMeta{
+			Name:               "test-key",
+			Algorithm:          "aes",
+			BitLength:          bitLength,
+			Mode:               "cbc",
+			PayloadContentType: constants.OctetStreamMime,
+		},
+	}
+}
+
+func TestSecret(t *testing.T) {
+	server := barbicantest.NewServer()
+	defer server.Close()
+
+	barbican := client.NewClient(server.URL, &communications.Headers{})
+	secretID, err := barbican.PostSecret(context.Background(), &client.PostSecretRequest{
+		Name:               "test-secret",
+		Payload:            "test-payload",
+		PayloadContentType: constants.TextPlainMime,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	payload, err := barbican.GetPayload(context.Background(), secretID, constants.TextPlainMime)
+	if err != nil || payload != "test-payload" {
+		t.Errorf("Expected test-payload, received %s, %+v", payload, err)
+	}
+
+	// Bad Path: the payload is only served as the type it was stored with
+	if _, err := barbican.GetPayload(context.Background(), secretID, constants.OctetStreamMime); err == nil || !strings.HasPrefix(err.Error(), http.StatusText(http.StatusNotAcceptable)) {
+		t.Errorf("Expected a Not Acceptable error, received %+v", err)
+	}
+
+	if err := barbican.DeleteSecret(context.Background(), secretID); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if server.Secrets() != 0 {
+		t.Errorf("Expected the secret to be deleted, received %d secrets", server.Secrets())
+	}
+
+	// Bad Path: the secret is gone
+	if err := barbican.DeleteSecret(context.Background(), secretID); err == nil {
+		t.Error("Expected an error deleting a missing secret")
+	}
+
+	// Bad Path: Barbican refuses empty payloads
+	if _, err := barbican.PostSecret(context.Background(), &client.PostSecretRequest{Name: "empty", PayloadContentType: constants.TextPlainMime}); err == nil {
+		t.Error("Expected an error storing an empty payload")
+	}
+}
+
+func TestOrder(t *testing.T) {
+	server := barbicantest.NewServer()
+	defer server.Close()
+	server.SetOrderPolls(2)
+
+	barbican := client.NewClient(server.URL, &communications.Headers{})
+	orderID, err := barbican.PostOrder(context.Background(), newTestOrder(256))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// The order is pending for as many checks as the server was set to
+	for i := 0; i < 2; i++ {
+		order, err := barbican.CheckOrder(context.Background(), orderID)
+		if err != nil || order.KeyStatus != secrets.Preactivation {
+			t.Fatalf("Expected a pending order, received %+v, %+v", order, err)
+		}
+	}
+
+	order, err := barbican.CheckOrder(context.Background(), orderID)
+	if err != nil || order.KeyStatus != secrets.Activation {
+		t.Fatalf("Expected an active order, received %+v, %+v", order, err)
+	}
+
+	payload, err := barbican.GetPayload(context.Background(), order.SecretRef, constants.OctetStreamMime)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if material, _ := base64.StdEncoding.DecodeString(payload); len(material) != 32 {
+		t.Errorf("Expected a 256 bit key, received %d bytes", len(material))
+	}
+
+	if err := barbican.DeleteOrder(context.Background(), orderID); err != nil || server.Orders() != 0 {
+		t.Errorf("Expected the order to be deleted, received %+v", err)
+	}
+
+	// Bad Path: bit lengths must be whole bytes
+	if _, err := barbican.PostOrder(context.Background(), newTestOrder(100)); err == nil || !strings.HasPrefix(err.Error(), http.StatusText(http.StatusBadRequest)) {
+		t.Errorf("Expected a Bad Request error, received %+v", err)
+	}
+}
+
+func TestOrderControl(t *testing.T) {
+	server := barbicantest.NewServer()
+	defer server.Close()
+	server.SetOrderPolls(-1)
+
+	barbican := client.NewClient(server.URL, &communications.Headers{})
+	completed, _ := barbican.PostOrder(context.Background(), newTestOrder(128))
+	failed, _ := barbican.PostOrder(context.Background(), newTestOrder(128))
+
+	if order, _ := barbican.CheckOrder(context.Background(), completed); order == nil || order.KeyStatus != secrets.Preactivation {
+		t.Fatalf("Expected the order to stay pending, received %+v", order)
+	}
+
+	if err := server.CompleteOrder(completed); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := server.FailOrder(failed, http.StatusInternalServerError, "HSM unavailable"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if order, _ := barbican.CheckOrder(context.Background(), completed); order == nil || order.KeyStatus != secrets.Activation {
+		t.Errorf("Expected an active order, received %+v", order)
+	}
+	if order, _ := barbican.CheckOrder(context.Background(), failed); order == nil || order.KeyStatus != secrets.GenerationError {
+		t.Errorf("Expected a failed order, received %+v", order)
+	}
+
+	// Bad Path: orders complete once
+	if err := server.CompleteOrder(completed); err == nil {
+		t.Error("Expected an error completing an active order")
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	server := barbicantest.NewServer()
+	defer server.Close()
+
+	barbican := client.NewClient(server.URL, &communications.Headers{})
+	secretID, _ := barbican.PostSecret(context.Background(), &client.PostSecretRequest{Name: "test-secret", Payload: "test-payload", PayloadContentType: constants.TextPlainMime})
+
+	if err := barbican.PutMetadata(context.Background(), secretID, map[string]string{"kp-name": "test-key"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	metadata, err := barbican.GetMetadata(context.Background(), secretID)
+	if err != nil || metadata["kp-name"] != "test-key" {
+		t.Errorf("Expected test-key, received %v, %+v", metadata, err)
+	}
+
+	// Bad Path: unknown secret
+	if _, err := barbican.GetMetadata(context.Background(), "missing-id"); err == nil || !strings.HasPrefix(err.Error(), http.StatusText(http.StatusNotFound)) {
+		t.Errorf("Expected a Not Found error, received %+v", err)
+	}
+}
+
+func TestContainer(t *testing.T) {
+	server := barbicantest.NewServer()
+	defer server.Close()
+
+	barbican := client.NewClient(server.URL, &communications.Headers{})
+	certificateID, _ := barbican.PostSecret(context.Background(), &client.PostSecretRequest{Name: "test", Payload: "certificate", PayloadContentType: constants.TextPlainMime, SecretType: client.CertificateSecretType})
+	privateKeyID, _ := barbican.PostSecret(context.Background(), &client.PostSecretRequest{Name: "test", Payload: "private-key", PayloadContentType: constants.TextPlainMime, SecretType: client.PrivateSecretType})
+
+	containerID, err := barbican.PostContainer(context.Background(), &client.PostContainerRequest{
+		Name:    "test-certificate",
+		Type:    client.CertificateContainer,
+		Secrets: map[string]string{client.CertificateSecret: certificateID, client.PrivateKeySecret: privateKeyID},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	container, err := barbican.GetContainer(context.Background(), containerID)
+	if err != nil || container.Secrets[client.CertificateSecret] != certificateID || container.Secrets[client.PrivateKeySecret] != privateKeyID {
+		t.Errorf("Expected the secrets of the container, received %+v, %+v", container, err)
+	}
+
+	if err := barbican.DeleteContainer(context.Background(), containerID); err != nil || server.Containers() != 0 {
+		t.Errorf("Expected the container to be deleted, received %+v", err)
+	}
+
+	// Bad Path: containers hold secrets the server has
+	_, err = barbican.PostContainer(context.Background(), &client.PostContainerRequest{
+		Name:    "test-certificate",
+		Type:    client.CertificateContainer,
+		Secrets: map[string]string{client.CertificateSecret: "missing-id"},
+	})
+	if err == nil {
+		t.Error("Expected an error for a missing secret")
+	}
+}
+
+func TestFault(t *testing.T) {
+	server := barbicantest.NewServer()
+	defer server.Close()
+
+	barbican := client.NewClient(server.URL, &communications.Headers{})
+	secretID, _ := barbican.PostSecret(context.Background(), &client.PostSecretRequest{Name: "test-secret", Payload: "test-payload", PayloadContentType: constants.TextPlainMime})
+
+	// Idempotent calls are retried past a failing node
+	server.InjectFault(barbicantest.Fault{Method: "GET", Path: "/v1/secrets", Status: http.StatusServiceUnavailable, Times: 1})
+	if _, err := barbican.GetPayload(context.Background(), secretID, constants.TextPlainMime); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	// Bad Path: the fault holds until cleared
+	server.InjectFault(barbicantest.Fault{Method: "DELETE", Status: http.StatusForbidden})
+	for i := 0; i < 2; i++ {
+		if err := barbican.DeleteSecret(context.Background(), secretID); err == nil || !strings.HasPrefix(err.Error(), http.StatusText(http.StatusForbidden)) {
+			t.Errorf("Expected a Forbidden error, received %+v", err)
+		}
+	}
+	server.ClearFaults()
+	if err := barbican.DeleteSecret(context.Background(), secretID); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	// Bad Path: a slow Barbican runs past the deadline of the request
+	server.InjectFault(barbicantest.Fault{Delay: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := barbican.CheckOrder(ctx, "order-id"); err != client.ErrDeadlineExceeded {
+		t.Errorf("Expected %s, received %+v", client.ErrDeadlineExceeded, err)
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	server := barbicantest.NewServer()
+	defer server.Close()
+	server.RequireToken("test-token")
+
+	// Bad Path: the token of the user is checked
+	barbican := client.NewClient(server.URL, &communications.Headers{Authorization: "Bearer wrong-token"})
+	if _, err := barbican.GetMetadata(context.Background(), "secret-id"); err == nil || !strings.HasPrefix(err.Error(), http.StatusText(http.StatusUnauthorized)) {
+		t.Errorf("Expected an Unauthorized error, received %+v", err)
+	}
+
+	barbican = client.NewClient(server.URL, &communications.Headers{Authorization: "Bearer test-token"})
+	if _, err := barbican.GetMetadata(context.Background(), "secret-id"); err == nil || !strings.HasPrefix(err.Error(), http.StatusText(http.StatusNotFound)) {
+		t.Errorf("Expected a Not Found error, received %+v", err)
+	}
+
+	if requests := server.Requests(); len(requests) != 2 || requests[1] != "GET /v1/secrets/secret-id/metadata" {
+		t.Errorf("Expected two requests, received %v", requests)
+	}
+}
@@ -0,0 +1,158 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package barbican
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/certificates"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/client"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-consts"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+var (
+	// ErrCertificateRotation notifies callers when a rotation is requested for a certificate, which is replaced instead
+	ErrCertificateRotation = errors.New(http.StatusText(http.StatusBadRequest) + ": Certificates cannot be rotated")
+
+	// ErrIncompleteContainer notifies callers when the container of a certificate is missing part of the bundle
+	ErrIncompleteContainer = errors.New(http.StatusText(http.StatusInternalServerError) + ": Certificate container is missing its certificate or private key")
+)
+
+// storeCertificate stores the bundle of a certificate secret as a Barbican certificate container. Each part of the
+// bundle is a secret of its own, and the translation row of the certificate names the container.
+func storeCertificate(ctx context.Context, s *keystore, secret *secrets.Secret, createTx *transactions.Transaction) (string, error) {
+	bundle, err := certificates.Parse(secret.Payload)
+	if err != nil {
+		return "", err
+	}
+
+	space, extractErr := extractBluemixSpace(s)
+	if extractErr != nil {
+		return "", extractErr
+	}
+
+	org, extractErr := extractBluemixOrg(s)
+	if extractErr != nil {
+		return "", extractErr
+	}
+
+	parts := map[string]*client.PostSecretRequest{
+		client.CertificateSecret: {Payload: bundle.Certificate, SecretType: client.CertificateSecretType},
+		client.PrivateKeySecret:  {Payload: bundle.PrivateKey, SecretType: client.PrivateSecretType},
+	}
+	if bundle.Intermediates != "" {
+		parts[client.IntermediatesSecret] = &client.PostSecretRequest{Payload: bundle.Intermediates, SecretType: client.CertificateSecretType}
+	}
+
+	secretIDs := make(map[string]string)
+	for name, part := range parts {
+		part.Name = secret.Name
+		part.PayloadContentType = constants.TextPlainMime
+		secretID, err := s.barbicanClient.PostSecret(ctx, part)
+		if err != nil {
+			s.logger.Log("err", err, "correlation_id", s.headers.CorrelationID)
+			return "", err
+		}
+
+		rbDeleteSecret := transactions.NewHsmCreateSecretRollback(s.rollbackDeleteSecret, secretID)
+		createTx.Add(rbDeleteSecret)
+		secretIDs[name] = secretID
+	}
+
+	containerID, err := s.barbicanClient.PostContainer(ctx, &client.PostContainerRequest{
+		Name:    secret.Name,
+		Type:    client.CertificateContainer,
+		Secrets: secretIDs,
+	})
+	if err != nil {
+		s.logger.Log("err", err, "correlation_id", s.headers.CorrelationID)
+		return "", err
+	}
+
+	rbDeleteContainer := transactions.NewHsmCreateSecretRollback(s.rollbackDeleteContainer, containerID)
+	createTx.Add(rbDeleteContainer)
+
+	id, err := createID(db.ContainerRefs("", containerID), space, org, s)
+	if err != nil {
+		return "", err
+	}
+
+	rbDeleteID := transactions.NewKeyIDRollback(deleteID, id, space, org, s)
+	createTx.Add(rbDeleteID)
+
+	secret.SetState(secrets.Activation)
+	return id, nil
+}
+
+// retrieveBundle returns the PEM bundle of the certificate held by the container
+func retrieveBundle(ctx context.Context, s *keystore, containerID string) (string, error) {
+	container, err := s.barbicanClient.GetContainer(ctx, containerID)
+	if err != nil {
+		return "", err
+	}
+
+	if container.Secrets[client.CertificateSecret] == "" || container.Secrets[client.PrivateKeySecret] == "" {
+		return "", ErrIncompleteContainer
+	}
+
+	bundle := new(certificates.Bundle)
+	parts := map[string]*string{
+		client.CertificateSecret:   &bundle.Certificate,
+		client.PrivateKeySecret:    &bundle.PrivateKey,
+		client.IntermediatesSecret: &bundle.Intermediates,
+	}
+	for name, part := range parts {
+		secretID, ok := container.Secrets[name]
+		if !ok {
+			continue
+		}
+
+		if *part, err = retrievePayload(ctx, s, secretID, false); err != nil {
+			return "", err
+		}
+	}
+	return bundle.String(), nil
+}
+
+// deleteCertificate deletes the container of the certificate, the secrets of its bundle, and its translation row
+func deleteCertificate(ctx context.Context, s *keystore, keyprotectID, space, org, containerID string) error {
+	container, err := s.barbicanClient.GetContainer(ctx, containerID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.barbicanClient.DeleteContainer(ctx, containerID); err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return err
+	}
+
+	names := make([]string, 0, len(container.Secrets))
+	for name := range container.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := s.barbicanClient.DeleteSecret(ctx, container.Secrets[name]); err != nil {
+			s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+			return err
+		}
+	}
+
+	if err := deleteID(keyprotectID, space, org, s); err != nil {
+		s.logger.Log("err", err, "correlation_id", s.headers.CorrelationID)
+		return err
+	}
+	return nil
+}
+
+// rollbackDeleteContainer deletes a container on rollback, without the context of the request
+func (s *keystore) rollbackDeleteContainer(containerRef string) error {
+	return s.barbicanClient.DeleteContainer(context.Background(), containerRef)
+}
@@ -0,0 +1,193 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package barbican
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/certificates"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/client"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+// fContainerBC keeps the secrets and containers posted to it
+type fContainerBC struct {
+	*fBC
+	secrets    map[string]*client.PostSecretRequest
+	containers map[string]*client.PostContainerRequest
+	deleted    []string
+}
+
+func newFContainerBC() *fContainerBC {
+	return &fContainerBC{
+		fBC:        new(fBC),
+		secrets:    make(map[string]*client.PostSecretRequest),
+		containers: make(map[string]*client.PostContainerRequest),
+	}
+}
+
+func (fb *fContainerBC) PostSecret(ctx context.Context, secret *client.PostSecretRequest) (string, error) {
+	secretID := "secret-" + strconv.Itoa(len(fb.secrets)+1)
+	fb.secrets[secretID] = secret
+	return secretID, nil
+}
+
+func (fb *fContainerBC) GetPayload(ctx context.Context, secretID string, accept string) (string, error) {
+	return fb.secrets[secretID].Payload, nil
+}
+
+func (fb *fContainerBC) DeleteSecret(ctx context.Context, secretID string) error {
+	delete(fb.secrets, secretID)
+	fb.deleted = append(fb.deleted, secretID)
+	return nil
+}
+
+func (fb *fContainerBC) PostContainer(ctx context.Context, container *client.PostContainerRequest) (string, error) {
+	if fb.err != nil {
+		return "", fb.err
+	}
+	containerID := "container-" + strconv.Itoa(len(fb.containers)+1)
+	fb.containers[containerID] = container
+	return containerID, nil
+}
+
+func (fb *fContainerBC) GetContainer(ctx context.Context, containerID string) (*client.GetContainerResponse, error) {
+	container := fb.containers[containerID]
+	return &client.GetContainerResponse{Name: container.Name, Type: container.Type, Secrets: container.Secrets}, nil
+}
+
+func (fb *fContainerBC) DeleteContainer(ctx context.Context, containerID string) error {
+	delete(fb.containers, containerID)
+	fb.deleted = append(fb.deleted, containerID)
+	return nil
+}
+
+// newTestCertificate returns the PEM of a self-signed certificate and of its private key
+func newTestCertificate(t *testing.T, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: name}, NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return string(pem.EncodeToMemory(&pem.Block{Type: certificates.CertificateBlock, Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: certificates.ECPrivateKeyBlock, Bytes: keyDER}))
+}
+
+func TestCertificate(t *testing.T) {
+	barbican := newFContainerBC()
+	certificateKeystore := &keystore{
+		barbicanClient: barbican,
+		logger:         log.NewNopLogger(),
+		database:       fDatabase,
+		headers:        &communications.Headers{BluemixSpace: "test-space", BluemixOrg: "test-org"},
+	}
+	defer cleanUp()
+
+	certificate, privateKey := newTestCertificate(t, "test.example.com")
+	intermediate, _ := newTestCertificate(t, "Test Intermediate CA")
+	testSecret := secrets.NewSecret()
+	testSecret.Name = "test-certificate"
+	testSecret.SecretType = certificates.SecretType
+	testSecret.Payload = certificate + intermediate + privateKey
+
+	// used to break out of loop
+	fDatabase.InjectError(db.ErrNotFound)
+	nilOverwriteForAdd = true
+
+	createTx := transactions.NewTransaction()
+	if _, err := certificateKeystore.CreateSecret(context.Background(), testSecret, &createTx); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if testSecret.State != secrets.Activation || len(barbican.secrets) != 3 {
+		t.Errorf("Expected an active certificate of 3 secrets, received %d secrets", len(barbican.secrets))
+	}
+
+	container := barbican.containers["container-1"]
+	if container == nil || container.Type != client.CertificateContainer {
+		t.Fatalf("Expected a certificate container, received %+v", container)
+	}
+	if barbican.secrets[container.Secrets[client.PrivateKeySecret]].SecretType != client.PrivateSecretType {
+		t.Errorf("Expected the private key to be a private secret")
+	}
+
+	refs := fDatabase.addedRefs
+	if refs.ContainerID() != "container-1" || refs.Keystore() != "" {
+		t.Errorf("Expected the row to name the container, received %+v", refs)
+	}
+
+	fDatabase.RemoveError()
+	fDatabase.InjectRefs(refs)
+	payload, state, err := certificateKeystore.GetPayload(context.Background(), refs.KpID)
+	if err != nil || state != secrets.Activation || payload != testSecret.Payload {
+		t.Errorf("Expected the bundle, received %s, %+v", payload, err)
+	}
+
+	// Bad Path: certificates are replaced rather than rotated
+	if _, err := certificateKeystore.RotateSecret(context.Background(), refs.KpID, testSecret, &createTx); err != ErrCertificateRotation {
+		t.Errorf("Expected %s, received %+v", ErrCertificateRotation, err)
+	}
+
+	if err := certificateKeystore.DeleteSecret(context.Background(), refs.KpID, &createTx); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if len(barbican.secrets) != 0 || len(barbican.containers) != 0 || barbican.deleted[0] != "container-1" {
+		t.Errorf("Expected the container and then its secrets to be deleted, received %v", barbican.deleted)
+	}
+}
+
+func TestCertificateRollback(t *testing.T) {
+	barbican := newFContainerBC()
+	certificateKeystore := &keystore{
+		barbicanClient: barbican,
+		logger:         log.NewNopLogger(),
+		database:       fDatabase,
+		headers:        &communications.Headers{BluemixSpace: "test-space", BluemixOrg: "test-org"},
+	}
+	defer cleanUp()
+
+	certificate, privateKey := newTestCertificate(t, "test.example.com")
+	testSecret := secrets.NewSecret()
+	testSecret.SecretType = certificates.SecretType
+	testSecret.Payload = certificate + privateKey
+
+	errTestContainer := errors.New(http.StatusText(http.StatusBadRequest) + ": test-PostContainer-error")
+	barbican.InjectError(errTestContainer)
+	createTx := transactions.NewTransaction()
+	if _, err := certificateKeystore.CreateSecret(context.Background(), testSecret, &createTx); err != errTestContainer {
+		t.Fatalf("Expected %s, received %+v", errTestContainer, err)
+	}
+
+	if err := createTx.Clean(); err != nil || len(barbican.secrets) != 0 {
+		t.Errorf("Expected the secrets of the bundle to be deleted, received %d, %+v", len(barbican.secrets), err)
+	}
+
+	// Bad Path: not a bundle
+	testSecret.Payload = "my secret payload"
+	if _, err := certificateKeystore.CreateSecret(context.Background(), testSecret, &createTx); err != certificates.ErrInvalidBundle {
+		t.Errorf("Expected %s, received %+v", certificates.ErrInvalidBundle, err)
+	}
+}
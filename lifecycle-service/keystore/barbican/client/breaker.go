@@ -0,0 +1,146 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of the circuit breaker of a Barbican host
+type BreakerState int
+
+// States of a circuit breaker, in the order they are reported
+const (
+	// BreakerClosed lets every call through
+	BreakerClosed BreakerState = iota
+
+	// BreakerHalfOpen lets a single trial call through, whose outcome closes or reopens the breaker
+	BreakerHalfOpen
+
+	// BreakerOpen fails every call without sending it, until BreakerCooldown has passed
+	BreakerOpen
+)
+
+const (
+	// BreakerThreshold is the number of consecutive failed calls that open the breaker of a host
+	BreakerThreshold = 5
+
+	// BreakerCooldown is how long an open breaker fails calls before letting a trial call through
+	BreakerCooldown = 30 * time.Second
+)
+
+var (
+	breakersMu sync.Mutex
+	breakers   = make(map[string]*breaker)
+
+	// breakerObserver is notified whenever a breaker changes state
+	breakerObserver = func(host string, state BreakerState) {}
+)
+
+// ObserveBreakers registers the function notified whenever the circuit breaker of a Barbican host changes state.
+// It is called with the lock of the breaker held, so it must not call the client.
+func ObserveBreakers(observer func(host string, state BreakerState)) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	breakerObserver = observer
+}
+
+// breaker tracks the health of a Barbican host across every client calling it. Clients are created per request,
+// so the breakers are shared by host.
+type breaker struct {
+	mu        sync.Mutex
+	host      string
+	threshold int
+	cooldown  time.Duration
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	trial     bool
+}
+
+// breakerFor returns the shared breaker of the host
+func breakerFor(host string) *breaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	b, ok := breakers[host]
+	if !ok {
+		b = newBreaker(host, BreakerThreshold, BreakerCooldown)
+		breakers[host] = b
+	}
+	return b
+}
+
+func newBreaker(host string, threshold int, cooldown time.Duration) *breaker {
+	return &breaker{host: host, threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a call may be sent. Once the cooldown of an open breaker has passed, a single trial call
+// is allowed until its outcome is recorded.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.transition(BreakerHalfOpen)
+		b.trial = true
+		return true
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return true
+}
+
+// record records the outcome of an allowed call
+func (b *breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if !failed {
+		b.failures = 0
+		b.transition(BreakerClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.transition(BreakerOpen)
+	}
+}
+
+// release gives up an allowed call without an outcome, such as one cancelled by its caller
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// current returns the state of the breaker
+func (b *breaker) current() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *breaker) transition(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+
+	breakersMu.Lock()
+	observer := breakerObserver
+	breakersMu.Unlock()
+	observer(b.host, state)
+}
@@ -4,6 +4,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -13,6 +14,7 @@ import (
 	"strings"
 	"time"
 
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/keystone"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-consts"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
 )
@@ -22,7 +24,8 @@ const (
 	CERTLOCATION = "/kp_data/config/ca.crt"
 	HTTPS        = "https"
 
-	// HTTPClientTimeout specifies the number of seconds the client should wait in seconds before canceling request
+	// HTTPClientTimeout specifies the number of seconds the client should wait in seconds before canceling request.
+	// Requests are cancelled sooner when the context of the caller is cancelled or reaches its deadline.
 	HTTPClientTimeout = time.Second * 60
 )
 
@@ -38,25 +41,49 @@ func init() {
 	certPool.AppendCertsFromPEM(caCert)
 }
 
-// Client is an interface for making calls to Barbican
+// Client is an interface for making calls to Barbican. Every call is cancelled along with its context. Calls that
+// do not change Barbican are retried with backoff, and every call fails fast while Barbican is failing.
 type Client interface {
-	PostSecret(secret *PostSecretRequest) (ref string, err error)
+	PostSecret(ctx context.Context, secret *PostSecretRequest) (ref string, err error)
 
-	PostOrder(order *PostOrderRequest) (ref string, err error)
+	PostOrder(ctx context.Context, order *PostOrderRequest) (ref string, err error)
 
-	CheckOrder(orderRef string) (*CheckOrderResponse, error)
+	CheckOrder(ctx context.Context, orderRef string) (*CheckOrderResponse, error)
 
-	GetPayload(secretID string, accept string) (payload string, err error)
+	GetPayload(ctx context.Context, secretID string, accept string) (payload string, err error)
 
-	DeleteOrder(orderRef string) error
+	DeleteOrder(ctx context.Context, orderRef string) error
 
-	DeleteSecret(ID string) error
+	DeleteSecret(ctx context.Context, ID string) error
+
+	// PutMetadata replaces the user metadata of the secret
+	PutMetadata(ctx context.Context, secretID string, metadata map[string]string) error
+
+	GetMetadata(ctx context.Context, secretID string) (map[string]string, error)
+
+	// PostContainer creates a container of secrets already in Barbican
+	PostContainer(ctx context.Context, container *PostContainerRequest) (ref string, err error)
+
+	GetContainer(ctx context.Context, containerID string) (*GetContainerResponse, error)
+
+	// DeleteContainer deletes the container, leaving its secrets in Barbican
+	DeleteContainer(ctx context.Context, containerID string) error
+}
+
+// Authenticator authenticates a client to Barbican as the service, instead of forwarding the token of the user
+type Authenticator interface {
+	Token(ctx context.Context) (string, error)
+	// Invalidate drops a token Barbican refused, so the next call to Token authenticates again
+	Invalidate(token string)
 }
 
 type barbicanClient struct {
 	client       *http.Client
 	barbicanHost string
 	headers      *communications.Headers
+	retry        RetryPolicy
+	breaker      *breaker
+	auth         Authenticator
 }
 
 // set base headers used for all requests
@@ -68,12 +95,17 @@ func basedHeaders(request *http.Request, clientHeaders *communications.Headers)
 
 // postRequest creates a request used for posts
 func postRequest(body interface{}, url string) (request *http.Request, err error) {
+	return jsonRequest("POST", body, url)
+}
+
+// jsonRequest creates a request whose body is encoded as JSON
+func jsonRequest(method string, body interface{}, url string) (request *http.Request, err error) {
 	json, err := json.Marshal(body)
 	if err != nil {
 		return
 	}
 
-	request, err = http.NewRequest("POST", url, bytes.NewBuffer(json))
+	request, err = http.NewRequest(method, url, bytes.NewBuffer(json))
 	if err != nil {
 		return
 	}
@@ -81,13 +113,73 @@ func postRequest(body interface{}, url string) (request *http.Request, err error
 	return
 }
 
-//Get an ID out of a barbican URI
+// authenticate replaces the token of the user on the request with the token of the service
+func (client *barbicanClient) authenticate(ctx context.Context, request *http.Request) (string, error) {
+	token, err := client.auth.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	request.Header.Del(constants.AuthorizationHeader)
+	request.Header.Set(keystone.TokenHeader, token)
+	return token, nil
+}
+
+// do sends the request, which is cancelled along with the context. Idempotent requests are retried under the
+// retry policy of the client, and no request is sent while the breaker of the Barbican host is open. A request
+// whose service token is refused is sent again once with a new token.
+func (client *barbicanClient) do(ctx context.Context, request *http.Request) (*http.Response, error) {
+	attempts := client.retry.attempts(request.Method)
+	reauthenticated := false
+	for attempt := 1; ; attempt++ {
+		var token string
+		if client.auth != nil {
+			var err error
+			if token, err = client.authenticate(ctx, request); err != nil {
+				return nil, err
+			}
+		}
+
+		if !client.breaker.allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		response, err := client.client.Do(request.WithContext(ctx))
+		if err != nil {
+			if ctxErr := contextError(ctx); ctxErr != nil {
+				client.breaker.release()
+				return nil, ctxErr
+			}
+		}
+		client.breaker.record(isFailure(response, err))
+
+		if client.auth != nil && err == nil && response.StatusCode == http.StatusUnauthorized && !reauthenticated {
+			response.Body.Close()
+			client.auth.Invalidate(token)
+			reauthenticated = true
+			attempt--
+			continue
+		}
+
+		if (err == nil && !isRetryableStatus(response.StatusCode)) || attempt >= attempts {
+			return response, err
+		}
+		if response != nil {
+			response.Body.Close()
+		}
+
+		if err := client.retry.wait(ctx, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
 func parseRef(barbicanURI string) string {
 	indx := strings.LastIndex(barbicanURI, "/")
 	return barbicanURI[indx+1:]
 }
 
-func (client *barbicanClient) PostSecret(secret *PostSecretRequest) (string, error) {
+func (client *barbicanClient) PostSecret(ctx context.Context, secret *PostSecretRequest) (string, error) {
 	url := client.barbicanHost + SECRETS
 	request, err := postRequest(secret, url)
 	if err != nil {
@@ -99,7 +191,7 @@ func (client *barbicanClient) PostSecret(secret *PostSecretRequest) (string, err
 	basedHeaders(request, clientHeaders)
 	request.Header.Set(constants.ContentTypeHeader, constants.AppJSONMime)
 
-	response, err := client.client.Do(request)
+	response, err := client.do(ctx, request)
 	if err != nil {
 		return "", err
 	}
@@ -108,7 +200,7 @@ func (client *barbicanClient) PostSecret(secret *PostSecretRequest) (string, err
 	return decoderPostSecretResponse(response)
 }
 
-func (client *barbicanClient) PostOrder(order *PostOrderRequest) (string, error) {
+func (client *barbicanClient) PostOrder(ctx context.Context, order *PostOrderRequest) (string, error) {
 	url := client.barbicanHost + ORDERS
 	request, err := postRequest(order, url)
 	if err != nil {
@@ -120,7 +212,7 @@ func (client *barbicanClient) PostOrder(order *PostOrderRequest) (string, error)
 	basedHeaders(request, clientHeaders)
 	request.Header.Set(constants.ContentTypeHeader, constants.AppJSONMime)
 
-	response, err := client.client.Do(request)
+	response, err := client.do(ctx, request)
 	if err != nil {
 		return "", err
 	}
@@ -129,7 +221,7 @@ func (client *barbicanClient) PostOrder(order *PostOrderRequest) (string, error)
 	return decoderPostOrderResponse(response)
 }
 
-func (client *barbicanClient) GetPayload(secretID string, accept string) (string, error) {
+func (client *barbicanClient) GetPayload(ctx context.Context, secretID string, accept string) (string, error) {
 	if accept != constants.OctetStreamMime && accept != constants.TextPlainMime {
 		return "", errors.New("Invalid accept header given")
 	}
@@ -144,7 +236,7 @@ func (client *barbicanClient) GetPayload(secretID string, accept string) (string
 	basedHeaders(request, clientHeaders)
 	request.Header.Set(constants.AcceptHeader, accept)
 
-	response, err := client.client.Do(request)
+	response, err := client.do(ctx, request)
 	if err != nil {
 		return "", err
 	}
@@ -153,7 +245,7 @@ func (client *barbicanClient) GetPayload(secretID string, accept string) (string
 	return decodeGetPayloadResponse(response, accept)
 }
 
-func (client *barbicanClient) DeleteSecret(secretID string) error {
+func (client *barbicanClient) DeleteSecret(ctx context.Context, secretID string) error {
 	url := client.barbicanHost + SECRETS + "/" + secretID
 	request, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
@@ -164,7 +256,7 @@ func (client *barbicanClient) DeleteSecret(secretID string) error {
 	clientHeaders := client.headers
 	basedHeaders(request, clientHeaders)
 
-	response, err := client.client.Do(request)
+	response, err := client.do(ctx, request)
 	if err != nil {
 		return err
 	}
@@ -182,7 +274,49 @@ func (client *barbicanClient) DeleteSecret(secretID string) error {
 	return nil
 }
 
-func (client *barbicanClient) DeleteOrder(orderRef string) error {
+func (client *barbicanClient) PutMetadata(ctx context.Context, secretID string, metadata map[string]string) error {
+	url := client.barbicanHost + SECRETS + "/" + secretID + METADATA
+	request, err := jsonRequest("PUT", &metadataBody{Metadata: metadata}, url)
+	if err != nil {
+		return err
+	}
+
+	// setup headers
+	clientHeaders := client.headers
+	basedHeaders(request, clientHeaders)
+	request.Header.Set(constants.ContentTypeHeader, constants.AppJSONMime)
+
+	response, err := client.do(ctx, request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	return decodePutMetadataResponse(response)
+}
+
+func (client *barbicanClient) GetMetadata(ctx context.Context, secretID string) (map[string]string, error) {
+	url := client.barbicanHost + SECRETS + "/" + secretID + METADATA
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// setup headers
+	clientHeaders := client.headers
+	basedHeaders(request, clientHeaders)
+	request.Header.Set(constants.AcceptHeader, constants.AppJSONMime)
+
+	response, err := client.do(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	return decodeGetMetadataResponse(response)
+}
+
+func (client *barbicanClient) DeleteOrder(ctx context.Context, orderRef string) error {
 	url := client.barbicanHost + ORDERS + "/" + orderRef
 	request, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
@@ -194,7 +328,7 @@ func (client *barbicanClient) DeleteOrder(orderRef string) error {
 	basedHeaders(request, clientHeaders)
 	request.Header.Set(constants.AcceptHeader, constants.AppJSONMime)
 
-	response, err := client.client.Do(request)
+	response, err := client.do(ctx, request)
 	if err != nil {
 		return err
 	}
@@ -207,7 +341,7 @@ func (client *barbicanClient) DeleteOrder(orderRef string) error {
 	return nil
 }
 
-func (client *barbicanClient) CheckOrder(orderRef string) (*CheckOrderResponse, error) {
+func (client *barbicanClient) CheckOrder(ctx context.Context, orderRef string) (*CheckOrderResponse, error) {
 	url := client.barbicanHost + ORDERS + "/" + orderRef
 	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -219,7 +353,7 @@ func (client *barbicanClient) CheckOrder(orderRef string) (*CheckOrderResponse,
 	basedHeaders(request, clientHeaders)
 	request.Header.Set(constants.AcceptHeader, constants.AppJSONMime)
 
-	response, err := client.client.Do(request)
+	response, err := client.do(ctx, request)
 	if err != nil {
 		return nil, err
 	}
@@ -228,7 +362,85 @@ func (client *barbicanClient) CheckOrder(orderRef string) (*CheckOrderResponse,
 	return decoderCheckOrderResponse(response)
 }
 
-// NewClient will return a new barbican Client
+func (client *barbicanClient) PostContainer(ctx context.Context, container *PostContainerRequest) (string, error) {
+	url := client.barbicanHost + CONTAINERS
+	request, err := postRequest(newContainerBody(container, client.barbicanHost), url)
+	if err != nil {
+		return "", err
+	}
+
+	// setup headers
+	clientHeaders := client.headers
+	basedHeaders(request, clientHeaders)
+	request.Header.Set(constants.ContentTypeHeader, constants.AppJSONMime)
+
+	response, err := client.do(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	return decodePostContainerResponse(response)
+}
+
+func (client *barbicanClient) GetContainer(ctx context.Context, containerID string) (*GetContainerResponse, error) {
+	url := client.barbicanHost + CONTAINERS + "/" + containerID
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// setup headers
+	clientHeaders := client.headers
+	basedHeaders(request, clientHeaders)
+	request.Header.Set(constants.AcceptHeader, constants.AppJSONMime)
+
+	response, err := client.do(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	return decodeGetContainerResponse(response)
+}
+
+func (client *barbicanClient) DeleteContainer(ctx context.Context, containerID string) error {
+	url := client.barbicanHost + CONTAINERS + "/" + containerID
+	request, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+
+	// setup headers
+	clientHeaders := client.headers
+	basedHeaders(request, clientHeaders)
+
+	response, err := client.do(ctx, request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusNoContent {
+		body, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+
+		return decodeContainerError(body)
+	}
+
+	return nil
+}
+
+// NewServiceClient will return a new barbican Client that authenticates as the service, with tokens of auth
+func NewServiceClient(barbicanHost string, auth Authenticator, headers *communications.Headers) Client {
+	client := NewClient(barbicanHost, headers).(*barbicanClient)
+	client.auth = auth
+	return client
+}
+
+// NewClient will return a new barbican Client that forwards the token of the user
 func NewClient(barbicanHost string, headers *communications.Headers) Client {
 	client := new(barbicanClient)
 	client.client = &http.Client{
@@ -248,5 +460,7 @@ func NewClient(barbicanHost string, headers *communications.Headers) Client {
 	}
 	client.barbicanHost = barbicanHost
 	client.headers = headers
+	client.retry = DefaultRetryPolicy
+	client.breaker = breakerFor(barbicanHost)
 	return client
 }
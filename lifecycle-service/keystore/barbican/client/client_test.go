@@ -0,0 +1,307 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/keystone"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-consts"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+)
+
+// newBlockingServer returns a server that holds every request until the test ends
+func newBlockingServer() (*httptest.Server, chan struct{}) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	return server, release
+}
+
+func TestDeadlineExceeded(t *testing.T) {
+	server, release := newBlockingServer()
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	barbican := NewClient(server.URL, &communications.Headers{})
+	if _, err := barbican.GetPayload(ctx, "secret-id", constants.OctetStreamMime); err != ErrDeadlineExceeded {
+		t.Errorf("Expected %s, received %+v", ErrDeadlineExceeded, err)
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	server, release := newBlockingServer()
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	barbican := NewClient(server.URL, &communications.Headers{})
+	if err := barbican.DeleteSecret(ctx, "secret-id"); err != ErrCanceled {
+		t.Errorf("Expected %s, received %+v", ErrCanceled, err)
+	}
+
+	// A request whose context is already cancelled is never sent
+	if _, err := barbican.CheckOrder(ctx, "order-id"); err != ErrCanceled {
+		t.Errorf("Expected %s, received %+v", ErrCanceled, err)
+	}
+}
+
+// newCountingServer returns a server that responds with the statuses in turn, repeating the last one
+func newCountingServer(statuses ...int) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(atomic.AddInt32(&calls, 1))
+		if call > len(statuses) {
+			call = len(statuses)
+		}
+		w.WriteHeader(statuses[call-1])
+		w.Write([]byte(`{"title": "test-title", "description": "test-description"}`))
+	}))
+	return server, &calls
+}
+
+// newTestClient returns a client with a fast retry policy and a breaker of its own
+func newTestClient(host string, threshold int, cooldown time.Duration) *barbicanClient {
+	return &barbicanClient{
+		client:       &http.Client{Timeout: HTTPClientTimeout},
+		barbicanHost: host,
+		headers:      &communications.Headers{},
+		retry:        RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond},
+		breaker:      newBreaker(host, threshold, cooldown),
+	}
+}
+
+func TestRetry(t *testing.T) {
+	server, calls := newCountingServer(http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusNoContent)
+	defer server.Close()
+
+	barbican := newTestClient(server.URL, BreakerThreshold, BreakerCooldown)
+	if err := barbican.DeleteSecret(context.Background(), "secret-id"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if *calls != 3 {
+		t.Errorf("Expected 3 attempts, received %d", *calls)
+	}
+}
+
+func TestNoRetry(t *testing.T) {
+	// Bad Path: calls that create in Barbican are sent once
+	server, calls := newCountingServer(http.StatusServiceUnavailable)
+	defer server.Close()
+
+	barbican := newTestClient(server.URL, BreakerThreshold, BreakerCooldown)
+	if _, err := barbican.PostSecret(context.Background(), &PostSecretRequest{Name: "test"}); err == nil {
+		t.Error("Expected Error")
+	}
+	if *calls != 1 {
+		t.Errorf("Expected 1 attempt, received %d", *calls)
+	}
+
+	// Bad Path: 4xx responses are not retried
+	server, calls = newCountingServer(http.StatusNotFound)
+	defer server.Close()
+
+	barbican = newTestClient(server.URL, BreakerThreshold, BreakerCooldown)
+	if _, err := barbican.CheckOrder(context.Background(), "order-id"); err == nil {
+		t.Error("Expected Error")
+	}
+	if *calls != 1 {
+		t.Errorf("Expected 1 attempt, received %d", *calls)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	server, calls := newCountingServer(http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusNoContent)
+	defer server.Close()
+
+	barbican := newTestClient(server.URL, 2, 50*time.Millisecond)
+	barbican.retry.MaxAttempts = 1
+
+	var observed []BreakerState
+	ObserveBreakers(func(host string, state BreakerState) { observed = append(observed, state) })
+	defer ObserveBreakers(func(host string, state BreakerState) {})
+
+	for i := 0; i < 2; i++ {
+		barbican.DeleteSecret(context.Background(), "secret-id")
+	}
+	if state := barbican.breaker.current(); state != BreakerOpen {
+		t.Fatalf("Expected the breaker to be open, received %d", state)
+	}
+
+	// Bad Path: an open breaker fails without calling Barbican
+	if err := barbican.DeleteSecret(context.Background(), "secret-id"); err != ErrCircuitOpen {
+		t.Errorf("Expected %s, received %+v", ErrCircuitOpen, err)
+	}
+	if *calls != 2 {
+		t.Errorf("Expected 2 calls to Barbican, received %d", *calls)
+	}
+
+	// A trial call once the cooldown has passed closes the breaker
+	time.Sleep(60 * time.Millisecond)
+	if err := barbican.DeleteSecret(context.Background(), "secret-id"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if state := barbican.breaker.current(); state != BreakerClosed {
+		t.Errorf("Expected the breaker to be closed, received %d", state)
+	}
+
+	expected := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(observed) != len(expected) {
+		t.Fatalf("Expected %v, received %v", expected, observed)
+	}
+	for i := range expected {
+		if observed[i] != expected[i] {
+			t.Errorf("Expected %v, received %v", expected, observed)
+		}
+	}
+}
+
+// testAuthenticator hands out numbered tokens, replacing the token once it is invalidated
+type testAuthenticator struct {
+	tokens int
+}
+
+func (auth *testAuthenticator) Token(ctx context.Context) (string, error) {
+	if auth.tokens == 0 {
+		auth.tokens++
+	}
+	return "service-token-" + strconv.Itoa(auth.tokens), nil
+}
+
+func (auth *testAuthenticator) Invalidate(token string) {
+	auth.tokens++
+}
+
+func TestServiceAuthentication(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(constants.AuthorizationHeader) != "" {
+			t.Error("Expected the token of the user not to be forwarded")
+		}
+
+		// The first token has expired
+		token := r.Header.Get(keystone.TokenHeader)
+		received = append(received, token)
+		if token != "service-token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	auth := new(testAuthenticator)
+	barbican := NewServiceClient(server.URL, auth, &communications.Headers{Authorization: "Bearer user-token"})
+	if err := barbican.DeleteSecret(context.Background(), "secret-id"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if len(received) != 2 || received[0] != "service-token-1" || received[1] != "service-token-2" {
+		t.Errorf("Expected the request to be sent again with a new token, received %v", received)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	stored := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != SECRETS+"/secret-id"+METADATA {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code": 404, "title": "Not Found", "description": "Secret not found."}`))
+			return
+		}
+
+		switch r.Method {
+		case "PUT":
+			body := new(metadataBody)
+			json.NewDecoder(r.Body).Decode(body)
+			stored = body.Metadata
+			w.WriteHeader(http.StatusCreated)
+		case "GET":
+			json.NewEncoder(w).Encode(&metadataBody{Metadata: stored})
+		}
+	}))
+	defer server.Close()
+
+	barbican := newTestClient(server.URL, BreakerThreshold, BreakerCooldown)
+	if err := barbican.PutMetadata(context.Background(), "secret-id", map[string]string{"kp-name": "test-key"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	metadata, err := barbican.GetMetadata(context.Background(), "secret-id")
+	if err != nil || metadata["kp-name"] != "test-key" {
+		t.Errorf("Expected test-key, received %v, %+v", metadata, err)
+	}
+
+	// Bad Path: unknown secret
+	if _, err := barbican.GetMetadata(context.Background(), "missing-id"); err == nil || !strings.HasPrefix(err.Error(), http.StatusText(http.StatusNotFound)) {
+		t.Errorf("Expected a Not Found error, received %+v", err)
+	}
+}
+
+func TestContainer(t *testing.T) {
+	var posted containerBody
+	var host string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == CONTAINERS:
+			json.NewDecoder(r.Body).Decode(&posted)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"container_ref": "` + host + CONTAINERS + `/container-id"}`))
+		case r.Method == "GET" && r.URL.Path == CONTAINERS+"/container-id":
+			json.NewEncoder(w).Encode(&posted)
+		case r.Method == "DELETE" && r.URL.Path == CONTAINERS+"/container-id":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code": 404, "title": "Not Found", "description": "Container not found."}`))
+		}
+	}))
+	defer server.Close()
+	host = server.URL
+
+	barbican := newTestClient(server.URL, BreakerThreshold, BreakerCooldown)
+	containerID, err := barbican.PostContainer(context.Background(), &PostContainerRequest{
+		Name:    "test-certificate",
+		Type:    CertificateContainer,
+		Secrets: map[string]string{CertificateSecret: "certificate-id", PrivateKeySecret: "private-key-id"},
+	})
+	if err != nil || containerID != "container-id" {
+		t.Fatalf("Expected container-id, received %s, %+v", containerID, err)
+	}
+
+	// Secrets are sent by their full ref
+	if len(posted.SecretRefs) != 2 || posted.SecretRefs[0].Ref != server.URL+SECRETS+"/certificate-id" {
+		t.Errorf("Expected the refs of the secrets, received %+v", posted.SecretRefs)
+	}
+
+	container, err := barbican.GetContainer(context.Background(), containerID)
+	if err != nil || container.Type != CertificateContainer || container.Secrets[PrivateKeySecret] != "private-key-id" {
+		t.Errorf("Expected the secrets of the container by ID, received %+v, %+v", container, err)
+	}
+
+	if err := barbican.DeleteContainer(context.Background(), containerID); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	// Bad Path: unknown container
+	if _, err := barbican.GetContainer(context.Background(), "missing-id"); err == nil || !strings.HasPrefix(err.Error(), http.StatusText(http.StatusNotFound)) {
+		t.Errorf("Expected a Not Found error, received %+v", err)
+	}
+}
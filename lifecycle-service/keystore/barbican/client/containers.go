@@ -0,0 +1,126 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+)
+
+// Barbican container types
+const (
+	CertificateContainer = "certificate"
+	RSAContainer         = "rsa"
+)
+
+// Names of the secrets of certificate and RSA containers
+const (
+	CertificateSecret   = "certificate"
+	IntermediatesSecret = "intermediates"
+	PrivateKeySecret    = "private_key"
+	PublicKeySecret     = "public_key"
+)
+
+// Barbican secret types of the secrets held by containers
+const (
+	CertificateSecretType = "certificate"
+	PrivateSecretType     = "private"
+	PublicSecretType      = "public"
+)
+
+// PostContainerRequest is the main structure for post container requests. Secrets maps the name of each secret
+// in the container to its ID.
+type PostContainerRequest struct {
+	Name    string
+	Type    string
+	Secrets map[string]string
+}
+
+// GetContainerResponse is a container and the IDs of its secrets, by name
+type GetContainerResponse struct {
+	Name    string
+	Type    string
+	Secrets map[string]string
+}
+
+// containerSecretRef is a secret of a container as Barbican sends it, by its full ref
+type containerSecretRef struct {
+	Name string `json:"name"`
+	Ref  string `json:"secret_ref"`
+}
+
+// containerBody is the body of a container, both sent and received
+type containerBody struct {
+	Name       string               `json:"name"`
+	Type       string               `json:"type"`
+	SecretRefs []containerSecretRef `json:"secret_refs"`
+}
+
+// postContainerResponse is the main structure for post container responses
+type postContainerResponse struct {
+	Ref string `json:"container_ref"`
+}
+
+// newContainerBody returns the body of the container, referring to its secrets by their full refs on the host
+func newContainerBody(container *PostContainerRequest, barbicanHost string) *containerBody {
+	body := &containerBody{Name: container.Name, Type: container.Type}
+	for name, secretID := range container.Secrets {
+		body.SecretRefs = append(body.SecretRefs, containerSecretRef{Name: name, Ref: barbicanHost + SECRETS + "/" + secretID})
+	}
+	sort.Slice(body.SecretRefs, func(i, j int) bool { return body.SecretRefs[i].Name < body.SecretRefs[j].Name })
+	return body
+}
+
+func decodeContainerError(body []byte) error {
+	errorResponse := new(ErrorResponse)
+	if err := json.Unmarshal(body, errorResponse); err != nil {
+		errorResponse = decodeError(body)
+	}
+	return errorResponse
+}
+
+func decodePostContainerResponse(response *http.Response) (string, error) {
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if response.StatusCode != http.StatusCreated {
+		return "", decodeContainerError(body)
+	}
+
+	formattedResponse := new(postContainerResponse)
+	if err := json.Unmarshal(body, formattedResponse); err != nil {
+		return "", err
+	}
+
+	return parseRef(formattedResponse.Ref), nil
+}
+
+func decodeGetContainerResponse(response *http.Response) (*GetContainerResponse, error) {
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, decodeContainerError(body)
+	}
+
+	formattedResponse := new(containerBody)
+	if err := json.Unmarshal(body, formattedResponse); err != nil {
+		return nil, err
+	}
+
+	container := &GetContainerResponse{
+		Name:    formattedResponse.Name,
+		Type:    formattedResponse.Type,
+		Secrets: make(map[string]string),
+	}
+	for _, secretRef := range formattedResponse.SecretRefs {
+		container.Secrets[secretRef.Name] = parseRef(secretRef.Ref)
+	}
+	return container, nil
+}
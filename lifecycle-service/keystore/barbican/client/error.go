@@ -2,7 +2,22 @@
 
 package client
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrDeadlineExceeded notifies callers when the deadline of the request passed before Barbican responded
+	ErrDeadlineExceeded = errors.New(http.StatusText(http.StatusGatewayTimeout) + ": Barbican did not respond before the deadline of the request")
+
+	// ErrCanceled notifies callers when the request was cancelled before Barbican responded
+	ErrCanceled = errors.New(http.StatusText(http.StatusRequestTimeout) + ": Request was cancelled before Barbican responded")
+
+	// ErrCircuitOpen notifies callers when calls to Barbican fail fast because it has been failing
+	ErrCircuitOpen = errors.New(http.StatusText(http.StatusServiceUnavailable) + ": Barbican is unavailable. Please try again later.")
+)
 
 // ErrorResponse is the standard barbican error response
 type ErrorResponse struct {
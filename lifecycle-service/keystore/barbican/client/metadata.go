@@ -0,0 +1,50 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+// metadataBody is the body of the user metadata of a secret, both sent and received
+type metadataBody struct {
+	Metadata map[string]string `json:"metadata"`
+}
+
+func decodeMetadataError(response *http.Response) error {
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	errorResponse := new(ErrorResponse)
+	if err := json.Unmarshal(body, errorResponse); err != nil {
+		errorResponse = decodeError(body)
+	}
+	return errorResponse
+}
+
+func decodePutMetadataResponse(response *http.Response) error {
+	if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusOK {
+		return decodeMetadataError(response)
+	}
+	return nil
+}
+
+func decodeGetMetadataResponse(response *http.Response) (map[string]string, error) {
+	if response.StatusCode != http.StatusOK {
+		return nil, decodeMetadataError(response)
+	}
+
+	formattedResponse := new(metadataBody)
+	if err := json.NewDecoder(response.Body).Decode(formattedResponse); err != nil {
+		return nil, err
+	}
+
+	if formattedResponse.Metadata == nil {
+		formattedResponse.Metadata = make(map[string]string)
+	}
+	return formattedResponse.Metadata, nil
+}
@@ -7,6 +7,8 @@ const (
 	SECRETS = "/v1/secrets"
 	// ORDERS is the path for orders in barbican
 	ORDERS = "/v1/orders"
+	// CONTAINERS is the path for containers in barbican
+	CONTAINERS = "/v1/containers"
 	// PAYLOAD is the path for getting secret payloads in barbican
 	PAYLOAD = "/payload"
 	//METADATA is the path for getting user defined metadata in barbican
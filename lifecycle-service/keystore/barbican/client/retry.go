@@ -0,0 +1,99 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package client
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy decides how often, and how long apart, a call to Barbican is attempted. Only idempotent calls are
+// retried, and only when Barbican did not respond or responded with a retryable status.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times an idempotent call is sent, including the first
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry, doubled before every later retry
+	BaseDelay time.Duration
+
+	// MaxDelay caps the delay between two attempts
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used by every client. MaxAttempts matches the total number of Barbican nodes.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+}
+
+// attempts returns the number of times a request with the method may be sent
+func (policy RetryPolicy) attempts(method string) int {
+	if !isIdempotent(method) || policy.MaxAttempts < 1 {
+		return 1
+	}
+	return policy.MaxAttempts
+}
+
+// backoff returns the delay before the retry following the attempt, jittered between half and all of the
+// exponential delay so clients retrying together spread out
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.BaseDelay << uint(attempt-1)
+	if delay <= 0 || delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	if delay <= 1 {
+		return delay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+}
+
+// wait sleeps for the backoff of the attempt, returning early with the error of the context
+func (policy RetryPolicy) wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(policy.backoff(attempt))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+}
+
+// isIdempotent reports whether a request with the method may be sent again without changing its outcome
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isRetryableStatus reports whether Barbican responded with a status a later attempt may not get. 4xx responses
+// other than rate limiting are the same on every attempt.
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isFailure reports whether the outcome of a call counts against the health of Barbican
+func isFailure(response *http.Response, err error) bool {
+	return err != nil || response.StatusCode >= http.StatusInternalServerError
+}
+
+// contextError returns the error of the client for a context that is done, or nil
+func contextError(ctx context.Context) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return ErrDeadlineExceeded
+	case context.Canceled:
+		return ErrCanceled
+	}
+	return nil
+}
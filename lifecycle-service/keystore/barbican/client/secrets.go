@@ -17,6 +17,8 @@ type PostSecretRequest struct {
 	Name               string `json:"name"`
 	Payload            string `json:"payload"`
 	PayloadContentType string `json:"payload_content_type"`
+	// SecretType is the Barbican type of the secret, which is opaque when it is not set
+	SecretType string `json:"secret_type,omitempty"`
 }
 
 // postSecretResponse is the main structure for post secret responses
@@ -0,0 +1,277 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+// Package keystone authenticates the core service to OpenStack Keystone v3 with credentials of its own, so calls
+// to Barbican are made as the service instead of with the token of the user.
+package keystone
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TokenHeader carries the Keystone token of a request to an OpenStack service
+	TokenHeader = "X-Auth-Token"
+
+	// SubjectTokenHeader carries the token issued by Keystone
+	SubjectTokenHeader = "X-Subject-Token"
+
+	// KeyManager is the type of Barbican in the service catalog
+	KeyManager = "key-manager"
+
+	// PublicInterface is the interface of the endpoints used when Config.Interface is not set
+	PublicInterface = "public"
+
+	// RefreshWindow is how long before it expires a token is replaced
+	RefreshWindow = 5 * time.Minute
+
+	// HTTPClientTimeout specifies the number of seconds the client should wait in seconds before canceling request
+	HTTPClientTimeout = time.Second * 60
+)
+
+// ErrEndpointNotFound notifies callers when the catalog has no endpoint for the service in the region
+var ErrEndpointNotFound = errors.New(http.StatusText(http.StatusInternalServerError) + ": No endpoint for the service in the Keystone catalog")
+
+// Config locates Keystone and holds the credentials of the service. The token is scoped to Project, and
+// endpoints are looked up in Region through Interface.
+type Config struct {
+	URL           string
+	Username      string
+	Password      string
+	UserDomain    string
+	Project       string
+	ProjectDomain string
+	Region        string
+	Interface     string
+	// CACert is the PEM file of the CA Keystone's certificate is checked against, if not a system CA
+	CACert string
+}
+
+// ErrorResponse is the standard keystone error response
+type ErrorResponse struct {
+	StatusCode int
+	Detail     struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Error will return an ErrorResponse in string Format. Keystone errors are failures of the service, as its credentials
+// are not the user's.
+func (err *ErrorResponse) Error() string {
+	return http.StatusText(http.StatusInternalServerError) + ": Keystone returned " + strconv.Itoa(err.StatusCode) + ": " + err.Detail.Message
+}
+
+type endpoint struct {
+	Interface string `json:"interface"`
+	RegionID  string `json:"region_id"`
+	Region    string `json:"region"`
+	URL       string `json:"url"`
+}
+
+type service struct {
+	Type      string     `json:"type"`
+	Endpoints []endpoint `json:"endpoints"`
+}
+
+type tokenResponse struct {
+	Token struct {
+		ExpiresAt time.Time `json:"expires_at"`
+		Catalog   []service `json:"catalog"`
+	} `json:"token"`
+}
+
+// Session is a token of the service and the catalog it was issued with. It is shared by every request, and
+// authenticates again shortly before the token expires or once the token is refused.
+type Session struct {
+	client *http.Client
+	config Config
+	now    func() time.Time
+
+	// lock guards the token and the catalog, and is held while authenticating so only one request does
+	lock      sync.Mutex
+	token     string
+	refreshAt time.Time
+	catalog   []service
+}
+
+func decodeError(response *http.Response, body []byte) error {
+	errorResponse := &ErrorResponse{StatusCode: response.StatusCode}
+	if err := json.Unmarshal(body, errorResponse); err != nil || errorResponse.Detail.Message == "" {
+		errorResponse.Detail.Message = http.StatusText(response.StatusCode)
+	}
+	return errorResponse
+}
+
+// authRequest is the body of a password authentication scoped to the project of the service
+func (session *Session) authRequest() map[string]interface{} {
+	return map[string]interface{}{
+		"auth": map[string]interface{}{
+			"identity": map[string]interface{}{
+				"methods": []string{"password"},
+				"password": map[string]interface{}{
+					"user": map[string]interface{}{
+						"name":     session.config.Username,
+						"password": session.config.Password,
+						"domain":   map[string]string{"name": session.config.UserDomain},
+					},
+				},
+			},
+			"scope": map[string]interface{}{
+				"project": map[string]interface{}{
+					"name":   session.config.Project,
+					"domain": map[string]string{"name": session.config.ProjectDomain},
+				},
+			},
+		},
+	}
+}
+
+// authenticate exchanges the credentials of the service for a token, replacing the token and catalog of the session
+func (session *Session) authenticate(ctx context.Context) error {
+	body, err := json.Marshal(session.authRequest())
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", strings.TrimSuffix(session.config.URL, "/")+"/v3/auth/tokens", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := session.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != http.StatusCreated {
+		return decodeError(response, contents)
+	}
+
+	issued := new(tokenResponse)
+	if err := json.Unmarshal(contents, issued); err != nil {
+		return err
+	}
+
+	token := response.Header.Get(SubjectTokenHeader)
+	if token == "" {
+		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Keystone issued no token")
+	}
+
+	// Tokens living shorter than the window are replaced halfway through their life
+	now := session.now()
+	refreshAt := issued.Token.ExpiresAt.Add(-RefreshWindow)
+	if refreshAt.Before(now) {
+		refreshAt = now.Add(issued.Token.ExpiresAt.Sub(now) / 2)
+	}
+
+	session.token = token
+	session.refreshAt = refreshAt
+	session.catalog = issued.Token.Catalog
+	return nil
+}
+
+// current authenticates if the session has no token, or its token is due to be replaced. The lock must be held.
+func (session *Session) current(ctx context.Context) error {
+	if session.token != "" && session.now().Before(session.refreshAt) {
+		return nil
+	}
+	return session.authenticate(ctx)
+}
+
+// Token returns the token of the service
+func (session *Session) Token(ctx context.Context) (string, error) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if err := session.current(ctx); err != nil {
+		return "", err
+	}
+	return session.token, nil
+}
+
+// Invalidate drops the token once a service refused it, so the next call authenticates again. A token already
+// replaced by another request is left alone.
+func (session *Session) Invalidate(token string) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if session.token == token {
+		session.token = ""
+	}
+}
+
+// Endpoint returns the URL of the service of the type in the catalog, in the region and through the interface of the
+// configuration. Any region matches if none is configured.
+func (session *Session) Endpoint(ctx context.Context, serviceType string) (string, error) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if err := session.current(ctx); err != nil {
+		return "", err
+	}
+
+	for _, entry := range session.catalog {
+		if entry.Type != serviceType {
+			continue
+		}
+
+		for _, candidate := range entry.Endpoints {
+			if candidate.Interface != session.config.Interface {
+				continue
+			}
+			if session.config.Region != "" && candidate.RegionID != session.config.Region && candidate.Region != session.config.Region {
+				continue
+			}
+			return strings.TrimSuffix(candidate.URL, "/"), nil
+		}
+	}
+	return "", ErrEndpointNotFound
+}
+
+// NewSession will return a new Session of the service. Nothing is sent to Keystone until a token or an endpoint is needed.
+func NewSession(config Config) (*Session, error) {
+	session := new(Session)
+	session.client = &http.Client{
+		Timeout: HTTPClientTimeout,
+	}
+
+	if config.CACert != "" {
+		caCert, err := ioutil.ReadFile(config.CACert)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(caCert)
+		session.client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: pool,
+			},
+		}
+	}
+
+	if config.Interface == "" {
+		config.Interface = PublicInterface
+	}
+
+	session.config = config
+	session.now = time.Now
+	return session, nil
+}
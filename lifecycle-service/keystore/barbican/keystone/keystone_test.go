@@ -0,0 +1,182 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package keystone
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testConfig = Config{
+	Username:      "test-user",
+	Password:      "test-password",
+	UserDomain:    "Default",
+	Project:       "test-project",
+	ProjectDomain: "Default",
+	Region:        "us-south",
+}
+
+// fakeKeystone issues a new token on every authentication, valid for lifetime
+type fakeKeystone struct {
+	auths    int
+	lifetime time.Duration
+}
+
+func (keystone *fakeKeystone) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.URL.Path != "/v3/auth/tokens" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var body struct {
+		Auth struct {
+			Identity struct {
+				Password struct {
+					User struct {
+						Name     string `json:"name"`
+						Password string `json:"password"`
+					} `json:"user"`
+				} `json:"password"`
+			} `json:"identity"`
+			Scope struct {
+				Project struct {
+					Name string `json:"name"`
+				} `json:"project"`
+			} `json:"scope"`
+		} `json:"auth"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	user := body.Auth.Identity.Password.User
+	if user.Name != testConfig.Username || user.Password != testConfig.Password || body.Auth.Scope.Project.Name != testConfig.Project {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"code":401,"message":"The request you have made requires authentication.","title":"Unauthorized"}}`))
+		return
+	}
+
+	keystone.auths++
+	w.Header().Set(SubjectTokenHeader, "token-"+strconv.Itoa(keystone.auths))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token": map[string]interface{}{
+			"expires_at": time.Now().Add(keystone.lifetime).UTC().Format(time.RFC3339),
+			"catalog": []map[string]interface{}{
+				{"type": "identity", "endpoints": []map[string]string{
+					{"interface": "public", "region_id": "us-south", "url": "https://keystone.us-south"},
+				}},
+				{"type": KeyManager, "endpoints": []map[string]string{
+					{"interface": "public", "region_id": "eu-gb", "url": "https://barbican.eu-gb"},
+					{"interface": "internal", "region_id": "us-south", "url": "https://barbican-internal.us-south"},
+					{"interface": "public", "region_id": "us-south", "url": "https://barbican.us-south/"},
+				}},
+			},
+		},
+	})
+}
+
+func newTestSession(t *testing.T, config Config) (*Session, *fakeKeystone, func()) {
+	keystone := &fakeKeystone{lifetime: time.Hour}
+	server := httptest.NewServer(keystone)
+
+	config.URL = server.URL
+	session, err := NewSession(config)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return session, keystone, server.Close
+}
+
+func TestToken(t *testing.T) {
+	session, keystone, cleanUp := newTestSession(t, testConfig)
+	defer cleanUp()
+
+	for i := 0; i < 3; i++ {
+		token, err := session.Token(context.Background())
+		if err != nil || token != "token-1" {
+			t.Fatalf("Expected token-1, received %s, %+v", token, err)
+		}
+	}
+	if keystone.auths != 1 {
+		t.Errorf("Expected the token to be cached, received %d authentications", keystone.auths)
+	}
+
+	// The token is replaced once it is within the refresh window
+	session.now = func() time.Time { return time.Now().Add(time.Hour - RefreshWindow) }
+	if token, err := session.Token(context.Background()); err != nil || token != "token-2" {
+		t.Errorf("Expected token-2, received %s, %+v", token, err)
+	}
+
+	// A refused token is replaced, unless another request replaced it already
+	session.Invalidate("token-1")
+	session.now = time.Now
+	if token, _ := session.Token(context.Background()); token != "token-2" {
+		t.Errorf("Expected token-2, received %s", token)
+	}
+	session.Invalidate("token-2")
+	if token, _ := session.Token(context.Background()); token != "token-3" {
+		t.Errorf("Expected token-3, received %s", token)
+	}
+}
+
+func TestShortLivedToken(t *testing.T) {
+	session, keystone, cleanUp := newTestSession(t, testConfig)
+	defer cleanUp()
+	keystone.lifetime = time.Minute
+
+	session.Token(context.Background())
+	session.Token(context.Background())
+	if keystone.auths != 1 {
+		t.Errorf("Expected a token shorter lived than the window to be cached, received %d authentications", keystone.auths)
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	session, _, cleanUp := newTestSession(t, testConfig)
+	defer cleanUp()
+
+	endpoint, err := session.Endpoint(context.Background(), KeyManager)
+	if err != nil || endpoint != "https://barbican.us-south" {
+		t.Errorf("Expected https://barbican.us-south, received %s, %+v", endpoint, err)
+	}
+
+	internal := testConfig
+	internal.Interface = "internal"
+	session, _, cleanUp = newTestSession(t, internal)
+	defer cleanUp()
+
+	endpoint, err = session.Endpoint(context.Background(), KeyManager)
+	if err != nil || endpoint != "https://barbican-internal.us-south" {
+		t.Errorf("Expected https://barbican-internal.us-south, received %s, %+v", endpoint, err)
+	}
+
+	// Bad Path: no endpoint in the region
+	missing := testConfig
+	missing.Region = "jp-tok"
+	session, _, cleanUp = newTestSession(t, missing)
+	defer cleanUp()
+
+	if _, err := session.Endpoint(context.Background(), KeyManager); err != ErrEndpointNotFound {
+		t.Errorf("Expected %s, received %+v", ErrEndpointNotFound, err)
+	}
+}
+
+func TestBadCredentials(t *testing.T) {
+	config := testConfig
+	config.Password = "wrong-password"
+	session, _, cleanUp := newTestSession(t, config)
+	defer cleanUp()
+
+	_, err := session.Token(context.Background())
+	if errorResponse, ok := err.(*ErrorResponse); !ok || errorResponse.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected a Keystone error, received %+v", err)
+	}
+
+	expected := "Internal Server Error: Keystone returned 401: The request you have made requires authentication."
+	if err.Error() != expected {
+		t.Errorf("Expected %s, received %s", expected, err)
+	}
+}
@@ -0,0 +1,253 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package barbican
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/client"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+// Fields of a key that can be mirrored into the user metadata of its Barbican secret, as named in openstack.barbican.metadata
+const (
+	MetadataName        = "name"
+	MetadataDescription = "description"
+	MetadataTags        = "tags"
+	MetadataExpiration  = "expiration"
+	MetadataCreator     = "creator"
+	MetadataCreated     = "created"
+	MetadataAlgorithm   = "algorithm"
+	MetadataExtractable = "extractable"
+	MetadataState       = "state"
+
+	// MetadataNone in openstack.barbican.metadata mirrors only the RequiredMetadataFields
+	MetadataNone = "none"
+)
+
+// metadataPrefix starts the user metadata written by the service, apart from any written by other Barbican clients
+const metadataPrefix = "kp-"
+
+// DefaultMetadataFields are mirrored when openstack.barbican.metadata is not set
+var DefaultMetadataFields = []string{MetadataName, MetadataTags, MetadataExpiration, MetadataCreator}
+
+// RequiredMetadataFields are mirrored whatever openstack.barbican.metadata names, as a key restored without them
+// would lose its algorithm, extractability or state
+var RequiredMetadataFields = []string{MetadataAlgorithm, MetadataExtractable, MetadataState}
+
+// ErrMetadataPending notifies callers when a generated key has no Barbican secret to hold its metadata yet
+var ErrMetadataPending = errors.New(http.StatusText(http.StatusConflict) + ": Key material is still being generated")
+
+// metadataField reads a field from a key, reporting false if it is not set, and writes it back
+type metadataField struct {
+	encode func(secret *secrets.Secret) (string, bool)
+	decode func(secret *secrets.Secret, value string) error
+}
+
+var metadataFields = map[string]metadataField{
+	MetadataName: {
+		encode: func(secret *secrets.Secret) (string, bool) { return secret.Name, secret.Name != "" },
+		decode: func(secret *secrets.Secret, value string) error { secret.Name = value; return nil },
+	},
+	MetadataDescription: {
+		encode: func(secret *secrets.Secret) (string, bool) { return secret.Description, secret.Description != "" },
+		decode: func(secret *secrets.Secret, value string) error { secret.Description = value; return nil },
+	},
+	MetadataTags: {
+		encode: func(secret *secrets.Secret) (string, bool) {
+			if len(secret.Tags) == 0 {
+				return "", false
+			}
+			// Tags may hold commas, so they are kept as a JSON array
+			tags, err := json.Marshal(secret.Tags)
+			return string(tags), err == nil
+		},
+		decode: func(secret *secrets.Secret, value string) error { return json.Unmarshal([]byte(value), &secret.Tags) },
+	},
+	MetadataExpiration: {
+		encode: func(secret *secrets.Secret) (string, bool) {
+			if secret.CryptoPeriod == nil {
+				return "", false
+			}
+			return secret.ExpirationDate, secret.ExpirationDate != ""
+		},
+		decode: func(secret *secrets.Secret, value string) error { secret.ExpirationDate = value; return nil },
+	},
+	MetadataCreator: {
+		encode: func(secret *secrets.Secret) (string, bool) {
+			if secret.AuditTrail == nil {
+				return "", false
+			}
+			return secret.CreatedBy, secret.CreatedBy != ""
+		},
+		decode: func(secret *secrets.Secret, value string) error { secret.SetCreatedBy(value); return nil },
+	},
+	MetadataCreated: {
+		encode: func(secret *secrets.Secret) (string, bool) {
+			if secret.AuditTrail == nil {
+				return "", false
+			}
+			return secret.CreationDate, secret.CreationDate != ""
+		},
+		decode: func(secret *secrets.Secret, value string) error { secret.CreationDate = value; return nil },
+	},
+	MetadataAlgorithm: {
+		// Imported keys carry no algorithm, which is mirrored too so the field is known to be set
+		encode: func(secret *secrets.Secret) (string, bool) { return secret.AlgorithmType, true },
+		decode: func(secret *secrets.Secret, value string) error { secret.AlgorithmType = value; return nil },
+	},
+	MetadataExtractable: {
+		encode: func(secret *secrets.Secret) (string, bool) {
+			// Keys without the flag are extractable
+			return strconv.FormatBool(secret.Extractable == nil || *secret.Extractable), true
+		},
+		decode: func(secret *secrets.Secret, value string) error {
+			extractable, err := strconv.ParseBool(value)
+			secret.Extractable = &extractable
+			return err
+		},
+	},
+	MetadataState: {
+		encode: func(secret *secrets.Secret) (string, bool) { return strconv.Itoa(int(secret.State)), true },
+		decode: func(secret *secrets.Secret, value string) error {
+			state, err := strconv.Atoi(value)
+			secret.State = secrets.KeyStates(state)
+			return err
+		},
+	},
+}
+
+// parseMetadataFields returns the fields named in the comma separated setting, or the default fields if it is empty,
+// followed by the RequiredMetadataFields not named
+func parseMetadataFields(setting string) ([]string, error) {
+	var fields []string
+	switch setting = strings.TrimSpace(setting); setting {
+	case "":
+		fields = append(fields, DefaultMetadataFields...)
+	case MetadataNone:
+	default:
+		for _, field := range strings.Split(setting, ",") {
+			field = strings.ToLower(strings.TrimSpace(field))
+			if _, ok := metadataFields[field]; !ok {
+				return nil, errors.New(http.StatusText(http.StatusInternalServerError) + ": Unknown Barbican metadata field " + field)
+			}
+			fields = append(fields, field)
+		}
+	}
+
+	for _, required := range RequiredMetadataFields {
+		named := false
+		for _, field := range fields {
+			named = named || field == required
+		}
+		if !named {
+			fields = append(fields, required)
+		}
+	}
+	return fields, nil
+}
+
+// encodeMetadata returns the user metadata mirroring the fields of the key that are set
+func encodeMetadata(secret *secrets.Secret, fields []string) map[string]string {
+	metadata := make(map[string]string)
+	for _, field := range fields {
+		if value, ok := metadataFields[field].encode(secret); ok {
+			metadata[metadataPrefix+field] = value
+		}
+	}
+	return metadata
+}
+
+// decodeMetadata returns the key whose fields were mirrored into the user metadata, whichever fields they were
+func decodeMetadata(keyprotectID string, metadata map[string]string) (*secrets.Secret, error) {
+	secret := secrets.NewSecret()
+	secret.SetID(keyprotectID)
+	for field, mirrored := range metadataFields {
+		value, ok := metadata[metadataPrefix+field]
+		if !ok {
+			continue
+		}
+		if err := mirrored.decode(secret, value); err != nil {
+			return nil, errors.New(http.StatusText(http.StatusInternalServerError) + ": Invalid Barbican metadata " + metadataPrefix + field)
+		}
+	}
+	return secret, nil
+}
+
+// metadataSecretRef returns the Barbican secret holding the metadata of the key, which is the secret of its first
+// version. The metadata of a certificate is held by the secret of its certificate, as containers have none.
+func (s *keystore) metadataSecretRef(ctx context.Context, keyprotectID string) (string, error) {
+	space, extractErr := extractBluemixSpace(s)
+	if extractErr != nil {
+		return "", extractErr
+	}
+
+	org, extractErr := extractBluemixOrg(s)
+	if extractErr != nil {
+		return "", extractErr
+	}
+
+	refs, errTranslate := translateID(keyprotectID, space, org, s)
+	if errTranslate != nil {
+		return "", errTranslate
+	}
+
+	if containerID := refs.ContainerID(); containerID != "" {
+		container, err := s.barbicanClient.GetContainer(ctx, containerID)
+		if err != nil {
+			return "", err
+		}
+		if container.Secrets[client.CertificateSecret] == "" {
+			return "", ErrIncompleteContainer
+		}
+		return container.Secrets[client.CertificateSecret], nil
+	}
+
+	if len(refs.SecretID) == 0 {
+		return "", ErrMetadataPending
+	}
+	return refs.SecretID, nil
+}
+
+// PutMetadata mirrors the fields of openstack.barbican.metadata into the user metadata of the key's Barbican secret.
+// Generated keys have no secret until their order completes, so their metadata is mirrored once they are activated.
+func (s *keystore) PutMetadata(ctx context.Context, keyprotectID string, secret *secrets.Secret) error {
+	if len(s.metadataFields) == 0 {
+		return nil
+	}
+
+	secretRef, err := s.metadataSecretRef(ctx, keyprotectID)
+	if err != nil {
+		return err
+	}
+
+	return s.barbicanClient.PutMetadata(ctx, secretRef, encodeMetadata(secret, s.metadataFields))
+}
+
+// GetMetadata returns the key with the fields mirrored into the user metadata of its Barbican secret. Keys
+// mirrored before the RequiredMetadataFields were are incomplete.
+func (s *keystore) GetMetadata(ctx context.Context, keyprotectID string) (*secrets.Secret, error) {
+	secretRef, err := s.metadataSecretRef(ctx, keyprotectID)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := s.barbicanClient.GetMetadata(ctx, secretRef)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, required := range RequiredMetadataFields {
+		if _, ok := metadata[metadataPrefix+required]; !ok {
+			return nil, definitions.ErrMetadataIncomplete
+		}
+	}
+
+	return decodeMetadata(keyprotectID, metadata)
+}
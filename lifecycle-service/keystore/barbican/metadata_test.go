@@ -0,0 +1,116 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package barbican
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+func TestParseMetadataFields(t *testing.T) {
+	// The required fields are mirrored whatever the setting
+	expected := append(append([]string{}, DefaultMetadataFields...), RequiredMetadataFields...)
+	fields, err := parseMetadataFields("")
+	if err != nil || !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected %v, received %v, %+v", expected, fields, err)
+	}
+
+	fields, err = parseMetadataFields(MetadataNone)
+	if err != nil || !reflect.DeepEqual(fields, RequiredMetadataFields) {
+		t.Errorf("Expected %v, received %v, %+v", RequiredMetadataFields, fields, err)
+	}
+
+	fields, err = parseMetadataFields(" Name, state ")
+	if expected := []string{MetadataName, MetadataState, MetadataAlgorithm, MetadataExtractable}; err != nil || !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected %v, received %v, %+v", expected, fields, err)
+	}
+
+	// Bad Path: unknown field
+	if _, err := parseMetadataFields("name,payload"); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+}
+
+func TestEncodeDecodeMetadata(t *testing.T) {
+	extractable := false
+	secret := secrets.NewSecret()
+	secret.Name = "test-key"
+	secret.Tags = []string{"a,b", "c"}
+	secret.State = secrets.Suspended
+	secret.Extractable = &extractable
+
+	fields := []string{MetadataName, MetadataDescription, MetadataTags, MetadataExtractable, MetadataState}
+	metadata := encodeMetadata(secret, fields)
+	if _, ok := metadata[metadataPrefix+MetadataDescription]; ok {
+		t.Error("Expected an unset field to be left out")
+	}
+	if metadata[metadataPrefix+MetadataTags] != `["a,b","c"]` {
+		t.Errorf("Expected the tags as a JSON array, received %s", metadata[metadataPrefix+MetadataTags])
+	}
+
+	// Metadata written by other clients is ignored
+	metadata["other"] = "value"
+	decoded, err := decodeMetadata("test-id", metadata)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if decoded.ID != "test-id" || decoded.Name != secret.Name || !reflect.DeepEqual(decoded.Tags, secret.Tags) ||
+		decoded.State != secret.State || decoded.Extractable == nil || *decoded.Extractable != extractable {
+		t.Errorf("Expected %+v, received %+v", secret, decoded)
+	}
+
+	// Bad Path: malformed value
+	if _, err := decodeMetadata("test-id", map[string]string{metadataPrefix + MetadataState: "active"}); err == nil {
+		t.Error("Expected an error for a malformed state")
+	}
+}
+
+func TestPutGetMetadata(t *testing.T) {
+	headerSetup()
+	defer cleanUp()
+
+	secret := secrets.NewSecret()
+	secret.Name = "test-key"
+
+	// No fields configured: nothing is written
+	if err := testKeystore.PutMetadata(context.Background(), "test-id", secret); err != nil || fBarbicanClient.metadata != nil {
+		t.Errorf("Expected nothing to be mirrored, received %v, %+v", fBarbicanClient.metadata, err)
+	}
+
+	fields, err := parseMetadataFields(MetadataName)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	testKeystore.metadataFields = fields
+
+	// Bad Path: generated key without material
+	fDatabase.InjectRefs(&db.BarbicanRefs{KpID: "test-id", OrderID: "test-order-id"})
+	if err := testKeystore.PutMetadata(context.Background(), "test-id", secret); err != ErrMetadataPending {
+		t.Errorf("Expected %s, received %+v", ErrMetadataPending, err)
+	}
+
+	fDatabase.InjectRefs(&db.BarbicanRefs{KpID: "test-id", SecretID: "test-secret-id"})
+	if err := testKeystore.PutMetadata(context.Background(), "test-id", secret); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if fBarbicanClient.metadata["test-secret-id"][metadataPrefix+MetadataName] != "test-key" {
+		t.Errorf("Expected the name on the secret, received %v", fBarbicanClient.metadata)
+	}
+
+	// Keys without the flag are mirrored as extractable
+	mirrored, err := testKeystore.GetMetadata(context.Background(), "test-id")
+	if err != nil || mirrored.ID != "test-id" || mirrored.Name != "test-key" || mirrored.Extractable == nil || !*mirrored.Extractable {
+		t.Errorf("Expected test-key, received %+v, %+v", mirrored, err)
+	}
+
+	// Bad Path: keys mirrored without the required fields cannot be restored
+	delete(fBarbicanClient.metadata["test-secret-id"], metadataPrefix+MetadataState)
+	if _, err := testKeystore.GetMetadata(context.Background(), "test-id"); err != definitions.ErrMetadataIncomplete {
+		t.Errorf("Expected %s, received %+v", definitions.ErrMetadataIncomplete, err)
+	}
+}
@@ -3,7 +3,8 @@
 package db
 
 /*
-This DB package is ONLY to be used for ID translations between KP ids and secret or order refs.
+This DB package is ONLY to be used for ID translations between KP ids and secret or order refs,
+along with the rotation and deletion policies of those ids.
 */
 import (
 	"bytes"
@@ -13,8 +14,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gocql/gocql"
 	config "github.ibm.com/Alchemy-Key-Protect/kp-go-config"
@@ -25,12 +28,25 @@ import (
 //in that table.
 var tables map[string][]string
 
-//table names related to KP ID translation in cassandra
+//table names related to KP ID translation in cassandra. The tables added along with
+//versions and policies, and the columns added to idTracker, are created by schema/cassandra.cql.
 /* #nosec */
 const (
 	idBySecret = "id_by_secret_ref"
 	idByOrder  = "id_by_order_ref"
-	idTracker  = "id_tracker"
+	//idTracker holds the refs of the FirstVersion of keys, keyed on (space_id, keyprotect_id).
+	//Like idByVersion, it has a deleted column, as keys are soft deleted so that their IDs are not reused.
+	//Rows written before the column was added have a null deleted, which is scanned as false.
+	idTracker = "id_tracker"
+	//idByVersion holds the refs of versions added by rotation, keyed on ((space_id, keyprotect_id), version).
+	//The FirstVersion of a key is kept in the tables above.
+	idByVersion = "id_by_version"
+	//policyByKey holds the rotation policies of keys, keyed on (space_id, keyprotect_id)
+	policyByKey = "rotation_policy_by_key"
+	//deletionPolicyByKey holds the deletion policies of keys, keyed on (space_id, keyprotect_id)
+	deletionPolicyByKey = "deletion_policy_by_key"
+	//importTokenBySpace holds the import token of each space, keyed on space_id
+	importTokenBySpace = "import_token_by_space"
 )
 
 //column names used in cassandra
@@ -41,16 +57,29 @@ const (
 	orderRefColumn  = "order_ref"
 	spaceIDColumn   = "space_id"
 	orgIDColumn     = "org_id"
+	versionColumn   = "version"
+	intervalColumn  = "interval_days"
+	lastRotation    = "last_rotation"
+	nextRotation    = "next_rotation"
+	pendingUser     = "pending_user_id"
+	pendingExpiry   = "pending_expiration"
+	deletedColumn   = "deleted"
+	tokenIDColumn   = "token_id"
+	publicKey       = "public_key"
+	sealedKey       = "sealed_private_key"
+	tokenExpiry     = "expiration"
 )
 
 //Keyspace used for ID translations
 const idNameSpace = "kp_id_tracker"
 
 func init() {
+	//the lookup tables, written along with idTracker
 	tables = make(map[string][]string)
 	tables[idBySecret] = []string{kpIDColumn, orgIDColumn, secretRefColumn}
 	tables[idByOrder] = []string{kpIDColumn, orgIDColumn, orderRefColumn}
-	tables[idTracker] = []string{kpIDColumn, secretRefColumn, orderRefColumn, spaceIDColumn, orgIDColumn}
+
+	Register(CassandraBackend, newCassandraInstance)
 }
 
 var dbInstance *cassandraDB
@@ -75,7 +104,7 @@ func (d *cassandraDB) refreshSession() error {
 }
 
 //NewDBInstance Creates a new instance of the DB.
-func newCassandraInstance() (DB, error) {
+func newCassandraInstance() (Store, error) {
 	var err error
 	once.Do(func() {
 		dbInstance = new(cassandraDB)
@@ -92,16 +121,26 @@ func newCassandraInstance() (DB, error) {
 
 		dbInstance.Session, err = dbInstance.Cluster.CreateSession()
 	})
+	//the configuration is only loaded once, so later calls report that it failed
+	if dbInstance.Cluster == nil {
+		if err == nil {
+			err = errors.New(http.StatusText(http.StatusInternalServerError) + ": Cassandra is not configured")
+		}
+		return nil, err
+	}
 	if err != nil || dbInstance.Session == nil || dbInstance.Session.Closed() {
 		err = dbInstance.refreshSession()
 	}
-
-	return dbInstance, err
+	if err != nil {
+		return nil, err
+	}
+	return dbInstance, nil
 }
 
 /*
 Add will add a new mapping into cassandra that relates the kp id to the given
-secret ref and order ref.
+secret ref and order ref. The mapping is only added if the key has none, even a
+deleted one, so that IDs are not reused.
 */
 func (d *cassandraDB) Add(space string, org string, refs *BarbicanRefs) error {
 	if refs == nil {
@@ -112,15 +151,36 @@ func (d *cassandraDB) Add(space string, org string, refs *BarbicanRefs) error {
 		return errors.New("Missing space refs")
 	}
 
-	if len(refs.KpID) == 0 || len(refs.SecretID) == 0 {
+	if len(refs.KpID) == 0 || (len(refs.SecretID) == 0 && len(refs.OrderID) == 0) {
 		return errors.New("Missing references")
 	}
 
+	if IsRotatedVersion(refs) {
+		return d.addVersion(space, org, refs)
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("INSERT INTO %s (%s,%s,%s,%s,%s) VALUES (?,?,?,?,?) IF NOT EXISTS", idTracker, kpIDColumn, secretRefColumn, orderRefColumn, spaceIDColumn, orgIDColumn)
+	applied, err := d.Session.Query(query, refs.KpID, refs.SecretID, refs.OrderID, space, org).Consistency(gocql.Quorum).MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrDuplicateRefs
+	}
+	return d.addLookups(org, refs)
+}
+
+/*
+addLookups writes the rows finding the kp id of the secret and order refs.
+Cassandra refuses empty partition keys, so only the refs that are set are written.
+*/
+func (d *cassandraDB) addLookups(org string, refs *BarbicanRefs) error {
 	insertBatch := d.Session.NewBatch(gocql.LoggedBatch)
-	var query string
 	for table, columns := range tables {
 		var columnNames bytes.Buffer
-		columnValues := make([]interface{}, 10)
+		columnValues := make([]interface{}, len(columns))
+		skip := false
 		for i, column := range columns {
 			columnNames.Write([]byte(column))
 			columnNames.Write([]byte(","))
@@ -128,53 +188,389 @@ func (d *cassandraDB) Add(space string, org string, refs *BarbicanRefs) error {
 				columnValues[i] = refs.KpID
 			} else if column == secretRefColumn {
 				columnValues[i] = refs.SecretID
+				skip = len(refs.SecretID) == 0
 			} else if column == orderRefColumn {
 				columnValues[i] = refs.OrderID
-			} else if column == spaceIDColumn {
-				columnValues[i] = space
+				skip = len(refs.OrderID) == 0
 			} else if column == orgIDColumn {
 				columnValues[i] = org
 			}
 		}
+		if skip {
+			continue
+		}
 		columns := strings.TrimSuffix(columnNames.String(), ",")
 		/* #nosec */
-		query = fmt.Sprintf("INSERT INTO %s (%s) VALUES (?,?,?,?)", table, columns)
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (?,?,?)", table, columns)
 		insertBatch.Query(query, columnValues...)
 	}
+	if insertBatch.Size() == 0 {
+		return nil
+	}
 	return d.Session.ExecuteBatch(insertBatch)
 }
 
 /*
-Updates the given row. Cassandra will overwrite any values when inserted, so this
-function simply calls add.
+Updates the refs of a key that was added. Unlike an insert, the update is only
+applied if the row exists.
 */
 func (d *cassandraDB) Update(space string, org string, refs *BarbicanRefs) error {
-	return d.Add(space, org, refs)
+	if refs == nil {
+		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires translation references")
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("UPDATE %s SET %s=?,%s=? WHERE %s = ? AND %s = ? IF EXISTS", idTracker, secretRefColumn, orderRefColumn, spaceIDColumn, kpIDColumn)
+	args := []interface{}{refs.SecretID, refs.OrderID, space, refs.KpID}
+	if IsRotatedVersion(refs) {
+		/* #nosec */
+		query = fmt.Sprintf("UPDATE %s SET %s=?,%s=? WHERE %s = ? AND %s = ? AND %s = ? IF EXISTS", idByVersion, secretRefColumn, orderRefColumn, spaceIDColumn, kpIDColumn, versionColumn)
+		args = append(args, refs.Version)
+	}
+
+	applied, err := d.Session.Query(query, args...).Consistency(gocql.Quorum).MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrNoRowsUpdated
+	}
+	if IsRotatedVersion(refs) {
+		return nil
+	}
+	return d.addLookups(org, refs)
 }
 
 func (d *cassandraDB) Get(space string, org string, kpID string) (*BarbicanRefs, error) {
 	var secret string
 	var order string
+	var deleted bool
 	tableName := string(idTracker)
 	/* #nosec */
-	query := fmt.Sprintf("SELECT %s,%s FROM %s WHERE %s = ? AND %s = ? LIMIT 1", secretRefColumn, orderRefColumn, tableName, spaceIDColumn, kpIDColumn)
-	err := d.Session.Query(query, space, kpID).Consistency(gocql.Quorum).Scan(&secret, &order)
+	query := fmt.Sprintf("SELECT %s,%s,%s FROM %s WHERE %s = ? AND %s = ? LIMIT 1", secretRefColumn, orderRefColumn, deletedColumn, tableName, spaceIDColumn, kpIDColumn)
+	err := d.Session.Query(query, space, kpID).Consistency(gocql.Quorum).Scan(&secret, &order, &deleted)
+	if err == gocql.ErrNotFound || deleted {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &BarbicanRefs{OrderID: order, SecretID: secret, KpID: kpID}, nil
+	return &BarbicanRefs{OrderID: order, SecretID: secret, KpID: kpID, Version: FirstVersion}, nil
 }
 
-func (d *cassandraDB) Delete(space string, org string, kpID string) error {
-	//Right now this is a no-op as we soft delete secrets.
+func (d *cassandraDB) GetVersion(space string, org string, kpID string, version int) (*BarbicanRefs, error) {
+	if version <= FirstVersion {
+		return d.Get(space, org, kpID)
+	}
+
+	var secret string
+	var order string
+	var deleted bool
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s FROM %s WHERE %s = ? AND %s = ? AND %s = ?", secretRefColumn, orderRefColumn, deletedColumn, idByVersion, spaceIDColumn, kpIDColumn, versionColumn)
+	err := d.Session.Query(query, space, kpID, version).Consistency(gocql.Quorum).Scan(&secret, &order, &deleted)
+	if err == gocql.ErrNotFound || deleted {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &BarbicanRefs{OrderID: order, SecretID: secret, KpID: kpID, Version: version}, nil
+}
+
+/*
+ListVersions returns every version of the key, ordered from the FirstVersion to the latest.
+*/
+func (d *cassandraDB) ListVersions(space string, org string, kpID string) ([]*BarbicanRefs, error) {
+	first, err := d.Get(space, org, kpID)
+	if err != nil {
+		return nil, err
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s,%s FROM %s WHERE %s = ? AND %s = ? ORDER BY %s ASC", versionColumn, secretRefColumn, orderRefColumn, deletedColumn, idByVersion, spaceIDColumn, kpIDColumn, versionColumn)
+	iter := d.Session.Query(query, space, kpID).Consistency(gocql.Quorum).Iter()
+
+	refs := []*BarbicanRefs{first}
+	var version int
+	var secret string
+	var order string
+	var deleted bool
+	for iter.Scan(&version, &secret, &order, &deleted) {
+		if !deleted {
+			refs = append(refs, &BarbicanRefs{OrderID: order, SecretID: secret, KpID: kpID, Version: version})
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+/*
+ListKeys returns the FirstVersion of every key of the space, ordered by KP ID.
+*/
+func (d *cassandraDB) ListKeys(space string, org string) ([]*BarbicanRefs, error) {
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s,%s FROM %s WHERE %s = ?", kpIDColumn, secretRefColumn, orderRefColumn, deletedColumn, idTracker, spaceIDColumn)
+	iter := d.Session.Query(query, space).Consistency(gocql.Quorum).Iter()
+
+	refs := []*BarbicanRefs{}
+	var kpID string
+	var secret string
+	var order string
+	var deleted bool
+	for iter.Scan(&kpID, &secret, &order, &deleted) {
+		if !deleted {
+			refs = append(refs, &BarbicanRefs{OrderID: order, SecretID: secret, KpID: kpID, Version: FirstVersion})
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].KpID < refs[j].KpID })
+	return refs, nil
+}
+
+/*
+ListSpaces returns the sorted spaces of the org that have keys. It reads the secondary
+index of id_tracker on org_id.
+*/
+func (d *cassandraDB) ListSpaces(org string) ([]string, error) {
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s FROM %s WHERE %s = ?", spaceIDColumn, deletedColumn, idTracker, orgIDColumn)
+	iter := d.Session.Query(query, org).Consistency(gocql.Quorum).Iter()
+
+	unique := make(map[string]bool)
+	var space string
+	var deleted bool
+	for iter.Scan(&space, &deleted) {
+		if !deleted {
+			unique[space] = true
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	spaces := []string{}
+	for space := range unique {
+		spaces = append(spaces, space)
+	}
+	sort.Strings(spaces)
+	return spaces, nil
+}
+
+/*
+addVersion adds the refs of a rotated version, if the version has none.
+*/
+func (d *cassandraDB) addVersion(space string, org string, refs *BarbicanRefs) error {
+	/* #nosec */
+	query := fmt.Sprintf("INSERT INTO %s (%s,%s,%s,%s,%s,%s) VALUES (?,?,?,?,?,?) IF NOT EXISTS", idByVersion, spaceIDColumn, kpIDColumn, versionColumn, orgIDColumn, secretRefColumn, orderRefColumn)
+	applied, err := d.Session.Query(query, space, refs.KpID, refs.Version, org, refs.SecretID, refs.OrderID).Consistency(gocql.Quorum).MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrDuplicateRefs
+	}
 	return nil
 }
 
+/*
+Delete marks every version of the key deleted. The rows are kept so that the
+ID is not reused.
+*/
+func (d *cassandraDB) Delete(space string, org string, kpID string) error {
+	/* #nosec */
+	query := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ? AND %s = ? IF EXISTS", idTracker, deletedColumn, spaceIDColumn, kpIDColumn)
+	if _, err := d.Session.Query(query, true, space, kpID).Consistency(gocql.Quorum).MapScanCAS(make(map[string]interface{})); err != nil {
+		return err
+	}
+
+	/* #nosec */
+	query = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? AND %s = ?", versionColumn, idByVersion, spaceIDColumn, kpIDColumn)
+	iter := d.Session.Query(query, space, kpID).Consistency(gocql.Quorum).Iter()
+
+	//the versions of a key share its partition, so they are marked in a single batch
+	deleteBatch := d.Session.NewBatch(gocql.LoggedBatch)
+	var version int
+	for iter.Scan(&version) {
+		/* #nosec */
+		deleteBatch.Query(fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ? AND %s = ? AND %s = ?", idByVersion, deletedColumn, spaceIDColumn, kpIDColumn, versionColumn), true, space, kpID, version)
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	if deleteBatch.Size() == 0 {
+		return nil
+	}
+	return d.Session.ExecuteBatch(deleteBatch)
+}
+
+/*
+SetPolicy writes the rotation policy of a key. Cassandra overwrites existing rows,
+so it replaces any earlier policy.
+*/
+func (d *cassandraDB) SetPolicy(policy *RotationPolicy) error {
+	if policy == nil {
+		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires a rotation policy")
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("INSERT INTO %s (%s,%s,%s,%s,%s,%s) VALUES (?,?,?,?,?,?)", policyByKey, spaceIDColumn, kpIDColumn, orgIDColumn, intervalColumn, lastRotation, nextRotation)
+	return d.Session.Query(query, policy.Space, policy.KpID, policy.Org, policy.IntervalDays, UnixSeconds(policy.LastRotation), policy.NextRotation.Unix()).Consistency(gocql.Quorum).Exec()
+}
+
+func (d *cassandraDB) GetPolicy(space string, org string, kpID string) (*RotationPolicy, error) {
+	var last, next int64
+	policy := &RotationPolicy{Space: space, Org: org, KpID: kpID}
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s FROM %s WHERE %s = ? AND %s = ?", intervalColumn, lastRotation, nextRotation, policyByKey, spaceIDColumn, kpIDColumn)
+	err := d.Session.Query(query, space, kpID).Consistency(gocql.Quorum).Scan(&policy.IntervalDays, &last, &next)
+	if err == gocql.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy.LastRotation = FromUnixSeconds(last)
+	policy.NextRotation = FromUnixSeconds(next)
+	return policy, nil
+}
+
+func (d *cassandraDB) DeletePolicy(space string, org string, kpID string) error {
+	/* #nosec */
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND %s = ?", policyByKey, spaceIDColumn, kpIDColumn)
+	return d.Session.Query(query, space, kpID).Consistency(gocql.Quorum).Exec()
+}
+
+/*
+ListDuePolicies returns the policies of every space whose next rotation is not after now.
+Policies are partitioned by key, so this scans the table and should only be run by the scheduler.
+*/
+func (d *cassandraDB) ListDuePolicies(now time.Time) ([]*RotationPolicy, error) {
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s,%s,%s,%s FROM %s WHERE %s <= ? ALLOW FILTERING", kpIDColumn, spaceIDColumn, orgIDColumn, intervalColumn, lastRotation, nextRotation, policyByKey, nextRotation)
+	iter := d.Session.Query(query, now.Unix()).Consistency(gocql.Quorum).Iter()
+
+	policies := []*RotationPolicy{}
+	var kpID, space, org string
+	var interval int
+	var last, next int64
+	for iter.Scan(&kpID, &space, &org, &interval, &last, &next) {
+		policies = append(policies, &RotationPolicy{
+			Space:        space,
+			Org:          org,
+			KpID:         kpID,
+			IntervalDays: interval,
+			LastRotation: FromUnixSeconds(last),
+			NextRotation: FromUnixSeconds(next),
+		})
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+/*
+ClaimPolicy moves the next rotation of the policy to until, unless it was moved since
+the policy was read. The condition is checked by a lightweight transaction.
+*/
+func (d *cassandraDB) ClaimPolicy(policy *RotationPolicy, until time.Time) (bool, error) {
+	/* #nosec */
+	query := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ? AND %s = ? IF %s = ?", policyByKey, nextRotation, spaceIDColumn, kpIDColumn, nextRotation)
+	return d.Session.Query(query, until.Unix(), policy.Space, policy.KpID, policy.NextRotation.Unix()).Consistency(gocql.Quorum).MapScanCAS(make(map[string]interface{}))
+}
+
+/*
+SetDeletionPolicy writes the deletion policy of a key. Cassandra overwrites existing rows,
+so it replaces any earlier policy.
+*/
+func (d *cassandraDB) SetDeletionPolicy(policy *DeletionPolicy) error {
+	if policy == nil {
+		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires a deletion policy")
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("INSERT INTO %s (%s,%s,%s,%s,%s) VALUES (?,?,?,?,?)", deletionPolicyByKey, spaceIDColumn, kpIDColumn, orgIDColumn, pendingUser, pendingExpiry)
+	return d.Session.Query(query, policy.Space, policy.KpID, policy.Org, policy.PendingUserID, UnixSeconds(policy.PendingExpiration)).Consistency(gocql.Quorum).Exec()
+}
+
+func (d *cassandraDB) GetDeletionPolicy(space string, org string, kpID string) (*DeletionPolicy, error) {
+	var expiration int64
+	policy := &DeletionPolicy{Space: space, Org: org, KpID: kpID}
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s FROM %s WHERE %s = ? AND %s = ?", pendingUser, pendingExpiry, deletionPolicyByKey, spaceIDColumn, kpIDColumn)
+	err := d.Session.Query(query, space, kpID).Consistency(gocql.Quorum).Scan(&policy.PendingUserID, &expiration)
+	if err == gocql.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy.PendingExpiration = FromUnixSeconds(expiration)
+	return policy, nil
+}
+
+func (d *cassandraDB) DeleteDeletionPolicy(space string, org string, kpID string) error {
+	/* #nosec */
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND %s = ?", deletionPolicyByKey, spaceIDColumn, kpIDColumn)
+	return d.Session.Query(query, space, kpID).Consistency(gocql.Quorum).Exec()
+}
+
+/*
+AddImportToken adds the import token of the space, unless it already has one.
+The condition is checked by a lightweight transaction.
+*/
+func (d *cassandraDB) AddImportToken(token *ImportToken) (bool, error) {
+	if token == nil {
+		return false, errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires an import token")
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("INSERT INTO %s (%s,%s,%s,%s,%s,%s) VALUES (?,?,?,?,?,?) IF NOT EXISTS", importTokenBySpace, spaceIDColumn, orgIDColumn, tokenIDColumn, publicKey, sealedKey, tokenExpiry)
+	return d.Session.Query(query, token.Space, token.Org, token.ID, token.PublicKey, token.SealedPrivateKey, UnixSeconds(token.Expiration)).Consistency(gocql.Quorum).MapScanCAS(make(map[string]interface{}))
+}
+
+func (d *cassandraDB) GetImportToken(space string, org string) (*ImportToken, error) {
+	var expiration int64
+	token := &ImportToken{Space: space, Org: org}
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s,%s FROM %s WHERE %s = ?", tokenIDColumn, publicKey, sealedKey, tokenExpiry, importTokenBySpace, spaceIDColumn)
+	err := d.Session.Query(query, space).Consistency(gocql.Quorum).Scan(&token.ID, &token.PublicKey, &token.SealedPrivateKey, &expiration)
+	if err == gocql.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	token.Expiration = FromUnixSeconds(expiration)
+	return token, nil
+}
+
+/*
+ClaimImportToken removes the import token of the space, unless it was replaced since
+it was read. The condition is checked by a lightweight transaction.
+*/
+func (d *cassandraDB) ClaimImportToken(space string, org string, id string) (bool, error) {
+	/* #nosec */
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = ? IF %s = ?", importTokenBySpace, spaceIDColumn, tokenIDColumn)
+	return d.Session.Query(query, space, id).Consistency(gocql.Quorum).MapScanCAS(make(map[string]interface{}))
+}
+
 func loadConfig(configuration *dbConfiguration) error {
-	file, err := os.Open(config.Get().GetString("database.credentialsLocation"))
+	var credentialsLocation string
+	if credentialsLocation = os.Getenv("CASSANDRA_CREDENTIALS_LOCATION"); credentialsLocation == "" {
+		credentialsLocation = config.Get().GetString("database.credentialsLocation")
+	}
+
+	file, err := os.Open(credentialsLocation)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
 	bytes, err := ioutil.ReadAll(file)
 	if err != nil {
@@ -0,0 +1,42 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package db_test
+
+import (
+	"os"
+	"testing"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db/dbtest"
+)
+
+// cassandraCredentialsEnv names the credentials of a Cassandra cluster with the keyspace of the service, created by
+// schema/cassandra.cql, which the tests add rows to. The tests are skipped without it.
+const cassandraCredentialsEnv = "CASSANDRA_CREDENTIALS_LOCATION"
+
+// openCassandra opens the Cassandra cluster of the tests, skipping them if none is set up
+func openCassandra(t *testing.T) db.Store {
+	if os.Getenv(cassandraCredentialsEnv) == "" {
+		t.Skip(cassandraCredentialsEnv + " is not set")
+	}
+	store, err := db.NewCassandraInstance()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return store
+}
+
+func TestCassandraDB(t *testing.T) {
+	store := openCassandra(t)
+	dbtest.TestDB(t, func() db.DB { return store })
+}
+
+func TestCassandraPolicyDB(t *testing.T) {
+	store := openCassandra(t)
+	dbtest.TestPolicyDB(t, func() db.PolicyDB { return store })
+}
+
+func TestCassandraImportTokenDB(t *testing.T) {
+	store := openCassandra(t)
+	dbtest.TestImportTokenDB(t, func() db.ImportTokenDB { return store })
+}
@@ -4,6 +4,9 @@ package db
 
 import (
 	"errors"
+	"net/http"
+	"strings"
+	"sync"
 
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-config"
 )
@@ -21,28 +24,141 @@ type BarbicanRefs struct {
 	SecretID string
 	OrderID  string
 	KpID     string
+	// Version of the key material the refs point to. Rotation adds a new version to the key.
+	Version int
+}
+
+// FirstVersion is the version of the material a key is created with
+const FirstVersion = 1
+
+// keystoreRefPrefix starts the secret ref of keys migrated out of Barbican, and is followed by the backend now holding them
+const keystoreRefPrefix = "keystore:"
+
+// MigratedRefs are the refs of a version of a key migrated to another backend, which holds it under the KP ID
+func MigratedRefs(kpID string, version int, backend string) *BarbicanRefs {
+	return &BarbicanRefs{SecretID: keystoreRefPrefix + backend, KpID: kpID, Version: version}
+}
+
+// Keystore returns the backend a migrated key is held in, or an empty string for keys held by Barbican
+func (refs *BarbicanRefs) Keystore() string {
+	if !strings.HasPrefix(refs.SecretID, keystoreRefPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(refs.SecretID, keystoreRefPrefix)
+}
+
+// containerRefPrefix starts the secret ref of certificates, and is followed by the Barbican container holding the
+// secrets of the bundle
+const containerRefPrefix = "container:"
+
+// ContainerRefs are the refs of a certificate, whose bundle is held by a Barbican container
+func ContainerRefs(kpID string, containerID string) *BarbicanRefs {
+	return &BarbicanRefs{SecretID: containerRefPrefix + containerID, KpID: kpID, Version: FirstVersion}
+}
+
+// ContainerID returns the Barbican container holding a certificate, or an empty string for keys
+func (refs *BarbicanRefs) ContainerID() string {
+	if !strings.HasPrefix(refs.SecretID, containerRefPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(refs.SecretID, containerRefPrefix)
 }
 
 // ErrNotFound is for when an entry in the db is not found
 var ErrNotFound = errors.New("Not found")
 
+// ErrNoRowsUpdated is returned when the refs to update were never added
+var ErrNoRowsUpdated = errors.New("Issue seen with Order processing")
+
+// ErrDuplicateRefs is returned when refs are added for a version of a key that already has refs, even deleted ones
+var ErrDuplicateRefs = errors.New(http.StatusText(http.StatusConflict) + ": Translation references already exist")
+
+// Backends selected by database.backend. Without a backend, MySQL is used, or Cassandra when featuretoggle.cassandra
+// is set. Backends register themselves, and SQLite, which needs cgo, is only built in with the sqlite build tag.
+const (
+	MySQLBackend     = "mysql"
+	CassandraBackend = "cassandra"
+	MemoryBackend    = "memory"
+	SQLiteBackend    = "sqlite"
+)
+
 //DB The interface for interacting with the csv database
+//Add and Update act on the version given in the refs. Refs without a version refer to the FirstVersion.
+//Get returns the FirstVersion of a key, while Delete removes every version.
+//ListKeys returns the FirstVersion of every key of the space.
+//ListSpaces returns the sorted spaces of the org that have keys, from the org each key was added with.
 type DB interface {
 	Add(space string, org string, refs *BarbicanRefs) error
 	Update(space string, org string, refs *BarbicanRefs) error
 	Get(space string, org string, kpID string) (*BarbicanRefs, error)
 	Delete(space string, org string, kpID string) error
+	GetVersion(space string, org string, kpID string, version int) (*BarbicanRefs, error)
+	ListVersions(space string, org string, kpID string) ([]*BarbicanRefs, error)
+	ListKeys(space string, org string) ([]*BarbicanRefs, error)
+	ListSpaces(org string) ([]string, error)
 }
 
-//NewDBInstance Creates a new instance of the DB.
-func NewDBInstance() DB {
-	useCassandra := configuration.Get().GetBool("featuretoggle.cassandra")
-	if useCassandra {
-		db, err := newCassandraInstance()
-		if err != nil {
-			panic(err.Error())
+// IsRotatedVersion reports whether refs point to material added by a rotation rather than the FirstVersion
+func IsRotatedVersion(refs *BarbicanRefs) bool {
+	return refs.Version > FirstVersion
+}
+
+// Store holds the ID translations, the policies of keys and the import tokens of spaces, as every backend keeps them
+// in the same database
+type Store interface {
+	DB
+	PolicyDB
+	ImportTokenDB
+}
+
+// Opener opens the Store of a backend. Stores hold the connections of the process, so they are opened once and
+// shared by every caller.
+type Opener func() (Store, error)
+
+var (
+	storesLock sync.RWMutex
+	stores     = make(map[string]Opener)
+)
+
+// Register makes a Store available to database.backend by name. Like the keystore backends, stores register
+// themselves from the init functions of their packages, so registering a name twice panics.
+func Register(name string, open Opener) {
+	storesLock.Lock()
+	defer storesLock.Unlock()
+
+	if open == nil {
+		panic("db: Register opener is nil for " + name)
+	}
+	if _, ok := stores[name]; ok {
+		panic("db: Register called twice for " + name)
+	}
+	stores[name] = open
+}
+
+// openStore opens the Store selected by database.backend
+func openStore() (Store, error) {
+	backend := configuration.Get().GetString("database.backend")
+	if backend == "" {
+		backend = MySQLBackend
+		if configuration.Get().GetBool("featuretoggle.cassandra") {
+			backend = CassandraBackend
 		}
-		return db
 	}
-	return newMYSQLinstance()
+
+	storesLock.RLock()
+	open, ok := stores[backend]
+	storesLock.RUnlock()
+	if !ok {
+		return nil, errors.New(http.StatusText(http.StatusInternalServerError) + ": Unknown database backend " + backend + ", which may need its build tag")
+	}
+	return open()
+}
+
+//NewDBInstance Creates a new instance of the DB.
+func NewDBInstance() (DB, error) {
+	store, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
 }
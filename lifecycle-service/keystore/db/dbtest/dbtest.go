@@ -0,0 +1,528 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+// Package dbtest checks that implementations of db.DB, db.PolicyDB and db.ImportTokenDB keep the semantics the
+// service relies on: refs are scoped to their space, deleted keys stay deleted, and missing refs are reported with
+// db.ErrNotFound.
+package dbtest
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+)
+
+const testOrg = "test-org"
+
+// spaces are the spaces a test works in. They are named after the test and the time it ran, so the suites can run
+// against databases holding the rows of earlier runs.
+type spaces struct {
+	space string
+	other string
+}
+
+func newSpaces(test string) spaces {
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	return spaces{space: "test-space-" + test + "-" + run, other: "test-other-space-" + test + "-" + run}
+}
+
+// TestDB runs the conformance tests against DBs returned by newDB. Each test works in spaces of its own, so the
+// DBs may be shared.
+func TestDB(t *testing.T, newDB func() db.DB) {
+	tests := []struct {
+		name string
+		test func(*testing.T, db.DB, spaces)
+	}{
+		{"AddGet", testAddGet},
+		{"AddDuplicate", testAddDuplicate},
+		{"Update", testUpdate},
+		{"Delete", testDelete},
+		{"Versions", testVersions},
+		{"ListKeys", testListKeys},
+		{"ListSpaces", testListSpaces},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newDB(), newSpaces(test.name))
+		})
+	}
+}
+
+// mustAdd adds the refs, failing the test on error
+func mustAdd(t *testing.T, database db.DB, space string, refs *db.BarbicanRefs) {
+	if err := database.Add(space, testOrg, refs); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+}
+
+func testAddGet(t *testing.T, database db.DB, s spaces) {
+	mustAdd(t, database, s.space, &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-a"})
+
+	// Refs added without a version are the FirstVersion
+	refs, err := database.Get(s.space, testOrg, "key-a")
+	expected := &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-a", Version: db.FirstVersion}
+	if err != nil || !reflect.DeepEqual(refs, expected) {
+		t.Errorf("Expected %+v, received %+v, %+v", expected, refs, err)
+	}
+
+	// Bad Path: keys are only found in their space
+	if _, err := database.Get(s.other, testOrg, "key-a"); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+
+	// Bad Path: unknown key
+	if _, err := database.Get(s.space, testOrg, "key-missing"); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+
+	// Bad Path: refs are required
+	if err := database.Add(s.space, testOrg, nil); err == nil {
+		t.Error("Expected an error adding nil refs")
+	}
+}
+
+func testAddDuplicate(t *testing.T, database db.DB, s spaces) {
+	mustAdd(t, database, s.space, &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-a"})
+
+	// Bad Path: an ID is only added once to a space
+	if err := database.Add(s.space, testOrg, &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-b"}); err == nil {
+		t.Error("Expected an error adding a key twice")
+	}
+	if refs, _ := database.Get(s.space, testOrg, "key-a"); refs == nil || refs.SecretID != "secret-a" {
+		t.Errorf("Expected the first refs to be kept, received %+v", refs)
+	}
+
+	// The same ID may be used by another space
+	mustAdd(t, database, s.other, &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-c"})
+	if refs, _ := database.Get(s.other, testOrg, "key-a"); refs == nil || refs.SecretID != "secret-c" {
+		t.Errorf("Expected secret-c, received %+v", refs)
+	}
+}
+
+func testUpdate(t *testing.T, database db.DB, s spaces) {
+	mustAdd(t, database, s.space, &db.BarbicanRefs{KpID: "key-a", OrderID: "order-a"})
+
+	// Generated keys are updated with their secret once the order completes
+	if err := database.Update(s.space, testOrg, &db.BarbicanRefs{KpID: "key-a", OrderID: "order-a", SecretID: "secret-a"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if refs, _ := database.Get(s.space, testOrg, "key-a"); refs == nil || refs.SecretID != "secret-a" || refs.OrderID != "order-a" {
+		t.Errorf("Expected the secret of the order, received %+v", refs)
+	}
+
+	// Bad Path: refs that were never added
+	if err := database.Update(s.space, testOrg, &db.BarbicanRefs{KpID: "key-missing", SecretID: "secret-b"}); err == nil {
+		t.Error("Expected an error updating a missing key")
+	}
+	if err := database.Update(s.other, testOrg, &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-b"}); err == nil {
+		t.Error("Expected an error updating a key of another space")
+	}
+	if err := database.Update(s.space, testOrg, nil); err == nil {
+		t.Error("Expected an error updating nil refs")
+	}
+}
+
+func testDelete(t *testing.T, database db.DB, s spaces) {
+	mustAdd(t, database, s.space, &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-a"})
+	mustAdd(t, database, s.space, &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-a2", Version: 2})
+	mustAdd(t, database, s.other, &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-b"})
+
+	if err := database.Delete(s.space, testOrg, "key-a"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// Every version of the key is deleted
+	if _, err := database.Get(s.space, testOrg, "key-a"); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+	if _, err := database.GetVersion(s.space, testOrg, "key-a", 2); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+	if _, err := database.ListVersions(s.space, testOrg, "key-a"); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+
+	// Deletes are soft, so the ID of a deleted key is not reused
+	if err := database.Add(s.space, testOrg, &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-c"}); err == nil {
+		t.Error("Expected an error adding the ID of a deleted key")
+	}
+
+	// Only the key of the space is deleted
+	if refs, err := database.Get(s.other, testOrg, "key-a"); err != nil || refs.SecretID != "secret-b" {
+		t.Errorf("Expected secret-b, received %+v, %+v", refs, err)
+	}
+
+	// Deleting a missing key is not an error
+	if err := database.Delete(s.space, testOrg, "key-missing"); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+}
+
+func testVersions(t *testing.T, database db.DB, s spaces) {
+	mustAdd(t, database, s.space, &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-1"})
+	mustAdd(t, database, s.space, &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-3", Version: 3})
+	mustAdd(t, database, s.space, &db.BarbicanRefs{KpID: "key-a", OrderID: "order-2", Version: 2})
+
+	if err := database.Update(s.space, testOrg, &db.BarbicanRefs{KpID: "key-a", OrderID: "order-2", SecretID: "secret-2", Version: 2}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// The FirstVersion is the refs the key was added with
+	if refs, err := database.GetVersion(s.space, testOrg, "key-a", db.FirstVersion); err != nil || refs.SecretID != "secret-1" || refs.Version != db.FirstVersion {
+		t.Errorf("Expected secret-1, received %+v, %+v", refs, err)
+	}
+	if refs, err := database.GetVersion(s.space, testOrg, "key-a", 2); err != nil || refs.SecretID != "secret-2" || refs.Version != 2 {
+		t.Errorf("Expected secret-2, received %+v, %+v", refs, err)
+	}
+
+	// Versions are listed in order, whatever order they were added in
+	versions, err := database.ListVersions(s.space, testOrg, "key-a")
+	if err != nil || len(versions) != 3 {
+		t.Fatalf("Expected 3 versions, received %+v, %+v", versions, err)
+	}
+	for i, refs := range versions {
+		if refs.Version != i+1 || refs.KpID != "key-a" {
+			t.Errorf("Expected version %d, received %+v", i+1, refs)
+		}
+	}
+
+	// Bad Path: versions are scoped like keys
+	if _, err := database.GetVersion(s.space, testOrg, "key-a", 4); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+	if _, err := database.GetVersion(s.other, testOrg, "key-a", 2); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+	if err := database.Add(s.space, testOrg, &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-4", Version: 2}); err == nil {
+		t.Error("Expected an error adding a version twice")
+	}
+	if err := database.Update(s.space, testOrg, &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-4", Version: 4}); err == nil {
+		t.Error("Expected an error updating a missing version")
+	}
+}
+
+func testListKeys(t *testing.T, database db.DB, s spaces) {
+	keys, err := database.ListKeys(s.space, testOrg)
+	if err != nil || len(keys) != 0 {
+		t.Errorf("Expected no keys, received %+v, %+v", keys, err)
+	}
+
+	mustAdd(t, database, s.space, &db.BarbicanRefs{KpID: "key-c", SecretID: "secret-c"})
+	mustAdd(t, database, s.space, &db.BarbicanRefs{KpID: "key-a", OrderID: "order-a"})
+	mustAdd(t, database, s.space, &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-a2", Version: 2})
+	mustAdd(t, database, s.space, &db.BarbicanRefs{KpID: "key-b", SecretID: "secret-b"})
+	mustAdd(t, database, s.other, &db.BarbicanRefs{KpID: "key-d", SecretID: "secret-d"})
+	if err := database.Delete(s.space, testOrg, "key-b"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// Only the FirstVersion of the keys of the space that are not deleted, ordered by ID
+	keys, err = database.ListKeys(s.space, testOrg)
+	expected := []*db.BarbicanRefs{
+		{KpID: "key-a", OrderID: "order-a", Version: db.FirstVersion},
+		{KpID: "key-c", SecretID: "secret-c", Version: db.FirstVersion},
+	}
+	if err != nil || !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %+v, received %+v, %+v", expected, keys, err)
+	}
+}
+
+func testListSpaces(t *testing.T, database db.DB, s spaces) {
+	// the org is of this run, as the spaces of earlier runs may share the database
+	org := "test-org-" + s.space
+	spaces, err := database.ListSpaces(org)
+	if err != nil || len(spaces) != 0 {
+		t.Errorf("Expected no spaces, received %+v, %+v", spaces, err)
+	}
+
+	for _, space := range []string{s.other, s.space} {
+		if err := database.Add(space, org, &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-a"}); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	}
+	if err := database.Add(s.space, org, &db.BarbicanRefs{KpID: "key-b", SecretID: "secret-b"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	mustAdd(t, database, s.space, &db.BarbicanRefs{KpID: "key-c", SecretID: "secret-c"})
+
+	// Each space of the org once, sorted, without those of other orgs
+	spaces, err = database.ListSpaces(org)
+	expected := []string{s.other, s.space}
+	if s.space < s.other {
+		expected = []string{s.space, s.other}
+	}
+	if err != nil || !reflect.DeepEqual(spaces, expected) {
+		t.Errorf("Expected %+v, received %+v, %+v", expected, spaces, err)
+	}
+
+	// Spaces whose keys are all deleted have none
+	if err := database.Delete(s.other, org, "key-a"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	spaces, err = database.ListSpaces(org)
+	if err != nil || !reflect.DeepEqual(spaces, []string{s.space}) {
+		t.Errorf("Expected %+v, received %+v, %+v", []string{s.space}, spaces, err)
+	}
+}
+
+// TestPolicyDB runs the conformance tests against PolicyDBs returned by newDB. Each test works in spaces of its own,
+// so the PolicyDBs may be shared.
+func TestPolicyDB(t *testing.T, newDB func() db.PolicyDB) {
+	tests := []struct {
+		name string
+		test func(*testing.T, db.PolicyDB, spaces)
+	}{
+		{"RotationPolicy", testRotationPolicy},
+		{"DuePolicies", testDuePolicies},
+		{"ClaimPolicy", testClaimPolicy},
+		{"DeletionPolicy", testDeletionPolicy},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newDB(), newSpaces(test.name))
+		})
+	}
+}
+
+// testTime is a time the databases store without losing precision
+var testTime = time.Unix(1500000000, 0).UTC()
+
+func testRotationPolicy(t *testing.T, database db.PolicyDB, s spaces) {
+	if _, err := database.GetPolicy(s.space, testOrg, "key-a"); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+
+	// Keys not yet rotated on schedule have no last rotation
+	policy := &db.RotationPolicy{Space: s.space, Org: testOrg, KpID: "key-a", IntervalDays: 30}
+	if err := database.SetPolicy(policy.Schedule(testTime)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if received, err := database.GetPolicy(s.space, testOrg, "key-a"); err != nil || !reflect.DeepEqual(received, policy) {
+		t.Errorf("Expected %+v, received %+v, %+v", policy, received, err)
+	}
+
+	// Setting the policy again replaces it
+	policy.IntervalDays = 7
+	policy.LastRotation = testTime
+	if err := database.SetPolicy(policy.Schedule(testTime)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if received, err := database.GetPolicy(s.space, testOrg, "key-a"); err != nil || !reflect.DeepEqual(received, policy) {
+		t.Errorf("Expected %+v, received %+v, %+v", policy, received, err)
+	}
+
+	// Bad Path: policies are scoped to their space
+	if _, err := database.GetPolicy(s.other, testOrg, "key-a"); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+	if err := database.SetPolicy(nil); err == nil {
+		t.Error("Expected an error setting a nil policy")
+	}
+
+	if err := database.DeletePolicy(s.space, testOrg, "key-a"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := database.GetPolicy(s.space, testOrg, "key-a"); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+
+	// Deleting a missing policy is not an error
+	if err := database.DeletePolicy(s.space, testOrg, "key-missing"); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+}
+
+func testDuePolicies(t *testing.T, database db.PolicyDB, s spaces) {
+	for _, policy := range []*db.RotationPolicy{
+		{Space: s.space, Org: testOrg, KpID: "key-due", IntervalDays: 1, NextRotation: testTime.Add(-time.Hour)},
+		{Space: s.space, Org: testOrg, KpID: "key-now", IntervalDays: 1, NextRotation: testTime},
+		{Space: s.space, Org: testOrg, KpID: "key-later", IntervalDays: 1, NextRotation: testTime.Add(time.Hour)},
+		{Space: s.other, Org: testOrg, KpID: "key-overdue", IntervalDays: 1, NextRotation: testTime.Add(-2 * time.Hour)},
+	} {
+		if err := database.SetPolicy(policy); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	}
+
+	policies, err := database.ListDuePolicies(testTime)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// Policies are listed across spaces, the most overdue first, and only once due
+	due := []string{}
+	for _, policy := range policies {
+		if policy.Space == s.space || policy.Space == s.other {
+			due = append(due, policy.KpID)
+		}
+	}
+	expected := []string{"key-overdue", "key-due", "key-now"}
+	if !reflect.DeepEqual(due, expected) {
+		t.Errorf("Expected %v, received %v", expected, due)
+	}
+}
+
+func testClaimPolicy(t *testing.T, database db.PolicyDB, s spaces) {
+	policy := &db.RotationPolicy{Space: s.space, Org: testOrg, KpID: "key-a", IntervalDays: 1, NextRotation: testTime}
+	if err := database.SetPolicy(policy); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// The first claim of a due policy moves its next rotation
+	until := testTime.Add(time.Hour)
+	if claimed, err := database.ClaimPolicy(policy, until); err != nil || !claimed {
+		t.Fatalf("Expected the policy to be claimed, received %t, %+v", claimed, err)
+	}
+	received, err := database.GetPolicy(s.space, testOrg, "key-a")
+	if err != nil || !received.NextRotation.Equal(until) {
+		t.Errorf("Expected next rotation %s, received %+v, %+v", until, received, err)
+	}
+
+	// Bad Path: a claim of the policy as it was read before is lost
+	if claimed, err := database.ClaimPolicy(policy, testTime.Add(2*time.Hour)); err != nil || claimed {
+		t.Errorf("Expected the claim to be lost, received %t, %+v", claimed, err)
+	}
+	if received, err := database.GetPolicy(s.space, testOrg, "key-a"); err != nil || !received.NextRotation.Equal(until) {
+		t.Errorf("Expected next rotation %s, received %+v, %+v", until, received, err)
+	}
+
+	// Bad Path: missing policies cannot be claimed
+	missing := &db.RotationPolicy{Space: s.other, Org: testOrg, KpID: "key-a", NextRotation: testTime}
+	if claimed, err := database.ClaimPolicy(missing, until); err != nil || claimed {
+		t.Errorf("Expected the claim to be lost, received %t, %+v", claimed, err)
+	}
+}
+
+func testDeletionPolicy(t *testing.T, database db.PolicyDB, s spaces) {
+	if _, err := database.GetDeletionPolicy(s.space, testOrg, "key-a"); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+
+	policy := &db.DeletionPolicy{Space: s.space, Org: testOrg, KpID: "key-a"}
+	if err := database.SetDeletionPolicy(policy); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if received, err := database.GetDeletionPolicy(s.space, testOrg, "key-a"); err != nil || !reflect.DeepEqual(received, policy) {
+		t.Errorf("Expected %+v, received %+v, %+v", policy, received, err)
+	}
+
+	// A deletion authorized by a first user replaces the policy
+	policy.PendingUserID = "user-a"
+	policy.PendingExpiration = testTime
+	if err := database.SetDeletionPolicy(policy); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if received, err := database.GetDeletionPolicy(s.space, testOrg, "key-a"); err != nil || !reflect.DeepEqual(received, policy) {
+		t.Errorf("Expected %+v, received %+v, %+v", policy, received, err)
+	}
+
+	// Bad Path: policies are scoped to their space
+	if _, err := database.GetDeletionPolicy(s.other, testOrg, "key-a"); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+	if err := database.SetDeletionPolicy(nil); err == nil {
+		t.Error("Expected an error setting a nil policy")
+	}
+
+	if err := database.DeleteDeletionPolicy(s.space, testOrg, "key-a"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := database.GetDeletionPolicy(s.space, testOrg, "key-a"); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+}
+
+// TestImportTokenDB runs the conformance tests against ImportTokenDBs returned by newDB. Each test works in spaces of
+// its own, so the ImportTokenDBs may be shared.
+func TestImportTokenDB(t *testing.T, newDB func() db.ImportTokenDB) {
+	tests := []struct {
+		name string
+		test func(*testing.T, db.ImportTokenDB, spaces)
+	}{
+		{"AddGet", testImportTokenAddGet},
+		{"Claim", testImportTokenClaim},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newDB(), newSpaces("ImportToken"+test.name))
+		})
+	}
+}
+
+func newTestImportToken(space, id string) *db.ImportToken {
+	return &db.ImportToken{
+		Space:            space,
+		Org:              testOrg,
+		ID:               id,
+		PublicKey:        []byte("public-" + id),
+		SealedPrivateKey: []byte("sealed-" + id),
+		Expiration:       testTime,
+	}
+}
+
+func testImportTokenAddGet(t *testing.T, database db.ImportTokenDB, s spaces) {
+	if _, err := database.GetImportToken(s.space, testOrg); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+
+	token := newTestImportToken(s.space, "token-a")
+	if added, err := database.AddImportToken(token); err != nil || !added {
+		t.Fatalf("Expected the token to be added, received %t, %+v", added, err)
+	}
+	if received, err := database.GetImportToken(s.space, testOrg); err != nil || !reflect.DeepEqual(received, token) {
+		t.Errorf("Expected %+v, received %+v, %+v", token, received, err)
+	}
+
+	// Bad Path: a space has a single token, so a replica adding another keeps the first
+	if added, err := database.AddImportToken(newTestImportToken(s.space, "token-b")); err != nil || added {
+		t.Errorf("Expected the token not to be added, received %t, %+v", added, err)
+	}
+	if received, err := database.GetImportToken(s.space, testOrg); err != nil || received.ID != token.ID {
+		t.Errorf("Expected %s, received %+v, %+v", token.ID, received, err)
+	}
+
+	// Bad Path: tokens are scoped to their space
+	if _, err := database.GetImportToken(s.other, testOrg); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+	if _, err := database.AddImportToken(nil); err == nil {
+		t.Error("Expected an error adding a nil token")
+	}
+}
+
+func testImportTokenClaim(t *testing.T, database db.ImportTokenDB, s spaces) {
+	token := newTestImportToken(s.space, "token-a")
+	if added, err := database.AddImportToken(token); err != nil || !added {
+		t.Fatalf("Expected the token to be added, received %t, %+v", added, err)
+	}
+
+	// Bad Path: a token the space no longer has is not claimed
+	if claimed, err := database.ClaimImportToken(s.space, testOrg, "token-b"); err != nil || claimed {
+		t.Errorf("Expected the token not to be claimed, received %t, %+v", claimed, err)
+	}
+
+	if claimed, err := database.ClaimImportToken(s.space, testOrg, token.ID); err != nil || !claimed {
+		t.Errorf("Expected the token to be claimed, received %t, %+v", claimed, err)
+	}
+	if _, err := database.GetImportToken(s.space, testOrg); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+
+	// Bad Path: a token is only claimed once
+	if claimed, err := database.ClaimImportToken(s.space, testOrg, token.ID); err != nil || claimed {
+		t.Errorf("Expected the token not to be claimed, received %t, %+v", claimed, err)
+	}
+
+	// a claimed token makes way for the next
+	if added, err := database.AddImportToken(newTestImportToken(s.space, "token-b")); err != nil || !added {
+		t.Errorf("Expected the token to be added, received %t, %+v", added, err)
+	}
+}
@@ -0,0 +1,9 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package db
+
+// Opened by the conformance tests of the databases the service runs against, when their credentials are set
+var (
+	NewMySQLInstance     = newMYSQLinstance
+	NewCassandraInstance = newCassandraInstance
+)
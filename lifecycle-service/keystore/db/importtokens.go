@@ -0,0 +1,38 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package db
+
+import (
+	"time"
+)
+
+// ImportToken is the keypair that key material imported into a space is encrypted under.
+// The private key is sealed by the service before it is stored, so the database never holds it in the clear.
+type ImportToken struct {
+	Space string
+	Org   string
+	// ID tells apart the tokens a space has over time, so a used token is only claimed once
+	ID               string
+	PublicKey        []byte
+	SealedPrivateKey []byte
+	Expiration       time.Time
+}
+
+//ImportTokenDB The interface for storing the import token of each space, which every replica of the service shares.
+//AddImportToken only adds the token if the space has none, and reports whether it did, so that replicas creating a
+//token for the same space at once agree on one of them. ClaimImportToken removes the token of the space only if it
+//still has the ID, and reports whether it did, so that each token is used for a single import.
+type ImportTokenDB interface {
+	AddImportToken(token *ImportToken) (bool, error)
+	GetImportToken(space string, org string) (*ImportToken, error)
+	ClaimImportToken(space string, org string, id string) (bool, error)
+}
+
+//NewImportTokenDBInstance Creates a new instance of the ImportTokenDB, sharing the connection used for ID translations.
+func NewImportTokenDBInstance() (ImportTokenDB, error) {
+	store, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
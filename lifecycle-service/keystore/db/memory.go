@@ -0,0 +1,330 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package db
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryKey identifies a version of a key within a space
+type memoryKey struct {
+	space   string
+	kpID    string
+	version int
+}
+
+// memoryRow is a row of the ID translation tables. Deleted rows are kept, as in MySQL, so their IDs are not reused.
+type memoryRow struct {
+	org       string
+	secretRef string
+	orderRef  string
+	deleted   bool
+}
+
+// memoryPolicyKey identifies the policies of a key within a space
+type memoryPolicyKey struct {
+	space string
+	kpID  string
+}
+
+// memoryDB holds ID translations, policies and import tokens in the memory of the process. Every version of a key is
+// a row, the FirstVersion included, so the semantics of the MySQL tables are kept without a second table.
+type memoryDB struct {
+	mu               sync.RWMutex
+	rows             map[memoryKey]*memoryRow
+	policies         map[memoryPolicyKey]RotationPolicy
+	deletionPolicies map[memoryPolicyKey]DeletionPolicy
+	importTokens     map[string]ImportToken
+}
+
+var memoryInstance Store
+var memoryOnce sync.Once
+
+func init() {
+	Register(MemoryBackend, newMemoryInstance)
+}
+
+// newMemoryInstance returns the in-memory Store of the process, which is shared like the connections of the other
+// backends
+func newMemoryInstance() (Store, error) {
+	memoryOnce.Do(func() {
+		memoryInstance = NewMemoryDB()
+	})
+	return memoryInstance, nil
+}
+
+// NewMemoryDB returns an empty in-memory Store. It is not shared, so tests may use one each.
+func NewMemoryDB() Store {
+	return &memoryDB{
+		rows:             make(map[memoryKey]*memoryRow),
+		policies:         make(map[memoryPolicyKey]RotationPolicy),
+		deletionPolicies: make(map[memoryPolicyKey]DeletionPolicy),
+		importTokens:     make(map[string]ImportToken),
+	}
+}
+
+// versionOf returns the version refs point to, with refs without a version pointing to the FirstVersion
+func versionOf(refs *BarbicanRefs) int {
+	if IsRotatedVersion(refs) {
+		return refs.Version
+	}
+	return FirstVersion
+}
+
+func (d *memoryDB) Add(space string, org string, refs *BarbicanRefs) error {
+	if refs == nil {
+		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires translation references")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := memoryKey{space: space, kpID: refs.KpID, version: versionOf(refs)}
+	if _, ok := d.rows[key]; ok {
+		return ErrDuplicateRefs
+	}
+	d.rows[key] = &memoryRow{org: org, secretRef: refs.SecretID, orderRef: refs.OrderID}
+	return nil
+}
+
+func (d *memoryDB) Update(space string, org string, refs *BarbicanRefs) error {
+	if refs == nil {
+		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires translation references")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	row, ok := d.rows[memoryKey{space: space, kpID: refs.KpID, version: versionOf(refs)}]
+	if !ok {
+		return ErrNoRowsUpdated
+	}
+	row.secretRef = refs.SecretID
+	row.orderRef = refs.OrderID
+	return nil
+}
+
+func (d *memoryDB) Get(space string, org string, kpID string) (*BarbicanRefs, error) {
+	return d.GetVersion(space, org, kpID, FirstVersion)
+}
+
+// Delete marks every version of the key deleted
+func (d *memoryDB) Delete(space string, org string, kpID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, row := range d.rows {
+		if key.space == space && key.kpID == kpID {
+			row.deleted = true
+		}
+	}
+	return nil
+}
+
+func (d *memoryDB) GetVersion(space string, org string, kpID string, version int) (*BarbicanRefs, error) {
+	if version < FirstVersion {
+		version = FirstVersion
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	row, ok := d.rows[memoryKey{space: space, kpID: kpID, version: version}]
+	if !ok || row.deleted {
+		return nil, ErrNotFound
+	}
+	return &BarbicanRefs{SecretID: row.secretRef, OrderID: row.orderRef, KpID: kpID, Version: version}, nil
+}
+
+// ListVersions returns every version of the key, ordered from the FirstVersion to the latest
+func (d *memoryDB) ListVersions(space string, org string, kpID string) ([]*BarbicanRefs, error) {
+	if _, err := d.Get(space, org, kpID); err != nil {
+		return nil, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	refs := []*BarbicanRefs{}
+	for key, row := range d.rows {
+		if key.space == space && key.kpID == kpID && !row.deleted {
+			refs = append(refs, &BarbicanRefs{SecretID: row.secretRef, OrderID: row.orderRef, KpID: kpID, Version: key.version})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Version < refs[j].Version })
+	return refs, nil
+}
+
+// ListKeys returns the FirstVersion of every key of the space, ordered by KP ID
+func (d *memoryDB) ListKeys(space string, org string) ([]*BarbicanRefs, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	refs := []*BarbicanRefs{}
+	for key, row := range d.rows {
+		if key.space == space && key.version == FirstVersion && !row.deleted {
+			refs = append(refs, &BarbicanRefs{SecretID: row.secretRef, OrderID: row.orderRef, KpID: key.kpID, Version: FirstVersion})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].KpID < refs[j].KpID })
+	return refs, nil
+}
+
+// ListSpaces returns the sorted spaces of the org that have keys
+func (d *memoryDB) ListSpaces(org string) ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	unique := make(map[string]bool)
+	for key, row := range d.rows {
+		if row.org == org && key.version == FirstVersion && !row.deleted {
+			unique[key.space] = true
+		}
+	}
+
+	spaces := []string{}
+	for space := range unique {
+		spaces = append(spaces, space)
+	}
+	sort.Strings(spaces)
+	return spaces, nil
+}
+
+// SetPolicy adds the rotation policy of a key or replaces the existing one
+func (d *memoryDB) SetPolicy(policy *RotationPolicy) error {
+	if policy == nil {
+		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires a rotation policy")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.policies[memoryPolicyKey{space: policy.Space, kpID: policy.KpID}] = *policy
+	return nil
+}
+
+func (d *memoryDB) GetPolicy(space string, org string, kpID string) (*RotationPolicy, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	policy, ok := d.policies[memoryPolicyKey{space: space, kpID: kpID}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &policy, nil
+}
+
+func (d *memoryDB) DeletePolicy(space string, org string, kpID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.policies, memoryPolicyKey{space: space, kpID: kpID})
+	return nil
+}
+
+// ListDuePolicies returns the policies of every space whose next rotation is not after now, the most overdue first
+func (d *memoryDB) ListDuePolicies(now time.Time) ([]*RotationPolicy, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	policies := []*RotationPolicy{}
+	for _, policy := range d.policies {
+		if !policy.NextRotation.After(now) {
+			policy := policy
+			policies = append(policies, &policy)
+		}
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].NextRotation.Before(policies[j].NextRotation) })
+	return policies, nil
+}
+
+// ClaimPolicy moves the next rotation of the policy to until, unless it was moved since the policy was read
+func (d *memoryDB) ClaimPolicy(policy *RotationPolicy, until time.Time) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := memoryPolicyKey{space: policy.Space, kpID: policy.KpID}
+	stored, ok := d.policies[key]
+	if !ok || !stored.NextRotation.Equal(policy.NextRotation) {
+		return false, nil
+	}
+	stored.NextRotation = until
+	d.policies[key] = stored
+	return true, nil
+}
+
+// SetDeletionPolicy adds the deletion policy of a key or replaces the existing one
+func (d *memoryDB) SetDeletionPolicy(policy *DeletionPolicy) error {
+	if policy == nil {
+		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires a deletion policy")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.deletionPolicies[memoryPolicyKey{space: policy.Space, kpID: policy.KpID}] = *policy
+	return nil
+}
+
+func (d *memoryDB) GetDeletionPolicy(space string, org string, kpID string) (*DeletionPolicy, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	policy, ok := d.deletionPolicies[memoryPolicyKey{space: space, kpID: kpID}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &policy, nil
+}
+
+func (d *memoryDB) DeleteDeletionPolicy(space string, org string, kpID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.deletionPolicies, memoryPolicyKey{space: space, kpID: kpID})
+	return nil
+}
+
+// AddImportToken adds the import token of the space, unless it already has one
+func (d *memoryDB) AddImportToken(token *ImportToken) (bool, error) {
+	if token == nil {
+		return false, errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires an import token")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.importTokens[token.Space]; ok {
+		return false, nil
+	}
+	d.importTokens[token.Space] = *token
+	return true, nil
+}
+
+func (d *memoryDB) GetImportToken(space string, org string) (*ImportToken, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	token, ok := d.importTokens[space]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &token, nil
+}
+
+// ClaimImportToken removes the import token of the space, unless it was replaced since it was read
+func (d *memoryDB) ClaimImportToken(space string, org string, id string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	token, ok := d.importTokens[space]
+	if !ok || token.ID != id {
+		return false, nil
+	}
+	delete(d.importTokens, space)
+	return true, nil
+}
@@ -0,0 +1,22 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package db_test
+
+import (
+	"testing"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db/dbtest"
+)
+
+func TestMemoryDB(t *testing.T) {
+	dbtest.TestDB(t, func() db.DB { return db.NewMemoryDB() })
+}
+
+func TestMemoryPolicyDB(t *testing.T) {
+	dbtest.TestPolicyDB(t, func() db.PolicyDB { return db.NewMemoryDB() })
+}
+
+func TestMemoryImportTokenDB(t *testing.T) {
+	dbtest.TestImportTokenDB(t, func() db.ImportTokenDB { return db.NewMemoryDB() })
+}
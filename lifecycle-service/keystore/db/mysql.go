@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"os"
 	"sync"
+	"time"
 	"log"
 
 	"github.com/go-sql-driver/mysql"
@@ -26,10 +27,39 @@ const (
 	orderRefColumnSQL  = "order_ref"
 	spaceIDColumnSQL   = "space_id"
 	deletedColumnSQL   = "deleted"
+	versionColumnSQL   = "version"
+	orgIDColumnSQL     = "org_id"
+	intervalColumnSQL  = "interval_days"
+	lastRotationSQL    = "last_rotation"
+	nextRotationSQL    = "next_rotation"
+	pendingUserSQL     = "pending_user_id"
+	pendingExpirySQL   = "pending_expiration"
+	tokenIDSQL         = "token_id"
+	publicKeySQL       = "public_key"
+	sealedKeySQL       = "sealed_private_key"
+	expirationSQL      = "expiration"
 )
 
-//Keyspace used for ID translations
+//The tables below are created, and keyprotect_ids altered, by schema/mysql.sql.
+
+//Keyspace used for ID translations, keyed on (space_id, kp_id). org_id is empty in rows added before it was recorded.
 const idTableSQL = "keyprotect_ids"
+
+//Table holding the refs of versions added by rotation. The FirstVersion of a key stays in idTableSQL.
+//It has the columns of idTableSQL along with version, keyed on (space_id, kp_id, version).
+const idVersionTableSQL = "keyprotect_id_versions"
+
+//Table holding the rotation policies of keys, keyed on (space_id, kp_id).
+//Rotation times are stored as unix seconds so next_rotation can be compared across spaces.
+const policyTableSQL = "keyprotect_rotation_policies"
+
+//Table holding the deletion policies of keys, keyed on (space_id, kp_id).
+//pending_user_id is empty and pending_expiration 0 while no deletion is pending.
+const deletionPolicyTableSQL = "keyprotect_deletion_policies"
+
+//Table holding the import token of each space, keyed on space_id.
+//The keys are stored as blobs, the private key sealed by the service, and expiration as unix seconds.
+const importTokenTableSQL = "keyprotect_import_tokens"
 const deadlockError = 1213
 const retries = 4
 
@@ -43,12 +73,22 @@ type mysqlDB struct {
 
 var dbConnectString = "?charset=utf8"
 var mysqlInstance *mysqlDB
+var mysqlErr error
 var mysqlOnce sync.Once
 
+func init() {
+	Register(MySQLBackend, newMYSQLinstance)
+}
+
 //NewDBInstance Creates a new instance of the DB.
-func newMYSQLinstance() DB {
-	mysqlOnce.Do(createMYSQLConnection)
-	return mysqlInstance
+func newMYSQLinstance() (Store, error) {
+	mysqlOnce.Do(func() {
+		mysqlInstance, mysqlErr = createMYSQLConnection()
+	})
+	if mysqlErr != nil {
+		return nil, mysqlErr
+	}
+	return mysqlInstance, nil
 }
 
 func (d *mysqlDB) Add(space string, org string, refs *BarbicanRefs) error {
@@ -56,9 +96,13 @@ func (d *mysqlDB) Add(space string, org string, refs *BarbicanRefs) error {
 		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires translation references")
 	}
 
+	if IsRotatedVersion(refs) {
+		return d.addVersion(space, refs)
+	}
+
 	//Add into table keyed on space.
 	/* #nosec */
-	query := fmt.Sprintf("INSERT INTO %s (%s,%s,%s,%s) VALUES (?,?,?,?)", idTableSQL, kpIDColumnSQL, spaceIDColumnSQL, secretRefColumnSQL, orderRefColumnSQL)
+	query := fmt.Sprintf("INSERT INTO %s (%s,%s,%s,%s,%s) VALUES (?,?,?,?,?)", idTableSQL, kpIDColumnSQL, spaceIDColumnSQL, orgIDColumnSQL, secretRefColumnSQL, orderRefColumnSQL)
 	insert, err := d.dbConnection.Prepare(query)
 	if err != nil && isDeadLockError(err) {
 		for i := 0; i < retries; i++ {
@@ -72,7 +116,7 @@ func (d *mysqlDB) Add(space string, org string, refs *BarbicanRefs) error {
 		return err
 	}
 	defer insert.Close()
-	_, err = insert.Exec(refs.KpID, space, refs.SecretID, refs.OrderID)
+	_, err = insert.Exec(refs.KpID, space, org, refs.SecretID, refs.OrderID)
 	if err != nil {
 		return err
 	}
@@ -100,7 +144,7 @@ func (d *mysqlDB) Get(space string, org string, kpID string) (*BarbicanRefs, err
 	defer get.Close()
 
 	res := get.QueryRow(space, kpID, false)
-	ref := &BarbicanRefs{KpID: kpID}
+	ref := &BarbicanRefs{KpID: kpID, Version: FirstVersion}
 	res.Scan(&ref.SecretID, &ref.OrderID)
 	if ref.SecretID == "" && ref.OrderID == "" {
 		return nil, ErrNotFound
@@ -113,6 +157,10 @@ func (d *mysqlDB) Update(space string, org string, refs *BarbicanRefs) error {
 		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires translation references")
 	}
 
+	if IsRotatedVersion(refs) {
+		return d.updateVersion(space, refs)
+	}
+
 	/* #nosec */
 	query := fmt.Sprintf("UPDATE %s SET %s=?,%s=? WHERE %s=? AND %s=?", idTableSQL, secretRefColumnSQL, orderRefColumnSQL, kpIDColumnSQL, spaceIDColumnSQL)
 	update, err := d.dbConnection.Prepare(query)
@@ -137,7 +185,7 @@ func (d *mysqlDB) Update(space string, org string, refs *BarbicanRefs) error {
 		return err
 	}
 	if rowsAffected == 0 {
-		return errors.New("Issue seen with Order processing")
+		return ErrNoRowsUpdated
 	}
 	return nil
 }
@@ -159,9 +207,273 @@ func (d *mysqlDB) Delete(space string, org string, kpID string) error {
 	}
 	defer del.Close()
 	del.Exec(true, space, kpID)
+
+	/* #nosec */
+	query = fmt.Sprintf("UPDATE %s SET %s=? WHERE %s = ? AND %s = ?", idVersionTableSQL, deletedColumnSQL, spaceIDColumnSQL, kpIDColumnSQL)
+	delVersions, err := d.prepare(query)
+	if err != nil {
+		return err
+	}
+	defer delVersions.Close()
+	delVersions.Exec(true, space, kpID)
+	return nil
+}
+
+func (d *mysqlDB) GetVersion(space string, org string, kpID string, version int) (*BarbicanRefs, error) {
+	if version <= FirstVersion {
+		return d.Get(space, org, kpID)
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s FROM %s WHERE %s = ? AND %s = ? AND %s = ? AND %s = ?", secretRefColumnSQL, orderRefColumnSQL, idVersionTableSQL, spaceIDColumnSQL, kpIDColumnSQL, versionColumnSQL, deletedColumnSQL)
+	get, err := d.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer get.Close()
+
+	res := get.QueryRow(space, kpID, version, false)
+	ref := &BarbicanRefs{KpID: kpID, Version: version}
+	res.Scan(&ref.SecretID, &ref.OrderID)
+	if ref.SecretID == "" && ref.OrderID == "" {
+		return nil, ErrNotFound
+	}
+	return ref, nil
+}
+
+// ListVersions returns every version of the key, ordered from the FirstVersion to the latest
+func (d *mysqlDB) ListVersions(space string, org string, kpID string) ([]*BarbicanRefs, error) {
+	first, err := d.Get(space, org, kpID)
+	if err != nil {
+		return nil, err
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s FROM %s WHERE %s = ? AND %s = ? AND %s = ? ORDER BY %s", versionColumnSQL, secretRefColumnSQL, orderRefColumnSQL, idVersionTableSQL, spaceIDColumnSQL, kpIDColumnSQL, deletedColumnSQL, versionColumnSQL)
+	list, err := d.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer list.Close()
+
+	rows, err := list.Query(space, kpID, false)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := []*BarbicanRefs{first}
+	for rows.Next() {
+		ref := &BarbicanRefs{KpID: kpID}
+		if err := rows.Scan(&ref.Version, &ref.SecretID, &ref.OrderID); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+// ListKeys returns the FirstVersion of every key of the space, ordered by KP ID
+func (d *mysqlDB) ListKeys(space string, org string) ([]*BarbicanRefs, error) {
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s FROM %s WHERE %s = ? AND %s = ? ORDER BY %s", kpIDColumnSQL, secretRefColumnSQL, orderRefColumnSQL, idTableSQL, spaceIDColumnSQL, deletedColumnSQL, kpIDColumnSQL)
+	list, err := d.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer list.Close()
+
+	rows, err := list.Query(space, false)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := []*BarbicanRefs{}
+	for rows.Next() {
+		ref := &BarbicanRefs{Version: FirstVersion}
+		if err := rows.Scan(&ref.KpID, &ref.SecretID, &ref.OrderID); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+// ListSpaces returns the sorted spaces of the org that have keys
+func (d *mysqlDB) ListSpaces(org string) ([]string, error) {
+	/* #nosec */
+	query := fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s = ? AND %s = ? ORDER BY %s", spaceIDColumnSQL, idTableSQL, orgIDColumnSQL, deletedColumnSQL, spaceIDColumnSQL)
+	list, err := d.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer list.Close()
+
+	rows, err := list.Query(org, false)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	spaces := []string{}
+	for rows.Next() {
+		var space string
+		if err := rows.Scan(&space); err != nil {
+			return nil, err
+		}
+		spaces = append(spaces, space)
+	}
+	return spaces, rows.Err()
+}
+
+func (d *mysqlDB) addVersion(space string, refs *BarbicanRefs) error {
+	/* #nosec */
+	query := fmt.Sprintf("INSERT INTO %s (%s,%s,%s,%s,%s) VALUES (?,?,?,?,?)", idVersionTableSQL, kpIDColumnSQL, spaceIDColumnSQL, versionColumnSQL, secretRefColumnSQL, orderRefColumnSQL)
+	insert, err := d.prepare(query)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	_, err = insert.Exec(refs.KpID, space, refs.Version, refs.SecretID, refs.OrderID)
+	return err
+}
+
+func (d *mysqlDB) updateVersion(space string, refs *BarbicanRefs) error {
+	/* #nosec */
+	query := fmt.Sprintf("UPDATE %s SET %s=?,%s=? WHERE %s=? AND %s=? AND %s=?", idVersionTableSQL, secretRefColumnSQL, orderRefColumnSQL, kpIDColumnSQL, spaceIDColumnSQL, versionColumnSQL)
+	update, err := d.prepare(query)
+	if err != nil {
+		return err
+	}
+	defer update.Close()
+	status, err := update.Exec(refs.SecretID, refs.OrderID, refs.KpID, space, refs.Version)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := status.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRowsUpdated
+	}
 	return nil
 }
 
+// SetPolicy adds the rotation policy of a key or replaces the existing one
+func (d *mysqlDB) SetPolicy(policy *RotationPolicy) error {
+	if policy == nil {
+		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires a rotation policy")
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("INSERT INTO %s (%s,%s,%s,%s,%s,%s) VALUES (?,?,?,?,?,?) ON DUPLICATE KEY UPDATE %s=VALUES(%s),%s=VALUES(%s),%s=VALUES(%s)",
+		policyTableSQL, kpIDColumnSQL, spaceIDColumnSQL, orgIDColumnSQL, intervalColumnSQL, lastRotationSQL, nextRotationSQL,
+		intervalColumnSQL, intervalColumnSQL, lastRotationSQL, lastRotationSQL, nextRotationSQL, nextRotationSQL)
+	insert, err := d.prepare(query)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	_, err = insert.Exec(policy.KpID, policy.Space, policy.Org, policy.IntervalDays, UnixSeconds(policy.LastRotation), policy.NextRotation.Unix())
+	return err
+}
+
+func (d *mysqlDB) GetPolicy(space string, org string, kpID string) (*RotationPolicy, error) {
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s FROM %s WHERE %s = ? AND %s = ?", intervalColumnSQL, lastRotationSQL, nextRotationSQL, policyTableSQL, spaceIDColumnSQL, kpIDColumnSQL)
+	get, err := d.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer get.Close()
+
+	var last, next int64
+	policy := &RotationPolicy{Space: space, Org: org, KpID: kpID}
+	err = get.QueryRow(space, kpID).Scan(&policy.IntervalDays, &last, &next)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy.LastRotation = FromUnixSeconds(last)
+	policy.NextRotation = FromUnixSeconds(next)
+	return policy, nil
+}
+
+func (d *mysqlDB) DeletePolicy(space string, org string, kpID string) error {
+	/* #nosec */
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND %s = ?", policyTableSQL, spaceIDColumnSQL, kpIDColumnSQL)
+	del, err := d.prepare(query)
+	if err != nil {
+		return err
+	}
+	defer del.Close()
+	_, err = del.Exec(space, kpID)
+	return err
+}
+
+// ListDuePolicies returns the policies of every space whose next rotation is not after now
+func (d *mysqlDB) ListDuePolicies(now time.Time) ([]*RotationPolicy, error) {
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s,%s,%s,%s FROM %s WHERE %s <= ? ORDER BY %s", kpIDColumnSQL, spaceIDColumnSQL, orgIDColumnSQL, intervalColumnSQL, lastRotationSQL, nextRotationSQL, policyTableSQL, nextRotationSQL, nextRotationSQL)
+	list, err := d.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer list.Close()
+
+	rows, err := list.Query(now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []*RotationPolicy{}
+	for rows.Next() {
+		var last, next int64
+		policy := new(RotationPolicy)
+		if err := rows.Scan(&policy.KpID, &policy.Space, &policy.Org, &policy.IntervalDays, &last, &next); err != nil {
+			return nil, err
+		}
+		policy.LastRotation = FromUnixSeconds(last)
+		policy.NextRotation = FromUnixSeconds(next)
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+// ClaimPolicy moves the next rotation of the policy to until, unless it was moved since the policy was read
+func (d *mysqlDB) ClaimPolicy(policy *RotationPolicy, until time.Time) (bool, error) {
+	/* #nosec */
+	query := fmt.Sprintf("UPDATE %s SET %s=? WHERE %s=? AND %s=? AND %s=?", policyTableSQL, nextRotationSQL, spaceIDColumnSQL, kpIDColumnSQL, nextRotationSQL)
+	update, err := d.prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer update.Close()
+	status, err := update.Exec(until.Unix(), policy.Space, policy.KpID, policy.NextRotation.Unix())
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := status.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// prepare prepares the query, retrying when mysql reports a deadlock
+func (d *mysqlDB) prepare(query string) (*sql.Stmt, error) {
+	stmt, err := d.dbConnection.Prepare(query)
+	for i := 0; i < retries && isDeadLockError(err); i++ {
+		stmt, err = d.dbConnection.Prepare(query)
+	}
+	return stmt, err
+}
+
 // setup TLS on 2 conditions.
 // 1.  We're not in local test environment. This enables `go test` to working
 // 2.  The feature toggle is set to true
@@ -217,7 +529,7 @@ func setupTLS() {
     })
 }
 
-func createMYSQLConnection() {
+func createMYSQLConnection() (*mysqlDB, error) {
 	var credentialsLocation string
 	if credentialsLocation = os.Getenv("MARIA_CREDENTIALS_LOCATION"); credentialsLocation == "" {
 		credentialsLocation = config.Get().GetString("database.credentialsLocation")
@@ -225,19 +537,20 @@ func createMYSQLConnection() {
 
 	file, err := os.Open(credentialsLocation)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
+	defer file.Close()
 
 	bytes, err := ioutil.ReadAll(file)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 
 	configuration := &dbConfiguration{}
 
 	err = json.Unmarshal(bytes, &configuration)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 
 
@@ -247,11 +560,10 @@ func createMYSQLConnection() {
 	//Login
 	db, err := sql.Open("mysql", loginString)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 
-	mysqlInstance = new(mysqlDB)
-	mysqlInstance.dbConnection = db
+	return &mysqlDB{dbConnection: db}, nil
 }
 
 func isDeadLockError(err error) bool {
@@ -265,3 +577,123 @@ func isDeadLockError(err error) bool {
 	}
 	return false
 }
+
+// SetDeletionPolicy adds the deletion policy of a key or replaces the existing one
+func (d *mysqlDB) SetDeletionPolicy(policy *DeletionPolicy) error {
+	if policy == nil {
+		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires a deletion policy")
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("INSERT INTO %s (%s,%s,%s,%s,%s) VALUES (?,?,?,?,?) ON DUPLICATE KEY UPDATE %s=VALUES(%s),%s=VALUES(%s)",
+		deletionPolicyTableSQL, kpIDColumnSQL, spaceIDColumnSQL, orgIDColumnSQL, pendingUserSQL, pendingExpirySQL,
+		pendingUserSQL, pendingUserSQL, pendingExpirySQL, pendingExpirySQL)
+	insert, err := d.prepare(query)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	_, err = insert.Exec(policy.KpID, policy.Space, policy.Org, policy.PendingUserID, UnixSeconds(policy.PendingExpiration))
+	return err
+}
+
+func (d *mysqlDB) GetDeletionPolicy(space string, org string, kpID string) (*DeletionPolicy, error) {
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s FROM %s WHERE %s = ? AND %s = ?", pendingUserSQL, pendingExpirySQL, deletionPolicyTableSQL, spaceIDColumnSQL, kpIDColumnSQL)
+	get, err := d.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer get.Close()
+
+	var expiration int64
+	policy := &DeletionPolicy{Space: space, Org: org, KpID: kpID}
+	err = get.QueryRow(space, kpID).Scan(&policy.PendingUserID, &expiration)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy.PendingExpiration = FromUnixSeconds(expiration)
+	return policy, nil
+}
+
+func (d *mysqlDB) DeleteDeletionPolicy(space string, org string, kpID string) error {
+	/* #nosec */
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND %s = ?", deletionPolicyTableSQL, spaceIDColumnSQL, kpIDColumnSQL)
+	del, err := d.prepare(query)
+	if err != nil {
+		return err
+	}
+	defer del.Close()
+	_, err = del.Exec(space, kpID)
+	return err
+}
+
+// AddImportToken adds the import token of the space, unless it already has one
+func (d *mysqlDB) AddImportToken(token *ImportToken) (bool, error) {
+	if token == nil {
+		return false, errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires an import token")
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("INSERT IGNORE INTO %s (%s,%s,%s,%s,%s,%s) VALUES (?,?,?,?,?,?)",
+		importTokenTableSQL, spaceIDColumnSQL, orgIDColumnSQL, tokenIDSQL, publicKeySQL, sealedKeySQL, expirationSQL)
+	insert, err := d.prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer insert.Close()
+	status, err := insert.Exec(token.Space, token.Org, token.ID, token.PublicKey, token.SealedPrivateKey, UnixSeconds(token.Expiration))
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := status.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+func (d *mysqlDB) GetImportToken(space string, org string) (*ImportToken, error) {
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s,%s FROM %s WHERE %s = ?", tokenIDSQL, publicKeySQL, sealedKeySQL, expirationSQL, importTokenTableSQL, spaceIDColumnSQL)
+	get, err := d.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer get.Close()
+
+	var expiration int64
+	token := &ImportToken{Space: space, Org: org}
+	err = get.QueryRow(space).Scan(&token.ID, &token.PublicKey, &token.SealedPrivateKey, &expiration)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	token.Expiration = FromUnixSeconds(expiration)
+	return token, nil
+}
+
+// ClaimImportToken removes the import token of the space, unless it was replaced since it was read
+func (d *mysqlDB) ClaimImportToken(space string, org string, id string) (bool, error) {
+	/* #nosec */
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND %s = ?", importTokenTableSQL, spaceIDColumnSQL, tokenIDSQL)
+	del, err := d.prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer del.Close()
+	status, err := del.Exec(space, id)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := status.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
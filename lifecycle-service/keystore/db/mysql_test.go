@@ -0,0 +1,42 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package db_test
+
+import (
+	"os"
+	"testing"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db/dbtest"
+)
+
+// mysqlCredentialsEnv names the credentials of a MySQL database with the tables of the service, created by
+// schema/mysql.sql, which the tests add rows to. The tests are skipped without it.
+const mysqlCredentialsEnv = "MARIA_CREDENTIALS_LOCATION"
+
+// openMySQL opens the MySQL database of the tests, skipping them if none is set up
+func openMySQL(t *testing.T) db.Store {
+	if os.Getenv(mysqlCredentialsEnv) == "" {
+		t.Skip(mysqlCredentialsEnv + " is not set")
+	}
+	store, err := db.NewMySQLInstance()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return store
+}
+
+func TestMySQLDB(t *testing.T) {
+	store := openMySQL(t)
+	dbtest.TestDB(t, func() db.DB { return store })
+}
+
+func TestMySQLPolicyDB(t *testing.T) {
+	store := openMySQL(t)
+	dbtest.TestPolicyDB(t, func() db.PolicyDB { return store })
+}
+
+func TestMySQLImportTokenDB(t *testing.T) {
+	store := openMySQL(t)
+	dbtest.TestImportTokenDB(t, func() db.ImportTokenDB { return store })
+}
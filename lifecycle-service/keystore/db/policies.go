@@ -0,0 +1,80 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package db
+
+import (
+	"time"
+)
+
+// RotationPolicy is the schedule on which the service rotates a key
+type RotationPolicy struct {
+	Space        string
+	Org          string
+	KpID         string
+	IntervalDays int
+	// LastRotation is the zero time until the key is first rotated on schedule
+	LastRotation time.Time
+	NextRotation time.Time
+}
+
+// Schedule sets the next rotation of the policy to one interval after the given time
+func (policy *RotationPolicy) Schedule(from time.Time) *RotationPolicy {
+	policy.NextRotation = from.AddDate(0, 0, policy.IntervalDays)
+	return policy
+}
+
+// DeletionPolicy requires the deletion of a key to be authorized by two different users.
+// A key has the policy for as long as it is stored.
+type DeletionPolicy struct {
+	Space string
+	Org   string
+	KpID  string
+	// PendingUserID is the user who authorized a deletion awaiting a second user, until PendingExpiration
+	PendingUserID     string
+	PendingExpiration time.Time
+}
+
+// IsPending reports whether a deletion has been authorized and has not expired at now
+func (policy *DeletionPolicy) IsPending(now time.Time) bool {
+	return policy.PendingUserID != "" && now.Before(policy.PendingExpiration)
+}
+
+//PolicyDB The interface for storing the rotation and deletion policies of keys.
+//Unlike DB, rotation policies are listed across every space so that due keys can be found.
+//ClaimPolicy moves the next rotation of a policy to until only if it is still the NextRotation of the policy, and
+//reports whether it did, so that of the replicas finding the same due policy only one rotates the key.
+type PolicyDB interface {
+	SetPolicy(policy *RotationPolicy) error
+	GetPolicy(space string, org string, kpID string) (*RotationPolicy, error)
+	DeletePolicy(space string, org string, kpID string) error
+	ListDuePolicies(now time.Time) ([]*RotationPolicy, error)
+	ClaimPolicy(policy *RotationPolicy, until time.Time) (bool, error)
+	SetDeletionPolicy(policy *DeletionPolicy) error
+	GetDeletionPolicy(space string, org string, kpID string) (*DeletionPolicy, error)
+	DeleteDeletionPolicy(space string, org string, kpID string) error
+}
+
+//NewPolicyDBInstance Creates a new instance of the PolicyDB, sharing the connection used for ID translations.
+func NewPolicyDBInstance() (PolicyDB, error) {
+	store, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// UnixSeconds stores the zero time as 0 rather than its negative unix time
+func UnixSeconds(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// FromUnixSeconds reads a time stored by UnixSeconds
+func FromUnixSeconds(seconds int64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0).UTC()
+}
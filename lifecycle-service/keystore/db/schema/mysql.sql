@@ -0,0 +1,59 @@
+-- © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+--
+-- Tables of the service in the MySQL (MariaDB) database shared with the ID translations of Barbican, as read and
+-- written by keystore/db/mysql.go. keyprotect_ids predates this file, so only the columns added to it are altered.
+-- Every statement may be applied again, so the file is applied as is to new and existing databases.
+
+-- org_id records the org of each key, so migrate can list the spaces of an org. Rows added before the column have
+-- an empty org_id, which no org lists, until they are backfilled:
+--   UPDATE keyprotect_ids SET org_id = '<org>' WHERE space_id IN (<spaces of the org>) AND org_id = '';
+ALTER TABLE keyprotect_ids ADD COLUMN IF NOT EXISTS org_id VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS keyprotect_ids_org ON keyprotect_ids (org_id, deleted);
+
+-- Versions added by rotation. The FirstVersion of a key stays in keyprotect_ids. Rows of deleted keys are kept with
+-- deleted set, as in keyprotect_ids, so their IDs are not reused.
+CREATE TABLE IF NOT EXISTS keyprotect_id_versions (
+  kp_id      VARCHAR(255) NOT NULL,
+  space_id   VARCHAR(255) NOT NULL,
+  version    INT          NOT NULL,
+  secret_ref VARCHAR(255) NOT NULL DEFAULT '',
+  order_ref  VARCHAR(255) NOT NULL DEFAULT '',
+  deleted    BOOLEAN      NOT NULL DEFAULT FALSE,
+  PRIMARY KEY (space_id, kp_id, version)
+);
+
+-- Rotation policies of keys. Rotation times are unix seconds, with 0 for a key never rotated on schedule. Due
+-- policies are listed across every space by next_rotation, which is indexed for that.
+CREATE TABLE IF NOT EXISTS keyprotect_rotation_policies (
+  kp_id         VARCHAR(255) NOT NULL,
+  space_id      VARCHAR(255) NOT NULL,
+  org_id        VARCHAR(255) NOT NULL DEFAULT '',
+  interval_days INT          NOT NULL,
+  last_rotation BIGINT       NOT NULL DEFAULT 0,
+  next_rotation BIGINT       NOT NULL,
+  PRIMARY KEY (space_id, kp_id),
+  INDEX keyprotect_rotation_policies_due (next_rotation)
+);
+
+-- Deletion policies of keys, requiring two users to authorize a deletion. pending_user_id is empty and
+-- pending_expiration 0 while no deletion is pending.
+CREATE TABLE IF NOT EXISTS keyprotect_deletion_policies (
+  kp_id              VARCHAR(255) NOT NULL,
+  space_id           VARCHAR(255) NOT NULL,
+  org_id             VARCHAR(255) NOT NULL DEFAULT '',
+  pending_user_id    VARCHAR(255) NOT NULL DEFAULT '',
+  pending_expiration BIGINT       NOT NULL DEFAULT 0,
+  PRIMARY KEY (space_id, kp_id)
+);
+
+-- The import token of each space. The private key is sealed by the service before it is stored. expiration is in
+-- unix seconds. The row is deleted once the token is used or replaced.
+CREATE TABLE IF NOT EXISTS keyprotect_import_tokens (
+  space_id           VARCHAR(255) NOT NULL,
+  org_id             VARCHAR(255) NOT NULL DEFAULT '',
+  token_id           VARCHAR(36)  NOT NULL,
+  public_key         BLOB         NOT NULL,
+  sealed_private_key BLOB         NOT NULL,
+  expiration         BIGINT       NOT NULL,
+  PRIMARY KEY (space_id)
+);
@@ -0,0 +1,445 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+//go:build sqlite
+// +build sqlite
+
+// Package sqlite keeps ID translations and policies in an embedded SQLite database, for local development without
+// MySQL. The go-sqlite3 driver needs cgo, so the package is only built with the sqlite build tag.
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	// registers the sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+	config "github.ibm.com/Alchemy-Key-Protect/kp-go-config"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+)
+
+// memoryPath opens a database that lives as long as its connection
+const memoryPath = ":memory:"
+
+// The tables and columns of MySQL
+/* #nosec */
+const (
+	idTable             = "keyprotect_ids"
+	idVersionTable      = "keyprotect_id_versions"
+	policyTable         = "keyprotect_rotation_policies"
+	deletionPolicyTable = "keyprotect_deletion_policies"
+	importTokenTable    = "keyprotect_import_tokens"
+
+	kpIDColumn      = "kp_id"
+	secretRefColumn = "secret_ref"
+	orderRefColumn  = "order_ref"
+	spaceIDColumn   = "space_id"
+	deletedColumn   = "deleted"
+	versionColumn   = "version"
+	orgIDColumn     = "org_id"
+	intervalColumn  = "interval_days"
+	lastRotation    = "last_rotation"
+	nextRotation    = "next_rotation"
+	pendingUser     = "pending_user_id"
+	pendingExpiry   = "pending_expiration"
+	tokenIDColumn   = "token_id"
+	publicKey       = "public_key"
+	sealedKey       = "sealed_private_key"
+	tokenExpiry     = "expiration"
+)
+
+// schema creates the tables of MySQL, with the same columns and keys. Rotation times are unix seconds, as in MySQL.
+var schema = []string{
+	fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s TEXT NOT NULL, %s TEXT NOT NULL, %s TEXT NOT NULL DEFAULT '', %s TEXT NOT NULL DEFAULT '', %s TEXT NOT NULL DEFAULT '', %s BOOLEAN NOT NULL DEFAULT 0, PRIMARY KEY (%s, %s))",
+		idTable, kpIDColumn, spaceIDColumn, orgIDColumn, secretRefColumn, orderRefColumn, deletedColumn, spaceIDColumn, kpIDColumn),
+	fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s TEXT NOT NULL, %s TEXT NOT NULL, %s INTEGER NOT NULL, %s TEXT NOT NULL DEFAULT '', %s TEXT NOT NULL DEFAULT '', %s BOOLEAN NOT NULL DEFAULT 0, PRIMARY KEY (%s, %s, %s))",
+		idVersionTable, kpIDColumn, spaceIDColumn, versionColumn, secretRefColumn, orderRefColumn, deletedColumn, spaceIDColumn, kpIDColumn, versionColumn),
+	fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s TEXT NOT NULL, %s TEXT NOT NULL, %s TEXT NOT NULL DEFAULT '', %s INTEGER NOT NULL, %s INTEGER NOT NULL, %s INTEGER NOT NULL, PRIMARY KEY (%s, %s))",
+		policyTable, kpIDColumn, spaceIDColumn, orgIDColumn, intervalColumn, lastRotation, nextRotation, spaceIDColumn, kpIDColumn),
+	fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s TEXT NOT NULL, %s TEXT NOT NULL, %s TEXT NOT NULL DEFAULT '', %s TEXT NOT NULL DEFAULT '', %s INTEGER NOT NULL DEFAULT 0, PRIMARY KEY (%s, %s))",
+		deletionPolicyTable, kpIDColumn, spaceIDColumn, orgIDColumn, pendingUser, pendingExpiry, spaceIDColumn, kpIDColumn),
+	fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s TEXT NOT NULL, %s TEXT NOT NULL DEFAULT '', %s TEXT NOT NULL, %s BLOB NOT NULL, %s BLOB NOT NULL, %s INTEGER NOT NULL, PRIMARY KEY (%s))",
+		importTokenTable, spaceIDColumn, orgIDColumn, tokenIDColumn, publicKey, sealedKey, tokenExpiry, spaceIDColumn),
+}
+
+// sqliteDB holds ID translations, policies and import tokens in an embedded SQLite database
+type sqliteDB struct {
+	dbConnection *sql.DB
+}
+
+var instance db.Store
+var instanceErr error
+var instanceOnce sync.Once
+
+func init() {
+	db.Register(db.SQLiteBackend, newInstance)
+}
+
+// newInstance opens the database at database.sqlitePath once for the process, or an in-memory database if no path
+// is configured
+func newInstance() (db.Store, error) {
+	instanceOnce.Do(func() {
+		path := config.Get().GetString("database.sqlitePath")
+		if path == "" {
+			path = memoryPath
+		}
+		instance, instanceErr = NewSQLiteDB(path)
+	})
+	return instance, instanceErr
+}
+
+// NewSQLiteDB opens the SQLite database at path, creating its tables if they do not exist.
+// A path of ":memory:" opens an empty database that is discarded once closed.
+func NewSQLiteDB(path string) (db.Store, error) {
+	connection, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite serializes writes, and every connection to ":memory:" would open a database of its own
+	connection.SetMaxOpenConns(1)
+
+	for _, create := range schema {
+		if _, err := connection.Exec(create); err != nil {
+			connection.Close()
+			return nil, err
+		}
+	}
+	return &sqliteDB{dbConnection: connection}, nil
+}
+
+// table returns the table holding the version refs point to
+func (d *sqliteDB) table(refs *db.BarbicanRefs) string {
+	if db.IsRotatedVersion(refs) {
+		return idVersionTable
+	}
+	return idTable
+}
+
+func (d *sqliteDB) Add(space string, org string, refs *db.BarbicanRefs) error {
+	if refs == nil {
+		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires translation references")
+	}
+
+	// Existing rows are ignored rather than failing, so the conflict is reported like the other backends
+	/* #nosec */
+	query := fmt.Sprintf("INSERT OR IGNORE INTO %s (%s,%s,%s,%s,%s) VALUES (?,?,?,?,?)", idTable, kpIDColumn, spaceIDColumn, orgIDColumn, secretRefColumn, orderRefColumn)
+	args := []interface{}{refs.KpID, space, org, refs.SecretID, refs.OrderID}
+	if db.IsRotatedVersion(refs) {
+		/* #nosec */
+		query = fmt.Sprintf("INSERT OR IGNORE INTO %s (%s,%s,%s,%s,%s) VALUES (?,?,?,?,?)", idVersionTable, kpIDColumn, spaceIDColumn, versionColumn, secretRefColumn, orderRefColumn)
+		args = []interface{}{refs.KpID, space, refs.Version, refs.SecretID, refs.OrderID}
+	}
+
+	status, err := d.dbConnection.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(status, db.ErrDuplicateRefs)
+}
+
+func (d *sqliteDB) Update(space string, org string, refs *db.BarbicanRefs) error {
+	if refs == nil {
+		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires translation references")
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("UPDATE %s SET %s=?,%s=? WHERE %s=? AND %s=?", d.table(refs), secretRefColumn, orderRefColumn, kpIDColumn, spaceIDColumn)
+	args := []interface{}{refs.SecretID, refs.OrderID, refs.KpID, space}
+	if db.IsRotatedVersion(refs) {
+		query += fmt.Sprintf(" AND %s=?", versionColumn)
+		args = append(args, refs.Version)
+	}
+
+	status, err := d.dbConnection.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(status, db.ErrNoRowsUpdated)
+}
+
+// checkRowsAffected returns errNone if the statement changed no rows
+func checkRowsAffected(status sql.Result, errNone error) error {
+	rowsAffected, err := status.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errNone
+	}
+	return nil
+}
+
+func (d *sqliteDB) Get(space string, org string, kpID string) (*db.BarbicanRefs, error) {
+	return d.GetVersion(space, org, kpID, db.FirstVersion)
+}
+
+// Delete marks every version of the key deleted
+func (d *sqliteDB) Delete(space string, org string, kpID string) error {
+	for _, table := range []string{idTable, idVersionTable} {
+		/* #nosec */
+		query := fmt.Sprintf("UPDATE %s SET %s=? WHERE %s = ? AND %s = ?", table, deletedColumn, spaceIDColumn, kpIDColumn)
+		if _, err := d.dbConnection.Exec(query, true, space, kpID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *sqliteDB) GetVersion(space string, org string, kpID string, version int) (*db.BarbicanRefs, error) {
+	ref := &db.BarbicanRefs{KpID: kpID, Version: version}
+
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s FROM %s WHERE %s = ? AND %s = ? AND %s = ? AND %s = ?", secretRefColumn, orderRefColumn, idVersionTable, spaceIDColumn, kpIDColumn, versionColumn, deletedColumn)
+	args := []interface{}{space, kpID, version, false}
+	if !db.IsRotatedVersion(ref) {
+		ref.Version = db.FirstVersion
+		/* #nosec */
+		query = fmt.Sprintf("SELECT %s,%s FROM %s WHERE %s = ? AND %s = ? AND %s = ?", secretRefColumn, orderRefColumn, idTable, spaceIDColumn, kpIDColumn, deletedColumn)
+		args = []interface{}{space, kpID, false}
+	}
+
+	err := d.dbConnection.QueryRow(query, args...).Scan(&ref.SecretID, &ref.OrderID)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// ListVersions returns every version of the key, ordered from the FirstVersion to the latest
+func (d *sqliteDB) ListVersions(space string, org string, kpID string) ([]*db.BarbicanRefs, error) {
+	first, err := d.Get(space, org, kpID)
+	if err != nil {
+		return nil, err
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s FROM %s WHERE %s = ? AND %s = ? AND %s = ? ORDER BY %s", versionColumn, secretRefColumn, orderRefColumn, idVersionTable, spaceIDColumn, kpIDColumn, deletedColumn, versionColumn)
+	rows, err := d.dbConnection.Query(query, space, kpID, false)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := []*db.BarbicanRefs{first}
+	for rows.Next() {
+		ref := &db.BarbicanRefs{KpID: kpID}
+		if err := rows.Scan(&ref.Version, &ref.SecretID, &ref.OrderID); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+// ListKeys returns the FirstVersion of every key of the space, ordered by KP ID
+func (d *sqliteDB) ListKeys(space string, org string) ([]*db.BarbicanRefs, error) {
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s FROM %s WHERE %s = ? AND %s = ? ORDER BY %s", kpIDColumn, secretRefColumn, orderRefColumn, idTable, spaceIDColumn, deletedColumn, kpIDColumn)
+	rows, err := d.dbConnection.Query(query, space, false)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := []*db.BarbicanRefs{}
+	for rows.Next() {
+		ref := &db.BarbicanRefs{Version: db.FirstVersion}
+		if err := rows.Scan(&ref.KpID, &ref.SecretID, &ref.OrderID); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+// ListSpaces returns the sorted spaces of the org that have keys
+func (d *sqliteDB) ListSpaces(org string) ([]string, error) {
+	/* #nosec */
+	query := fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s = ? AND %s = ? ORDER BY %s", spaceIDColumn, idTable, orgIDColumn, deletedColumn, spaceIDColumn)
+	rows, err := d.dbConnection.Query(query, org, false)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	spaces := []string{}
+	for rows.Next() {
+		var space string
+		if err := rows.Scan(&space); err != nil {
+			return nil, err
+		}
+		spaces = append(spaces, space)
+	}
+	return spaces, rows.Err()
+}
+
+// SetPolicy adds the rotation policy of a key or replaces the existing one
+func (d *sqliteDB) SetPolicy(policy *db.RotationPolicy) error {
+	if policy == nil {
+		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires a rotation policy")
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("INSERT OR REPLACE INTO %s (%s,%s,%s,%s,%s,%s) VALUES (?,?,?,?,?,?)", policyTable, kpIDColumn, spaceIDColumn, orgIDColumn, intervalColumn, lastRotation, nextRotation)
+	_, err := d.dbConnection.Exec(query, policy.KpID, policy.Space, policy.Org, policy.IntervalDays, db.UnixSeconds(policy.LastRotation), policy.NextRotation.Unix())
+	return err
+}
+
+func (d *sqliteDB) GetPolicy(space string, org string, kpID string) (*db.RotationPolicy, error) {
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s FROM %s WHERE %s = ? AND %s = ?", intervalColumn, lastRotation, nextRotation, policyTable, spaceIDColumn, kpIDColumn)
+
+	var last, next int64
+	policy := &db.RotationPolicy{Space: space, Org: org, KpID: kpID}
+	err := d.dbConnection.QueryRow(query, space, kpID).Scan(&policy.IntervalDays, &last, &next)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy.LastRotation = db.FromUnixSeconds(last)
+	policy.NextRotation = db.FromUnixSeconds(next)
+	return policy, nil
+}
+
+func (d *sqliteDB) DeletePolicy(space string, org string, kpID string) error {
+	/* #nosec */
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND %s = ?", policyTable, spaceIDColumn, kpIDColumn)
+	_, err := d.dbConnection.Exec(query, space, kpID)
+	return err
+}
+
+// ListDuePolicies returns the policies of every space whose next rotation is not after now, the most overdue first
+func (d *sqliteDB) ListDuePolicies(now time.Time) ([]*db.RotationPolicy, error) {
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s,%s,%s,%s FROM %s WHERE %s <= ? ORDER BY %s", kpIDColumn, spaceIDColumn, orgIDColumn, intervalColumn, lastRotation, nextRotation, policyTable, nextRotation, nextRotation)
+	rows, err := d.dbConnection.Query(query, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []*db.RotationPolicy{}
+	for rows.Next() {
+		var last, next int64
+		policy := new(db.RotationPolicy)
+		if err := rows.Scan(&policy.KpID, &policy.Space, &policy.Org, &policy.IntervalDays, &last, &next); err != nil {
+			return nil, err
+		}
+		policy.LastRotation = db.FromUnixSeconds(last)
+		policy.NextRotation = db.FromUnixSeconds(next)
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+// ClaimPolicy moves the next rotation of the policy to until, unless it was moved since the policy was read
+func (d *sqliteDB) ClaimPolicy(policy *db.RotationPolicy, until time.Time) (bool, error) {
+	/* #nosec */
+	query := fmt.Sprintf("UPDATE %s SET %s=? WHERE %s=? AND %s=? AND %s=?", policyTable, nextRotation, spaceIDColumn, kpIDColumn, nextRotation)
+	status, err := d.dbConnection.Exec(query, until.Unix(), policy.Space, policy.KpID, policy.NextRotation.Unix())
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := status.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// SetDeletionPolicy adds the deletion policy of a key or replaces the existing one
+func (d *sqliteDB) SetDeletionPolicy(policy *db.DeletionPolicy) error {
+	if policy == nil {
+		return errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires a deletion policy")
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("INSERT OR REPLACE INTO %s (%s,%s,%s,%s,%s) VALUES (?,?,?,?,?)", deletionPolicyTable, kpIDColumn, spaceIDColumn, orgIDColumn, pendingUser, pendingExpiry)
+	_, err := d.dbConnection.Exec(query, policy.KpID, policy.Space, policy.Org, policy.PendingUserID, db.UnixSeconds(policy.PendingExpiration))
+	return err
+}
+
+func (d *sqliteDB) GetDeletionPolicy(space string, org string, kpID string) (*db.DeletionPolicy, error) {
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s FROM %s WHERE %s = ? AND %s = ?", pendingUser, pendingExpiry, deletionPolicyTable, spaceIDColumn, kpIDColumn)
+
+	var expiration int64
+	policy := &db.DeletionPolicy{Space: space, Org: org, KpID: kpID}
+	err := d.dbConnection.QueryRow(query, space, kpID).Scan(&policy.PendingUserID, &expiration)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy.PendingExpiration = db.FromUnixSeconds(expiration)
+	return policy, nil
+}
+
+func (d *sqliteDB) DeleteDeletionPolicy(space string, org string, kpID string) error {
+	/* #nosec */
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND %s = ?", deletionPolicyTable, spaceIDColumn, kpIDColumn)
+	_, err := d.dbConnection.Exec(query, space, kpID)
+	return err
+}
+
+// AddImportToken adds the import token of the space, unless it already has one
+func (d *sqliteDB) AddImportToken(token *db.ImportToken) (bool, error) {
+	if token == nil {
+		return false, errors.New(http.StatusText(http.StatusInternalServerError) + ": Request requires an import token")
+	}
+
+	/* #nosec */
+	query := fmt.Sprintf("INSERT OR IGNORE INTO %s (%s,%s,%s,%s,%s,%s) VALUES (?,?,?,?,?,?)", importTokenTable, spaceIDColumn, orgIDColumn, tokenIDColumn, publicKey, sealedKey, tokenExpiry)
+	status, err := d.dbConnection.Exec(query, token.Space, token.Org, token.ID, token.PublicKey, token.SealedPrivateKey, db.UnixSeconds(token.Expiration))
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := status.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+func (d *sqliteDB) GetImportToken(space string, org string) (*db.ImportToken, error) {
+	/* #nosec */
+	query := fmt.Sprintf("SELECT %s,%s,%s,%s FROM %s WHERE %s = ?", tokenIDColumn, publicKey, sealedKey, tokenExpiry, importTokenTable, spaceIDColumn)
+
+	var expiration int64
+	token := &db.ImportToken{Space: space, Org: org}
+	err := d.dbConnection.QueryRow(query, space).Scan(&token.ID, &token.PublicKey, &token.SealedPrivateKey, &expiration)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	token.Expiration = db.FromUnixSeconds(expiration)
+	return token, nil
+}
+
+// ClaimImportToken removes the import token of the space, unless it was replaced since it was read
+func (d *sqliteDB) ClaimImportToken(space string, org string, id string) (bool, error) {
+	/* #nosec */
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND %s = ?", importTokenTable, spaceIDColumn, tokenIDColumn)
+	status, err := d.dbConnection.Exec(query, space, id)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := status.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
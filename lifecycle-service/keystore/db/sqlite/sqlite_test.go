@@ -0,0 +1,69 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+//go:build sqlite
+// +build sqlite
+
+package sqlite_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db/dbtest"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db/sqlite"
+)
+
+// newSQLiteDB opens an empty in-memory database
+func newSQLiteDB(t *testing.T) db.Store {
+	database, err := sqlite.NewSQLiteDB(":memory:")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return database
+}
+
+func TestSQLiteDB(t *testing.T) {
+	dbtest.TestDB(t, func() db.DB { return newSQLiteDB(t) })
+}
+
+func TestSQLitePolicyDB(t *testing.T) {
+	dbtest.TestPolicyDB(t, func() db.PolicyDB { return newSQLiteDB(t) })
+}
+
+func TestSQLiteImportTokenDB(t *testing.T) {
+	dbtest.TestImportTokenDB(t, func() db.ImportTokenDB { return newSQLiteDB(t) })
+}
+
+func TestSQLiteDBFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite-test")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keyprotect.db")
+
+	database, err := sqlite.NewSQLiteDB(path)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := database.Add("test-space", "test-org", &db.BarbicanRefs{KpID: "key-a", SecretID: "secret-a"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// The refs outlive the connection, and opening the file again keeps its tables
+	reopened, err := sqlite.NewSQLiteDB(path)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if refs, err := reopened.Get("test-space", "test-org", "key-a"); err != nil || refs.SecretID != "secret-a" {
+		t.Errorf("Expected secret-a, received %+v, %+v", refs, err)
+	}
+
+	// Bad Path: a database in a directory that does not exist
+	if _, err := sqlite.NewSQLiteDB(filepath.Join(dir, "missing", "keyprotect.db")); err == nil {
+		t.Error("Expected an error opening a database in a missing directory")
+	}
+}
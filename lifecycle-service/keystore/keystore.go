@@ -4,36 +4,143 @@ package keystore
 
 import (
 	"errors"
+	"net/http"
+	"sort"
+	"sync"
 
 	"github.com/go-kit/kit/log"
 
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
-	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican"
-	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/mock"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
 )
 
-// Type is the type of backend end strategies that are supported
-// currently only support barbican
-type Type int
-
+// Names of the backends of this repository, which register themselves when their package is imported
 const (
-	// Barbican Keystore Type
-	Barbican Type = iota
+	// Barbican Keystore
+	Barbican = "barbican"
 
 	// Mock for mock Keystore for local testing
-	Mock
+	Mock = "mock"
+
+	// Local Keystore that seals keys under a master key on local disk, for deployments without Barbican
+	Local = "local"
+
+	// PKCS11 Keystore that generates and holds keys on the token of an HSM
+	PKCS11 = "pkcs11"
+
+	// Vault Keystore that keeps keys in the KV and transit engines of HashiCorp Vault
+	Vault = "vault"
+
+	// KMIP Keystore that keeps keys on an external key manager reached over KMIP 1.4
+	KMIP = "kmip"
+
+	// Mirror Keystore that keeps every key in the backends of mirror.primary and mirror.secondary, to move tenants between them
+	Mirror = "mirror"
 )
 
-// NewKeystore will return the selected Keystore based on type, authorization needed
+// Names of the stores the policies of the registered backends are kept in
+const (
+	// DBPolicyStore is the database shared with the ID translations of Barbican
+	DBPolicyStore    = "db"
+	MockPolicyStore  = "mock"
+	LocalPolicyStore = "local"
+)
+
+// Factory creates the Keystore of a request, authorization needed for the Keystore is also required to be based in on creation
+type Factory func(auth *communications.Headers, logger log.Logger) (definitions.Keystore, error)
+
+// PolicyDBFactory opens the store for the rotation and deletion policies of the keys of a backend
+type PolicyDBFactory func() (db.PolicyDB, error)
+
+// Backend is a Keystore implementation, registered by name
+type Backend struct {
+	NewKeystore Factory
+	NewPolicyDB PolicyDBFactory
+	// PolicyStore names the store NewPolicyDB opens, as several backends may keep their policies in the same one
+	PolicyStore string
+	// ServiceAuth reports an error if the backend can only be called with the token of a user, which jobs running
+	// without a request do not have. Backends without it always authenticate as the service.
+	ServiceAuth func() error
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]Backend)
+)
+
+// SharedPolicyDB opens the database shared with the ID translations of Barbican, for backends whose policies are
+// kept in DBPolicyStore
+func SharedPolicyDB() (db.PolicyDB, error) {
+	return db.NewPolicyDBInstance()
+}
+
+// Register makes a backend available by name. Like database/sql drivers, backends register themselves from the
+// init functions of their packages, so registering a name twice, or a backend without factories, panics.
+func Register(name string, backend Backend) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if backend.NewKeystore == nil || backend.NewPolicyDB == nil {
+		panic("keystore: Register of " + name + " is missing a factory")
+	}
+	if _, dup := registry[name]; dup {
+		panic("keystore: Register called twice for " + name)
+	}
+	registry[name] = backend
+}
+
+// Registered returns the sorted names of the registered backends
+func Registered() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookup(name string) (Backend, error) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	backend, ok := registry[name]
+	if !ok {
+		return Backend{}, errors.New(http.StatusText(http.StatusInternalServerError) + ": Keystore backend " + name + " is not registered")
+	}
+	return backend, nil
+}
+
+// NewKeystore will return the Keystore of the named backend, authorization needed
 // for the Keystore is also required to be based in on creation
-func NewKeystore(keystoreType Type, auth *communications.Headers, logger log.Logger) (definitions.Keystore, error) {
-	switch keystoreType {
-	case Barbican:
-		return barbican.NewBarbicanKeystore(auth, logger), nil
-	case Mock:
-		return mock.NewMockKeystore(auth, logger), nil
-	default:
-		return nil, errors.New("Type not supported")
+func NewKeystore(name string, auth *communications.Headers, logger log.Logger) (definitions.Keystore, error) {
+	backend, err := lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return backend.NewKeystore(auth, logger)
+}
+
+// CheckServiceAuth reports an error if the named backend cannot be called without the token of a user
+func CheckServiceAuth(name string) error {
+	backend, err := lookup(name)
+	if err != nil {
+		return err
+	}
+	if backend.ServiceAuth == nil {
+		return nil
+	}
+	return backend.ServiceAuth()
+}
+
+// NewPolicyDB will return the store for rotation policies that is used along with the named backend
+func NewPolicyDB(name string) (db.PolicyDB, error) {
+	backend, err := lookup(name)
+	if err != nil {
+		return nil, err
 	}
+	return backend.NewPolicyDB()
 }
@@ -0,0 +1,237 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package kmip
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/kmip/ttlv"
+)
+
+// ClientTimeout bounds every exchange with the key manager, from dialing to reading the response
+const ClientTimeout = time.Second * 60
+
+// Config locates the key manager and the PEM files of the mutual TLS connection to it
+type Config struct {
+	Address    string
+	ClientCert string
+	ClientKey  string
+	CACert     string
+	// ServerName is checked against the certificate of the key manager, if it differs from the host of Address
+	ServerName string
+}
+
+// ResultError is a failed KMIP operation. KMIP failures are failures of the service, unless mapped by the caller.
+type ResultError struct {
+	Operation uint32
+	Reason    uint32
+	Message   string
+}
+
+// Error will return the ResultError in string format
+func (err *ResultError) Error() string {
+	return http.StatusText(http.StatusInternalServerError) + ": KMIP operation " + strconv.Itoa(int(err.Operation)) +
+		" failed with reason " + strconv.Itoa(int(err.Reason)) + ": " + err.Message
+}
+
+// isNotFound reports whether the error is a KMIP Item Not Found
+func isNotFound(err error) bool {
+	resultErr, ok := err.(*ResultError)
+	return ok && resultErr.Reason == ttlv.ResultReasonItemNotFound
+}
+
+// client sends single operations to the key manager, over a new connection for each
+type client struct {
+	address   string
+	tlsConfig *tls.Config
+}
+
+func newClient(address string, tlsConfig *tls.Config) *client {
+	return &client{address: address, tlsConfig: tlsConfig}
+}
+
+// loadTLSConfig reads the certificate and key of the service and the CA that signed the key manager's certificate
+func loadTLSConfig(config Config) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ServerName:   config.ServerName,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if config.CACert != "" {
+		caCert, err := ioutil.ReadFile(config.CACert)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("No certificates found in " + config.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// send sends a request of a single batch item and returns the payload of its response
+func (c *client) send(operation uint32, payload ...ttlv.Item) (ttlv.Item, error) {
+	request := ttlv.Structure(ttlv.TagRequestMessage,
+		ttlv.Structure(ttlv.TagRequestHeader,
+			ttlv.Structure(ttlv.TagProtocolVersion,
+				ttlv.Integer(ttlv.TagProtocolVersionMajor, 1),
+				ttlv.Integer(ttlv.TagProtocolVersionMinor, 4)),
+			ttlv.Integer(ttlv.TagBatchCount, 1)),
+		ttlv.Structure(ttlv.TagBatchItem,
+			ttlv.Enumeration(ttlv.TagOperation, operation),
+			ttlv.Structure(ttlv.TagRequestPayload, payload...)))
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: ClientTimeout}, "tcp", c.address, c.tlsConfig)
+	if err != nil {
+		return ttlv.Item{}, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(ClientTimeout)); err != nil {
+		return ttlv.Item{}, err
+	}
+
+	if err := ttlv.WriteMessage(conn, request); err != nil {
+		return ttlv.Item{}, err
+	}
+
+	response, err := ttlv.ReadMessage(conn)
+	if err != nil {
+		return ttlv.Item{}, err
+	}
+
+	batchItem, ok := response.Find(ttlv.TagBatchItem)
+	if response.Tag != ttlv.TagResponseMessage || !ok {
+		return ttlv.Item{}, ttlv.ErrMalformed
+	}
+
+	if batchItem.Enum(ttlv.TagResultStatus) != ttlv.ResultStatusSuccess {
+		return ttlv.Item{}, &ResultError{
+			Operation: operation,
+			Reason:    batchItem.Enum(ttlv.TagResultReason),
+			Message:   batchItem.Text(ttlv.TagResultMessage),
+		}
+	}
+
+	responsePayload, _ := batchItem.Find(ttlv.TagResponsePayload)
+	return responsePayload, nil
+}
+
+// create creates a symmetric key on the key manager with the attributes, returning its unique identifier
+func (c *client) create(attributes ...ttlv.Item) (string, error) {
+	response, err := c.send(ttlv.OperationCreate,
+		ttlv.Enumeration(ttlv.TagObjectType, ttlv.ObjectTypeSymmetricKey),
+		ttlv.Structure(ttlv.TagTemplateAttribute, attributes...))
+	if err != nil {
+		return "", err
+	}
+	return response.Text(ttlv.TagUniqueIdentifier), nil
+}
+
+// register registers the data as an opaque secret data object with the attributes, returning its unique identifier
+func (c *client) register(data []byte, attributes ...ttlv.Item) (string, error) {
+	response, err := c.send(ttlv.OperationRegister,
+		ttlv.Enumeration(ttlv.TagObjectType, ttlv.ObjectTypeSecretData),
+		ttlv.Structure(ttlv.TagTemplateAttribute, attributes...),
+		ttlv.Structure(ttlv.TagSecretData,
+			ttlv.Enumeration(ttlv.TagSecretDataType, ttlv.SecretDataTypePassword),
+			ttlv.Structure(ttlv.TagKeyBlock,
+				ttlv.Enumeration(ttlv.TagKeyFormatType, ttlv.KeyFormatTypeOpaque),
+				ttlv.Structure(ttlv.TagKeyValue,
+					ttlv.ByteString(ttlv.TagKeyMaterial, data)))))
+	if err != nil {
+		return "", err
+	}
+	return response.Text(ttlv.TagUniqueIdentifier), nil
+}
+
+// locate returns the unique identifiers of the objects with the name
+func (c *client) locate(name string) ([]string, error) {
+	response, err := c.send(ttlv.OperationLocate, ttlv.NameAttribute(name))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, id := range response.FindAll(ttlv.TagUniqueIdentifier) {
+		ids = append(ids, id.Value.(string))
+	}
+	return ids, nil
+}
+
+// get returns the object type and the key material of the object
+func (c *client) get(id string) (uint32, []byte, error) {
+	response, err := c.send(ttlv.OperationGet, ttlv.TextString(ttlv.TagUniqueIdentifier, id))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	objectType := response.Enum(ttlv.TagObjectType)
+	objectTag := ttlv.TagSymmetricKey
+	if objectType == ttlv.ObjectTypeSecretData {
+		objectTag = ttlv.TagSecretData
+	}
+
+	object, _ := response.Find(objectTag)
+	keyBlock, _ := object.Find(ttlv.TagKeyBlock)
+	keyValue, _ := keyBlock.Find(ttlv.TagKeyValue)
+	material := keyValue.Bytes(ttlv.TagKeyMaterial)
+	if material == nil {
+		return 0, nil, ttlv.ErrMalformed
+	}
+	return objectType, material, nil
+}
+
+// state returns the KMIP state of the object
+func (c *client) state(id string) (uint32, error) {
+	response, err := c.send(ttlv.OperationGetAttributes,
+		ttlv.TextString(ttlv.TagUniqueIdentifier, id),
+		ttlv.TextString(ttlv.TagAttributeName, ttlv.AttributeState))
+	if err != nil {
+		return 0, err
+	}
+
+	states := response.Attributes(ttlv.AttributeState)
+	if len(states) == 0 {
+		return 0, ttlv.ErrMalformed
+	}
+	state, _ := states[0].Value.(uint32)
+	return state, nil
+}
+
+// activate moves the object from Pre-Active to Active
+func (c *client) activate(id string) error {
+	_, err := c.send(ttlv.OperationActivate, ttlv.TextString(ttlv.TagUniqueIdentifier, id))
+	return err
+}
+
+// revoke deactivates the object, as it is no longer in use
+func (c *client) revoke(id string) error {
+	_, err := c.send(ttlv.OperationRevoke,
+		ttlv.TextString(ttlv.TagUniqueIdentifier, id),
+		ttlv.Structure(ttlv.TagRevocationReason,
+			ttlv.Enumeration(ttlv.TagRevocationReasonCode, ttlv.RevocationReasonCessationOfOperation)))
+	return err
+}
+
+// destroy destroys the key material of the object, which must not be Active
+func (c *client) destroy(id string) error {
+	_, err := c.send(ttlv.OperationDestroy, ttlv.TextString(ttlv.TagUniqueIdentifier, id))
+	return err
+}
@@ -0,0 +1,428 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package kmip
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/algorithms"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/kmip/ttlv"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-config"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+var (
+	// ErrNotFound notifies callers when the secret cannot be found on the key manager
+	ErrNotFound = errors.New(http.StatusText(http.StatusNotFound) + ": Unable to find secret")
+
+	// ErrUnsupportedObject notifies callers when the key manager holds an object the keystore does not create
+	ErrUnsupportedObject = errors.New(http.StatusText(http.StatusInternalServerError) + ": Unsupported KMIP object type")
+
+	clientOnce   sync.Once
+	sharedClient *client
+	clientErr    error
+)
+
+// kmipAlgorithm is how a generated key of an algorithm is created on the key manager
+type kmipAlgorithm struct {
+	algorithm uint32
+	usageMask int32
+}
+
+var kmipAlgorithms = map[string]kmipAlgorithm{
+	algorithms.AES:        {ttlv.CryptographicAlgorithmAES, ttlv.UsageMaskEncrypt | ttlv.UsageMaskDecrypt},
+	algorithms.HMACSHA256: {ttlv.CryptographicAlgorithmHMACSHA256, ttlv.UsageMaskMACGenerate | ttlv.UsageMaskMACVerify},
+	algorithms.HMACSHA384: {ttlv.CryptographicAlgorithmHMACSHA384, ttlv.UsageMaskMACGenerate | ttlv.UsageMaskMACVerify},
+	algorithms.HMACSHA512: {ttlv.CryptographicAlgorithmHMACSHA512, ttlv.UsageMaskMACGenerate | ttlv.UsageMaskMACVerify},
+}
+
+type keystore struct {
+	kmipClient *client
+	logger     log.Logger
+	headers    *communications.Headers
+}
+
+// baseName is the Name every version of a key has on the key manager. The space and org are hex encoded and
+// the ID must be a UUID, so no name can reach the keys of another space or org.
+func baseName(space, org, keyprotectID string) (string, error) {
+	if _, err := uuid.FromString(keyprotectID); err != nil {
+		return "", ErrNotFound
+	}
+	return "keyprotect/" + hex.EncodeToString([]byte(space)) + "/" + hex.EncodeToString([]byte(org)) + "/" + keyprotectID, nil
+}
+
+// versionName is the second Name of a version of a key, which locates that version alone
+func versionName(base string, version int) string {
+	return base + "#" + strconv.Itoa(version)
+}
+
+// nameAttributes are the Names of a version of a key
+func nameAttributes(base string, version int) []ttlv.Item {
+	return []ttlv.Item{ttlv.NameAttribute(base), ttlv.NameAttribute(versionName(base, version))}
+}
+
+// keyState maps the state of a KMIP object onto the state of a key. Compromised objects may only be used to
+// process data they already protect, as deactivated keys are.
+func keyState(state uint32) secrets.KeyStates {
+	switch state {
+	case ttlv.StatePreActive:
+		return secrets.Preactivation
+	case ttlv.StateActive:
+		return secrets.Activation
+	case ttlv.StateDeactivated, ttlv.StateCompromised:
+		return secrets.Deactivated
+	default:
+		return secrets.Destroyed
+	}
+}
+
+// versions returns the number of versions of the key
+func (s *keystore) versions(base string) (int, error) {
+	ids, err := s.kmipClient.locate(base)
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, ErrNotFound
+	}
+	return len(ids), nil
+}
+
+// locateVersion returns the unique identifier of a version of the key, along with that version. CurrentVersion locates the latest one.
+func (s *keystore) locateVersion(base string, version int) (string, int, error) {
+	count, err := s.versions(base)
+	if err != nil {
+		return "", version, err
+	}
+
+	if version == definitions.CurrentVersion {
+		version = count
+	}
+	if version < 1 || version > count {
+		return "", version, definitions.ErrVersionNotFound
+	}
+
+	ids, err := s.kmipClient.locate(versionName(base, version))
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return "", version, err
+	}
+	if len(ids) == 0 {
+		return "", version, definitions.ErrVersionNotFound
+	}
+	return ids[0], version, nil
+}
+
+// destroyObject revokes the object, if it is still in use, and destroys it
+func (s *keystore) destroyObject(id string) error {
+	state, err := s.kmipClient.state(id)
+	if err != nil {
+		return err
+	}
+
+	switch state {
+	case ttlv.StateDestroyed, ttlv.StateDestroyedCompromised:
+		return nil
+	case ttlv.StatePreActive, ttlv.StateActive:
+		if err := s.kmipClient.revoke(id); err != nil {
+			return err
+		}
+	}
+	return s.kmipClient.destroy(id)
+}
+
+// createVersion creates an active key of the algorithm as a version of the key, adding its destruction to the transaction
+func (s *keystore) createVersion(base string, version int, spec *algorithms.Spec, tx *transactions.Transaction) error {
+	kmipAlg, ok := kmipAlgorithms[spec.Algorithm]
+	if !ok {
+		return errors.New(http.StatusText(http.StatusBadRequest) + ": Algorithm " + spec.Algorithm + " is not supported by the key manager")
+	}
+
+	attributes := append(nameAttributes(base, version),
+		ttlv.Attribute(ttlv.AttributeCryptographicAlgorithm, ttlv.Enumeration(ttlv.TagAttributeValue, kmipAlg.algorithm)),
+		ttlv.Attribute(ttlv.AttributeCryptographicLength, ttlv.Integer(ttlv.TagAttributeValue, int32(spec.BitLength))),
+		ttlv.Attribute(ttlv.AttributeCryptographicUsageMask, ttlv.Integer(ttlv.TagAttributeValue, kmipAlg.usageMask)))
+
+	id, err := s.kmipClient.create(attributes...)
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return err
+	}
+
+	rbDestroyKey := transactions.NewHsmCreateSecretRollback(s.destroyObject, id)
+	tx.Add(rbDestroyKey)
+
+	return s.activate(id)
+}
+
+func (s *keystore) activate(id string) error {
+	if err := s.kmipClient.activate(id); err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return err
+	}
+	return nil
+}
+
+// GetPayload : Get the payload the secret with the given keyprotect ID was created with
+func (s *keystore) GetPayload(ctx context.Context, keyprotectID string) (string, secrets.KeyStates, error) {
+	payload, _, state, err := s.GetPayloadVersion(ctx, keyprotectID, 1)
+	return payload, state, err
+}
+
+// GetPayloadVersion : Get the payload of a version of the secret with the given keyprotect ID, along with the state of that version.
+// Generated keys are returned base64 encoded, as Barbican returns them.
+func (s *keystore) GetPayloadVersion(ctx context.Context, keyprotectID string, version int) (string, int, secrets.KeyStates, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return "", version, secrets.Destroyed, extractErr
+	}
+
+	base, err := baseName(space, org, keyprotectID)
+	if err != nil {
+		return "", version, secrets.Destroyed, err
+	}
+
+	id, version, err := s.locateVersion(base, version)
+	if err != nil {
+		return "", version, secrets.Destroyed, err
+	}
+
+	kmipState, err := s.kmipClient.state(id)
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return "", version, secrets.Destroyed, err
+	}
+
+	state := keyState(kmipState)
+	if state == secrets.Destroyed {
+		return "", version, state, ErrNotFound
+	}
+
+	objectType, material, err := s.kmipClient.get(id)
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return "", version, secrets.Destroyed, err
+	}
+
+	switch objectType {
+	case ttlv.ObjectTypeSymmetricKey:
+		return base64.StdEncoding.EncodeToString(material), version, state, nil
+	case ttlv.ObjectTypeSecretData:
+		return string(material), version, state, nil
+	default:
+		return "", version, secrets.Destroyed, ErrUnsupportedObject
+	}
+}
+
+// CreateSecret registers the payload of the secret with the key manager as secret data, or has the key manager
+// create a key of the secret's algorithm when there is no payload. Either is activated once created.
+func (s *keystore) CreateSecret(ctx context.Context, secret *secrets.Secret, createTx *transactions.Transaction) (string, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return "", extractErr
+	}
+
+	id := uuid.NewV4().String()
+	base, err := baseName(space, org, id)
+	if err != nil {
+		return "", err
+	}
+
+	if len(secret.Payload) == 0 {
+		spec, err := algorithms.ResolveSecret(secret)
+		if err != nil {
+			return "", err
+		}
+
+		if err := s.createVersion(base, 1, spec, createTx); err != nil {
+			return "", err
+		}
+
+		secret.SetState(secrets.Activation)
+		return id, nil
+	}
+
+	if err := s.registerVersion(base, 1, secret.Payload, createTx); err != nil {
+		return "", err
+	}
+
+	secret.SetState(secrets.Activation)
+	return id, nil
+}
+
+// registerVersion registers the payload as secret data named for the version, and activates it
+func (s *keystore) registerVersion(base string, version int, payload string, tx *transactions.Transaction) error {
+	objectID, err := s.kmipClient.register([]byte(payload), nameAttributes(base, version)...)
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return err
+	}
+
+	rbDestroySecret := transactions.NewHsmCreateSecretRollback(s.destroyObject, objectID)
+	tx.Add(rbDestroySecret)
+
+	return s.activate(objectID)
+}
+
+// RotateSecret has the key manager create a new version of the secret for its algorithm
+func (s *keystore) RotateSecret(ctx context.Context, keyprotectID string, secret *secrets.Secret, rotateTx *transactions.Transaction) (int, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return 0, extractErr
+	}
+
+	base, err := baseName(space, org, keyprotectID)
+	if err != nil {
+		return 0, err
+	}
+
+	spec, err := algorithms.Resolve(secret.AlgorithmType, secret.AlgorithmMetadata)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := s.versions(base)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.createVersion(base, count+1, spec, rotateTx); err != nil {
+		return 0, err
+	}
+	return count + 1, nil
+}
+
+// ImportVersion registers the payload of the secret as a version of the key with the given keyprotect ID
+func (s *keystore) ImportVersion(ctx context.Context, keyprotectID string, version int, secret *secrets.Secret, importTx *transactions.Transaction) error {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return extractErr
+	}
+
+	if len(secret.Payload) == 0 {
+		return definitions.ErrNoPayload
+	}
+
+	base, err := baseName(space, org, keyprotectID)
+	if err != nil {
+		return err
+	}
+
+	count, err := s.versions(base)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+
+	if version != count+1 {
+		return definitions.ErrVersionConflict
+	}
+
+	return s.registerVersion(base, version, secret.Payload, importTx)
+}
+
+// DeleteSecret Given a keyprotect ID. Revoke and destroy every version of the user's secret.
+func (s *keystore) DeleteSecret(ctx context.Context, keyprotectID string, deleteTx *transactions.Transaction) error {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return extractErr
+	}
+
+	base, err := baseName(space, org, keyprotectID)
+	if err != nil {
+		return err
+	}
+
+	ids, err := s.kmipClient.locate(base)
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return err
+	}
+	if len(ids) == 0 {
+		return ErrNotFound
+	}
+
+	for _, id := range ids {
+		if err := s.destroyObject(id); err != nil && !isNotFound(err) {
+			s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckSecret: Given a keyprotect id, what state is the latest version of the secret in on the key manager?
+func (s *keystore) CheckSecret(ctx context.Context, keyprotectID string) (secrets.KeyStates, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return secrets.Destroyed, extractErr
+	}
+
+	base, err := baseName(space, org, keyprotectID)
+	if err != nil {
+		return secrets.Destroyed, err
+	}
+
+	id, _, err := s.locateVersion(base, definitions.CurrentVersion)
+	if err != nil {
+		return secrets.Destroyed, err
+	}
+
+	state, err := s.kmipClient.state(id)
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return secrets.Destroyed, err
+	}
+	return keyState(state), nil
+}
+
+// newSharedClient reads the location of the key manager and the certificates of the service from the configuration
+func newSharedClient() (*client, error) {
+	config := configuration.Get()
+	kmipConfig := Config{
+		Address:    config.GetString("kmip.address"),
+		ClientCert: config.GetString("kmip.clientCert"),
+		ClientKey:  config.GetString("kmip.clientKey"),
+		CACert:     config.GetString("kmip.caCert"),
+		ServerName: config.GetString("kmip.serverName"),
+	}
+
+	tlsConfig, err := loadTLSConfig(kmipConfig)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(kmipConfig.Address, tlsConfig), nil
+}
+
+func newKeystore(kmipClient *client, auth *communications.Headers, logger log.Logger) definitions.Keystore {
+	s := new(keystore)
+	s.kmipClient = kmipClient
+	s.headers = auth
+	s.logger = logger
+	return s
+}
+
+// NewKMIPKeystore will return a Keystore that keeps keys on the KMIP 1.4 key manager at kmip.address, which the
+// service authenticates to with its client certificate. The certificates are loaded once and shared by every request.
+func NewKMIPKeystore(auth *communications.Headers, logger log.Logger) (definitions.Keystore, error) {
+	clientOnce.Do(func() {
+		sharedClient, clientErr = newSharedClient()
+	})
+	if clientErr != nil {
+		return nil, clientErr
+	}
+
+	return newKeystore(sharedClient, auth, logger), nil
+}
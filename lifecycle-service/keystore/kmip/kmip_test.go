@@ -0,0 +1,270 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package kmip
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/kmip/kmiptest"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/kmip/ttlv"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+var testHeaders = &communications.Headers{
+	BluemixSpace:  "test-space",
+	BluemixOrg:    "test-org",
+	CorrelationID: "test-correlation-id",
+}
+
+func newTestServer(t *testing.T) *kmiptest.Server {
+	server, err := kmiptest.NewServer()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return server
+}
+
+func newTestKeystore(server *kmiptest.Server, auth *communications.Headers) definitions.Keystore {
+	return newKeystore(newClient(server.Addr, server.ClientTLSConfig()), auth, log.NewNopLogger())
+}
+
+func TestGeneratedKey(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+	s := newTestKeystore(server, testHeaders)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	secret := secrets.NewSecret()
+	secret.AlgorithmMetadata = map[string]string{"bitLength": "128"}
+	id, err := s.CreateSecret(context.Background(), secret, &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	payload, state, err := s.GetPayload(context.Background(), id)
+	if err != nil || state != secrets.Activation {
+		t.Fatalf("Expected an active key, received %d, %+v", state, err)
+	}
+	if material, _ := base64.StdEncoding.DecodeString(payload); len(material) != 16 {
+		t.Errorf("Expected 16 bytes of key material, received %s", payload)
+	}
+
+	version, err := s.RotateSecret(context.Background(), id, secret, &tx)
+	if err != nil || version != 2 {
+		t.Fatalf("Expected version 2, received %d, %+v", version, err)
+	}
+
+	rotated, version, _, err := s.GetPayloadVersion(context.Background(), id, definitions.CurrentVersion)
+	if err != nil || version != 2 || rotated == payload {
+		t.Errorf("Expected new material for version 2, received %s, %d, %+v", rotated, version, err)
+	}
+
+	if first, _, _, err := s.GetPayloadVersion(context.Background(), id, 1); err != nil || first != payload {
+		t.Errorf("Expected %s, received %s, %+v", payload, first, err)
+	}
+
+	if _, _, _, err := s.GetPayloadVersion(context.Background(), id, 3); err != definitions.ErrVersionNotFound {
+		t.Errorf("Expected %s, received %+v", definitions.ErrVersionNotFound, err)
+	}
+
+	// Delete revokes and destroys every version, which the key manager keeps as destroyed objects
+	if err := s.DeleteSecret(context.Background(), id, &tx); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if state, err := s.CheckSecret(context.Background(), id); err != nil || state != secrets.Destroyed {
+		t.Errorf("Expected a destroyed key, received %d, %+v", state, err)
+	}
+
+	if _, _, err := s.GetPayload(context.Background(), id); err != ErrNotFound {
+		t.Errorf("Expected %s, received %+v", ErrNotFound, err)
+	}
+}
+
+func TestRegisteredPayload(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+	s := newTestKeystore(server, testHeaders)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	secret := secrets.NewSecret()
+	secret.Payload = "test-payload"
+	id, err := s.CreateSecret(context.Background(), secret, &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if payload, state, err := s.GetPayload(context.Background(), id); err != nil || payload != "test-payload" || state != secrets.Activation {
+		t.Errorf("Expected test-payload, received %s, %d, %+v", payload, state, err)
+	}
+
+	// Another space or org cannot find it
+	others := []*communications.Headers{
+		{BluemixSpace: "other-space", BluemixOrg: "test-org"},
+		{BluemixSpace: "test-space", BluemixOrg: "other-org"},
+	}
+	for _, headers := range others {
+		if _, err := newTestKeystore(server, headers).CheckSecret(context.Background(), id); err != ErrNotFound {
+			t.Errorf("Expected %s, received %+v", ErrNotFound, err)
+		}
+	}
+
+	if _, err := s.CheckSecret(context.Background(), "not-a-uuid"); err != ErrNotFound {
+		t.Errorf("Expected %s, received %+v", ErrNotFound, err)
+	}
+}
+
+func TestKeyStates(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+	s := newTestKeystore(server, testHeaders)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	id, err := s.CreateSecret(context.Background(), secrets.NewSecret(), &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	base, _ := baseName(testHeaders.BluemixSpace, testHeaders.BluemixOrg, id)
+	states := map[uint32]secrets.KeyStates{
+		ttlv.StatePreActive:            secrets.Preactivation,
+		ttlv.StateActive:               secrets.Activation,
+		ttlv.StateDeactivated:          secrets.Deactivated,
+		ttlv.StateCompromised:          secrets.Deactivated,
+		ttlv.StateDestroyed:            secrets.Destroyed,
+		ttlv.StateDestroyedCompromised: secrets.Destroyed,
+	}
+	for kmipState, expected := range states {
+		server.SetState(base, kmipState)
+		if state, err := s.CheckSecret(context.Background(), id); err != nil || state != expected {
+			t.Errorf("Expected state %d for KMIP state %d, received %d, %+v", expected, kmipState, state, err)
+		}
+	}
+}
+
+func TestCreateRollback(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+	s := newTestKeystore(server, testHeaders)
+
+	tx := transactions.NewTransaction()
+	id, err := s.CreateSecret(context.Background(), secrets.NewSecret(), &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if err := tx.Clean(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if state, err := s.CheckSecret(context.Background(), id); err != nil || state != secrets.Destroyed {
+		t.Errorf("Expected a destroyed key, received %d, %+v", state, err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "kmip")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := Config{
+		Address:    server.Addr,
+		ClientCert: filepath.Join(dir, "client.pem"),
+		ClientKey:  filepath.Join(dir, "client-key.pem"),
+		CACert:     filepath.Join(dir, "ca.pem"),
+	}
+	ioutil.WriteFile(config.ClientCert, server.ClientCertPEM, 0600)
+	ioutil.WriteFile(config.ClientKey, server.ClientKeyPEM, 0600)
+	ioutil.WriteFile(config.CACert, server.CACertPEM, 0600)
+
+	tlsConfig, err := loadTLSConfig(config)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := newClient(server.Addr, tlsConfig).locate("test-name"); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	// Bad Path: a client without a certificate is refused
+	tlsConfig.Certificates = nil
+	if _, err := newClient(server.Addr, tlsConfig).locate("test-name"); err == nil {
+		t.Error("Expected Error")
+	}
+
+	// Bad Path: the object is missing
+	if _, _, err := newClient(server.Addr, server.ClientTLSConfig()).get("404"); !isNotFound(err) {
+		t.Errorf("Expected Item Not Found, received %+v", err)
+	}
+}
+
+func TestImportVersion(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+	s := newTestKeystore(server, testHeaders)
+	importer := s.(definitions.KeyImporter)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	// Keys are imported under the ID they have in another keystore
+	id := uuid.NewV4().String()
+	for version, payload := range []string{"version-1", "version-2"} {
+		secret := secrets.NewSecret()
+		secret.Payload = payload
+		if err := importer.ImportVersion(context.Background(), id, version+1, secret, &tx); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	}
+
+	if payload, version, state, err := s.GetPayloadVersion(context.Background(), id, definitions.CurrentVersion); err != nil || version != 2 || payload != "version-2" || state != secrets.Activation {
+		t.Errorf("Expected the second version, received %s, %d, %d, %+v", payload, version, state, err)
+	}
+
+	// Bad Path: versions must follow the latest one, and carry material
+	secret := secrets.NewSecret()
+	secret.Payload = "version-1"
+	for _, version := range []int{1, 2, 4} {
+		if err := importer.ImportVersion(context.Background(), id, version, secret, &tx); err != definitions.ErrVersionConflict {
+			t.Errorf("Expected %s for version %d, received %+v", definitions.ErrVersionConflict, version, err)
+		}
+	}
+	if err := importer.ImportVersion(context.Background(), id, 3, secrets.NewSecret(), &tx); err != definitions.ErrNoPayload {
+		t.Errorf("Expected %s, received %+v", definitions.ErrNoPayload, err)
+	}
+
+	// Rolling back the import of a new key destroys it
+	rollbackID := uuid.NewV4().String()
+	rollbackTx := transactions.NewTransaction()
+	if err := importer.ImportVersion(context.Background(), rollbackID, 1, secret, &rollbackTx); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := rollbackTx.Clean(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if state, err := s.CheckSecret(context.Background(), rollbackID); err != nil || state != secrets.Destroyed {
+		t.Errorf("Expected a destroyed key, received %d, %+v", state, err)
+	}
+}
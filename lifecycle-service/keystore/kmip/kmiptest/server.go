@@ -0,0 +1,476 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+// Package kmiptest provides an in-process KMIP 1.4 key manager, reached over mutual TLS, for testing the KMIP keystore
+// without hardware. It keeps objects in memory and implements the operations the keystore uses.
+package kmiptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/kmip/ttlv"
+)
+
+// object is a managed object. Destroyed objects keep their attributes, as a key manager keeps them, but lose their material.
+type object struct {
+	objectType uint32
+	state      uint32
+	names      []string
+	algorithm  uint32
+	length     int32
+	usageMask  int32
+	material   []byte
+}
+
+// Server is a KMIP key manager listening on a local address. Only clients presenting the certificate of
+// ClientTLSConfig, or another certificate signed by its CA, are accepted.
+type Server struct {
+	// Addr is the host and port the server listens on
+	Addr string
+
+	// CACertPEM, ClientCertPEM and ClientKeyPEM are the PEM encodings of the CA and of the client certificate and key
+	CACertPEM     []byte
+	ClientCertPEM []byte
+	ClientKeyPEM  []byte
+
+	listener net.Listener
+	caPool   *x509.CertPool
+
+	lock    sync.Mutex
+	objects map[string]*object
+	nextID  int
+}
+
+// certificate signs a certificate for the template with the CA, or self-signs it when there is no CA
+func certificate(template *x509.Certificate, ca *x509.Certificate, caKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(24 * time.Hour)
+
+	if ca == nil {
+		ca, caKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func keyPair(cert *x509.Certificate, key *ecdsa.PrivateKey) (certPEM, keyPEM []byte, err error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	return certPEM, keyPEM, nil
+}
+
+// NewServer starts a key manager on a random local port, with a new CA that signs both its certificate and the client's
+func NewServer() (*Server, error) {
+	ca, caKey, err := certificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "kmiptest CA"},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	serverCert, serverKey, err := certificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "kmiptest"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, ca, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCert, clientKey, err := certificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "keyprotect"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &Server{objects: make(map[string]*object)}
+	server.CACertPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	server.ClientCertPEM, server.ClientKeyPEM, err = keyPair(clientCert, clientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	serverCertPEM, serverKeyPEM, err := keyPair(serverCert, serverKey)
+	if err != nil {
+		return nil, err
+	}
+	serverPair, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	server.caPool = x509.NewCertPool()
+	server.caPool.AddCert(ca)
+
+	server.listener, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    server.caPool,
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		return nil, err
+	}
+	server.Addr = server.listener.Addr().String()
+
+	go server.serve()
+	return server, nil
+}
+
+// ClientTLSConfig returns the TLS configuration of a client the server accepts
+func (server *Server) ClientTLSConfig() *tls.Config {
+	pair, err := tls.X509KeyPair(server.ClientCertPEM, server.ClientKeyPEM)
+	if err != nil {
+		panic(err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		RootCAs:      server.caPool,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// Close stops the server from accepting connections
+func (server *Server) Close() error {
+	return server.listener.Close()
+}
+
+// SetState moves every object with the name into the KMIP state, as the administrator of a key manager might
+func (server *Server) SetState(name string, state uint32) int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	count := 0
+	for _, obj := range server.objects {
+		if obj.hasName(name) {
+			obj.state = state
+			count++
+		}
+	}
+	return count
+}
+
+func (obj *object) hasName(name string) bool {
+	for _, objName := range obj.names {
+		if objName == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (server *Server) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.handle(conn)
+	}
+}
+
+// handle answers the requests of a connection until the client closes it
+func (server *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		request, err := ttlv.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+
+		var response ttlv.Item
+		if request.Tag != ttlv.TagRequestMessage {
+			response = ttlv.Structure(ttlv.TagResponseMessage, responseHeader(1),
+				failure(0, ttlv.ResultReasonInvalidMessage, "Expected a request message"))
+		} else {
+			batchItems := request.FindAll(ttlv.TagBatchItem)
+			response = ttlv.Structure(ttlv.TagResponseMessage, responseHeader(len(batchItems)))
+			for _, batchItem := range batchItems {
+				response.Value = append(response.Items(), server.process(batchItem))
+			}
+		}
+
+		if err := ttlv.WriteMessage(conn, response); err != nil {
+			return
+		}
+	}
+}
+
+func responseHeader(batchCount int) ttlv.Item {
+	return ttlv.Structure(ttlv.TagResponseHeader,
+		ttlv.Structure(ttlv.TagProtocolVersion,
+			ttlv.Integer(ttlv.TagProtocolVersionMajor, 1),
+			ttlv.Integer(ttlv.TagProtocolVersionMinor, 4)),
+		ttlv.DateTime(ttlv.TagTimeStamp, time.Now()),
+		ttlv.Integer(ttlv.TagBatchCount, int32(batchCount)))
+}
+
+func failure(operation uint32, reason uint32, message string) ttlv.Item {
+	return ttlv.Structure(ttlv.TagBatchItem,
+		ttlv.Enumeration(ttlv.TagOperation, operation),
+		ttlv.Enumeration(ttlv.TagResultStatus, ttlv.ResultStatusOperationFailed),
+		ttlv.Enumeration(ttlv.TagResultReason, reason),
+		ttlv.TextString(ttlv.TagResultMessage, message))
+}
+
+func success(operation uint32, payload ...ttlv.Item) ttlv.Item {
+	return ttlv.Structure(ttlv.TagBatchItem,
+		ttlv.Enumeration(ttlv.TagOperation, operation),
+		ttlv.Enumeration(ttlv.TagResultStatus, ttlv.ResultStatusSuccess),
+		ttlv.Structure(ttlv.TagResponsePayload, payload...))
+}
+
+// names returns the text of the Name attributes of the structure
+func names(item ttlv.Item) []string {
+	found := []string{}
+	for _, value := range item.Attributes(ttlv.AttributeName) {
+		found = append(found, value.Text(ttlv.TagNameValue))
+	}
+	return found
+}
+
+// attributeValue returns the value of the first attribute of the structure with the name
+func attributeValue(item ttlv.Item, name string) (interface{}, bool) {
+	values := item.Attributes(name)
+	if len(values) == 0 {
+		return nil, false
+	}
+	return values[0].Value, true
+}
+
+func (server *Server) add(obj *object) string {
+	server.nextID++
+	id := strconv.Itoa(server.nextID)
+	server.objects[id] = obj
+	return id
+}
+
+// process performs the operation of a batch item
+func (server *Server) process(batchItem ttlv.Item) ttlv.Item {
+	operation := batchItem.Enum(ttlv.TagOperation)
+	payload, _ := batchItem.Find(ttlv.TagRequestPayload)
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	switch operation {
+	case ttlv.OperationCreate:
+		return server.create(payload)
+	case ttlv.OperationRegister:
+		return server.register(payload)
+	case ttlv.OperationLocate:
+		return server.locate(payload)
+	}
+
+	id := payload.Text(ttlv.TagUniqueIdentifier)
+	obj, ok := server.objects[id]
+	if !ok {
+		return failure(operation, ttlv.ResultReasonItemNotFound, "No object with identifier "+id)
+	}
+	uniqueIdentifier := ttlv.TextString(ttlv.TagUniqueIdentifier, id)
+
+	switch operation {
+	case ttlv.OperationGet:
+		return server.get(obj, uniqueIdentifier)
+	case ttlv.OperationGetAttributes:
+		return server.getAttributes(obj, payload, uniqueIdentifier)
+	case ttlv.OperationActivate:
+		if obj.state != ttlv.StatePreActive {
+			return failure(operation, ttlv.ResultReasonIllegalOperation, "Only Pre-Active objects can be activated")
+		}
+		obj.state = ttlv.StateActive
+		return success(operation, uniqueIdentifier)
+	case ttlv.OperationRevoke:
+		if obj.state != ttlv.StatePreActive && obj.state != ttlv.StateActive {
+			return failure(operation, ttlv.ResultReasonIllegalOperation, "Only Pre-Active and Active objects can be revoked")
+		}
+		obj.state = ttlv.StateDeactivated
+		return success(operation, uniqueIdentifier)
+	case ttlv.OperationDestroy:
+		switch obj.state {
+		case ttlv.StateActive:
+			return failure(operation, ttlv.ResultReasonIllegalOperation, "Active objects must be revoked before they are destroyed")
+		case ttlv.StateCompromised, ttlv.StateDestroyedCompromised:
+			obj.state = ttlv.StateDestroyedCompromised
+		default:
+			obj.state = ttlv.StateDestroyed
+		}
+		obj.material = nil
+		return success(operation, uniqueIdentifier)
+	default:
+		return failure(operation, ttlv.ResultReasonNotSupported, "Operation not supported")
+	}
+}
+
+func (server *Server) create(payload ttlv.Item) ttlv.Item {
+	if payload.Enum(ttlv.TagObjectType) != ttlv.ObjectTypeSymmetricKey {
+		return failure(ttlv.OperationCreate, ttlv.ResultReasonInvalidField, "Only symmetric keys can be created")
+	}
+
+	template, _ := payload.Find(ttlv.TagTemplateAttribute)
+	obj := &object{objectType: ttlv.ObjectTypeSymmetricKey, state: ttlv.StatePreActive, names: names(template)}
+
+	algorithm, ok := attributeValue(template, ttlv.AttributeCryptographicAlgorithm)
+	if !ok {
+		return failure(ttlv.OperationCreate, ttlv.ResultReasonInvalidField, "Cryptographic Algorithm required")
+	}
+	length, ok := attributeValue(template, ttlv.AttributeCryptographicLength)
+	if !ok || length.(int32) <= 0 || length.(int32)%8 != 0 {
+		return failure(ttlv.OperationCreate, ttlv.ResultReasonInvalidField, "Cryptographic Length must be a positive multiple of 8")
+	}
+	usageMask, _ := attributeValue(template, ttlv.AttributeCryptographicUsageMask)
+
+	obj.algorithm = algorithm.(uint32)
+	obj.length = length.(int32)
+	obj.usageMask, _ = usageMask.(int32)
+	obj.material = make([]byte, obj.length/8)
+	if _, err := rand.Read(obj.material); err != nil {
+		return failure(ttlv.OperationCreate, ttlv.ResultReasonIllegalOperation, err.Error())
+	}
+
+	return success(ttlv.OperationCreate,
+		ttlv.Enumeration(ttlv.TagObjectType, obj.objectType),
+		ttlv.TextString(ttlv.TagUniqueIdentifier, server.add(obj)))
+}
+
+func (server *Server) register(payload ttlv.Item) ttlv.Item {
+	if payload.Enum(ttlv.TagObjectType) != ttlv.ObjectTypeSecretData {
+		return failure(ttlv.OperationRegister, ttlv.ResultReasonInvalidField, "Only secret data can be registered")
+	}
+
+	template, _ := payload.Find(ttlv.TagTemplateAttribute)
+	secretData, _ := payload.Find(ttlv.TagSecretData)
+	keyBlock, _ := secretData.Find(ttlv.TagKeyBlock)
+	keyValue, _ := keyBlock.Find(ttlv.TagKeyValue)
+	material := keyValue.Bytes(ttlv.TagKeyMaterial)
+	if material == nil {
+		return failure(ttlv.OperationRegister, ttlv.ResultReasonInvalidField, "Key Material required")
+	}
+
+	obj := &object{objectType: ttlv.ObjectTypeSecretData, state: ttlv.StatePreActive, names: names(template), material: material}
+	return success(ttlv.OperationRegister, ttlv.TextString(ttlv.TagUniqueIdentifier, server.add(obj)))
+}
+
+// locate returns the identifiers of the objects that have every name of the request
+func (server *Server) locate(payload ttlv.Item) ttlv.Item {
+	wanted := names(payload)
+
+	ids := []ttlv.Item{}
+	for id := 1; id <= server.nextID; id++ {
+		obj, ok := server.objects[strconv.Itoa(id)]
+		if !ok {
+			continue
+		}
+
+		matches := true
+		for _, name := range wanted {
+			matches = matches && obj.hasName(name)
+		}
+		if matches {
+			ids = append(ids, ttlv.TextString(ttlv.TagUniqueIdentifier, strconv.Itoa(id)))
+		}
+	}
+	return success(ttlv.OperationLocate, ids...)
+}
+
+func (server *Server) get(obj *object, uniqueIdentifier ttlv.Item) ttlv.Item {
+	if obj.material == nil {
+		return failure(ttlv.OperationGet, ttlv.ResultReasonIllegalOperation, "Object is destroyed")
+	}
+
+	if obj.objectType == ttlv.ObjectTypeSecretData {
+		return success(ttlv.OperationGet,
+			ttlv.Enumeration(ttlv.TagObjectType, obj.objectType),
+			uniqueIdentifier,
+			ttlv.Structure(ttlv.TagSecretData,
+				ttlv.Enumeration(ttlv.TagSecretDataType, ttlv.SecretDataTypePassword),
+				ttlv.Structure(ttlv.TagKeyBlock,
+					ttlv.Enumeration(ttlv.TagKeyFormatType, ttlv.KeyFormatTypeOpaque),
+					ttlv.Structure(ttlv.TagKeyValue, ttlv.ByteString(ttlv.TagKeyMaterial, obj.material)))))
+	}
+
+	return success(ttlv.OperationGet,
+		ttlv.Enumeration(ttlv.TagObjectType, obj.objectType),
+		uniqueIdentifier,
+		ttlv.Structure(ttlv.TagSymmetricKey,
+			ttlv.Structure(ttlv.TagKeyBlock,
+				ttlv.Enumeration(ttlv.TagKeyFormatType, ttlv.KeyFormatTypeRaw),
+				ttlv.Structure(ttlv.TagKeyValue, ttlv.ByteString(ttlv.TagKeyMaterial, obj.material)),
+				ttlv.Enumeration(ttlv.TagCryptographicAlgorithm, obj.algorithm),
+				ttlv.Integer(ttlv.TagCryptographicLength, obj.length))))
+}
+
+// getAttributes returns the requested attributes the object has, or all of them when none are requested
+func (server *Server) getAttributes(obj *object, payload ttlv.Item, uniqueIdentifier ttlv.Item) ttlv.Item {
+	attributes := []ttlv.Item{
+		ttlv.Attribute(ttlv.AttributeState, ttlv.Enumeration(ttlv.TagAttributeValue, obj.state)),
+	}
+	for _, name := range obj.names {
+		attributes = append(attributes, ttlv.NameAttribute(name))
+	}
+	if obj.objectType == ttlv.ObjectTypeSymmetricKey {
+		attributes = append(attributes,
+			ttlv.Attribute(ttlv.AttributeCryptographicAlgorithm, ttlv.Enumeration(ttlv.TagAttributeValue, obj.algorithm)),
+			ttlv.Attribute(ttlv.AttributeCryptographicLength, ttlv.Integer(ttlv.TagAttributeValue, obj.length)),
+			ttlv.Attribute(ttlv.AttributeCryptographicUsageMask, ttlv.Integer(ttlv.TagAttributeValue, obj.usageMask)))
+	}
+
+	requested := payload.FindAll(ttlv.TagAttributeName)
+	response := []ttlv.Item{uniqueIdentifier}
+	for _, attribute := range attributes {
+		if len(requested) == 0 {
+			response = append(response, attribute)
+			continue
+		}
+		for _, name := range requested {
+			if attribute.Text(ttlv.TagAttributeName) == name.Value.(string) {
+				response = append(response, attribute)
+			}
+		}
+	}
+	return success(ttlv.OperationGetAttributes, response...)
+}
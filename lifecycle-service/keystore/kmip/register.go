@@ -0,0 +1,11 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package kmip
+
+import (
+	registry "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+)
+
+func init() {
+	registry.Register(registry.KMIP, registry.Backend{NewKeystore: NewKMIPKeystore, NewPolicyDB: registry.SharedPolicyDB, PolicyStore: registry.DBPolicyStore})
+}
@@ -0,0 +1,156 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package ttlv
+
+// Tag identifies a TTLV item. KMIP tags are three bytes long.
+type Tag uint32
+
+// Tags of the KMIP 1.4 items used by the keystore
+const (
+	TagAttribute              Tag = 0x420008
+	TagAttributeName          Tag = 0x42000A
+	TagAttributeValue         Tag = 0x42000B
+	TagBatchCount             Tag = 0x42000D
+	TagBatchItem              Tag = 0x42000F
+	TagCryptographicAlgorithm Tag = 0x420028
+	TagCryptographicLength    Tag = 0x42002A
+	TagCryptographicUsageMask Tag = 0x42002C
+	TagKeyBlock               Tag = 0x420040
+	TagKeyFormatType          Tag = 0x420042
+	TagKeyMaterial            Tag = 0x420043
+	TagKeyValue               Tag = 0x420045
+	TagName                   Tag = 0x420053
+	TagNameType               Tag = 0x420054
+	TagNameValue              Tag = 0x420055
+	TagObjectType             Tag = 0x420057
+	TagOperation              Tag = 0x42005C
+	TagProtocolVersion        Tag = 0x420069
+	TagProtocolVersionMajor   Tag = 0x42006A
+	TagProtocolVersionMinor   Tag = 0x42006B
+	TagRequestHeader          Tag = 0x420077
+	TagRequestMessage         Tag = 0x420078
+	TagRequestPayload         Tag = 0x420079
+	TagResponseHeader         Tag = 0x42007A
+	TagResponseMessage        Tag = 0x42007B
+	TagResponsePayload        Tag = 0x42007C
+	TagResultMessage          Tag = 0x42007D
+	TagResultReason           Tag = 0x42007E
+	TagResultStatus           Tag = 0x42007F
+	TagRevocationReason       Tag = 0x420081
+	TagRevocationReasonCode   Tag = 0x420082
+	TagSecretData             Tag = 0x420085
+	TagSecretDataType         Tag = 0x420086
+	TagState                  Tag = 0x42008D
+	TagSymmetricKey           Tag = 0x42008F
+	TagTemplateAttribute      Tag = 0x420091
+	TagTimeStamp              Tag = 0x420092
+	TagUniqueIdentifier       Tag = 0x420094
+)
+
+// Names of the attributes used by the keystore, as carried in an AttributeName
+const (
+	AttributeName                   = "Name"
+	AttributeState                  = "State"
+	AttributeCryptographicAlgorithm = "Cryptographic Algorithm"
+	AttributeCryptographicLength    = "Cryptographic Length"
+	AttributeCryptographicUsageMask = "Cryptographic Usage Mask"
+)
+
+// Operations
+const (
+	OperationCreate        uint32 = 0x01
+	OperationRegister      uint32 = 0x03
+	OperationLocate        uint32 = 0x08
+	OperationGet           uint32 = 0x0A
+	OperationGetAttributes uint32 = 0x0B
+	OperationActivate      uint32 = 0x12
+	OperationRevoke        uint32 = 0x13
+	OperationDestroy       uint32 = 0x14
+)
+
+// Object types
+const (
+	ObjectTypeSymmetricKey uint32 = 0x02
+	ObjectTypeSecretData   uint32 = 0x07
+)
+
+// Cryptographic algorithms
+const (
+	CryptographicAlgorithmAES        uint32 = 0x03
+	CryptographicAlgorithmHMACSHA256 uint32 = 0x09
+	CryptographicAlgorithmHMACSHA384 uint32 = 0x0A
+	CryptographicAlgorithmHMACSHA512 uint32 = 0x0B
+)
+
+// Cryptographic usage mask bits
+const (
+	UsageMaskEncrypt     int32 = 0x04
+	UsageMaskDecrypt     int32 = 0x08
+	UsageMaskMACGenerate int32 = 0x80
+	UsageMaskMACVerify   int32 = 0x100
+)
+
+// Key format, secret data and name types
+const (
+	KeyFormatTypeRaw    uint32 = 0x01
+	KeyFormatTypeOpaque uint32 = 0x02
+
+	SecretDataTypePassword uint32 = 0x01
+
+	NameTypeUninterpretedTextString uint32 = 0x01
+)
+
+// Object states
+const (
+	StatePreActive            uint32 = 0x01
+	StateActive               uint32 = 0x02
+	StateDeactivated          uint32 = 0x03
+	StateCompromised          uint32 = 0x04
+	StateDestroyed            uint32 = 0x05
+	StateDestroyedCompromised uint32 = 0x06
+)
+
+// Revocation reason codes
+const (
+	RevocationReasonCessationOfOperation uint32 = 0x06
+)
+
+// Result statuses and reasons
+const (
+	ResultStatusSuccess         uint32 = 0x00
+	ResultStatusOperationFailed uint32 = 0x01
+
+	ResultReasonItemNotFound     uint32 = 0x01
+	ResultReasonInvalidMessage   uint32 = 0x04
+	ResultReasonNotSupported     uint32 = 0x05
+	ResultReasonInvalidField     uint32 = 0x07
+	ResultReasonIllegalOperation uint32 = 0x0B
+	ResultReasonPermissionDenied uint32 = 0x0C
+)
+
+// Attribute returns an Attribute of the name and value. The tag of the value is always AttributeValue.
+func Attribute(name string, value Item) Item {
+	value.Tag = TagAttributeValue
+	return Structure(TagAttribute, TextString(TagAttributeName, name), value)
+}
+
+// NameAttribute returns a Name attribute holding the text
+func NameAttribute(name string) Item {
+	return Attribute(AttributeName, Structure(TagAttributeValue,
+		TextString(TagNameValue, name),
+		Enumeration(TagNameType, NameTypeUninterpretedTextString)))
+}
+
+// Attributes returns the values of the attributes of the structure with the name
+func (item Item) Attributes(name string) []Item {
+	values := []Item{}
+	for _, attribute := range item.FindAll(TagAttribute) {
+		if attribute.Text(TagAttributeName) != name {
+			continue
+		}
+		if value, ok := attribute.Find(TagAttributeValue); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,291 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package ttlv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Type is the type of a TTLV item
+type Type byte
+
+// Types of the KMIP 1.4 encoding
+const (
+	TypeStructure   Type = 0x01
+	TypeInteger     Type = 0x02
+	TypeLongInteger Type = 0x03
+	TypeBigInteger  Type = 0x04
+	TypeEnumeration Type = 0x05
+	TypeBoolean     Type = 0x06
+	TypeTextString  Type = 0x07
+	TypeByteString  Type = 0x08
+	TypeDateTime    Type = 0x09
+	TypeInterval    Type = 0x0A
+)
+
+// headerLength is the length of the tag, type and length of every item
+const headerLength = 8
+
+// MaxMessageLength bounds the messages read from a connection
+const MaxMessageLength = 1 << 20
+
+// ErrMalformed is returned when bytes cannot be decoded as TTLV
+var ErrMalformed = errors.New("Malformed TTLV")
+
+// Item is a tagged value. Value holds []Item for structures, int32 for integers, uint32 for enumerations and
+// intervals, int64 for long integers, time.Time for date-times, bool, string and []byte for big integers and byte strings.
+type Item struct {
+	Tag   Tag
+	Type  Type
+	Value interface{}
+}
+
+// Structure returns a structure of the items
+func Structure(tag Tag, items ...Item) Item {
+	return Item{Tag: tag, Type: TypeStructure, Value: items}
+}
+
+// Integer returns an integer item
+func Integer(tag Tag, value int32) Item {
+	return Item{Tag: tag, Type: TypeInteger, Value: value}
+}
+
+// Enumeration returns an enumeration item
+func Enumeration(tag Tag, value uint32) Item {
+	return Item{Tag: tag, Type: TypeEnumeration, Value: value}
+}
+
+// Boolean returns a boolean item
+func Boolean(tag Tag, value bool) Item {
+	return Item{Tag: tag, Type: TypeBoolean, Value: value}
+}
+
+// TextString returns a text string item
+func TextString(tag Tag, value string) Item {
+	return Item{Tag: tag, Type: TypeTextString, Value: value}
+}
+
+// ByteString returns a byte string item
+func ByteString(tag Tag, value []byte) Item {
+	return Item{Tag: tag, Type: TypeByteString, Value: value}
+}
+
+// DateTime returns a date-time item, which has a precision of seconds
+func DateTime(tag Tag, value time.Time) Item {
+	return Item{Tag: tag, Type: TypeDateTime, Value: value.UTC().Truncate(time.Second)}
+}
+
+// Items returns the items of a structure, or nil for any other type
+func (item Item) Items() []Item {
+	items, _ := item.Value.([]Item)
+	return items
+}
+
+// Find returns the first item of the structure with the tag
+func (item Item) Find(tag Tag) (Item, bool) {
+	for _, child := range item.Items() {
+		if child.Tag == tag {
+			return child, true
+		}
+	}
+	return Item{}, false
+}
+
+// FindAll returns every item of the structure with the tag
+func (item Item) FindAll(tag Tag) []Item {
+	found := []Item{}
+	for _, child := range item.Items() {
+		if child.Tag == tag {
+			found = append(found, child)
+		}
+	}
+	return found
+}
+
+// Text returns the text of the first item of the structure with the tag, or the empty string
+func (item Item) Text(tag Tag) string {
+	child, _ := item.Find(tag)
+	text, _ := child.Value.(string)
+	return text
+}
+
+// Bytes returns the bytes of the first item of the structure with the tag, or nil
+func (item Item) Bytes(tag Tag) []byte {
+	child, _ := item.Find(tag)
+	bytes, _ := child.Value.([]byte)
+	return bytes
+}
+
+// Enum returns the enumeration of the first item of the structure with the tag, or 0
+func (item Item) Enum(tag Tag) uint32 {
+	child, _ := item.Find(tag)
+	enum, _ := child.Value.(uint32)
+	return enum
+}
+
+// Int returns the integer of the first item of the structure with the tag, or 0
+func (item Item) Int(tag Tag) int32 {
+	child, _ := item.Find(tag)
+	integer, _ := child.Value.(int32)
+	return integer
+}
+
+// padded rounds the length of a value up to the 8 byte alignment of TTLV
+func padded(length int) int {
+	return (length + 7) / 8 * 8
+}
+
+// Encode returns the TTLV encoding of the item
+func Encode(item Item) ([]byte, error) {
+	var value []byte
+	switch item.Type {
+	case TypeStructure:
+		for _, child := range item.Items() {
+			encoded, err := Encode(child)
+			if err != nil {
+				return nil, err
+			}
+			value = append(value, encoded...)
+		}
+	case TypeInteger:
+		value = make([]byte, 4)
+		binary.BigEndian.PutUint32(value, uint32(item.Value.(int32)))
+	case TypeEnumeration, TypeInterval:
+		value = make([]byte, 4)
+		binary.BigEndian.PutUint32(value, item.Value.(uint32))
+	case TypeLongInteger:
+		value = make([]byte, 8)
+		binary.BigEndian.PutUint64(value, uint64(item.Value.(int64)))
+	case TypeDateTime:
+		value = make([]byte, 8)
+		binary.BigEndian.PutUint64(value, uint64(item.Value.(time.Time).Unix()))
+	case TypeBoolean:
+		value = make([]byte, 8)
+		if item.Value.(bool) {
+			value[7] = 1
+		}
+	case TypeTextString:
+		value = []byte(item.Value.(string))
+	case TypeByteString, TypeBigInteger:
+		value = item.Value.([]byte)
+	default:
+		return nil, fmt.Errorf("Unsupported TTLV type %d", item.Type)
+	}
+
+	encoded := make([]byte, headerLength, headerLength+padded(len(value)))
+	encoded[0] = byte(item.Tag >> 16)
+	encoded[1] = byte(item.Tag >> 8)
+	encoded[2] = byte(item.Tag)
+	encoded[3] = byte(item.Type)
+	binary.BigEndian.PutUint32(encoded[4:headerLength], uint32(len(value)))
+	encoded = append(encoded, value...)
+	return append(encoded, make([]byte, padded(len(value))-len(value))...), nil
+}
+
+// Decode decodes the item at the start of the bytes, returning it along with the number of bytes it used
+func Decode(encoded []byte) (Item, int, error) {
+	if len(encoded) < headerLength {
+		return Item{}, 0, ErrMalformed
+	}
+
+	item := Item{
+		Tag:  Tag(uint32(encoded[0])<<16 | uint32(encoded[1])<<8 | uint32(encoded[2])),
+		Type: Type(encoded[3]),
+	}
+
+	length := int(binary.BigEndian.Uint32(encoded[4:headerLength]))
+	if length > len(encoded)-headerLength {
+		return Item{}, 0, ErrMalformed
+	}
+	value := encoded[headerLength : headerLength+length]
+
+	switch item.Type {
+	case TypeStructure:
+		items := []Item{}
+		for len(value) > 0 {
+			child, used, err := Decode(value)
+			if err != nil {
+				return Item{}, 0, err
+			}
+			items = append(items, child)
+			value = value[used:]
+		}
+		item.Value = items
+		// Structures are made of padded items, so they are padded themselves
+		return item, headerLength + length, nil
+	case TypeInteger, TypeEnumeration, TypeInterval:
+		if length != 4 {
+			return Item{}, 0, ErrMalformed
+		}
+		if item.Type == TypeInteger {
+			item.Value = int32(binary.BigEndian.Uint32(value))
+		} else {
+			item.Value = binary.BigEndian.Uint32(value)
+		}
+	case TypeLongInteger, TypeDateTime, TypeBoolean:
+		if length != 8 {
+			return Item{}, 0, ErrMalformed
+		}
+		switch item.Type {
+		case TypeLongInteger:
+			item.Value = int64(binary.BigEndian.Uint64(value))
+		case TypeDateTime:
+			item.Value = time.Unix(int64(binary.BigEndian.Uint64(value)), 0).UTC()
+		default:
+			item.Value = binary.BigEndian.Uint64(value) != 0
+		}
+	case TypeTextString:
+		item.Value = string(value)
+	case TypeByteString, TypeBigInteger:
+		item.Value = append([]byte(nil), value...)
+	default:
+		return Item{}, 0, ErrMalformed
+	}
+
+	used := headerLength + padded(length)
+	if used > len(encoded) {
+		return Item{}, 0, ErrMalformed
+	}
+	return item, used, nil
+}
+
+// ReadMessage reads a single item, such as a request or response message, from the reader
+func ReadMessage(r io.Reader) (Item, error) {
+	header := make([]byte, headerLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return Item{}, err
+	}
+
+	if Type(header[3]) != TypeStructure {
+		return Item{}, ErrMalformed
+	}
+
+	length := binary.BigEndian.Uint32(header[4:headerLength])
+	if length > MaxMessageLength {
+		return Item{}, ErrMalformed
+	}
+
+	message := make([]byte, headerLength+int(length))
+	copy(message, header)
+	if _, err := io.ReadFull(r, message[headerLength:]); err != nil {
+		return Item{}, err
+	}
+
+	item, _, err := Decode(message)
+	return item, err
+}
+
+// WriteMessage writes the encoding of a single item to the writer
+func WriteMessage(w io.Writer, item Item) error {
+	encoded, err := Encode(item)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(encoded)
+	return err
+}
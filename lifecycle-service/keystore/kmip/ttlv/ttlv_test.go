@@ -0,0 +1,83 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package ttlv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncode(t *testing.T) {
+	// Examples from the KMIP 1.4 specification, section 9.1.2
+	examples := map[string]Item{
+		"42002002000000040000000800000000":                 Integer(0x420020, 8),
+		"4200200500000004000000FF00000000":                 Enumeration(0x420020, 255),
+		"42002006000000080000000000000001":                 Boolean(0x420020, true),
+		"420020070000000B48656C6C6F20576F726C640000000000": TextString(0x420020, "Hello World"),
+		"42002008000000030102030000000000":                 ByteString(0x420020, []byte{1, 2, 3}),
+		"42002009000000080000000047DA67F8":                 DateTime(0x420020, time.Unix(0x47DA67F8, 0)),
+		"42002001000000204200040500000004000000FE000000004200050200000004000000FF00000000": Structure(0x420020,
+			Enumeration(0x420004, 254), Integer(0x420005, 255)),
+	}
+
+	for expected, item := range examples {
+		encoded, err := Encode(item)
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		if !bytes.Equal(encoded, mustDecodeHex(expected)) {
+			t.Errorf("Expected %s, received %X", expected, encoded)
+		}
+
+		decoded, used, err := Decode(encoded)
+		if err != nil || used != len(encoded) || !reflect.DeepEqual(decoded, item) {
+			t.Errorf("Expected %+v, received %+v, %d, %+v", item, decoded, used, err)
+		}
+	}
+}
+
+func mustDecodeHex(s string) []byte {
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return decoded
+}
+
+func TestMessages(t *testing.T) {
+	message := Structure(TagRequestMessage,
+		Structure(TagBatchItem,
+			Enumeration(TagOperation, OperationLocate),
+			Structure(TagRequestPayload, NameAttribute("test-name"))))
+
+	buffer := new(bytes.Buffer)
+	if err := WriteMessage(buffer, message); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	read, err := ReadMessage(buffer)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	batchItem, _ := read.Find(TagBatchItem)
+	payload, _ := batchItem.Find(TagRequestPayload)
+	names := payload.Attributes(AttributeName)
+	if batchItem.Enum(TagOperation) != OperationLocate || len(names) != 1 || names[0].Text(TagNameValue) != "test-name" {
+		t.Errorf("Expected a Locate of test-name, received %+v", read)
+	}
+
+	// Bad Path: truncated and mistyped messages
+	encoded, _ := Encode(message)
+	if _, _, err := Decode(encoded[:len(encoded)-8]); err != ErrMalformed {
+		t.Errorf("Expected %s, received %+v", ErrMalformed, err)
+	}
+
+	encoded, _ = Encode(Integer(TagBatchCount, 1))
+	if _, err := ReadMessage(bytes.NewReader(encoded)); err != ErrMalformed {
+		t.Errorf("Expected %s, received %+v", ErrMalformed, err)
+	}
+}
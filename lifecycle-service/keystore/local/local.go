@@ -0,0 +1,497 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package local
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/algorithms"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-config"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+const (
+	// MasterKeyEnv holds the base64 encoded master key, and takes precedence over the master key file
+	MasterKeyEnv = "KP_LOCAL_MASTER_KEY"
+
+	// MasterKeyLength is the length in bytes of the AES-256 master key
+	MasterKeyLength = 32
+)
+
+var (
+	// ErrNotFound notifies callers when the secret cannot be found
+	ErrNotFound = errors.New(http.StatusText(http.StatusNotFound) + ": Unable to find secret")
+
+	// ErrMasterKey notifies callers when the master key is missing or is not a base64 encoded 256 bit key
+	ErrMasterKey = errors.New(http.StatusText(http.StatusInternalServerError) + ": Unable to load the master key of the local keystore")
+
+	// ErrUnsealFailed notifies callers when stored key material cannot be decrypted with the master key
+	ErrUnsealFailed = errors.New(http.StatusText(http.StatusInternalServerError) + ": Unable to decrypt the key material of the local keystore")
+
+	// storeLock serializes access to the files of the store across the keystores of every request
+	storeLock sync.RWMutex
+
+	masterKeyOnce sync.Once
+	masterKey     []byte
+	masterKeyErr  error
+)
+
+// record is the file stored for each key. Versions hold the sealed payload of every version,
+// starting with the payload the key was created with.
+type record struct {
+	Org      string   `json:"org"`
+	Versions []string `json:"versions"`
+}
+
+type keystore struct {
+	logger  log.Logger
+	headers *communications.Headers
+	path    string
+	aead    cipher.AEAD
+}
+
+// loadMasterKey reads the master key from the environment, or else from the file set in the configuration
+func loadMasterKey() ([]byte, error) {
+	encoded, ok := os.LookupEnv(MasterKeyEnv)
+	if !ok {
+		file := configuration.Get().GetString("local.masterKeyFile")
+		if file == "" {
+			return nil, ErrMasterKey
+		}
+
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, ErrMasterKey
+		}
+		encoded = string(contents)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != MasterKeyLength {
+		return nil, ErrMasterKey
+	}
+	return key, nil
+}
+
+// recordPath returns the file of the key within the directory of its space. The space is hex encoded and
+// the ID must be a UUID, so neither can escape the store.
+func (s *keystore) recordPath(space, keyprotectID string) (string, error) {
+	if _, err := uuid.FromString(keyprotectID); err != nil {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.path, hex.EncodeToString([]byte(space)), keyprotectID+".json"), nil
+}
+
+// readRecord loads the record of the key, which is only found by the org that created it
+func (s *keystore) readRecord(space, org, keyprotectID string) (*record, error) {
+	path, err := s.recordPath(space, keyprotectID)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rec := new(record)
+	if err := json.Unmarshal(contents, rec); err != nil {
+		return nil, err
+	}
+
+	if rec.Org != org {
+		return nil, ErrNotFound
+	}
+	return rec, nil
+}
+
+// writeRecord replaces the file of the key through a rename, so a failed write never leaves a partial record
+func (s *keystore) writeRecord(space, keyprotectID string, rec *record) error {
+	path, err := s.recordPath(space, keyprotectID)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	contents, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), keyprotectID)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// additionalData binds the sealed payload to its key and version, so payloads cannot be swapped between files
+func additionalData(space, keyprotectID string, version int) []byte {
+	return []byte(space + "/" + keyprotectID + "/" + strconv.Itoa(version))
+}
+
+// seal encrypts the payload of a version with the master key, prefixing the ciphertext with its nonce
+func (s *keystore) seal(space, keyprotectID string, version int, payload string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := s.aead.Seal(nonce, nonce, []byte(payload), additionalData(space, keyprotectID, version))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// unseal decrypts the payload of a version sealed with the master key
+func (s *keystore) unseal(space, keyprotectID string, version int, sealed string) (string, error) {
+	contents, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(contents) < s.aead.NonceSize() {
+		return "", ErrUnsealFailed
+	}
+
+	nonceSize := s.aead.NonceSize()
+	payload, err := s.aead.Open(nil, contents[:nonceSize], contents[nonceSize:], additionalData(space, keyprotectID, version))
+	if err != nil {
+		return "", ErrUnsealFailed
+	}
+	return string(payload), nil
+}
+
+// GetPayload : Get the payload the secret with the given keyprotect ID was created with
+func (s *keystore) GetPayload(ctx context.Context, keyprotectID string) (string, secrets.KeyStates, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return "", secrets.Destroyed, extractErr
+	}
+
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+
+	rec, err := s.readRecord(space, org, keyprotectID)
+	if err != nil {
+		return "", secrets.Destroyed, err
+	}
+
+	payload, err := s.unseal(space, keyprotectID, 1, rec.Versions[0])
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return "", secrets.Destroyed, err
+	}
+
+	return payload, secrets.Activation, nil
+}
+
+// GetPayloadVersion : Get the payload of a version of the secret with the given keyprotect ID
+func (s *keystore) GetPayloadVersion(ctx context.Context, keyprotectID string, version int) (string, int, secrets.KeyStates, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return "", version, secrets.Destroyed, extractErr
+	}
+
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+
+	rec, err := s.readRecord(space, org, keyprotectID)
+	if err != nil {
+		return "", version, secrets.Destroyed, err
+	}
+
+	if version == definitions.CurrentVersion {
+		version = len(rec.Versions)
+	}
+
+	if version < 1 || version > len(rec.Versions) {
+		return "", version, secrets.Destroyed, definitions.ErrVersionNotFound
+	}
+
+	payload, err := s.unseal(space, keyprotectID, version, rec.Versions[version-1])
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return "", version, secrets.Destroyed, err
+	}
+
+	return payload, version, secrets.Activation, nil
+}
+
+// CreateSecret seals the payload of the secret, or key material generated for its algorithm, into the store
+func (s *keystore) CreateSecret(ctx context.Context, secret *secrets.Secret, createTx *transactions.Transaction) (string, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return "", extractErr
+	}
+
+	if len(secret.Payload) == 0 {
+		spec, err := algorithms.ResolveSecret(secret)
+		if err != nil {
+			return "", err
+		}
+
+		payload, err := spec.Generate()
+		if err != nil {
+			return "", err
+		}
+		secret.SetPayload(payload)
+	}
+
+	id := uuid.NewV4().String()
+	if err := s.createRecord(space, org, id, secret.Payload, createTx); err != nil {
+		return "", err
+	}
+
+	secret.SetState(secrets.Activation)
+	return id, nil
+}
+
+// createRecord seals the payload as the first version of a new key
+func (s *keystore) createRecord(space, org, keyprotectID, payload string, createTx *transactions.Transaction) error {
+	sealed, err := s.seal(space, keyprotectID, 1, payload)
+	if err != nil {
+		return err
+	}
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	path, err := s.recordPath(space, keyprotectID)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return definitions.ErrVersionConflict
+	}
+
+	if err := s.writeRecord(space, keyprotectID, &record{Org: org, Versions: []string{sealed}}); err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return err
+	}
+
+	rbDeleteID := transactions.NewKeyIDRollback(deleteID, keyprotectID, space, org, s)
+	createTx.Add(rbDeleteID)
+	return nil
+}
+
+// RotateSecret seals newly generated material for the algorithm of the secret as its next version
+func (s *keystore) RotateSecret(ctx context.Context, keyprotectID string, secret *secrets.Secret, rotateTx *transactions.Transaction) (int, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return 0, extractErr
+	}
+
+	spec, err := algorithms.Resolve(secret.AlgorithmType, secret.AlgorithmMetadata)
+	if err != nil {
+		return 0, err
+	}
+
+	payload, err := spec.Generate()
+	if err != nil {
+		return 0, err
+	}
+
+	return s.appendVersion(space, org, keyprotectID, definitions.CurrentVersion, payload, rotateTx)
+}
+
+// appendVersion seals the payload as the next version of the key. A version other than CurrentVersion must be that next version.
+func (s *keystore) appendVersion(space, org, keyprotectID string, version int, payload string, tx *transactions.Transaction) (int, error) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	rec, err := s.readRecord(space, org, keyprotectID)
+	if err != nil {
+		return 0, err
+	}
+
+	next := len(rec.Versions) + 1
+	if version != definitions.CurrentVersion && version != next {
+		return 0, definitions.ErrVersionConflict
+	}
+
+	sealed, err := s.seal(space, keyprotectID, next, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	rec.Versions = append(rec.Versions, sealed)
+	if err := s.writeRecord(space, keyprotectID, rec); err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return 0, err
+	}
+
+	rbDeleteVersion := transactions.NewKeyIDRollback(func(keyprotectID string, space string, org string, i interface{}) error {
+		return deleteVersion(keyprotectID, space, org, next, s)
+	}, keyprotectID, space, org, s)
+	tx.Add(rbDeleteVersion)
+
+	return next, nil
+}
+
+// ImportVersion seals the payload of the secret as a version of the key with the given keyprotect ID
+func (s *keystore) ImportVersion(ctx context.Context, keyprotectID string, version int, secret *secrets.Secret, importTx *transactions.Transaction) error {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return extractErr
+	}
+
+	if len(secret.Payload) == 0 {
+		return definitions.ErrNoPayload
+	}
+
+	if version == 1 {
+		return s.createRecord(space, org, keyprotectID, secret.Payload, importTx)
+	}
+
+	if version < 1 {
+		return definitions.ErrVersionConflict
+	}
+
+	_, err := s.appendVersion(space, org, keyprotectID, version, secret.Payload, importTx)
+	return err
+}
+
+// deleteID takes an interface so that we can perform rollbacks using this function
+func deleteID(keyprotectID string, space string, org string, i interface{}) error {
+	s, ok := i.(*keystore)
+	if !ok {
+		return errors.New("Requires type *keystore")
+	}
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	return s.removeRecord(space, org, keyprotectID)
+}
+
+// deleteVersion removes a rotated version, provided it is still the latest version of the key
+func deleteVersion(keyprotectID string, space string, org string, version int, s *keystore) error {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	rec, err := s.readRecord(space, org, keyprotectID)
+	if err != nil {
+		return err
+	}
+
+	if len(rec.Versions) != version {
+		return definitions.ErrVersionNotFound
+	}
+
+	rec.Versions = rec.Versions[:version-1]
+	return s.writeRecord(space, keyprotectID, rec)
+}
+
+// removeRecord deletes the file of the key, along with every version it holds
+func (s *keystore) removeRecord(space, org, keyprotectID string) error {
+	if _, err := s.readRecord(space, org, keyprotectID); err != nil {
+		return err
+	}
+
+	path, err := s.recordPath(space, keyprotectID)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// DeleteSecret Given a keyprotect ID. Delete the user's secret.
+func (s *keystore) DeleteSecret(ctx context.Context, keyprotectID string, deleteTx *transactions.Transaction) error {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return extractErr
+	}
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	if err := s.removeRecord(space, org, keyprotectID); err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return err
+	}
+	return nil
+}
+
+// CheckSecret: Given a keyprotect id, is the secret active? Local keys are active as soon as they are stored.
+func (s *keystore) CheckSecret(ctx context.Context, keyprotectID string) (secrets.KeyStates, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return secrets.Destroyed, extractErr
+	}
+
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+
+	if _, err := s.readRecord(space, org, keyprotectID); err != nil {
+		return secrets.Destroyed, err
+	}
+	return secrets.Activation, nil
+}
+
+// newKeystore returns a keystore storing its keys under path, sealed with the master key
+func newKeystore(path string, key []byte, auth *communications.Headers, logger log.Logger) (definitions.Keystore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrMasterKey
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	s := new(keystore)
+	s.logger = logger
+	s.headers = auth
+	s.path = path
+	s.aead = aead
+	return s, nil
+}
+
+// NewLocalKeystore will return a Keystore that keeps key material in files under local.path, every payload
+// AES-GCM encrypted under the master key. The master key is loaded once, from KP_LOCAL_MASTER_KEY or local.masterKeyFile.
+func NewLocalKeystore(auth *communications.Headers, logger log.Logger) (definitions.Keystore, error) {
+	masterKeyOnce.Do(func() {
+		masterKey, masterKeyErr = loadMasterKey()
+	})
+	if masterKeyErr != nil {
+		return nil, masterKeyErr
+	}
+
+	return newKeystore(configuration.Get().GetString("local.path"), masterKey, auth, logger)
+}
@@ -0,0 +1,308 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package local
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+var testHeaders = &communications.Headers{
+	BluemixSpace:  "test-space",
+	BluemixOrg:    "test-org",
+	CorrelationID: "test-correlation-id",
+}
+
+// newTestKeystore returns a keystore in a new temporary directory, which the caller removes
+func newTestKeystore(t *testing.T, auth *communications.Headers) (definitions.Keystore, string) {
+	path, err := ioutil.TempDir("", "local-keystore")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	s, err := newKeystore(path, bytes.Repeat([]byte{0x42}, MasterKeyLength), auth, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return s, path
+}
+
+func TestCreateGetRotateDelete(t *testing.T) {
+	s, path := newTestKeystore(t, testHeaders)
+	defer os.RemoveAll(path)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	secret := secrets.NewSecret()
+	secret.Payload = "dGVzdC1wYXlsb2Fk"
+	id, err := s.CreateSecret(context.Background(), secret, &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	payload, state, err := s.GetPayload(context.Background(), id)
+	if err != nil || payload != secret.Payload || state != secrets.Activation {
+		t.Errorf("Expected %s, received %s, %d, %+v", secret.Payload, payload, state, err)
+	}
+
+	// The payload is only stored sealed
+	files, _ := filepath.Glob(filepath.Join(path, "*", id+".json"))
+	if len(files) != 1 {
+		t.Fatalf("Expected a single record, received %v", files)
+	}
+	contents, _ := ioutil.ReadFile(files[0])
+	if bytes.Contains(contents, []byte(secret.Payload)) {
+		t.Error("Expected the payload to be encrypted")
+	}
+
+	version, err := s.RotateSecret(context.Background(), id, secrets.NewSecret(), &tx)
+	if err != nil || version != 2 {
+		t.Fatalf("Expected version 2, received %d, %+v", version, err)
+	}
+
+	rotated, version, _, err := s.GetPayloadVersion(context.Background(), id, definitions.CurrentVersion)
+	if err != nil || version != 2 || rotated == secret.Payload {
+		t.Errorf("Expected new material as version 2, received %s, %d, %+v", rotated, version, err)
+	}
+
+	if material, _ := base64.StdEncoding.DecodeString(rotated); len(material) != 32 {
+		t.Errorf("Expected 256 bits of material, received %d bytes", len(material))
+	}
+
+	if original, _, _, err := s.GetPayloadVersion(context.Background(), id, 1); err != nil || original != secret.Payload {
+		t.Errorf("Expected %s, received %s, %+v", secret.Payload, original, err)
+	}
+
+	if _, _, _, err := s.GetPayloadVersion(context.Background(), id, 3); err != definitions.ErrVersionNotFound {
+		t.Errorf("Expected %s, received %+v", definitions.ErrVersionNotFound, err)
+	}
+
+	if err := s.DeleteSecret(context.Background(), id, &tx); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := s.CheckSecret(context.Background(), id); err != ErrNotFound {
+		t.Errorf("Expected %s, received %+v", ErrNotFound, err)
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	s, path := newTestKeystore(t, testHeaders)
+	defer os.RemoveAll(path)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	secret := secrets.NewSecret()
+	secret.AlgorithmType = "hmac-sha384"
+	id, err := s.CreateSecret(context.Background(), secret, &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if secret.AlgorithmType != "HMAC-SHA384" || secret.AlgorithmMetadata["bitLength"] != "384" {
+		t.Errorf("Expected the resolved algorithm in the metadata, received %s %v", secret.AlgorithmType, secret.AlgorithmMetadata)
+	}
+
+	payload, _, err := s.GetPayload(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if material, _ := base64.StdEncoding.DecodeString(payload); len(material) != 48 {
+		t.Errorf("Expected 384 bits of material, received %d bytes", len(material))
+	}
+
+	// Bad Path: unsupported algorithm
+	secret = secrets.NewSecret()
+	secret.AlgorithmType = "DES"
+	if _, err := s.CreateSecret(context.Background(), secret, &tx); err == nil {
+		t.Error("Expected Error")
+	}
+}
+
+func TestCreateRollback(t *testing.T) {
+	s, path := newTestKeystore(t, testHeaders)
+	defer os.RemoveAll(path)
+
+	tx := transactions.NewTransaction()
+	id, err := s.CreateSecret(context.Background(), secrets.NewSecret(), &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if err := tx.Clean(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := s.CheckSecret(context.Background(), id); err != ErrNotFound {
+		t.Errorf("Expected %s, received %+v", ErrNotFound, err)
+	}
+}
+
+func TestImportVersion(t *testing.T) {
+	s, path := newTestKeystore(t, testHeaders)
+	defer os.RemoveAll(path)
+	importer := s.(definitions.KeyImporter)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	id := uuid.NewV4().String()
+	for version, payload := range []string{"dmVyc2lvbi0x", "dmVyc2lvbi0y"} {
+		secret := secrets.NewSecret()
+		secret.Payload = payload
+		if err := importer.ImportVersion(context.Background(), id, version+1, secret, &tx); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	}
+
+	if payload, version, _, err := s.GetPayloadVersion(context.Background(), id, definitions.CurrentVersion); err != nil || version != 2 || payload != "dmVyc2lvbi0y" {
+		t.Errorf("Expected the second version under the imported ID, received %s, %d, %+v", payload, version, err)
+	}
+
+	// Bad Path: versions must follow the latest one, and carry material
+	secret := secrets.NewSecret()
+	secret.Payload = "dmVyc2lvbi0x"
+	for _, version := range []int{1, 2, 4} {
+		if err := importer.ImportVersion(context.Background(), id, version, secret, &tx); err != definitions.ErrVersionConflict {
+			t.Errorf("Expected %s for version %d, received %+v", definitions.ErrVersionConflict, version, err)
+		}
+	}
+	if err := importer.ImportVersion(context.Background(), id, 3, secrets.NewSecret(), &tx); err != definitions.ErrNoPayload {
+		t.Errorf("Expected %s, received %+v", definitions.ErrNoPayload, err)
+	}
+
+	// Rolling back removes the imported versions
+	rollbackTx := transactions.NewTransaction()
+	if err := importer.ImportVersion(context.Background(), id, 3, secret, &rollbackTx); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := rollbackTx.Clean(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, version, _, err := s.GetPayloadVersion(context.Background(), id, definitions.CurrentVersion); err != nil || version != 2 {
+		t.Errorf("Expected version 2 after the rollback, received %d, %+v", version, err)
+	}
+}
+
+func TestIsolation(t *testing.T) {
+	s, path := newTestKeystore(t, testHeaders)
+	defer os.RemoveAll(path)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	id, err := s.CreateSecret(context.Background(), secrets.NewSecret(), &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	others := []*communications.Headers{
+		{BluemixSpace: "other-space", BluemixOrg: "test-org"},
+		{BluemixSpace: "test-space", BluemixOrg: "other-org"},
+	}
+	for _, headers := range others {
+		other, err := newKeystore(path, bytes.Repeat([]byte{0x42}, MasterKeyLength), headers, log.NewNopLogger())
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+
+		if _, _, err := other.GetPayload(context.Background(), id); err != ErrNotFound {
+			t.Errorf("Expected %s, received %+v", ErrNotFound, err)
+		}
+	}
+
+	// Bad Path: IDs that are not UUIDs never reach the filesystem
+	if _, _, err := s.GetPayload(context.Background(), "../../etc/passwd"); err != ErrNotFound {
+		t.Errorf("Expected %s, received %+v", ErrNotFound, err)
+	}
+
+	// Bad Path: another master key cannot unseal the payload
+	wrongKey, err := newKeystore(path, bytes.Repeat([]byte{0x24}, MasterKeyLength), testHeaders, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, _, err := wrongKey.GetPayload(context.Background(), id); err != ErrUnsealFailed {
+		t.Errorf("Expected %s, received %+v", ErrUnsealFailed, err)
+	}
+}
+
+func TestLoadMasterKey(t *testing.T) {
+	defer os.Unsetenv(MasterKeyEnv)
+
+	os.Setenv(MasterKeyEnv, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0x42}, MasterKeyLength))+"\n")
+	if key, err := loadMasterKey(); err != nil || len(key) != MasterKeyLength {
+		t.Errorf("Expected a %d byte key, received %d, %+v", MasterKeyLength, len(key), err)
+	}
+
+	// Bad Path: a 128 bit key
+	os.Setenv(MasterKeyEnv, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0x42}, 16)))
+	if _, err := loadMasterKey(); err != ErrMasterKey {
+		t.Errorf("Expected %s, received %+v", ErrMasterKey, err)
+	}
+
+	// Bad Path: not base64
+	os.Setenv(MasterKeyEnv, "not base64!")
+	if _, err := loadMasterKey(); err != ErrMasterKey {
+		t.Errorf("Expected %s, received %+v", ErrMasterKey, err)
+	}
+}
+
+func TestPolicyDB(t *testing.T) {
+	path, err := ioutil.TempDir("", "local-policies")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(path)
+
+	now := time.Now()
+	policy := (&db.RotationPolicy{Space: "test-space", Org: "test-org", KpID: "test-id", IntervalDays: 30}).Schedule(now.AddDate(0, 0, -31))
+	if err := newPolicyDB(path).SetPolicy(policy); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// A new instance reads the policies back from the file
+	policies := newPolicyDB(path)
+	stored, err := policies.GetPolicy("test-space", "test-org", "test-id")
+	if err != nil || stored.IntervalDays != 30 {
+		t.Errorf("Expected the stored policy, received %+v, %+v", stored, err)
+	}
+
+	if due, err := policies.ListDuePolicies(now); err != nil || len(due) != 1 {
+		t.Errorf("Expected one due policy, received %v, %+v", due, err)
+	}
+
+	if err := policies.SetDeletionPolicy(&db.DeletionPolicy{Space: "test-space", Org: "test-org", KpID: "test-id"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := policies.GetDeletionPolicy("test-space", "other-org", "test-id"); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+
+	if err := policies.DeletePolicy("test-space", "test-org", "test-id"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := policies.GetPolicy("test-space", "test-org", "test-id"); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+}
@@ -0,0 +1,199 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package local
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-config"
+)
+
+// policiesFile is the file, beside the keys of the store, holding the policies of every key
+const policiesFile = "policies.json"
+
+var policyLock sync.RWMutex
+
+// policies is the contents of the policies file, keyed by the space and ID of each key
+type policies struct {
+	Rotation map[string]db.RotationPolicy `json:"rotation"`
+	Deletion map[string]db.DeletionPolicy `json:"deletion"`
+}
+
+type filePolicyDB struct {
+	path string
+}
+
+// NewLocalPolicyDB returns a PolicyDB that keeps rotation and deletion policies in a file under local.path,
+// so the policies of the local keystore outlive the process just as its keys do
+func NewLocalPolicyDB() db.PolicyDB {
+	return newPolicyDB(configuration.Get().GetString("local.path"))
+}
+
+func newPolicyDB(path string) db.PolicyDB {
+	return &filePolicyDB{path: path}
+}
+
+func policyKey(space string, kpID string) string {
+	return space + "/" + kpID
+}
+
+func (p *filePolicyDB) load() (*policies, error) {
+	stored := &policies{
+		Rotation: make(map[string]db.RotationPolicy),
+		Deletion: make(map[string]db.DeletionPolicy),
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(p.path, policiesFile))
+	if os.IsNotExist(err) {
+		return stored, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(contents, stored); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// save replaces the policies file through a rename, so a failed write never leaves a partial file
+func (p *filePolicyDB) save(stored *policies) error {
+	if err := os.MkdirAll(p.path, 0700); err != nil {
+		return err
+	}
+
+	contents, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(p.path, policiesFile)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(p.path, policiesFile))
+}
+
+// update loads the policies, applies the change and saves them, holding the lock throughout
+func (p *filePolicyDB) update(change func(stored *policies)) error {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	stored, err := p.load()
+	if err != nil {
+		return err
+	}
+
+	change(stored)
+	return p.save(stored)
+}
+
+func (p *filePolicyDB) SetPolicy(policy *db.RotationPolicy) error {
+	return p.update(func(stored *policies) {
+		stored.Rotation[policyKey(policy.Space, policy.KpID)] = *policy
+	})
+}
+
+func (p *filePolicyDB) GetPolicy(space string, org string, kpID string) (*db.RotationPolicy, error) {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+
+	stored, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+
+	policy, ok := stored.Rotation[policyKey(space, kpID)]
+	if !ok || policy.Org != org {
+		return nil, db.ErrNotFound
+	}
+	return &policy, nil
+}
+
+func (p *filePolicyDB) DeletePolicy(space string, org string, kpID string) error {
+	return p.update(func(stored *policies) {
+		delete(stored.Rotation, policyKey(space, kpID))
+	})
+}
+
+func (p *filePolicyDB) ListDuePolicies(now time.Time) ([]*db.RotationPolicy, error) {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+
+	stored, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+
+	due := []*db.RotationPolicy{}
+	for _, policy := range stored.Rotation {
+		if !policy.NextRotation.After(now) {
+			policy := policy
+			due = append(due, &policy)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextRotation.Before(due[j].NextRotation)
+	})
+	return due, nil
+}
+
+func (p *filePolicyDB) ClaimPolicy(policy *db.RotationPolicy, until time.Time) (bool, error) {
+	claimed := false
+	err := p.update(func(stored *policies) {
+		key := policyKey(policy.Space, policy.KpID)
+		if current, ok := stored.Rotation[key]; ok && current.NextRotation.Equal(policy.NextRotation) {
+			current.NextRotation = until
+			stored.Rotation[key] = current
+			claimed = true
+		}
+	})
+	return claimed, err
+}
+
+func (p *filePolicyDB) SetDeletionPolicy(policy *db.DeletionPolicy) error {
+	return p.update(func(stored *policies) {
+		stored.Deletion[policyKey(policy.Space, policy.KpID)] = *policy
+	})
+}
+
+func (p *filePolicyDB) GetDeletionPolicy(space string, org string, kpID string) (*db.DeletionPolicy, error) {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+
+	stored, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+
+	policy, ok := stored.Deletion[policyKey(space, kpID)]
+	if !ok || policy.Org != org {
+		return nil, db.ErrNotFound
+	}
+	return &policy, nil
+}
+
+func (p *filePolicyDB) DeleteDeletionPolicy(space string, org string, kpID string) error {
+	return p.update(func(stored *policies) {
+		delete(stored.Deletion, policyKey(space, kpID))
+	})
+}
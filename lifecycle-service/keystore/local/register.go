@@ -0,0 +1,16 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package local
+
+import (
+	registry "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+)
+
+func init() {
+	registry.Register(registry.Local, registry.Backend{
+		NewKeystore: NewLocalKeystore,
+		NewPolicyDB: func() (db.PolicyDB, error) { return NewLocalPolicyDB(), nil },
+		PolicyStore: registry.LocalPolicyStore,
+	})
+}
@@ -0,0 +1,305 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package mirror
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/algorithms"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	httperrors "github.ibm.com/Alchemy-Key-Protect/kp-go-models/errors"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+// ErrNotImporter notifies callers when the secondary keystore cannot hold keys under the IDs of the primary
+var ErrNotImporter = errors.New(http.StatusText(http.StatusInternalServerError) + ": Secondary keystore cannot import keys")
+
+// ErrAsyncPrimary notifies callers when the primary keystore generates asynchronously, so the keys it generates
+// cannot be copied when they are created or rotated, and the mirror can neither generate them nor retry the copies
+var ErrAsyncPrimary = errors.New(http.StatusText(http.StatusInternalServerError) + ": Primary keystore generates keys asynchronously, so mirror.generate must be " + GenerateService + " or both keystores must authenticate as the service")
+
+// keystore keeps every key in a primary and a secondary keystore under the ID given by the primary, so tenants
+// can be moved from one backend to another without downtime. Reads are served by the primary, falling back to
+// the secondary while the primary is unavailable.
+type keystore struct {
+	primary   definitions.Keystore
+	secondary definitions.Keystore
+	importer  definitions.KeyImporter
+	logger    log.Logger
+	headers   *communications.Headers
+
+	// generate is set when key material is generated by the service and imported into both keystores, rather
+	// than generated by the primary and copied to the secondary
+	generate bool
+
+	// retrier copies the versions of rotated keys the primary is still generating, and is nil for mirrors that
+	// cannot be opened with the credentials of the service
+	retrier *syncRetrier
+}
+
+// isNotFound reports whether the error of a keystore is a Not Found
+func isNotFound(err error) bool {
+	return strings.HasPrefix(err.Error(), http.StatusText(http.StatusNotFound))
+}
+
+// isUnavailable reports whether the error of the primary means it could not serve the request, rather than that the
+// request failed, so the secondary should serve it instead. Requests that were canceled or timed out are not retried.
+func isUnavailable(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && httperrors.ConvertError(err).StatusCode >= http.StatusInternalServerError
+}
+
+func (s *keystore) correlationID() string {
+	if s.headers == nil {
+		return ""
+	}
+	return s.headers.CorrelationID
+}
+
+// GetPayload : Get the payload the secret with the given keyprotect ID was created with
+func (s *keystore) GetPayload(ctx context.Context, keyprotectID string) (string, secrets.KeyStates, error) {
+	payload, _, state, err := s.GetPayloadVersion(ctx, keyprotectID, 1)
+	return payload, state, err
+}
+
+// GetPayloadVersion returns the payload of a version of the secret from the primary, or from the secondary while
+// the primary is unavailable
+func (s *keystore) GetPayloadVersion(ctx context.Context, keyprotectID string, version int) (string, int, secrets.KeyStates, error) {
+	payload, found, state, err := s.primary.GetPayloadVersion(ctx, keyprotectID, version)
+	if err == nil {
+		return payload, found, state, nil
+	}
+	s.logger.Log("err", err.Error(), "correlation_id", s.correlationID())
+	if !isUnavailable(ctx, err) {
+		return "", version, secrets.Destroyed, err
+	}
+
+	payload, found, state, secondaryErr := s.secondary.GetPayloadVersion(ctx, keyprotectID, version)
+	if secondaryErr != nil {
+		return "", version, secrets.Destroyed, err
+	}
+	return payload, found, state, nil
+}
+
+// CreateSecret creates the secret in the primary, then copies it to the secondary under the same ID. When the
+// mirror generates, material for generated keys is generated here and imported into both keystores, so they hold
+// the same key even if the primary generates asynchronously. Otherwise the primary generates the key, and copies
+// that fail are retried like those of rotations. The rollbacks of both keystores are added to the transaction, so a
+// partial create is undone.
+func (s *keystore) CreateSecret(ctx context.Context, secret *secrets.Secret, createTx *transactions.Transaction) (string, error) {
+	generated := len(secret.Payload) == 0
+	if generated && s.generate {
+		spec, err := algorithms.ResolveSecret(secret)
+		if err != nil {
+			return "", err
+		}
+
+		payload, err := spec.Generate()
+		if err != nil {
+			return "", err
+		}
+		secret.SetPayload(payload)
+		generated = false
+	}
+
+	id, err := s.primary.CreateSecret(ctx, secret, createTx)
+	if err != nil {
+		return "", err
+	}
+
+	if generated {
+		if err := s.syncVersions(ctx, id, 1, createTx); err != nil {
+			return "", s.deferSync(id, 1, err)
+		}
+		return id, nil
+	}
+
+	imported := *secret
+	if err := s.importer.ImportVersion(ctx, id, 1, &imported, createTx); err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.correlationID())
+		return "", err
+	}
+
+	return id, nil
+}
+
+// RotateSecret rotates the secret in the primary, then copies the versions the secondary is missing. When the
+// mirror generates and the primary can store it, the material of the new version is generated here, so it can be
+// copied at once. Otherwise the primary may still be generating the version, so copies that fail are retried in the
+// background until they succeed. Mirrors that cannot retry fail the rotation instead, and the rotation of the primary
+// is rolled back.
+func (s *keystore) RotateSecret(ctx context.Context, keyprotectID string, secret *secrets.Secret, rotateTx *transactions.Transaction) (int, error) {
+	version, err := s.rotatePrimary(ctx, keyprotectID, secret, rotateTx)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.syncVersions(ctx, keyprotectID, version, rotateTx); err != nil {
+		if err := s.deferSync(keyprotectID, version, err); err != nil {
+			return 0, err
+		}
+	}
+	return version, nil
+}
+
+func (s *keystore) rotatePrimary(ctx context.Context, keyprotectID string, secret *secrets.Secret, rotateTx *transactions.Transaction) (int, error) {
+	rotator, ok := s.primary.(definitions.PayloadRotator)
+	if !s.generate || !ok {
+		return s.primary.RotateSecret(ctx, keyprotectID, secret, rotateTx)
+	}
+
+	// Imported keys carry no algorithm, so their new versions are generated with the defaults
+	spec, err := algorithms.Resolve(secret.AlgorithmType, secret.AlgorithmMetadata)
+	if err != nil {
+		return 0, err
+	}
+
+	payload, err := spec.Generate()
+	if err != nil {
+		return 0, err
+	}
+	return rotator.RotateSecretPayload(ctx, keyprotectID, secret, payload, rotateTx)
+}
+
+// deferSync retries the copy of the versions of the key up to latest in the background, or returns the error of the
+// failed copy for mirrors that cannot retry
+func (s *keystore) deferSync(keyprotectID string, latest int, err error) error {
+	s.logger.Log("err", err.Error(), "correlation_id", s.correlationID(), "kp_id", keyprotectID)
+	if s.retrier == nil {
+		return err
+	}
+	s.retrier.track(s.headers, s.logger, keyprotectID, latest)
+	return nil
+}
+
+// checkGeneration returns ErrAsyncPrimary if the keys generated by the primary may have no material when they are
+// created or rotated, while the mirror neither generates them itself nor retries the copies
+func (s *keystore) checkGeneration() error {
+	if s.retrier != nil {
+		return nil
+	}
+	if async, ok := s.primary.(definitions.AsyncGenerator); !ok || !async.GeneratesAsync() {
+		return nil
+	}
+	if _, ok := s.primary.(definitions.PayloadRotator); s.generate && ok {
+		return nil
+	}
+	return ErrAsyncPrimary
+}
+
+// syncVersions imports the versions of the primary, up to the latest, that follow the latest version of the secondary
+func (s *keystore) syncVersions(ctx context.Context, keyprotectID string, latest int, tx *transactions.Transaction) error {
+	_, synced, _, err := s.secondary.GetPayloadVersion(ctx, keyprotectID, definitions.CurrentVersion)
+	if err != nil {
+		if !isNotFound(err) {
+			return err
+		}
+		synced = 0
+	}
+
+	for version := synced + 1; version <= latest; version++ {
+		payload, _, state, err := s.primary.GetPayloadVersion(ctx, keyprotectID, version)
+		if err != nil {
+			return err
+		}
+		if state != secrets.Activation || len(payload) == 0 {
+			return errors.New(http.StatusText(http.StatusConflict) + ": Version " + strconv.Itoa(version) + " is not active in the primary keystore")
+		}
+
+		imported := secrets.NewSecret()
+		imported.Payload = payload
+		if err := s.importer.ImportVersion(ctx, keyprotectID, version, imported, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteSecret deletes the secret from the secondary, where it may never have been copied, and then from the primary
+func (s *keystore) DeleteSecret(ctx context.Context, keyprotectID string, deleteTx *transactions.Transaction) error {
+	if err := s.secondary.DeleteSecret(ctx, keyprotectID, deleteTx); err != nil && !isNotFound(err) {
+		s.logger.Log("err", err.Error(), "correlation_id", s.correlationID())
+		return err
+	}
+	return s.primary.DeleteSecret(ctx, keyprotectID, deleteTx)
+}
+
+// CheckSecret returns the state of the secret in the primary, or in the secondary while the primary is unavailable
+func (s *keystore) CheckSecret(ctx context.Context, keyprotectID string) (secrets.KeyStates, error) {
+	state, err := s.primary.CheckSecret(ctx, keyprotectID)
+	if err == nil {
+		return state, nil
+	}
+	s.logger.Log("err", err.Error(), "correlation_id", s.correlationID())
+	if !isUnavailable(ctx, err) {
+		return secrets.Destroyed, err
+	}
+
+	state, secondaryErr := s.secondary.CheckSecret(ctx, keyprotectID)
+	if secondaryErr != nil {
+		return secrets.Destroyed, err
+	}
+	return state, nil
+}
+
+// wrappingKeystore is a mirror whose primary wraps keys itself, as it may hold material that cannot be read
+type wrappingKeystore struct {
+	*keystore
+	wrapper definitions.KeyWrapper
+}
+
+// WrapKey wraps the plaintext with the current version of the key in the primary
+func (s *wrappingKeystore) WrapKey(ctx context.Context, keyprotectID string, plaintext, aad []byte) ([]byte, error) {
+	return s.wrapper.WrapKey(ctx, keyprotectID, plaintext, aad)
+}
+
+// UnwrapKey unwraps the ciphertext with the version of the key in the primary it was wrapped with
+func (s *wrappingKeystore) UnwrapKey(ctx context.Context, keyprotectID string, ciphertext, aad []byte) ([]byte, error) {
+	return s.wrapper.UnwrapKey(ctx, keyprotectID, ciphertext, aad)
+}
+
+// withWrapper returns the mirror as a KeyWrapper when its primary is one
+func withWrapper(s *keystore) definitions.Keystore {
+	if wrapper, ok := s.primary.(definitions.KeyWrapper); ok {
+		return &wrappingKeystore{keystore: s, wrapper: wrapper}
+	}
+	return s
+}
+
+// NewMirrorKeystore will return a Keystore that keeps every key in both keystores, the secondary of which must
+// be able to import keys. Key material is generated by the primary unless generate is set, in which case it is
+// generated by the service. Authorization for the request is required to be based in on creation.
+func NewMirrorKeystore(primary, secondary definitions.Keystore, generate bool, auth *communications.Headers, logger log.Logger) (definitions.Keystore, error) {
+	s, err := newMirrorKeystore(primary, secondary, auth, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	s.generate = generate
+	if err := s.checkGeneration(); err != nil {
+		return nil, err
+	}
+	return withWrapper(s), nil
+}
+
+func newMirrorKeystore(primary, secondary definitions.Keystore, auth *communications.Headers, logger log.Logger) (*keystore, error) {
+	importer, ok := secondary.(definitions.KeyImporter)
+	if !ok {
+		return nil, ErrNotImporter
+	}
+
+	return &keystore{
+		primary:   primary,
+		secondary: secondary,
+		importer:  importer,
+		logger:    logger,
+		headers:   auth,
+	}, nil
+}
@@ -0,0 +1,491 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package mirror
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+var (
+	errTestNotFound    = errors.New(http.StatusText(http.StatusNotFound) + ": Unable to find secret")
+	errTestUnavailable = errors.New(http.StatusText(http.StatusServiceUnavailable) + ": Keystore unavailable")
+	errTestForbidden   = errors.New(http.StatusText(http.StatusForbidden) + ": Not authorized")
+)
+
+var testHeaders = &communications.Headers{
+	BluemixSpace:  "test-space",
+	BluemixOrg:    "test-org",
+	CorrelationID: "test-correlation-id",
+}
+
+// testKeystore keeps versions in memory, and fails every call while unavailable. The generating version of every
+// key has no material yet, as when the keystore generates asynchronously. Reads fail with readErr when it is set.
+type testKeystore struct {
+	keys        map[string][]string
+	unavailable bool
+	generating  int
+	readErr     error
+}
+
+func newTestKeystore() *testKeystore {
+	return &testKeystore{keys: make(map[string][]string)}
+}
+
+func (s *testKeystore) remove(id string) error {
+	delete(s.keys, id)
+	return nil
+}
+
+func (s *testKeystore) GetPayload(ctx context.Context, id string) (string, secrets.KeyStates, error) {
+	payload, _, state, err := s.GetPayloadVersion(ctx, id, definitions.CurrentVersion)
+	return payload, state, err
+}
+
+func (s *testKeystore) GetPayloadVersion(ctx context.Context, id string, version int) (string, int, secrets.KeyStates, error) {
+	if s.unavailable {
+		return "", version, secrets.Destroyed, errTestUnavailable
+	}
+	if s.readErr != nil {
+		return "", version, secrets.Destroyed, s.readErr
+	}
+	versions, ok := s.keys[id]
+	if !ok {
+		return "", version, secrets.Destroyed, errTestNotFound
+	}
+	if version == definitions.CurrentVersion {
+		version = len(versions)
+	}
+	if version < 1 || version > len(versions) {
+		return "", version, secrets.Destroyed, errTestNotFound
+	}
+	if version == s.generating {
+		return "", version, secrets.Preactivation, nil
+	}
+	return versions[version-1], version, secrets.Activation, nil
+}
+
+func (s *testKeystore) CreateSecret(ctx context.Context, secret *secrets.Secret, tx *transactions.Transaction) (string, error) {
+	if s.unavailable {
+		return "", errTestUnavailable
+	}
+	id := uuid.NewV4().String()
+	payload := secret.Payload
+	if payload == "" {
+		payload = uuid.NewV4().String()
+	}
+	s.keys[id] = []string{payload}
+	tx.Add(transactions.NewHsmCreateSecretRollback(s.remove, id))
+	secret.SetState(secrets.Activation)
+	return id, nil
+}
+
+func (s *testKeystore) RotateSecret(ctx context.Context, id string, secret *secrets.Secret, tx *transactions.Transaction) (int, error) {
+	if s.unavailable {
+		return 0, errTestUnavailable
+	}
+	if _, ok := s.keys[id]; !ok {
+		return 0, errTestNotFound
+	}
+	s.keys[id] = append(s.keys[id], uuid.NewV4().String())
+	return len(s.keys[id]), nil
+}
+
+func (s *testKeystore) DeleteSecret(ctx context.Context, id string, tx *transactions.Transaction) error {
+	if s.unavailable {
+		return errTestUnavailable
+	}
+	if _, ok := s.keys[id]; !ok {
+		return errTestNotFound
+	}
+	return s.remove(id)
+}
+
+func (s *testKeystore) CheckSecret(ctx context.Context, id string) (secrets.KeyStates, error) {
+	_, _, state, err := s.GetPayloadVersion(ctx, id, definitions.CurrentVersion)
+	return state, err
+}
+
+func (s *testKeystore) ImportVersion(ctx context.Context, id string, version int, secret *secrets.Secret, tx *transactions.Transaction) error {
+	if s.unavailable {
+		return errTestUnavailable
+	}
+	if version != len(s.keys[id])+1 {
+		return errors.New(http.StatusText(http.StatusConflict) + ": Version conflict")
+	}
+	s.keys[id] = append(s.keys[id], secret.Payload)
+	if version == 1 {
+		tx.Add(transactions.NewHsmCreateSecretRollback(s.remove, id))
+	}
+	return nil
+}
+
+// notImporter hides the ImportVersion of a testKeystore
+type notImporter struct {
+	definitions.Keystore
+}
+
+// asyncKeystore is a testKeystore whose rotations are generated asynchronously, and which can be rotated to material
+// generated by the service instead
+type asyncKeystore struct {
+	*testKeystore
+}
+
+func (s asyncKeystore) GeneratesAsync() bool {
+	return true
+}
+
+func (s asyncKeystore) RotateSecret(ctx context.Context, id string, secret *secrets.Secret, tx *transactions.Transaction) (int, error) {
+	version, err := s.testKeystore.RotateSecret(ctx, id, secret, tx)
+	s.generating = version
+	return version, err
+}
+
+func (s asyncKeystore) RotateSecretPayload(ctx context.Context, id string, secret *secrets.Secret, payload string, tx *transactions.Transaction) (int, error) {
+	if _, ok := s.keys[id]; !ok {
+		return 0, errTestNotFound
+	}
+	s.keys[id] = append(s.keys[id], payload)
+	return len(s.keys[id]), nil
+}
+
+// wrapperKeystore is a testKeystore that wraps keys itself
+type wrapperKeystore struct {
+	*testKeystore
+}
+
+func (s wrapperKeystore) WrapKey(ctx context.Context, id string, plaintext, aad []byte) ([]byte, error) {
+	return append([]byte(id+":"), plaintext...), nil
+}
+
+func (s wrapperKeystore) UnwrapKey(ctx context.Context, id string, ciphertext, aad []byte) ([]byte, error) {
+	return ciphertext[len(id)+1:], nil
+}
+
+func newTestMirror(t *testing.T) (definitions.Keystore, *testKeystore, *testKeystore) {
+	primary, secondary := newTestKeystore(), newTestKeystore()
+	s, err := NewMirrorKeystore(primary, secondary, true, testHeaders, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return s, primary, secondary
+}
+
+func TestCreateInBoth(t *testing.T) {
+	s, primary, secondary := newTestMirror(t)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	// Material of generated keys is the same in both keystores
+	secret := secrets.NewSecret()
+	secret.AlgorithmMetadata = map[string]string{"bitLength": "128"}
+	id, err := s.CreateSecret(context.Background(), secret, &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if material, _ := base64.StdEncoding.DecodeString(secret.Payload); len(material) != 16 {
+		t.Errorf("Expected 16 bytes of key material, received %s", secret.Payload)
+	}
+	if primary.keys[id][0] != secret.Payload || secondary.keys[id][0] != secret.Payload {
+		t.Errorf("Expected %s in both keystores, received %v and %v", secret.Payload, primary.keys[id], secondary.keys[id])
+	}
+
+	// Reads fall back to the secondary
+	primary.unavailable = true
+	if payload, state, err := s.GetPayload(context.Background(), id); err != nil || payload != secret.Payload || state != secrets.Activation {
+		t.Errorf("Expected %s from the secondary, received %s, %d, %+v", secret.Payload, payload, state, err)
+	}
+	if state, err := s.CheckSecret(context.Background(), id); err != nil || state != secrets.Activation {
+		t.Errorf("Expected an active key, received %d, %+v", state, err)
+	}
+
+	// Bad Path: both keystores fail, and the error of the primary is returned
+	secondary.unavailable = true
+	if _, _, err := s.GetPayload(context.Background(), id); err != errTestUnavailable {
+		t.Errorf("Expected %s, received %+v", errTestUnavailable, err)
+	}
+}
+
+func TestReadFallback(t *testing.T) {
+	s, primary, _ := newTestMirror(t)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	secret := secrets.NewSecret()
+	secret.Payload = "dGVzdC1wYXlsb2Fk"
+	id, err := s.CreateSecret(context.Background(), secret, &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// Bad Path: requests the primary refuses are not served by the secondary
+	primary.readErr = errTestForbidden
+	if _, _, err := s.GetPayload(context.Background(), id); err != errTestForbidden {
+		t.Errorf("Expected %s, received %+v", errTestForbidden, err)
+	}
+	if _, err := s.CheckSecret(context.Background(), id); err != errTestForbidden {
+		t.Errorf("Expected %s, received %+v", errTestForbidden, err)
+	}
+
+	// Bad Path: nor are requests that were canceled
+	primary.readErr = nil
+	primary.unavailable = true
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := s.GetPayload(ctx, id); err != errTestUnavailable {
+		t.Errorf("Expected %s, received %+v", errTestUnavailable, err)
+	}
+	if _, err := s.CheckSecret(ctx, id); err != errTestUnavailable {
+		t.Errorf("Expected %s, received %+v", errTestUnavailable, err)
+	}
+}
+
+func TestCreateGeneratedByPrimary(t *testing.T) {
+	primary, secondary := newTestKeystore(), newTestKeystore()
+	s, err := NewMirrorKeystore(primary, secondary, false, testHeaders, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	tx := transactions.NewTransaction()
+	id, err := s.CreateSecret(context.Background(), secrets.NewSecret(), &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if len(secondary.keys[id]) != 1 || secondary.keys[id][0] != primary.keys[id][0] {
+		t.Errorf("Expected the material of the primary in the secondary, received %v and %v", primary.keys[id], secondary.keys[id])
+	}
+
+	// Bad Path: a mirror that cannot retry fails the create when the primary is still generating the key
+	primary.generating = 1
+	if _, err := s.CreateSecret(context.Background(), secrets.NewSecret(), &tx); err == nil {
+		t.Fatal("Expected an error, received nil")
+	}
+	if err := tx.Clean(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if len(primary.keys) != 0 || len(secondary.keys) != 0 {
+		t.Errorf("Expected the keys to be removed, received %v and %v", primary.keys, secondary.keys)
+	}
+}
+
+func TestAsyncPrimary(t *testing.T) {
+	primary, secondary := asyncKeystore{newTestKeystore()}, newTestKeystore()
+
+	// Bad Path: the keys of the primary could not be copied without retries
+	if _, err := NewMirrorKeystore(primary, secondary, false, testHeaders, log.NewNopLogger()); err != ErrAsyncPrimary {
+		t.Errorf("Expected %s, received %+v", ErrAsyncPrimary, err)
+	}
+
+	s, err := NewMirrorKeystore(primary, secondary, true, testHeaders, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	secret := secrets.NewSecret()
+	id, err := s.CreateSecret(context.Background(), secret, &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// Rotations are generated by the service, so they are copied at once without a retrier
+	version, err := s.RotateSecret(context.Background(), id, secret, &tx)
+	if err != nil || version != 2 {
+		t.Fatalf("Expected version 2, received %d, %+v", version, err)
+	}
+	if primary.generating != 0 {
+		t.Error("Expected the primary not to generate the version")
+	}
+	if len(secondary.keys[id]) != 2 || secondary.keys[id][1] != primary.keys[id][1] {
+		t.Errorf("Expected both versions in the secondary, received %v and %v", primary.keys[id], secondary.keys[id])
+	}
+	if material, _ := base64.StdEncoding.DecodeString(primary.keys[id][1]); len(material) != 32 {
+		t.Errorf("Expected 32 bytes of key material, received %s", primary.keys[id][1])
+	}
+}
+
+func TestWrapWithPrimary(t *testing.T) {
+	s, err := NewMirrorKeystore(wrapperKeystore{newTestKeystore()}, newTestKeystore(), true, testHeaders, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	wrapper, ok := s.(definitions.KeyWrapper)
+	if !ok {
+		t.Fatal("Expected the mirror of a wrapping primary to wrap keys")
+	}
+	ciphertext, err := wrapper.WrapKey(context.Background(), "test-id", []byte("plaintext"), nil)
+	if err != nil || string(ciphertext) != "test-id:plaintext" {
+		t.Errorf("Expected the primary to wrap, received %s, %+v", ciphertext, err)
+	}
+	plaintext, err := wrapper.UnwrapKey(context.Background(), "test-id", ciphertext, nil)
+	if err != nil || string(plaintext) != "plaintext" {
+		t.Errorf("Expected the primary to unwrap, received %s, %+v", plaintext, err)
+	}
+
+	// Mirrors of primaries that do not wrap keys leave it to the service
+	s, _, _ = newTestMirror(t)
+	if _, ok := s.(definitions.KeyWrapper); ok {
+		t.Error("Expected the mirror not to wrap keys")
+	}
+}
+
+func TestCreateRollback(t *testing.T) {
+	s, primary, secondary := newTestMirror(t)
+	secondary.unavailable = true
+
+	tx := transactions.NewTransaction()
+	secret := secrets.NewSecret()
+	secret.Payload = "dGVzdC1wYXlsb2Fk"
+	if _, err := s.CreateSecret(context.Background(), secret, &tx); err != errTestUnavailable {
+		t.Fatalf("Expected %s, received %+v", errTestUnavailable, err)
+	}
+
+	if err := tx.Clean(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if len(primary.keys) != 0 {
+		t.Errorf("Expected the key to be removed from the primary, received %v", primary.keys)
+	}
+}
+
+func TestRotateSyncsVersions(t *testing.T) {
+	s, primary, secondary := newTestMirror(t)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	// A key created before mirroring began is copied by its next rotation
+	id := uuid.NewV4().String()
+	primary.keys[id] = []string{"version-1"}
+	if version, err := s.RotateSecret(context.Background(), id, secrets.NewSecret(), &tx); err != nil || version != 2 {
+		t.Fatalf("Expected version 2, received %d, %+v", version, err)
+	}
+	if len(secondary.keys[id]) != 2 || secondary.keys[id][1] != primary.keys[id][1] {
+		t.Errorf("Expected both versions in the secondary, received %v", secondary.keys[id])
+	}
+
+	// The payload of a key is the material it was created with
+	if payload, _, err := s.GetPayload(context.Background(), id); err != nil || payload != "version-1" {
+		t.Errorf("Expected %s, received %s, %+v", "version-1", payload, err)
+	}
+
+	// Bad Path: a mirror that cannot retry fails the rotation when the copy fails
+	secondary.unavailable = true
+	if _, err := s.RotateSecret(context.Background(), id, secrets.NewSecret(), &tx); err != errTestUnavailable {
+		t.Fatalf("Expected %s, received %+v", errTestUnavailable, err)
+	}
+}
+
+func TestRotateRetriesSync(t *testing.T) {
+	primary, secondary := newTestKeystore(), newTestKeystore()
+	s, err := newMirrorKeystore(primary, secondary, testHeaders, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	retrier := newSyncRetrier(func(headers *communications.Headers, logger log.Logger) (*keystore, error) {
+		if headers.Authorization != "" {
+			t.Errorf("Expected the retry to be made without the token of the user, received %s", headers.Authorization)
+		}
+		return s, nil
+	})
+	s.retrier = retrier
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	// The rotation succeeds while the primary is still generating the version, which is copied once it is active
+	id := uuid.NewV4().String()
+	primary.keys[id] = []string{"version-1"}
+	secondary.keys[id] = []string{"version-1"}
+	primary.generating = 2
+	if version, err := s.RotateSecret(context.Background(), id, secrets.NewSecret(), &tx); err != nil || version != 2 {
+		t.Fatalf("Expected version 2, received %d, %+v", version, err)
+	}
+	if retrier.count() != 1 || len(secondary.keys[id]) != 1 {
+		t.Fatalf("Expected the copy to be pending, received %d pending and %v", retrier.count(), secondary.keys[id])
+	}
+
+	now := time.Now()
+	if synced := retrier.retryDue(context.Background(), now); synced != 0 || retrier.count() != 1 {
+		t.Errorf("Expected the copy to be retried again, received %d synced and %d pending", synced, retrier.count())
+	}
+
+	primary.generating = 0
+	if synced := retrier.retryDue(context.Background(), now); synced != 1 || retrier.count() != 0 {
+		t.Errorf("Expected the copy to succeed, received %d synced and %d pending", synced, retrier.count())
+	}
+	if len(secondary.keys[id]) != 2 || secondary.keys[id][1] != primary.keys[id][1] {
+		t.Errorf("Expected both versions in the secondary, received %v", secondary.keys[id])
+	}
+
+	// Bad Path: the retrier gives up on versions that never become active
+	primary.generating = 3
+	if _, err := s.RotateSecret(context.Background(), id, secrets.NewSecret(), &tx); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if synced := retrier.retryDue(context.Background(), time.Now().Add(SyncRetryMaxAge)); synced != 0 || retrier.count() != 0 {
+		t.Errorf("Expected the copy to be dropped, received %d synced and %d pending", synced, retrier.count())
+	}
+}
+
+func TestDeleteFromBoth(t *testing.T) {
+	s, primary, secondary := newTestMirror(t)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	secret := secrets.NewSecret()
+	secret.Payload = "dGVzdC1wYXlsb2Fk"
+	id, err := s.CreateSecret(context.Background(), secret, &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// Bad Path: the secondary fails, and the primary keeps the key
+	secondary.unavailable = true
+	if err := s.DeleteSecret(context.Background(), id, &tx); err != errTestUnavailable {
+		t.Errorf("Expected %s, received %+v", errTestUnavailable, err)
+	}
+	if _, ok := primary.keys[id]; !ok {
+		t.Error("Expected the key to remain in the primary")
+	}
+
+	secondary.unavailable = false
+	if err := s.DeleteSecret(context.Background(), id, &tx); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if len(primary.keys) != 0 || len(secondary.keys) != 0 {
+		t.Errorf("Expected the key to be deleted from both, received %v and %v", primary.keys, secondary.keys)
+	}
+
+	// Keys missing from the secondary are still deleted from the primary
+	primary.keys[id] = []string{"version-1"}
+	if err := s.DeleteSecret(context.Background(), id, &tx); err != nil || len(primary.keys) != 0 {
+		t.Errorf("Expected the key to be deleted from the primary, received %v, %+v", primary.keys, err)
+	}
+}
+
+func TestNotImporter(t *testing.T) {
+	if _, err := NewMirrorKeystore(newTestKeystore(), notImporter{newTestKeystore()}, false, testHeaders, log.NewNopLogger()); err != ErrNotImporter {
+		t.Errorf("Expected %s, received %+v", ErrNotImporter, err)
+	}
+}
@@ -0,0 +1,98 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package mirror
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	registry "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-config"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+)
+
+// Values of mirror.generate
+const (
+	// GeneratePrimary generates the material of keys in the primary and copies it to the secondary, and is the default
+	GeneratePrimary = "primary"
+
+	// GenerateService generates the material of keys in the service and imports it into both keystores, so keys can
+	// be copied at once when the primary generates asynchronously. The material then exists in the memory of the
+	// service, rather than only within the primary, before it is stored.
+	GenerateService = "service"
+)
+
+func init() {
+	// Tenants are mirrored to move them off Barbican, so their policies stay in the shared database
+	registry.Register(registry.Mirror, registry.Backend{NewKeystore: newConfiguredKeystore, NewPolicyDB: registry.SharedPolicyDB, PolicyStore: registry.DBPolicyStore, ServiceAuth: checkServiceAuth})
+}
+
+// checkServiceAuth reports an error if either mirrored backend cannot be called without the token of a user
+func checkServiceAuth() error {
+	config := configuration.Get()
+	for _, name := range []string{config.GetString("mirror.primary"), config.GetString("mirror.secondary")} {
+		if name == registry.Mirror {
+			return errors.New(http.StatusText(http.StatusInternalServerError) + ": Keystore backend " + registry.Mirror + " cannot mirror itself")
+		}
+		if err := registry.CheckServiceAuth(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newConfiguredKeystore will return a Keystore that keeps every key in the backends of mirror.primary and
+// mirror.secondary. When both authenticate as the service, versions that fail to be copied on creation or rotation
+// are retried in the background. Mirrors of a primary that generates asynchronously are refused unless they can retry
+// the copies or mirror.generate is GenerateService.
+func newConfiguredKeystore(auth *communications.Headers, logger log.Logger) (definitions.Keystore, error) {
+	s, err := openMirror(auth, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if checkServiceAuth() == nil {
+		syncs.start()
+		s.retrier = syncs
+	}
+	if err := s.checkGeneration(); err != nil {
+		return nil, err
+	}
+	return withWrapper(s), nil
+}
+
+// openMirror will return the mirror of the backends of mirror.primary and mirror.secondary, without retries
+func openMirror(auth *communications.Headers, logger log.Logger) (*keystore, error) {
+	config := configuration.Get()
+	primaryName, secondaryName := config.GetString("mirror.primary"), config.GetString("mirror.secondary")
+	if primaryName == registry.Mirror || secondaryName == registry.Mirror {
+		return nil, errors.New(http.StatusText(http.StatusInternalServerError) + ": Keystore backend " + registry.Mirror + " cannot mirror itself")
+	}
+
+	primary, err := registry.NewKeystore(primaryName, auth, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	secondary, err := registry.NewKeystore(secondaryName, auth, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newMirrorKeystore(primary, secondary, auth, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	switch generate := config.GetString("mirror.generate"); generate {
+	case "", GeneratePrimary:
+	case GenerateService:
+		s.generate = true
+	default:
+		return nil, errors.New(http.StatusText(http.StatusInternalServerError) + ": Unknown mirror generation " + generate)
+	}
+	return s, nil
+}
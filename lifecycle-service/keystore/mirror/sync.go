@@ -0,0 +1,142 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package mirror
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+)
+
+const (
+	// SyncRetryInterval is the delay between two attempts to copy the versions of a rotated key to the secondary
+	SyncRetryInterval = 30 * time.Second
+
+	// SyncRetryMaxAge is how long the versions of a rotated key are retried before the retrier gives up on them.
+	// They are still copied by the next rotation of the key.
+	SyncRetryMaxAge = time.Hour
+)
+
+// pendingSync is a rotated key whose latest versions are missing from the secondary
+type pendingSync struct {
+	id      string
+	latest  int
+	headers communications.Headers
+	logger  log.Logger
+	tracked time.Time
+}
+
+// syncRetrier copies the versions of rotated keys the primary was still generating when the key was rotated.
+// Retries are made with the credentials of the service, through a mirror opened for every retry. Keys are
+// tracked in memory, so versions pending when the service stops are copied by the next rotation instead.
+type syncRetrier struct {
+	open func(headers *communications.Headers, logger log.Logger) (*keystore, error)
+
+	lock    sync.Mutex
+	pending map[string]*pendingSync
+
+	startOnce sync.Once
+}
+
+// syncs retries the versions of the keys of mirrors opened from the configuration
+var syncs = newSyncRetrier(openMirror)
+
+func newSyncRetrier(open func(headers *communications.Headers, logger log.Logger) (*keystore, error)) *syncRetrier {
+	return &syncRetrier{
+		open:    open,
+		pending: make(map[string]*pendingSync),
+	}
+}
+
+// start retries the pending syncs every SyncRetryInterval, for the life of the process
+func (r *syncRetrier) start() {
+	r.startOnce.Do(func() {
+		go func() {
+			for now := range time.NewTicker(SyncRetryInterval).C {
+				r.retryDue(context.Background(), now)
+			}
+		}()
+	})
+}
+
+// track retries the sync of the key up to the latest version. The token of the user is not kept.
+func (r *syncRetrier) track(headers *communications.Headers, logger log.Logger, id string, latest int) {
+	tracked := communications.Headers{}
+	if headers != nil {
+		tracked = *headers
+	}
+	tracked.Authorization = ""
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := tracked.BluemixSpace + "/" + id
+	if existing, ok := r.pending[key]; ok {
+		if latest > existing.latest {
+			existing.latest = latest
+		}
+		return
+	}
+	r.pending[key] = &pendingSync{id: id, latest: latest, headers: tracked, logger: logger, tracked: time.Now()}
+}
+
+// count returns the number of keys whose sync is being retried
+func (r *syncRetrier) count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.pending)
+}
+
+// retryDue retries every pending sync and returns how many keys were copied up to their latest version
+func (r *syncRetrier) retryDue(ctx context.Context, now time.Time) int {
+	var due []*pendingSync
+	r.lock.Lock()
+	for _, pending := range r.pending {
+		due = append(due, pending)
+	}
+	r.lock.Unlock()
+
+	synced := 0
+	for _, pending := range due {
+		err := r.retry(ctx, pending)
+
+		r.lock.Lock()
+		key := pending.headers.BluemixSpace + "/" + pending.id
+		switch {
+		case err == nil:
+			delete(r.pending, key)
+			synced++
+		case now.Sub(pending.tracked) >= SyncRetryMaxAge:
+			delete(r.pending, key)
+			pending.logger.Log("err", err.Error(), "msg", "CRITICAL - Gave up copying rotated versions to the secondary", "kp_id", pending.id, "correlation_id", pending.headers.CorrelationID)
+		default:
+			pending.logger.Log("err", err.Error(), "msg", "Unable to copy rotated versions to the secondary", "kp_id", pending.id, "correlation_id", pending.headers.CorrelationID)
+		}
+		r.lock.Unlock()
+	}
+	return synced
+}
+
+// retry copies the versions of the key the secondary is missing, undoing the copies if one fails
+func (r *syncRetrier) retry(ctx context.Context, pending *pendingSync) error {
+	headers := pending.headers
+	s, err := r.open(&headers, pending.logger)
+	if err != nil {
+		return err
+	}
+
+	syncTx := transactions.NewTransaction()
+	if err := s.syncVersions(ctx, pending.id, pending.latest, &syncTx); err != nil {
+		if cleanErr := syncTx.Clean(); cleanErr != nil {
+			pending.logger.Log("err", cleanErr.Error(), "kp_id", pending.id, "correlation_id", headers.CorrelationID)
+		}
+		return err
+	}
+	syncTx.Complete()
+	return nil
+}
@@ -3,6 +3,9 @@
 package mock
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"net/http"
 	"sync"
@@ -24,8 +27,9 @@ var (
 )
 
 type simpleSecret struct {
-	payload string
-	state   secrets.KeyStates
+	// versions holds the payload of every version, starting with the payload the secret was created with
+	versions []string
+	state    secrets.KeyStates
 }
 
 func init() {
@@ -37,47 +41,51 @@ type inmemKeystore struct {
 	headers *communications.Headers
 }
 
-func extractBluemixOrgSpace(s *inmemKeystore) (org, space string, err error) {
-	headers := s.headers
-	if headers == nil {
-		err = errors.New(http.StatusText(http.StatusBadRequest) + ": Headers required")
-		return
+//GetPayload : Get a payload with the given keyprotect ID
+func (s *inmemKeystore) GetPayload(ctx context.Context, keyprotectID string) (string, secrets.KeyStates, error) {
+	_, _, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return "", secrets.Destroyed, extractErr
 	}
 
-	space = headers.BluemixSpace
-	if space == "" {
-		err = errors.New(http.StatusText(http.StatusBadRequest) + ": Header Bluemix-Space required")
-		return
+	if _, ok := secretStore[keyprotectID]; !ok {
+		return "", secrets.Destroyed, ErrNotFound
 	}
 
-	org = headers.BluemixOrg
-	if org == "" {
-		err = errors.New(http.StatusText(http.StatusBadRequest) + ": Header Bluemix-Org required")
-		return
-	}
+	payload := secretStore[keyprotectID].versions[0]
 
-	return
+	return payload, secrets.Activation, nil
 }
 
-//GetPayload : Get a payload with the given keyprotect ID
-func (s *inmemKeystore) GetPayload(keyprotectID string) (string, secrets.KeyStates, error) {
-	_, _, extractErr := extractBluemixOrgSpace(s)
+// GetPayloadVersion : Get the payload of a version of the secret with the given keyprotect ID
+func (s *inmemKeystore) GetPayloadVersion(ctx context.Context, keyprotectID string, version int) (string, int, secrets.KeyStates, error) {
+	_, _, extractErr := definitions.BluemixOrgSpace(s.headers)
 	if extractErr != nil {
-		return "", secrets.Destroyed, extractErr
+		return "", version, secrets.Destroyed, extractErr
 	}
 
+	s.RLock()
+	defer s.RUnlock()
+
 	if _, ok := secretStore[keyprotectID]; !ok {
-		return "", secrets.Destroyed, ErrNotFound
+		return "", version, secrets.Destroyed, ErrNotFound
+	}
+
+	versions := secretStore[keyprotectID].versions
+	if version == definitions.CurrentVersion {
+		version = len(versions)
 	}
 
-	payload := secretStore[keyprotectID].payload
+	if version < 1 || version > len(versions) {
+		return "", version, secrets.Destroyed, definitions.ErrVersionNotFound
+	}
 
-	return payload, secrets.Activation, nil
+	return versions[version-1], version, secrets.Activation, nil
 }
 
 // CreateSecret creates a secret using inside the barbican user defined metadata table
-func (s *inmemKeystore) CreateSecret(secret *secrets.Secret, createTx *transactions.Transaction) (string, error) {
-	_, _, extractErr := extractBluemixOrgSpace(s)
+func (s *inmemKeystore) CreateSecret(ctx context.Context, secret *secrets.Secret, createTx *transactions.Transaction) (string, error) {
+	_, _, extractErr := definitions.BluemixOrgSpace(s.headers)
 	if extractErr != nil {
 		return "", extractErr
 	}
@@ -93,8 +101,8 @@ func (s *inmemKeystore) CreateSecret(secret *secrets.Secret, createTx *transacti
 	id := uuid.NewV4().String()
 
 	simpleSecret := &simpleSecret{
-		payload: secret.Payload,
-		state:   secret.State,
+		versions: []string{secret.Payload},
+		state:    secret.State,
 	}
 
 	secretStore[id] = simpleSecret
@@ -102,13 +110,62 @@ func (s *inmemKeystore) CreateSecret(secret *secrets.Secret, createTx *transacti
 	return id, nil
 }
 
+// RotateSecret adds newly generated material to the secret as its next version
+func (s *inmemKeystore) RotateSecret(ctx context.Context, keyprotectID string, secret *secrets.Secret, rotateTx *transactions.Transaction) (int, error) {
+	_, _, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return 0, extractErr
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := secretStore[keyprotectID]; !ok {
+		return 0, ErrNotFound
+	}
+
+	secretStore[keyprotectID].versions = append(secretStore[keyprotectID].versions, generateSecret())
+
+	return len(secretStore[keyprotectID].versions), nil
+}
+
+// ImportVersion stores the payload of the secret as a version of the secret with the given keyprotect ID
+func (s *inmemKeystore) ImportVersion(ctx context.Context, keyprotectID string, version int, secret *secrets.Secret, importTx *transactions.Transaction) error {
+	_, _, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return extractErr
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	stored, ok := secretStore[keyprotectID]
+	switch {
+	case !ok && version == 1:
+		secretStore[keyprotectID] = &simpleSecret{versions: []string{secret.Payload}, state: secrets.Activation}
+	case !ok:
+		return ErrNotFound
+	case version == len(stored.versions)+1:
+		stored.versions = append(stored.versions, secret.Payload)
+	default:
+		return definitions.ErrVersionConflict
+	}
+
+	return nil
+}
+
+// generateSecret returns 256 bits of random key material, base64 encoded as Barbican does for generated keys
 func generateSecret() string {
-	return uuid.NewV4().String()
+	material := make([]byte, 32)
+	if _, err := rand.Read(material); err != nil {
+		panic(err.Error())
+	}
+	return base64.StdEncoding.EncodeToString(material)
 }
 
 // DeleteSecret Given a keyprotect ID. Delete the user's secret.
-func (s *inmemKeystore) DeleteSecret(keyprotectID string, createTx *transactions.Transaction) error {
-	_, _, extractErr := extractBluemixOrgSpace(s)
+func (s *inmemKeystore) DeleteSecret(ctx context.Context, keyprotectID string, createTx *transactions.Transaction) error {
+	_, _, extractErr := definitions.BluemixOrgSpace(s.headers)
 	if extractErr != nil {
 		return extractErr
 	}
@@ -126,7 +183,7 @@ func (s *inmemKeystore) DeleteSecret(keyprotectID string, createTx *transactions
 }
 
 // CheckSecret: Given a keyprotect id, is the secret active?
-func (s *inmemKeystore) CheckSecret(keyprotectID string) (secrets.KeyStates, error) {
+func (s *inmemKeystore) CheckSecret(ctx context.Context, keyprotectID string) (secrets.KeyStates, error) {
 	if _, ok := secretStore[keyprotectID]; !ok {
 		return secrets.Destroyed, ErrNotFound
 	}
@@ -0,0 +1,114 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package mock
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+)
+
+var (
+	policyStore         = make(map[string]db.RotationPolicy)
+	deletionPolicyStore = make(map[string]db.DeletionPolicy)
+	policyLock          sync.RWMutex
+)
+
+type inmemPolicyDB struct{}
+
+// NewMockPolicyDB returns a PolicyDB that keeps rotation and deletion policies in memory for local testing
+func NewMockPolicyDB() db.PolicyDB {
+	return new(inmemPolicyDB)
+}
+
+func policyKey(space string, kpID string) string {
+	return space + "/" + kpID
+}
+
+func (p *inmemPolicyDB) SetPolicy(policy *db.RotationPolicy) error {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	policyStore[policyKey(policy.Space, policy.KpID)] = *policy
+	return nil
+}
+
+func (p *inmemPolicyDB) GetPolicy(space string, org string, kpID string) (*db.RotationPolicy, error) {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+
+	policy, ok := policyStore[policyKey(space, kpID)]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	return &policy, nil
+}
+
+func (p *inmemPolicyDB) DeletePolicy(space string, org string, kpID string) error {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	delete(policyStore, policyKey(space, kpID))
+	return nil
+}
+
+func (p *inmemPolicyDB) ListDuePolicies(now time.Time) ([]*db.RotationPolicy, error) {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+
+	policies := []*db.RotationPolicy{}
+	for _, policy := range policyStore {
+		if !policy.NextRotation.After(now) {
+			due := policy
+			policies = append(policies, &due)
+		}
+	}
+
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].NextRotation.Before(policies[j].NextRotation)
+	})
+	return policies, nil
+}
+
+func (p *inmemPolicyDB) ClaimPolicy(policy *db.RotationPolicy, until time.Time) (bool, error) {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	key := policyKey(policy.Space, policy.KpID)
+	stored, ok := policyStore[key]
+	if !ok || !stored.NextRotation.Equal(policy.NextRotation) {
+		return false, nil
+	}
+	stored.NextRotation = until
+	policyStore[key] = stored
+	return true, nil
+}
+
+func (p *inmemPolicyDB) SetDeletionPolicy(policy *db.DeletionPolicy) error {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	deletionPolicyStore[policyKey(policy.Space, policy.KpID)] = *policy
+	return nil
+}
+
+func (p *inmemPolicyDB) GetDeletionPolicy(space string, org string, kpID string) (*db.DeletionPolicy, error) {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+
+	policy, ok := deletionPolicyStore[policyKey(space, kpID)]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	return &policy, nil
+}
+
+func (p *inmemPolicyDB) DeleteDeletionPolicy(space string, org string, kpID string) error {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	delete(deletionPolicyStore, policyKey(space, kpID))
+	return nil
+}
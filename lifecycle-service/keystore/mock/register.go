@@ -0,0 +1,22 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package mock
+
+import (
+	"github.com/go-kit/kit/log"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	registry "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+)
+
+func init() {
+	registry.Register(registry.Mock, registry.Backend{
+		NewKeystore: func(auth *communications.Headers, logger log.Logger) (definitions.Keystore, error) {
+			return NewMockKeystore(auth, logger), nil
+		},
+		NewPolicyDB: func() (db.PolicyDB, error) { return NewMockPolicyDB(), nil },
+		PolicyStore: registry.MockPolicyStore,
+	})
+}
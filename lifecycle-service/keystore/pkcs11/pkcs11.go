@@ -0,0 +1,612 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+//go:build pkcs11
+// +build pkcs11
+
+// Package pkcs11 keeps keys on the token of an HSM. The miekg/pkcs11 bindings need cgo, so the package is only built
+// with the pkcs11 build tag.
+package pkcs11
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	p11 "github.com/miekg/pkcs11"
+	uuid "github.com/satori/go.uuid"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/algorithms"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keywrap"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-config"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+const (
+	// PINEnv holds the PIN of the token user, and takes precedence over pkcs11.pin
+	PINEnv = "KP_PKCS11_PIN"
+
+	gcmNonceSize = 12
+	gcmTagBits   = 128
+)
+
+var (
+	// ErrNotFound notifies callers when the secret cannot be found on the token
+	ErrNotFound = errors.New(http.StatusText(http.StatusNotFound) + ": Unable to find secret")
+
+	// ErrNotExtractable notifies callers when key material was requested from a key that never leaves the token
+	ErrNotExtractable = errors.New(http.StatusText(http.StatusBadRequest) + ": Key material cannot be extracted from the HSM")
+
+	// ErrGeneratedExtractable notifies callers when a key generated on the token was requested to be extractable
+	ErrGeneratedExtractable = errors.New(http.StatusText(http.StatusBadRequest) + ": Keys generated in the HSM are root keys and cannot be extractable")
+
+	// ErrModule notifies callers when the PKCS#11 module cannot be loaded or initialized
+	ErrModule = errors.New(http.StatusText(http.StatusInternalServerError) + ": Unable to initialize the PKCS#11 module")
+
+	tokenOnce   sync.Once
+	sharedToken *token
+	tokenErr    error
+)
+
+// token is the slot of a PKCS#11 module holding the keys. The module is initialized once for the process,
+// and every operation runs in a session of its own.
+type token struct {
+	ctx  *p11.Ctx
+	slot uint
+	pin  string
+}
+
+type keystore struct {
+	token   *token
+	logger  log.Logger
+	headers *communications.Headers
+}
+
+// openToken loads and initializes the module. A module already initialized by the process is used as is.
+func openToken(module string, slot uint, pin string) (*token, error) {
+	ctx := p11.New(module)
+	if ctx == nil {
+		return nil, ErrModule
+	}
+
+	if err := ctx.Initialize(); err != nil && !isError(err, p11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+		return nil, ErrModule
+	}
+
+	return &token{ctx: ctx, slot: slot, pin: pin}, nil
+}
+
+func isError(err error, code uint) bool {
+	e, ok := err.(p11.Error)
+	return ok && uint(e) == code
+}
+
+// session opens a read/write session logged in as the token user. The caller must close it.
+func (t *token) session() (p11.SessionHandle, error) {
+	session, err := t.ctx.OpenSession(t.slot, p11.CKF_SERIAL_SESSION|p11.CKF_RW_SESSION)
+	if err != nil {
+		return 0, err
+	}
+
+	// The login state is shared by every session of the application
+	if err := t.ctx.Login(session, p11.CKU_USER, t.pin); err != nil && !isError(err, p11.CKR_USER_ALREADY_LOGGED_IN) {
+		t.ctx.CloseSession(session)
+		return 0, err
+	}
+
+	return session, nil
+}
+
+// keyLabel is the CKA_LABEL shared by every version of a key, so only the space and org of the key find it.
+// Each version is an object of its own with the version number as its CKA_ID.
+func keyLabel(space, org, keyprotectID string) string {
+	return space + "/" + org + "/" + keyprotectID
+}
+
+func versionID(version int) []byte {
+	return []byte(strconv.Itoa(version))
+}
+
+// findObjects returns the handles of every object on the token matching the template
+func (t *token) findObjects(session p11.SessionHandle, template []*p11.Attribute) ([]p11.ObjectHandle, error) {
+	if err := t.ctx.FindObjectsInit(session, template); err != nil {
+		return nil, err
+	}
+	defer t.ctx.FindObjectsFinal(session)
+
+	handles := []p11.ObjectHandle{}
+	for {
+		found, _, err := t.ctx.FindObjects(session, 16)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return handles, nil
+		}
+		handles = append(handles, found...)
+	}
+}
+
+// versions returns the object of every version of the key, by version
+func (t *token) versions(session p11.SessionHandle, label string) (map[int]p11.ObjectHandle, error) {
+	handles, err := t.findObjects(session, []*p11.Attribute{p11.NewAttribute(p11.CKA_LABEL, label)})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(handles) == 0 {
+		return nil, ErrNotFound
+	}
+
+	versions := make(map[int]p11.ObjectHandle, len(handles))
+	for _, handle := range handles {
+		attributes, err := t.ctx.GetAttributeValue(session, handle, []*p11.Attribute{p11.NewAttribute(p11.CKA_ID, nil)})
+		if err != nil {
+			return nil, err
+		}
+
+		version, err := strconv.Atoi(string(attributes[0].Value))
+		if err != nil {
+			return nil, err
+		}
+		versions[version] = handle
+	}
+	return versions, nil
+}
+
+// findVersion returns the object of a version of the key along with that version, which is resolved to the
+// latest one when definitions.CurrentVersion is requested
+func (t *token) findVersion(session p11.SessionHandle, label string, version int) (p11.ObjectHandle, int, error) {
+	versions, err := t.versions(session, label)
+	if err != nil {
+		return 0, version, err
+	}
+
+	if version == definitions.CurrentVersion {
+		for v := range versions {
+			if v > version {
+				version = v
+			}
+		}
+	}
+
+	handle, ok := versions[version]
+	if !ok {
+		return 0, version, definitions.ErrVersionNotFound
+	}
+	return handle, version, nil
+}
+
+// readValue returns the value of a data object, or ErrNotExtractable for a key held by the token
+func (t *token) readValue(session p11.SessionHandle, handle p11.ObjectHandle) (string, error) {
+	attributes, err := t.ctx.GetAttributeValue(session, handle, []*p11.Attribute{p11.NewAttribute(p11.CKA_CLASS, nil)})
+	if err != nil {
+		return "", err
+	}
+
+	if !bytes.Equal(attributes[0].Value, p11.NewAttribute(p11.CKA_CLASS, p11.CKO_DATA).Value) {
+		return "", ErrNotExtractable
+	}
+
+	attributes, err = t.ctx.GetAttributeValue(session, handle, []*p11.Attribute{p11.NewAttribute(p11.CKA_VALUE, nil)})
+	if err != nil {
+		return "", err
+	}
+	return string(attributes[0].Value), nil
+}
+
+// isExtractable reports whether the caller may retrieve the payload of the secret, as standard keys are by default
+func isExtractable(secret *secrets.Secret) bool {
+	return secret.Extractable == nil || *secret.Extractable
+}
+
+// isAESKey reports whether the payload of the secret can be stored as an AES key on the token
+func isAESKey(secret *secrets.Secret) bool {
+	algorithmType := strings.ToUpper(secret.AlgorithmType)
+	if algorithmType != "" && algorithmType != algorithms.AES {
+		return false
+	}
+
+	material, err := base64.StdEncoding.DecodeString(secret.Payload)
+	if err != nil {
+		return false
+	}
+
+	switch len(material) {
+	case 16, 24, 32:
+		return true
+	default:
+		return false
+	}
+}
+
+// keyTemplate is the template of a secret key held by the token, which is sensitive and never extractable
+func keyTemplate(label string, version int, keyType uint) []*p11.Attribute {
+	return []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_CLASS, p11.CKO_SECRET_KEY),
+		p11.NewAttribute(p11.CKA_KEY_TYPE, keyType),
+		p11.NewAttribute(p11.CKA_LABEL, label),
+		p11.NewAttribute(p11.CKA_ID, versionID(version)),
+		p11.NewAttribute(p11.CKA_TOKEN, true),
+		p11.NewAttribute(p11.CKA_PRIVATE, true),
+		p11.NewAttribute(p11.CKA_SENSITIVE, true),
+		p11.NewAttribute(p11.CKA_EXTRACTABLE, false),
+	}
+}
+
+// generateKey generates a version of a key on the token for the resolved algorithm
+func (t *token) generateKey(session p11.SessionHandle, label string, version int, spec *algorithms.Spec) (p11.ObjectHandle, error) {
+	if spec.Algorithm == algorithms.AES {
+		template := append(keyTemplate(label, version, p11.CKK_AES),
+			p11.NewAttribute(p11.CKA_VALUE_LEN, spec.BitLength/8),
+			p11.NewAttribute(p11.CKA_ENCRYPT, true),
+			p11.NewAttribute(p11.CKA_DECRYPT, true))
+		return t.ctx.GenerateKey(session, []*p11.Mechanism{p11.NewMechanism(p11.CKM_AES_KEY_GEN, nil)}, template)
+	}
+
+	template := append(keyTemplate(label, version, p11.CKK_GENERIC_SECRET),
+		p11.NewAttribute(p11.CKA_VALUE_LEN, spec.BitLength/8),
+		p11.NewAttribute(p11.CKA_SIGN, true),
+		p11.NewAttribute(p11.CKA_VERIFY, true))
+	return t.ctx.GenerateKey(session, []*p11.Mechanism{p11.NewMechanism(p11.CKM_GENERIC_SECRET_KEY_GEN, nil)}, template)
+}
+
+// storePayload stores the payload of the secret as its first version. Root keys of AES material become keys held
+// by the token, while other payloads, such as standard secrets and the private keys of signing keys, are private data objects.
+func (t *token) storePayload(session p11.SessionHandle, label string, secret *secrets.Secret) (p11.ObjectHandle, error) {
+	if !isExtractable(secret) && isAESKey(secret) {
+		material, _ := base64.StdEncoding.DecodeString(secret.Payload)
+		template := append(keyTemplate(label, 1, p11.CKK_AES),
+			p11.NewAttribute(p11.CKA_VALUE, material),
+			p11.NewAttribute(p11.CKA_ENCRYPT, true),
+			p11.NewAttribute(p11.CKA_DECRYPT, true))
+		return t.ctx.CreateObject(session, template)
+	}
+
+	return t.ctx.CreateObject(session, []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_CLASS, p11.CKO_DATA),
+		p11.NewAttribute(p11.CKA_LABEL, label),
+		p11.NewAttribute(p11.CKA_ID, versionID(1)),
+		p11.NewAttribute(p11.CKA_TOKEN, true),
+		p11.NewAttribute(p11.CKA_PRIVATE, true),
+		p11.NewAttribute(p11.CKA_VALUE, []byte(secret.Payload)),
+	})
+}
+
+// GetPayload : Get the payload the secret with the given keyprotect ID was created with.
+// Keys held by the token have no payload, as their material never leaves it.
+func (s *keystore) GetPayload(ctx context.Context, keyprotectID string) (string, secrets.KeyStates, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return "", secrets.Destroyed, extractErr
+	}
+
+	session, err := s.token.session()
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return "", secrets.Destroyed, err
+	}
+	defer s.token.ctx.CloseSession(session)
+
+	handle, _, err := s.token.findVersion(session, keyLabel(space, org, keyprotectID), 1)
+	if err != nil {
+		return "", secrets.Destroyed, err
+	}
+
+	payload, err := s.token.readValue(session, handle)
+	if err == ErrNotExtractable {
+		return "", secrets.Activation, nil
+	}
+	if err != nil {
+		return "", secrets.Destroyed, err
+	}
+
+	return payload, secrets.Activation, nil
+}
+
+// GetPayloadVersion : Get the payload of a version of the secret with the given keyprotect ID.
+// Keys held by the token return ErrNotExtractable, and are used through WrapKey and UnwrapKey instead.
+func (s *keystore) GetPayloadVersion(ctx context.Context, keyprotectID string, version int) (string, int, secrets.KeyStates, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return "", version, secrets.Destroyed, extractErr
+	}
+
+	session, err := s.token.session()
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return "", version, secrets.Destroyed, err
+	}
+	defer s.token.ctx.CloseSession(session)
+
+	handle, version, err := s.token.findVersion(session, keyLabel(space, org, keyprotectID), version)
+	if err != nil {
+		return "", version, secrets.Destroyed, err
+	}
+
+	payload, err := s.token.readValue(session, handle)
+	if err != nil {
+		return "", version, secrets.Destroyed, err
+	}
+
+	return payload, version, secrets.Activation, nil
+}
+
+// CreateSecret stores the payload of the secret on the token, or generates a non-extractable key on the token
+func (s *keystore) CreateSecret(ctx context.Context, secret *secrets.Secret, createTx *transactions.Transaction) (string, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return "", extractErr
+	}
+
+	var spec *algorithms.Spec
+	if len(secret.Payload) == 0 {
+		if isExtractable(secret) {
+			return "", ErrGeneratedExtractable
+		}
+
+		var err error
+		spec, err = algorithms.ResolveSecret(secret)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	session, err := s.token.session()
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return "", err
+	}
+	defer s.token.ctx.CloseSession(session)
+
+	id := uuid.NewV4().String()
+	label := keyLabel(space, org, id)
+
+	if spec != nil {
+		_, err = s.token.generateKey(session, label, 1, spec)
+	} else {
+		_, err = s.token.storePayload(session, label, secret)
+	}
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return "", err
+	}
+
+	rbDeleteID := transactions.NewKeyIDRollback(deleteID, id, space, org, s)
+	createTx.Add(rbDeleteID)
+
+	secret.SetState(secrets.Activation)
+	return id, nil
+}
+
+// RotateSecret generates a key for the algorithm of the secret on the token as its next version
+func (s *keystore) RotateSecret(ctx context.Context, keyprotectID string, secret *secrets.Secret, rotateTx *transactions.Transaction) (int, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return 0, extractErr
+	}
+
+	spec, err := algorithms.Resolve(secret.AlgorithmType, secret.AlgorithmMetadata)
+	if err != nil {
+		return 0, err
+	}
+
+	session, err := s.token.session()
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return 0, err
+	}
+	defer s.token.ctx.CloseSession(session)
+
+	label := keyLabel(space, org, keyprotectID)
+	_, current, err := s.token.findVersion(session, label, definitions.CurrentVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	version := current + 1
+	handle, err := s.token.generateKey(session, label, version, spec)
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return 0, err
+	}
+
+	rbDeleteVersion := transactions.NewHsmCreateSecretRollback(func(string) error {
+		return s.destroyObjects([]p11.ObjectHandle{handle})
+	}, keyprotectID)
+	rotateTx.Add(rbDeleteVersion)
+
+	return version, nil
+}
+
+// deleteID takes an interface so that we can perform rollbacks using this function
+func deleteID(keyprotectID string, space string, org string, i interface{}) error {
+	s, ok := i.(*keystore)
+	if !ok {
+		return errors.New("Requires type *keystore")
+	}
+
+	session, err := s.token.session()
+	if err != nil {
+		return err
+	}
+	defer s.token.ctx.CloseSession(session)
+
+	versions, err := s.token.versions(session, keyLabel(space, org, keyprotectID))
+	if err != nil {
+		return err
+	}
+
+	for _, handle := range versions {
+		if err := s.token.ctx.DestroyObject(session, handle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// destroyObjects destroys objects of the token in a session of their own
+func (s *keystore) destroyObjects(handles []p11.ObjectHandle) error {
+	session, err := s.token.session()
+	if err != nil {
+		return err
+	}
+	defer s.token.ctx.CloseSession(session)
+
+	for _, handle := range handles {
+		if err := s.token.ctx.DestroyObject(session, handle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteSecret Given a keyprotect ID. Delete every version of the user's secret from the token.
+func (s *keystore) DeleteSecret(ctx context.Context, keyprotectID string, deleteTx *transactions.Transaction) error {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return extractErr
+	}
+
+	if err := deleteID(keyprotectID, space, org, s); err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return err
+	}
+	return nil
+}
+
+// CheckSecret: Given a keyprotect id, is the secret active? Keys are active as soon as they are on the token.
+func (s *keystore) CheckSecret(ctx context.Context, keyprotectID string) (secrets.KeyStates, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return secrets.Destroyed, extractErr
+	}
+
+	session, err := s.token.session()
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return secrets.Destroyed, err
+	}
+	defer s.token.ctx.CloseSession(session)
+
+	if _, err := s.token.versions(session, keyLabel(space, org, keyprotectID)); err != nil {
+		return secrets.Destroyed, err
+	}
+	return secrets.Activation, nil
+}
+
+// tokenSealer seals with AES-GCM inside the token, under a version of a key held by it
+type tokenSealer struct {
+	ctx     *p11.Ctx
+	session p11.SessionHandle
+	key     p11.ObjectHandle
+}
+
+func (t tokenSealer) NonceSize() int {
+	return gcmNonceSize
+}
+
+func (t tokenSealer) Seal(nonce, plaintext, additionalData []byte) ([]byte, error) {
+	params := p11.NewGCMParams(nonce, additionalData, gcmTagBits)
+	defer params.Free()
+
+	if err := t.ctx.EncryptInit(t.session, []*p11.Mechanism{p11.NewMechanism(p11.CKM_AES_GCM, params)}, t.key); err != nil {
+		return nil, err
+	}
+	return t.ctx.Encrypt(t.session, plaintext)
+}
+
+func (t tokenSealer) Open(nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	params := p11.NewGCMParams(nonce, additionalData, gcmTagBits)
+	defer params.Free()
+
+	if err := t.ctx.DecryptInit(t.session, []*p11.Mechanism{p11.NewMechanism(p11.CKM_AES_GCM, params)}, t.key); err != nil {
+		return nil, err
+	}
+	return t.ctx.Decrypt(t.session, ciphertext)
+}
+
+// WrapKey encrypts the plaintext inside the token under the current version of the root key
+func (s *keystore) WrapKey(ctx context.Context, keyprotectID string, plaintext, aad []byte) ([]byte, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return nil, extractErr
+	}
+
+	session, err := s.token.session()
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return nil, err
+	}
+	defer s.token.ctx.CloseSession(session)
+
+	handle, version, err := s.token.findVersion(session, keyLabel(space, org, keyprotectID), definitions.CurrentVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return keywrap.WrapWith(tokenSealer{ctx: s.token.ctx, session: session, key: handle}, version, plaintext, aad)
+}
+
+// UnwrapKey decrypts the ciphertext inside the token with the version of the root key recorded in its header
+func (s *keystore) UnwrapKey(ctx context.Context, keyprotectID string, ciphertext, aad []byte) ([]byte, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return nil, extractErr
+	}
+
+	version, err := keywrap.KeyVersion(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.token.session()
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return nil, err
+	}
+	defer s.token.ctx.CloseSession(session)
+
+	handle, _, err := s.token.findVersion(session, keyLabel(space, org, keyprotectID), version)
+	if err != nil {
+		return nil, err
+	}
+
+	return keywrap.UnwrapWith(tokenSealer{ctx: s.token.ctx, session: session, key: handle}, ciphertext, aad)
+}
+
+func newKeystore(t *token, auth *communications.Headers, logger log.Logger) *keystore {
+	s := new(keystore)
+	s.token = t
+	s.headers = auth
+	s.logger = logger
+	return s
+}
+
+// NewPKCS11Keystore will return a Keystore that generates and holds keys on the token in slot pkcs11.slot of the
+// module at pkcs11.module. The token user logs in with KP_PKCS11_PIN, or else pkcs11.pin.
+func NewPKCS11Keystore(auth *communications.Headers, logger log.Logger) (definitions.Keystore, error) {
+	tokenOnce.Do(func() {
+		config := configuration.Get()
+		pin, ok := os.LookupEnv(PINEnv)
+		if !ok {
+			pin = config.GetString("pkcs11.pin")
+		}
+		sharedToken, tokenErr = openToken(config.GetString("pkcs11.module"), uint(config.GetInt("pkcs11.slot")), pin)
+	})
+	if tokenErr != nil {
+		return nil, tokenErr
+	}
+
+	return newKeystore(sharedToken, auth, logger), nil
+}
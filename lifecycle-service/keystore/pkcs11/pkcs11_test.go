@@ -0,0 +1,243 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+//go:build pkcs11
+// +build pkcs11
+
+package pkcs11
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	p11 "github.com/miekg/pkcs11"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+// The tests that need a token run against SoftHSM, or any other module, when these are set. For example:
+//
+//	softhsm2-util --init-token --free --label kp-test --pin 1234 --so-pin 1234
+//	KP_PKCS11_TEST_MODULE=/usr/lib/softhsm/libsofthsm2.so KP_PKCS11_TEST_SLOT=<slot> KP_PKCS11_TEST_PIN=1234 go test
+const (
+	testModuleEnv = "KP_PKCS11_TEST_MODULE"
+	testSlotEnv   = "KP_PKCS11_TEST_SLOT"
+	testPINEnv    = "KP_PKCS11_TEST_PIN"
+)
+
+var testHeaders = &communications.Headers{
+	BluemixSpace:  "test-space",
+	BluemixOrg:    "test-org",
+	CorrelationID: "test-correlation-id",
+}
+
+// newTestKeystore returns a keystore on the test token, skipping the test when no module is configured
+func newTestKeystore(t *testing.T, auth *communications.Headers) *keystore {
+	module, ok := os.LookupEnv(testModuleEnv)
+	if !ok {
+		t.Skip(testModuleEnv + " is not set")
+	}
+
+	slot, err := strconv.Atoi(os.Getenv(testSlotEnv))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	tok, err := openToken(module, uint(slot), os.Getenv(testPINEnv))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	return newKeystore(tok, auth, log.NewNopLogger())
+}
+
+func notExtractable() *secrets.Secret {
+	extractable := false
+	secret := secrets.NewSecret()
+	secret.Extractable = &extractable
+	return secret
+}
+
+func TestGeneratedRootKey(t *testing.T) {
+	s := newTestKeystore(t, testHeaders)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	secret := notExtractable()
+	id, err := s.CreateSecret(context.Background(), secret, &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer s.DeleteSecret(context.Background(), id, &tx)
+
+	if secret.AlgorithmType != "AES" || secret.AlgorithmMetadata["bitLength"] != "256" {
+		t.Errorf("Expected the resolved algorithm in the metadata, received %s %v", secret.AlgorithmType, secret.AlgorithmMetadata)
+	}
+
+	// The key is generated non-extractable on the token
+	session, err := s.token.session()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	handle, _, err := s.token.findVersion(session, keyLabel("test-space", "test-org", id), 1)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	attributes, err := s.token.ctx.GetAttributeValue(session, handle, []*p11.Attribute{p11.NewAttribute(p11.CKA_EXTRACTABLE, nil)})
+	s.token.ctx.CloseSession(session)
+	if err != nil || len(attributes[0].Value) != 1 || attributes[0].Value[0] != 0 {
+		t.Errorf("Expected CKA_EXTRACTABLE to be false, received %v, %+v", attributes, err)
+	}
+
+	if payload, state, err := s.GetPayload(context.Background(), id); err != nil || payload != "" || state != secrets.Activation {
+		t.Errorf("Expected an active key without payload, received %s, %d, %+v", payload, state, err)
+	}
+
+	if _, _, _, err := s.GetPayloadVersion(context.Background(), id, definitions.CurrentVersion); err != ErrNotExtractable {
+		t.Errorf("Expected %s, received %+v", ErrNotExtractable, err)
+	}
+
+	ciphertext, err := s.WrapKey(context.Background(), id, []byte("data-encryption-key"), []byte("test-aad"))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	version, err := s.RotateSecret(context.Background(), id, secret, &tx)
+	if err != nil || version != 2 {
+		t.Fatalf("Expected version 2, received %d, %+v", version, err)
+	}
+
+	// The ciphertext of version 1 still unwraps after rotation
+	plaintext, err := s.UnwrapKey(context.Background(), id, ciphertext, []byte("test-aad"))
+	if err != nil || string(plaintext) != "data-encryption-key" {
+		t.Errorf("Expected data-encryption-key, received %s, %+v", plaintext, err)
+	}
+
+	rewrapped, err := s.WrapKey(context.Background(), id, plaintext, nil)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if rewrapped[4] != 2 {
+		t.Errorf("Expected a ciphertext of version 2, received version %d", rewrapped[4])
+	}
+
+	// Bad Path: wrong aad
+	if _, err := s.UnwrapKey(context.Background(), id, ciphertext, []byte("other-aad")); err == nil {
+		t.Error("Expected Error")
+	}
+}
+
+func TestStoredPayloads(t *testing.T) {
+	s := newTestKeystore(t, testHeaders)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	// A standard secret is a data object
+	standard := secrets.NewSecret()
+	standard.Payload = "test-payload"
+	id, err := s.CreateSecret(context.Background(), standard, &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if payload, _, err := s.GetPayload(context.Background(), id); err != nil || payload != "test-payload" {
+		t.Errorf("Expected test-payload, received %s, %+v", payload, err)
+	}
+
+	// Another space cannot find it
+	other := newKeystore(s.token, &communications.Headers{BluemixSpace: "other-space", BluemixOrg: "test-org"}, log.NewNopLogger())
+	if _, err := other.CheckSecret(context.Background(), id); err != ErrNotFound {
+		t.Errorf("Expected %s, received %+v", ErrNotFound, err)
+	}
+
+	if err := s.DeleteSecret(context.Background(), id, &tx); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := s.CheckSecret(context.Background(), id); err != ErrNotFound {
+		t.Errorf("Expected %s, received %+v", ErrNotFound, err)
+	}
+
+	// An imported root key becomes a key held by the token
+	imported := notExtractable()
+	imported.Payload = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0x42}, 32))
+	id, err = s.CreateSecret(context.Background(), imported, &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer s.DeleteSecret(context.Background(), id, &tx)
+
+	if _, _, _, err := s.GetPayloadVersion(context.Background(), id, 1); err != ErrNotExtractable {
+		t.Errorf("Expected %s, received %+v", ErrNotExtractable, err)
+	}
+}
+
+func TestCreateRollback(t *testing.T) {
+	s := newTestKeystore(t, testHeaders)
+
+	tx := transactions.NewTransaction()
+	id, err := s.CreateSecret(context.Background(), notExtractable(), &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if err := tx.Clean(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := s.CheckSecret(context.Background(), id); err != ErrNotFound {
+		t.Errorf("Expected %s, received %+v", ErrNotFound, err)
+	}
+}
+
+func TestCreateSecretValidation(t *testing.T) {
+	// Validation happens before the token is used
+	s := newKeystore(nil, testHeaders, log.NewNopLogger())
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	// Bad Path: keys generated on the token cannot be extractable
+	if _, err := s.CreateSecret(context.Background(), secrets.NewSecret(), &tx); err != ErrGeneratedExtractable {
+		t.Errorf("Expected %s, received %+v", ErrGeneratedExtractable, err)
+	}
+
+	// Bad Path: unsupported algorithm
+	secret := notExtractable()
+	secret.AlgorithmType = "DES"
+	if _, err := s.CreateSecret(context.Background(), secret, &tx); err == nil {
+		t.Error("Expected Error")
+	}
+}
+
+func TestIsAESKey(t *testing.T) {
+	tests := []struct {
+		algorithmType string
+		payload       string
+		aes           bool
+	}{
+		{payload: base64.StdEncoding.EncodeToString(make([]byte, 32)), aes: true},
+		{algorithmType: "aes", payload: base64.StdEncoding.EncodeToString(make([]byte, 16)), aes: true},
+		{payload: base64.StdEncoding.EncodeToString(make([]byte, 20)), aes: false},
+		{algorithmType: "RSA", payload: base64.StdEncoding.EncodeToString(make([]byte, 32)), aes: false},
+		{payload: "not base64!", aes: false},
+	}
+
+	for _, test := range tests {
+		secret := secrets.NewSecret()
+		secret.AlgorithmType = test.algorithmType
+		secret.Payload = test.payload
+		if isAESKey(secret) != test.aes {
+			t.Errorf("Expected %t for %s %s", test.aes, test.algorithmType, test.payload)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+//go:build pkcs11
+// +build pkcs11
+
+package pkcs11
+
+import (
+	registry "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+)
+
+func init() {
+	registry.Register(registry.PKCS11, registry.Backend{NewKeystore: NewPKCS11Keystore, NewPolicyDB: registry.SharedPolicyDB, PolicyStore: registry.DBPolicyStore})
+}
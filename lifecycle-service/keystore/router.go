@@ -0,0 +1,252 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package keystore
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-config"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+)
+
+// Router picks the backend of every request: the default backend of the deployment, unless the space, or else the
+// org, of the request is routed to another one. One service can so keep the keys of different tenants in different
+// backends. Routes are set up before the Router is shared, as it is read without locking.
+type Router struct {
+	defaultBackend string
+	spaces         map[string]string
+	orgs           map[string]string
+}
+
+// NewRouter returns a Router that sends every request to the default backend
+func NewRouter(defaultBackend string) *Router {
+	return &Router{
+		defaultBackend: defaultBackend,
+		spaces:         make(map[string]string),
+		orgs:           make(map[string]string),
+	}
+}
+
+// NewRouterFromConfig returns a Router for the default backend, with the routes of keystore.spaces and keystore.orgs
+func NewRouterFromConfig(defaultBackend string) (*Router, error) {
+	config := configuration.Get()
+	router := NewRouter(defaultBackend)
+
+	spaces, err := ParseRoutes(config.GetString("keystore.spaces"))
+	if err != nil {
+		return nil, err
+	}
+	for space, backend := range spaces {
+		router.RouteSpace(space, backend)
+	}
+
+	orgs, err := ParseRoutes(config.GetString("keystore.orgs"))
+	if err != nil {
+		return nil, err
+	}
+	for org, backend := range orgs {
+		router.RouteOrg(org, backend)
+	}
+
+	return router, router.Validate()
+}
+
+// ParseRoutes parses routes of the form "tenant=backend,tenant=backend"
+func ParseRoutes(routes string) (map[string]string, error) {
+	parsed := make(map[string]string)
+	for _, route := range strings.Split(routes, ",") {
+		route = strings.TrimSpace(route)
+		if route == "" {
+			continue
+		}
+
+		parts := strings.SplitN(route, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, errors.New("Keystore route " + route + " must be of the form tenant=backend")
+		}
+		parsed[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return parsed, nil
+}
+
+// RouteSpace sends the requests of the space to the backend
+func (r *Router) RouteSpace(space, backend string) *Router {
+	r.spaces[space] = backend
+	return r
+}
+
+// RouteOrg sends the requests of the org to the backend, unless their space is routed
+func (r *Router) RouteOrg(org, backend string) *Router {
+	r.orgs[org] = backend
+	return r
+}
+
+// Backend returns the name of the backend of the space and org
+func (r *Router) Backend(space, org string) string {
+	if backend, ok := r.spaces[space]; ok {
+		return backend
+	}
+	if backend, ok := r.orgs[org]; ok {
+		return backend
+	}
+	return r.defaultBackend
+}
+
+// Backends returns the sorted names of every backend requests may be sent to
+func (r *Router) Backends() []string {
+	unique := map[string]bool{r.defaultBackend: true}
+	for _, backend := range r.spaces {
+		unique[backend] = true
+	}
+	for _, backend := range r.orgs {
+		unique[backend] = true
+	}
+
+	backends := make([]string, 0, len(unique))
+	for backend := range unique {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+	return backends
+}
+
+// Validate checks that every backend requests may be sent to is registered
+func (r *Router) Validate() error {
+	for _, backend := range r.Backends() {
+		if _, err := lookup(backend); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckServiceAuth reports an error if any backend requests are routed to cannot be called without the token of a user
+func (r *Router) CheckServiceAuth() error {
+	for _, backend := range r.Backends() {
+		if err := CheckServiceAuth(backend); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewKeystore will return the Keystore of the backend the request is routed to
+func (r *Router) NewKeystore(auth *communications.Headers, logger log.Logger) (definitions.Keystore, error) {
+	if auth == nil {
+		return NewKeystore(r.defaultBackend, auth, logger)
+	}
+	return NewKeystore(r.Backend(auth.BluemixSpace, auth.BluemixOrg), auth, logger)
+}
+
+// NewPolicyDB will return a PolicyDB that keeps the policies of every key in the store of the backend the key is routed to
+func (r *Router) NewPolicyDB() (db.PolicyDB, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return &routedPolicyDB{router: r}, nil
+}
+
+type routedPolicyDB struct {
+	router *Router
+}
+
+func (p *routedPolicyDB) store(space, org string) (db.PolicyDB, error) {
+	return NewPolicyDB(p.router.Backend(space, org))
+}
+
+func (p *routedPolicyDB) SetPolicy(policy *db.RotationPolicy) error {
+	store, err := p.store(policy.Space, policy.Org)
+	if err != nil {
+		return err
+	}
+	return store.SetPolicy(policy)
+}
+
+func (p *routedPolicyDB) GetPolicy(space string, org string, kpID string) (*db.RotationPolicy, error) {
+	store, err := p.store(space, org)
+	if err != nil {
+		return nil, err
+	}
+	return store.GetPolicy(space, org, kpID)
+}
+
+func (p *routedPolicyDB) DeletePolicy(space string, org string, kpID string) error {
+	store, err := p.store(space, org)
+	if err != nil {
+		return err
+	}
+	return store.DeletePolicy(space, org, kpID)
+}
+
+// ListDuePolicies lists the due policies of each store once, keeping those of the keys still routed to a backend of that store
+func (p *routedPolicyDB) ListDuePolicies(now time.Time) ([]*db.RotationPolicy, error) {
+	listed := make(map[string]bool)
+	due := []*db.RotationPolicy{}
+	for _, name := range p.router.Backends() {
+		backend, err := lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		if listed[backend.PolicyStore] {
+			continue
+		}
+		listed[backend.PolicyStore] = true
+
+		store, err := backend.NewPolicyDB()
+		if err != nil {
+			return nil, err
+		}
+
+		policies, err := store.ListDuePolicies(now)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, policy := range policies {
+			routed, err := lookup(p.router.Backend(policy.Space, policy.Org))
+			if err == nil && routed.PolicyStore == backend.PolicyStore {
+				due = append(due, policy)
+			}
+		}
+	}
+	return due, nil
+}
+
+func (p *routedPolicyDB) ClaimPolicy(policy *db.RotationPolicy, until time.Time) (bool, error) {
+	store, err := p.store(policy.Space, policy.Org)
+	if err != nil {
+		return false, err
+	}
+	return store.ClaimPolicy(policy, until)
+}
+
+func (p *routedPolicyDB) SetDeletionPolicy(policy *db.DeletionPolicy) error {
+	store, err := p.store(policy.Space, policy.Org)
+	if err != nil {
+		return err
+	}
+	return store.SetDeletionPolicy(policy)
+}
+
+func (p *routedPolicyDB) GetDeletionPolicy(space string, org string, kpID string) (*db.DeletionPolicy, error) {
+	store, err := p.store(space, org)
+	if err != nil {
+		return nil, err
+	}
+	return store.GetDeletionPolicy(space, org, kpID)
+}
+
+func (p *routedPolicyDB) DeleteDeletionPolicy(space string, org string, kpID string) error {
+	store, err := p.store(space, org)
+	if err != nil {
+		return err
+	}
+	return store.DeleteDeletionPolicy(space, org, kpID)
+}
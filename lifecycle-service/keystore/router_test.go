@@ -0,0 +1,196 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package keystore_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/mock"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+)
+
+// testPolicyDB keeps the rotation policies of a test backend, which has a store of its own
+type testPolicyDB struct {
+	db.PolicyDB
+	policies map[string]db.RotationPolicy
+}
+
+func (p *testPolicyDB) SetPolicy(policy *db.RotationPolicy) error {
+	p.policies[policy.KpID] = *policy
+	return nil
+}
+
+func (p *testPolicyDB) ListDuePolicies(now time.Time) ([]*db.RotationPolicy, error) {
+	due := []*db.RotationPolicy{}
+	for _, policy := range p.policies {
+		policy := policy
+		if !policy.NextRotation.After(now) {
+			due = append(due, &policy)
+		}
+	}
+	return due, nil
+}
+
+var (
+	testPolicies = &testPolicyDB{policies: make(map[string]db.RotationPolicy)}
+	testKeystore = "test-keystore"
+
+	// errTestUserAuth is returned by the test backend, which can only be called with the token of a user
+	errTestUserAuth = errors.New("Test keystore requires the token of a user")
+)
+
+func init() {
+	keystore.Register(testKeystore, keystore.Backend{
+		NewKeystore: func(auth *communications.Headers, logger log.Logger) (definitions.Keystore, error) {
+			return mock.NewMockKeystore(auth, logger), nil
+		},
+		NewPolicyDB: func() (db.PolicyDB, error) { return testPolicies, nil },
+		PolicyStore: "test",
+		ServiceAuth: func() error { return errTestUserAuth },
+	})
+}
+
+func TestRegister(t *testing.T) {
+	if _, err := keystore.NewKeystore("unregistered", nil, log.NewNopLogger()); err == nil {
+		t.Error("Expected Error")
+	}
+
+	if _, err := keystore.NewPolicyDB(keystore.Mock); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	// Only the backends whose packages are imported are registered
+	registered := keystore.Registered()
+	if len(registered) != 2 || registered[0] != keystore.Mock || registered[1] != testKeystore {
+		t.Errorf("Expected %s and %s, received %v", keystore.Mock, testKeystore, registered)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic registering a backend twice")
+		}
+	}()
+	keystore.Register(keystore.Mock, keystore.Backend{NewKeystore: keystore.NewRouter(keystore.Mock).NewKeystore, NewPolicyDB: func() (db.PolicyDB, error) { return nil, nil }})
+}
+
+func TestRouter(t *testing.T) {
+	router := keystore.NewRouter(keystore.Mock).RouteSpace("space-1", testKeystore).RouteOrg("org-1", testKeystore).RouteSpace("space-2", keystore.Mock)
+
+	routes := []struct {
+		space, org, backend string
+	}{
+		{"space-1", "org-2", testKeystore},
+		{"space-2", "org-1", keystore.Mock},
+		{"space-3", "org-1", testKeystore},
+		{"space-3", "org-3", keystore.Mock},
+	}
+	for _, route := range routes {
+		if backend := router.Backend(route.space, route.org); backend != route.backend {
+			t.Errorf("Expected %s for %s/%s, received %s", route.backend, route.space, route.org, backend)
+		}
+	}
+
+	if err := router.Validate(); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	// Bad Path: a route to a backend that is not registered
+	if _, err := keystore.NewRouter(keystore.Mock).RouteOrg("org-1", "unregistered").NewPolicyDB(); err == nil {
+		t.Error("Expected Error")
+	}
+}
+
+func TestRouterCheckServiceAuth(t *testing.T) {
+	// Backends without a check always authenticate as the service
+	if err := keystore.NewRouter(keystore.Mock).CheckServiceAuth(); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	// Bad Path: a single route to a backend requiring the token of a user fails the check
+	if err := keystore.NewRouter(keystore.Mock).RouteSpace("space-1", testKeystore).CheckServiceAuth(); err != errTestUserAuth {
+		t.Errorf("Expected %s, received %+v", errTestUserAuth, err)
+	}
+
+	// Bad Path: a route to a backend that is not registered
+	if err := keystore.CheckServiceAuth("unregistered"); err == nil {
+		t.Error("Expected Error")
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := keystore.ParseRoutes(" space-1=vault, space-2=local ,")
+	if err != nil || len(routes) != 2 || routes["space-1"] != keystore.Vault || routes["space-2"] != keystore.Local {
+		t.Errorf("Expected two routes, received %v, %+v", routes, err)
+	}
+
+	if routes, err := keystore.ParseRoutes(""); err != nil || len(routes) != 0 {
+		t.Errorf("Expected no routes, received %v, %+v", routes, err)
+	}
+
+	for _, bad := range []string{"space-1", "space-1=", "=vault"} {
+		if _, err := keystore.ParseRoutes(bad); err == nil {
+			t.Errorf("Expected Error for %s", bad)
+		}
+	}
+}
+
+func TestRoutedPolicyDB(t *testing.T) {
+	router := keystore.NewRouter(keystore.Mock).RouteSpace("routed-space", testKeystore)
+	policies, err := router.NewPolicyDB()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	now := time.Now().UTC()
+	routed := &db.RotationPolicy{Space: "routed-space", Org: "org", KpID: "routed-id", NextRotation: now.Add(-time.Hour)}
+	unrouted := &db.RotationPolicy{Space: "mock-space", Org: "org", KpID: "mock-id", NextRotation: now.Add(-time.Hour)}
+	for _, policy := range []*db.RotationPolicy{routed, unrouted} {
+		if err := policies.SetPolicy(policy); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	}
+	defer policies.DeletePolicy(unrouted.Space, unrouted.Org, unrouted.KpID)
+
+	if _, ok := testPolicies.policies["routed-id"]; !ok {
+		t.Error("Expected the policy in the store of the routed backend")
+	}
+	if _, err := mock.NewMockPolicyDB().GetPolicy(unrouted.Space, unrouted.Org, unrouted.KpID); err != nil {
+		t.Errorf("Expected the policy in the mock store, received %+v", err)
+	}
+
+	due, err := policies.ListDuePolicies(now)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	found := make(map[string]bool)
+	for _, policy := range due {
+		found[policy.KpID] = true
+	}
+	if !found["routed-id"] || !found["mock-id"] {
+		t.Errorf("Expected the due policies of both stores, received %v", found)
+	}
+
+	// A tenant routed away from a store no longer has its policies listed from it
+	moved, err := keystore.NewRouter(keystore.Mock).RouteSpace("mock-space", testKeystore).NewPolicyDB()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	due, err = moved.ListDuePolicies(now)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	for _, policy := range due {
+		if policy.KpID == "mock-id" {
+			t.Error("Expected the policy of the mock store to be skipped")
+		}
+	}
+}
@@ -0,0 +1,382 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TokenHeader carries the Vault token of every request
+	TokenHeader = "X-Vault-Token"
+
+	// HTTPClientTimeout specifies the number of seconds the client should wait in seconds before canceling request
+	HTTPClientTimeout = time.Second * 60
+)
+
+// ErrNotFound is returned when Vault has nothing at the requested path
+var ErrNotFound = errors.New(http.StatusText(http.StatusNotFound) + ": Unable to find secret")
+
+// Client is an interface for making calls to the KV (version 2) and transit secrets engines of Vault
+type Client interface {
+	ReadRecord(path string, record interface{}) error
+	WriteRecord(path string, record interface{}) error
+	DeleteRecord(path string) error
+
+	CreateTransitKey(name string, keyType string, exportable bool) error
+	ReadTransitKey(name string) (*TransitKey, error)
+	RotateTransitKey(name string) error
+	ExportTransitKey(name string, version int) (string, error)
+	DeleteTransitKey(name string) error
+	Encrypt(name string, plaintext, context []byte) (string, error)
+	Decrypt(name string, ciphertext string, context []byte) ([]byte, error)
+}
+
+// Config locates Vault, its engines and the credentials of the service. A Token is used as is,
+// otherwise the client logs in with the AppRole of RoleID and SecretID.
+type Config struct {
+	Address      string
+	KVMount      string
+	TransitMount string
+	Token        string
+	RoleID       string
+	SecretID     string
+	// CACert is the PEM file of the CA Vault's certificate is checked against, if not a system CA
+	CACert string
+}
+
+// TransitKey is the part of a transit key the keystore reads
+type TransitKey struct {
+	LatestVersion int  `json:"latest_version"`
+	Exportable    bool `json:"exportable"`
+}
+
+// ErrorResponse is the standard vault error response
+type ErrorResponse struct {
+	StatusCode int
+	Errors     []string `json:"errors"`
+}
+
+// Error will return an ErrorResponse in string Format. Vault errors are failures of the service, unless mapped by the caller.
+func (err *ErrorResponse) Error() string {
+	return http.StatusText(http.StatusInternalServerError) + ": Vault returned " + strconv.Itoa(err.StatusCode) + ": " + strings.Join(err.Errors, ", ")
+}
+
+type vaultClient struct {
+	client *http.Client
+	config Config
+
+	// tokenLock guards the token, which is replaced when an AppRole login expires
+	tokenLock sync.RWMutex
+	token     string
+}
+
+type kvData struct {
+	Data json.RawMessage `json:"data"`
+}
+
+type loginResponse struct {
+	Auth struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+}
+
+func decodeError(response *http.Response, body []byte) error {
+	if response.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	errorResponse := &ErrorResponse{StatusCode: response.StatusCode}
+	if err := json.Unmarshal(body, errorResponse); err != nil || len(errorResponse.Errors) == 0 {
+		errorResponse.Errors = []string{http.StatusText(response.StatusCode)}
+	}
+	return errorResponse
+}
+
+// login exchanges the AppRole credentials for a token
+func (client *vaultClient) login() (string, error) {
+	body, err := json.Marshal(map[string]string{"role_id": client.config.RoleID, "secret_id": client.config.SecretID})
+	if err != nil {
+		return "", err
+	}
+
+	response, err := client.client.Post(client.config.Address+"/v1/auth/approle/login", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return "", decodeError(response, contents)
+	}
+
+	login := new(loginResponse)
+	if err := json.Unmarshal(contents, login); err != nil {
+		return "", err
+	}
+	return login.Auth.ClientToken, nil
+}
+
+// currentToken returns the token of the client, logging in with the AppRole if there is none
+func (client *vaultClient) currentToken(renew bool) (string, error) {
+	client.tokenLock.RLock()
+	token := client.token
+	client.tokenLock.RUnlock()
+
+	if token != "" && !renew {
+		return token, nil
+	}
+
+	if client.config.RoleID == "" {
+		return client.config.Token, nil
+	}
+
+	client.tokenLock.Lock()
+	defer client.tokenLock.Unlock()
+
+	// Another request may have logged in already
+	if client.token != token {
+		return client.token, nil
+	}
+
+	token, err := client.login()
+	if err != nil {
+		return "", err
+	}
+	client.token = token
+	return token, nil
+}
+
+// do sends the request with the token of the client. A request refused with an AppRole token is sent again
+// after logging in, as the token may have expired.
+func (client *vaultClient) do(method, path string, body interface{}) ([]byte, error) {
+	var encoded []byte
+	if body != nil {
+		var err error
+		encoded, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	renew := false
+	for {
+		token, err := client.currentToken(renew)
+		if err != nil {
+			return nil, err
+		}
+
+		request, err := http.NewRequest(method, client.config.Address+"/v1/"+path, bytes.NewBuffer(encoded))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set(TokenHeader, token)
+		if body != nil {
+			request.Header.Set("Content-Type", "application/json")
+		}
+
+		response, err := client.client.Do(request)
+		if err != nil {
+			return nil, err
+		}
+
+		contents, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if response.StatusCode == http.StatusForbidden && client.config.RoleID != "" && !renew {
+			renew = true
+			continue
+		}
+
+		if response.StatusCode < 200 || response.StatusCode >= 300 {
+			return nil, decodeError(response, contents)
+		}
+		return contents, nil
+	}
+}
+
+// ReadRecord reads the latest version of the KV secret at path into record
+func (client *vaultClient) ReadRecord(path string, record interface{}) error {
+	contents, err := client.do("GET", client.config.KVMount+"/data/"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	response := new(struct {
+		Data kvData `json:"data"`
+	})
+	if err := json.Unmarshal(contents, response); err != nil {
+		return err
+	}
+	return json.Unmarshal(response.Data.Data, record)
+}
+
+// WriteRecord writes record as a new version of the KV secret at path
+func (client *vaultClient) WriteRecord(path string, record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.do("POST", client.config.KVMount+"/data/"+path, kvData{Data: data})
+	return err
+}
+
+// DeleteRecord deletes every version of the KV secret at path
+func (client *vaultClient) DeleteRecord(path string) error {
+	_, err := client.do("DELETE", client.config.KVMount+"/metadata/"+path, nil)
+	return err
+}
+
+// CreateTransitKey creates a transit key whose encryption keys are derived from the context of each request
+func (client *vaultClient) CreateTransitKey(name string, keyType string, exportable bool) error {
+	_, err := client.do("POST", client.config.TransitMount+"/keys/"+name, map[string]interface{}{
+		"type":       keyType,
+		"derived":    true,
+		"exportable": exportable,
+	})
+	return err
+}
+
+// ReadTransitKey reads the versions and policy of a transit key
+func (client *vaultClient) ReadTransitKey(name string) (*TransitKey, error) {
+	contents, err := client.do("GET", client.config.TransitMount+"/keys/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response := new(struct {
+		Data TransitKey `json:"data"`
+	})
+	if err := json.Unmarshal(contents, response); err != nil {
+		return nil, err
+	}
+	return &response.Data, nil
+}
+
+// RotateTransitKey adds a new version to the transit key
+func (client *vaultClient) RotateTransitKey(name string) error {
+	_, err := client.do("POST", client.config.TransitMount+"/keys/"+name+"/rotate", nil)
+	return err
+}
+
+// ExportTransitKey returns the base64 material of a version of an exportable transit key
+func (client *vaultClient) ExportTransitKey(name string, version int) (string, error) {
+	contents, err := client.do("GET", client.config.TransitMount+"/export/encryption-key/"+name+"/"+strconv.Itoa(version), nil)
+	if err != nil {
+		return "", err
+	}
+
+	response := new(struct {
+		Data struct {
+			Keys map[string]string `json:"keys"`
+		} `json:"data"`
+	})
+	if err := json.Unmarshal(contents, response); err != nil {
+		return "", err
+	}
+
+	material, ok := response.Data.Keys[strconv.Itoa(version)]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return material, nil
+}
+
+// DeleteTransitKey allows the deletion of the transit key, which Vault refuses by default, and then deletes it
+func (client *vaultClient) DeleteTransitKey(name string) error {
+	if _, err := client.do("POST", client.config.TransitMount+"/keys/"+name+"/config", map[string]interface{}{"deletion_allowed": true}); err != nil {
+		return err
+	}
+
+	_, err := client.do("DELETE", client.config.TransitMount+"/keys/"+name, nil)
+	return err
+}
+
+// Encrypt encrypts the plaintext under the latest version of the transit key. The ciphertext records that version.
+func (client *vaultClient) Encrypt(name string, plaintext, context []byte) (string, error) {
+	contents, err := client.do("POST", client.config.TransitMount+"/encrypt/"+name, map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+		"context":   base64.StdEncoding.EncodeToString(context),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	response := new(struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	})
+	if err := json.Unmarshal(contents, response); err != nil {
+		return "", err
+	}
+	return response.Data.Ciphertext, nil
+}
+
+// Decrypt decrypts a ciphertext of the transit key with the version it records
+func (client *vaultClient) Decrypt(name string, ciphertext string, context []byte) ([]byte, error) {
+	contents, err := client.do("POST", client.config.TransitMount+"/decrypt/"+name, map[string]string{
+		"ciphertext": ciphertext,
+		"context":    base64.StdEncoding.EncodeToString(context),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := new(struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	})
+	if err := json.Unmarshal(contents, response); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(response.Data.Plaintext)
+}
+
+// NewClient will return a new vault Client
+func NewClient(config Config) (Client, error) {
+	client := new(vaultClient)
+	client.client = &http.Client{
+		Timeout: HTTPClientTimeout,
+	}
+
+	if config.CACert != "" {
+		caCert, err := ioutil.ReadFile(config.CACert)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(caCert)
+		client.client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: pool,
+			},
+		}
+	}
+
+	client.config = config
+	client.token = config.Token
+	return client, nil
+}
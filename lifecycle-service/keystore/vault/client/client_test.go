@@ -0,0 +1,112 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// appRoleVault issues a new token on every AppRole login, and only accepts the latest one
+type appRoleVault struct {
+	logins int
+}
+
+func (vault *appRoleVault) token() string {
+	return "token-" + strconv.Itoa(vault.logins)
+}
+
+func (vault *appRoleVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/auth/approle/login" {
+		credentials := make(map[string]string)
+		json.NewDecoder(r.Body).Decode(&credentials)
+		if credentials["role_id"] != "test-role" || credentials["secret_id"] != "test-secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":["invalid role or secret ID"]}`))
+			return
+		}
+
+		vault.logins++
+		json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]string{"client_token": vault.token()}})
+		return
+	}
+
+	if r.Header.Get(TokenHeader) != vault.token() {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"errors":["permission denied"]}`))
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/transit/keys/test-key":
+		w.Write([]byte(`{"data":{"latest_version":3,"exportable":false}}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errors":[]}`))
+	}
+}
+
+func TestAppRoleLogin(t *testing.T) {
+	vault := new(appRoleVault)
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	client, err := NewClient(Config{Address: server.URL, TransitMount: "transit", RoleID: "test-role", SecretID: "test-secret"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	key, err := client.ReadTransitKey("test-key")
+	if err != nil || key.LatestVersion != 3 {
+		t.Fatalf("Expected version 3, received %+v, %+v", key, err)
+	}
+
+	// The token expires, so the client logs in again
+	vault.logins++
+	if _, err := client.ReadTransitKey("test-key"); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	if vault.logins != 3 {
+		t.Errorf("Expected 3 logins, received %d", vault.logins)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	vault := new(appRoleVault)
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	client, err := NewClient(Config{Address: server.URL, TransitMount: "transit", RoleID: "test-role", SecretID: "test-secret"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := client.ReadTransitKey("other-key"); err != ErrNotFound {
+		t.Errorf("Expected %s, received %+v", ErrNotFound, err)
+	}
+
+	// Bad Path: a token that is refused is not renewed
+	client, err = NewClient(Config{Address: server.URL, TransitMount: "transit", Token: "other-token"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	_, err = client.ReadTransitKey("test-key")
+	if vaultErr, ok := err.(*ErrorResponse); !ok || vaultErr.StatusCode != http.StatusForbidden || vaultErr.Errors[0] != "permission denied" {
+		t.Errorf("Expected a 403 ErrorResponse, received %+v", err)
+	}
+
+	// Bad Path: wrong AppRole credentials
+	client, err = NewClient(Config{Address: server.URL, TransitMount: "transit", RoleID: "test-role", SecretID: "wrong-secret"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := client.ReadTransitKey("test-key"); err == nil {
+		t.Error("Expected Error")
+	}
+}
@@ -0,0 +1,11 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package vault
+
+import (
+	registry "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+)
+
+func init() {
+	registry.Register(registry.Vault, registry.Backend{NewKeystore: NewVaultKeystore, NewPolicyDB: registry.SharedPolicyDB, PolicyStore: registry.DBPolicyStore})
+}
@@ -0,0 +1,514 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/algorithms"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/vault/client"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keywrap"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-config"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+// Environment variables holding the credentials of the service, which take precedence over the configuration
+const (
+	TokenEnv    = "VAULT_TOKEN"
+	RoleIDEnv   = "VAULT_ROLE_ID"
+	SecretIDEnv = "VAULT_SECRET_ID"
+)
+
+// transitPrefix starts every ciphertext created by a transit key
+const transitPrefix = "vault:"
+
+var (
+	// ErrNotFound notifies callers when the secret cannot be found in Vault
+	ErrNotFound = client.ErrNotFound
+
+	// ErrNotExtractable notifies callers when key material was requested from a key that never leaves Vault
+	ErrNotExtractable = errors.New(http.StatusText(http.StatusBadRequest) + ": Key material cannot be extracted from Vault")
+
+	clientLock   sync.Mutex
+	sharedClient client.Client
+)
+
+// record is the KV secret kept for every key. Keys generated in the transit engine have no versions in the
+// record, as their material stays in the transit key of the same name.
+type record struct {
+	Org        string   `json:"org"`
+	Transit    bool     `json:"transit,omitempty"`
+	Exportable bool     `json:"exportable,omitempty"`
+	Versions   []string `json:"versions,omitempty"`
+}
+
+type keystore struct {
+	vaultClient client.Client
+	logger      log.Logger
+	headers     *communications.Headers
+}
+
+// keyName is both the KV path of the record of a key and the name of its transit key. The space is hex
+// encoded and the ID must be a UUID, so neither can reach the keys of another space.
+func keyName(space, keyprotectID string) (string, error) {
+	if _, err := uuid.FromString(keyprotectID); err != nil {
+		return "", client.ErrNotFound
+	}
+	return "keyprotect-" + hex.EncodeToString([]byte(space)) + "-" + keyprotectID, nil
+}
+
+// readRecord reads the record of the key, which is only found by the org that created it
+func (s *keystore) readRecord(space, org, keyprotectID string) (string, *record, error) {
+	name, err := keyName(space, keyprotectID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	rec := new(record)
+	if err := s.vaultClient.ReadRecord(name, rec); err != nil {
+		return "", nil, err
+	}
+
+	if rec.Org != org {
+		return "", nil, client.ErrNotFound
+	}
+	return name, rec, nil
+}
+
+// transitKeyType is the transit key type of a generated key, if the transit engine supports its algorithm
+func transitKeyType(spec *algorithms.Spec) (string, bool) {
+	if spec.Algorithm != algorithms.AES || spec.Mode != "GCM" {
+		return "", false
+	}
+
+	switch spec.BitLength {
+	case 128:
+		return "aes128-gcm96", true
+	case 256:
+		return "aes256-gcm96", true
+	default:
+		return "", false
+	}
+}
+
+// isExtractable reports whether the caller may retrieve the payload of the secret, as standard keys are by default
+func isExtractable(secret *secrets.Secret) bool {
+	return secret.Extractable == nil || *secret.Extractable
+}
+
+// GetPayload : Get the payload the secret with the given keyprotect ID was created with.
+// Root keys in the transit engine have no payload, as their material never leaves Vault.
+func (s *keystore) GetPayload(ctx context.Context, keyprotectID string) (string, secrets.KeyStates, error) {
+	payload, _, state, err := s.GetPayloadVersion(ctx, keyprotectID, 1)
+	if err == ErrNotExtractable {
+		return "", secrets.Activation, nil
+	}
+	return payload, state, err
+}
+
+// GetPayloadVersion : Get the payload of a version of the secret with the given keyprotect ID.
+// Root keys in the transit engine return ErrNotExtractable, and are used through WrapKey and UnwrapKey instead.
+func (s *keystore) GetPayloadVersion(ctx context.Context, keyprotectID string, version int) (string, int, secrets.KeyStates, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return "", version, secrets.Destroyed, extractErr
+	}
+
+	name, rec, err := s.readRecord(space, org, keyprotectID)
+	if err != nil {
+		return "", version, secrets.Destroyed, err
+	}
+
+	if !rec.Transit {
+		if version == definitions.CurrentVersion {
+			version = len(rec.Versions)
+		}
+		if version < 1 || version > len(rec.Versions) {
+			return "", version, secrets.Destroyed, definitions.ErrVersionNotFound
+		}
+		return rec.Versions[version-1], version, secrets.Activation, nil
+	}
+
+	if !rec.Exportable {
+		return "", version, secrets.Destroyed, ErrNotExtractable
+	}
+
+	if version == definitions.CurrentVersion {
+		key, err := s.vaultClient.ReadTransitKey(name)
+		if err != nil {
+			return "", version, secrets.Destroyed, err
+		}
+		version = key.LatestVersion
+	}
+
+	payload, err := s.vaultClient.ExportTransitKey(name, version)
+	if err == client.ErrNotFound {
+		return "", version, secrets.Destroyed, definitions.ErrVersionNotFound
+	}
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return "", version, secrets.Destroyed, err
+	}
+	return payload, version, secrets.Activation, nil
+}
+
+// CreateSecret stores the payload of the secret in the KV engine. Generated AES-GCM keys are created in the
+// transit engine, exportable only if they are standard keys, while other algorithms are generated by the service.
+func (s *keystore) CreateSecret(ctx context.Context, secret *secrets.Secret, createTx *transactions.Transaction) (string, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return "", extractErr
+	}
+
+	id := uuid.NewV4().String()
+	name, err := keyName(space, id)
+	if err != nil {
+		return "", err
+	}
+
+	rec := &record{Org: org}
+	if len(secret.Payload) == 0 {
+		spec, err := algorithms.ResolveSecret(secret)
+		if err != nil {
+			return "", err
+		}
+
+		if keyType, ok := transitKeyType(spec); ok {
+			rec.Transit = true
+			rec.Exportable = isExtractable(secret)
+			if err := s.vaultClient.CreateTransitKey(name, keyType, rec.Exportable); err != nil {
+				s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+				return "", err
+			}
+
+			rbDeleteKey := transactions.NewHsmCreateSecretRollback(s.vaultClient.DeleteTransitKey, name)
+			createTx.Add(rbDeleteKey)
+		} else {
+			payload, err := spec.Generate()
+			if err != nil {
+				return "", err
+			}
+			secret.SetPayload(payload)
+		}
+	}
+
+	if !rec.Transit {
+		rec.Versions = []string{secret.Payload}
+	}
+
+	if err := s.vaultClient.WriteRecord(name, rec); err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return "", err
+	}
+
+	rbDeleteRecord := transactions.NewHsmCreateSecretRollback(s.vaultClient.DeleteRecord, name)
+	createTx.Add(rbDeleteRecord)
+
+	secret.SetState(secrets.Activation)
+	return id, nil
+}
+
+// RotateSecret rotates the transit key of the secret, or adds material generated for its algorithm to its record
+func (s *keystore) RotateSecret(ctx context.Context, keyprotectID string, secret *secrets.Secret, rotateTx *transactions.Transaction) (int, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return 0, extractErr
+	}
+
+	name, rec, err := s.readRecord(space, org, keyprotectID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Rotated transit versions cannot be removed, so a failed rotation is not rolled back
+	if rec.Transit {
+		if err := s.vaultClient.RotateTransitKey(name); err != nil {
+			s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+			return 0, err
+		}
+
+		key, err := s.vaultClient.ReadTransitKey(name)
+		if err != nil {
+			return 0, err
+		}
+		return key.LatestVersion, nil
+	}
+
+	spec, err := algorithms.Resolve(secret.AlgorithmType, secret.AlgorithmMetadata)
+	if err != nil {
+		return 0, err
+	}
+
+	payload, err := spec.Generate()
+	if err != nil {
+		return 0, err
+	}
+
+	previous := *rec
+	rec.Versions = append(rec.Versions, payload)
+	if err := s.vaultClient.WriteRecord(name, rec); err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return 0, err
+	}
+
+	rbRestoreRecord := transactions.NewHsmCreateSecretRollback(func(name string) error {
+		return s.vaultClient.WriteRecord(name, &previous)
+	}, name)
+	rotateTx.Add(rbRestoreRecord)
+
+	return len(rec.Versions), nil
+}
+
+// ImportVersion adds the payload of the secret to the record of the key with the given keyprotect ID. Imported
+// keys always keep their material in the KV engine, as transit keys cannot be given material of an older version.
+func (s *keystore) ImportVersion(ctx context.Context, keyprotectID string, version int, secret *secrets.Secret, importTx *transactions.Transaction) error {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return extractErr
+	}
+
+	if len(secret.Payload) == 0 {
+		return definitions.ErrNoPayload
+	}
+
+	if version == 1 {
+		name, err := keyName(space, keyprotectID)
+		if err != nil {
+			return err
+		}
+
+		if err := s.vaultClient.ReadRecord(name, new(record)); err != client.ErrNotFound {
+			if err == nil {
+				err = definitions.ErrVersionConflict
+			}
+			return err
+		}
+
+		if err := s.vaultClient.WriteRecord(name, &record{Org: org, Versions: []string{secret.Payload}}); err != nil {
+			s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+			return err
+		}
+
+		rbDeleteRecord := transactions.NewHsmCreateSecretRollback(s.vaultClient.DeleteRecord, name)
+		importTx.Add(rbDeleteRecord)
+		return nil
+	}
+
+	name, rec, err := s.readRecord(space, org, keyprotectID)
+	if err != nil {
+		return err
+	}
+
+	if rec.Transit || version != len(rec.Versions)+1 {
+		return definitions.ErrVersionConflict
+	}
+
+	previous := *rec
+	rec.Versions = append(rec.Versions, secret.Payload)
+	if err := s.vaultClient.WriteRecord(name, rec); err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return err
+	}
+
+	rbRestoreRecord := transactions.NewHsmCreateSecretRollback(func(name string) error {
+		return s.vaultClient.WriteRecord(name, &previous)
+	}, name)
+	importTx.Add(rbRestoreRecord)
+	return nil
+}
+
+// DeleteSecret Given a keyprotect ID. Delete the user's secret, along with its transit key.
+func (s *keystore) DeleteSecret(ctx context.Context, keyprotectID string, deleteTx *transactions.Transaction) error {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return extractErr
+	}
+
+	name, rec, err := s.readRecord(space, org, keyprotectID)
+	if err != nil {
+		return err
+	}
+
+	if rec.Transit {
+		if err := s.vaultClient.DeleteTransitKey(name); err != nil && err != client.ErrNotFound {
+			s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+			return err
+		}
+	}
+
+	if err := s.vaultClient.DeleteRecord(name); err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return err
+	}
+	return nil
+}
+
+// CheckSecret: Given a keyprotect id, is the secret active? Keys are active as soon as they are in Vault.
+func (s *keystore) CheckSecret(ctx context.Context, keyprotectID string) (secrets.KeyStates, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return secrets.Destroyed, extractErr
+	}
+
+	if _, _, err := s.readRecord(space, org, keyprotectID); err != nil {
+		return secrets.Destroyed, err
+	}
+	return secrets.Activation, nil
+}
+
+// transitContext derives the encryption key of a transit request from the aad, so a ciphertext only decrypts with
+// the aad it was created with. Derived keys need a context, so it is never empty.
+func transitContext(aad []byte) []byte {
+	return append([]byte("keyprotect:"), aad...)
+}
+
+// WrapKey encrypts the plaintext with the transit key of the root key, or with the latest material of its record
+func (s *keystore) WrapKey(ctx context.Context, keyprotectID string, plaintext, aad []byte) ([]byte, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return nil, extractErr
+	}
+
+	name, rec, err := s.readRecord(space, org, keyprotectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !rec.Transit {
+		version := len(rec.Versions)
+		material, err := base64.StdEncoding.DecodeString(rec.Versions[version-1])
+		if err != nil {
+			return nil, keywrap.ErrInvalidKey
+		}
+		return keywrap.Wrap(material, version, plaintext, aad)
+	}
+
+	ciphertext, err := s.vaultClient.Encrypt(name, plaintext, transitContext(aad))
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return nil, err
+	}
+	return []byte(ciphertext), nil
+}
+
+// UnwrapKey decrypts the ciphertext with the version of the root key that created it
+func (s *keystore) UnwrapKey(ctx context.Context, keyprotectID string, ciphertext, aad []byte) ([]byte, error) {
+	org, space, extractErr := definitions.BluemixOrgSpace(s.headers)
+	if extractErr != nil {
+		return nil, extractErr
+	}
+
+	name, rec, err := s.readRecord(space, org, keyprotectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !rec.Transit {
+		version, err := keywrap.KeyVersion(ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		if version > len(rec.Versions) {
+			return nil, definitions.ErrVersionNotFound
+		}
+
+		material, err := base64.StdEncoding.DecodeString(rec.Versions[version-1])
+		if err != nil {
+			return nil, keywrap.ErrInvalidKey
+		}
+		return keywrap.Unwrap(material, ciphertext, aad)
+	}
+
+	if !strings.HasPrefix(string(ciphertext), transitPrefix) {
+		return nil, keywrap.ErrInvalidCiphertext
+	}
+
+	plaintext, err := s.vaultClient.Decrypt(name, string(ciphertext), transitContext(aad))
+	if vaultErr, ok := err.(*client.ErrorResponse); ok && vaultErr.StatusCode == http.StatusBadRequest {
+		return nil, keywrap.ErrInvalidCiphertext
+	}
+	if err != nil {
+		s.logger.Log("err", err.Error(), "correlation_id", s.headers.CorrelationID)
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// newClient reads the location of Vault and the credentials of the service from the configuration and environment
+func newClient() (client.Client, error) {
+	config := configuration.Get()
+	vaultConfig := client.Config{
+		Address:      config.GetString("vault.address"),
+		KVMount:      config.GetString("vault.kvMount"),
+		TransitMount: config.GetString("vault.transitMount"),
+		Token:        config.GetString("vault.token"),
+		RoleID:       config.GetString("vault.roleID"),
+		SecretID:     config.GetString("vault.secretID"),
+		CACert:       config.GetString("vault.caCert"),
+	}
+
+	if token, ok := os.LookupEnv(TokenEnv); ok {
+		vaultConfig.Token = token
+	}
+	if roleID, ok := os.LookupEnv(RoleIDEnv); ok {
+		vaultConfig.RoleID = roleID
+	}
+	if secretID, ok := os.LookupEnv(SecretIDEnv); ok {
+		vaultConfig.SecretID = secretID
+	}
+
+	// A token is used as is, so the AppRole is only used without one
+	if vaultConfig.Token != "" {
+		vaultConfig.RoleID = ""
+	}
+
+	return client.NewClient(vaultConfig)
+}
+
+func newKeystore(vaultClient client.Client, auth *communications.Headers, logger log.Logger) definitions.Keystore {
+	s := new(keystore)
+	s.vaultClient = vaultClient
+	s.headers = auth
+	s.logger = logger
+	return s
+}
+
+// NewVaultKeystore will return a Keystore that keeps keys in the KV and transit engines of the Vault at vault.address.
+// The client, and the token of its AppRole login, are shared by every request.
+func NewVaultKeystore(auth *communications.Headers, logger log.Logger) (definitions.Keystore, error) {
+	vaultClient, err := serviceClient(newClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return newKeystore(vaultClient, auth, logger), nil
+}
+
+// serviceClient returns the client shared by every request, opening it on first use. A client that fails to open,
+// as when Vault cannot be reached to log in, is not kept, so the next request opens it again.
+func serviceClient(open func() (client.Client, error)) (client.Client, error) {
+	clientLock.Lock()
+	defer clientLock.Unlock()
+
+	if sharedClient == nil {
+		vaultClient, err := open()
+		if err != nil {
+			return nil, err
+		}
+		sharedClient = vaultClient
+	}
+	return sharedClient, nil
+}
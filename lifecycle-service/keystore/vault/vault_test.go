@@ -0,0 +1,436 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package vault
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/vault/client"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keywrap"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+const testToken = "test-token"
+
+var testHeaders = &communications.Headers{
+	BluemixSpace:  "test-space",
+	BluemixOrg:    "test-org",
+	CorrelationID: "test-correlation-id",
+}
+
+// fakeTransitKey holds the material of every version of a transit key of the fake Vault
+type fakeTransitKey struct {
+	versions        [][]byte
+	exportable      bool
+	deletionAllowed bool
+}
+
+// fakeVault stands in for the KV version 2 and transit engines of a Vault dev server, mounted at secret and transit
+type fakeVault struct {
+	sync.Mutex
+	records map[string]json.RawMessage
+	keys    map[string]*fakeTransitKey
+}
+
+func newFakeVault() *httptest.Server {
+	vault := &fakeVault{records: make(map[string]json.RawMessage), keys: make(map[string]*fakeTransitKey)}
+	return httptest.NewServer(vault)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeErrors(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string][]string{"errors": {message}})
+}
+
+func (vault *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(client.TokenHeader) != testToken {
+		writeErrors(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	vault.Lock()
+	defer vault.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case strings.HasPrefix(path, "secret/data/"):
+		vault.serveRecord(w, r, strings.TrimPrefix(path, "secret/data/"))
+	case strings.HasPrefix(path, "secret/metadata/") && r.Method == "DELETE":
+		delete(vault.records, strings.TrimPrefix(path, "secret/metadata/"))
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(path, "transit/"):
+		vault.serveTransit(w, r, strings.Split(strings.TrimPrefix(path, "transit/"), "/"))
+	default:
+		writeErrors(w, http.StatusNotFound, "no handler for route")
+	}
+}
+
+func (vault *fakeVault) serveRecord(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case "GET":
+		data, ok := vault.records[name]
+		if !ok {
+			writeErrors(w, http.StatusNotFound, "")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"data": data}})
+	case "POST":
+		body := new(struct {
+			Data json.RawMessage `json:"data"`
+		})
+		json.NewDecoder(r.Body).Decode(body)
+		vault.records[name] = body.Data
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]int{"version": 1}})
+	}
+}
+
+func newMaterial() []byte {
+	material := make([]byte, 32)
+	rand.Read(material)
+	return material
+}
+
+func (vault *fakeVault) serveTransit(w http.ResponseWriter, r *http.Request, parts []string) {
+	body := make(map[string]interface{})
+	json.NewDecoder(r.Body).Decode(&body)
+
+	name := parts[len(parts)-1]
+	if parts[0] == "keys" {
+		name = parts[1]
+	}
+	if parts[0] == "export" {
+		name = parts[2]
+	}
+
+	key, ok := vault.keys[name]
+	if !ok && !(parts[0] == "keys" && len(parts) == 2 && r.Method == "POST") {
+		writeErrors(w, http.StatusNotFound, "")
+		return
+	}
+
+	switch {
+	case parts[0] == "keys" && len(parts) == 2 && r.Method == "POST":
+		if body["derived"] != true {
+			writeErrors(w, http.StatusBadRequest, "expected a derived key")
+			return
+		}
+		vault.keys[name] = &fakeTransitKey{versions: [][]byte{newMaterial()}, exportable: body["exportable"] == true}
+		w.WriteHeader(http.StatusNoContent)
+	case parts[0] == "keys" && len(parts) == 2 && r.Method == "GET":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"latest_version": len(key.versions), "exportable": key.exportable}})
+	case parts[0] == "keys" && len(parts) == 2 && r.Method == "DELETE":
+		if !key.deletionAllowed {
+			writeErrors(w, http.StatusBadRequest, "deletion is not allowed for this key")
+			return
+		}
+		delete(vault.keys, name)
+		w.WriteHeader(http.StatusNoContent)
+	case parts[0] == "keys" && parts[2] == "rotate":
+		key.versions = append(key.versions, newMaterial())
+		w.WriteHeader(http.StatusNoContent)
+	case parts[0] == "keys" && parts[2] == "config":
+		key.deletionAllowed = body["deletion_allowed"] == true
+		w.WriteHeader(http.StatusNoContent)
+	case parts[0] == "export":
+		version, _ := strconv.Atoi(parts[3])
+		if !key.exportable || version < 1 || version > len(key.versions) {
+			writeErrors(w, http.StatusBadRequest, "key is not exportable")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"keys": map[string]string{
+			parts[3]: base64.StdEncoding.EncodeToString(key.versions[version-1])}}})
+	case parts[0] == "encrypt":
+		plaintext, _ := base64.StdEncoding.DecodeString(body["plaintext"].(string))
+		aad, _ := base64.StdEncoding.DecodeString(body["context"].(string))
+		gcm := newFakeGCM(key.versions[len(key.versions)-1])
+		nonce := make([]byte, gcm.NonceSize())
+		rand.Read(nonce)
+		sealed := gcm.Seal(nonce, nonce, plaintext, aad)
+		ciphertext := "vault:v" + strconv.Itoa(len(key.versions)) + ":" + base64.StdEncoding.EncodeToString(sealed)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]string{"ciphertext": ciphertext}})
+	case parts[0] == "decrypt":
+		fields := strings.SplitN(body["ciphertext"].(string), ":", 3)
+		version, _ := strconv.Atoi(strings.TrimPrefix(fields[1], "v"))
+		sealed, _ := base64.StdEncoding.DecodeString(fields[2])
+		aad, _ := base64.StdEncoding.DecodeString(body["context"].(string))
+		gcm := newFakeGCM(key.versions[version-1])
+		plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], aad)
+		if err != nil {
+			writeErrors(w, http.StatusBadRequest, "cipher: message authentication failed")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plaintext)}})
+	default:
+		writeErrors(w, http.StatusNotFound, "no handler for route")
+	}
+}
+
+func newFakeGCM(key []byte) cipher.AEAD {
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	return gcm
+}
+
+func newTestKeystore(t *testing.T, address string, auth *communications.Headers) definitions.Keystore {
+	vaultClient, err := client.NewClient(client.Config{Address: address, KVMount: "secret", TransitMount: "transit", Token: testToken})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return newKeystore(vaultClient, auth, log.NewNopLogger())
+}
+
+func rootKey() *secrets.Secret {
+	extractable := false
+	secret := secrets.NewSecret()
+	secret.Extractable = &extractable
+	return secret
+}
+
+func TestTransitRootKey(t *testing.T) {
+	server := newFakeVault()
+	defer server.Close()
+	s := newTestKeystore(t, server.URL, testHeaders)
+	wrapper := s.(definitions.KeyWrapper)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	secret := rootKey()
+	id, err := s.CreateSecret(context.Background(), secret, &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if payload, state, err := s.GetPayload(context.Background(), id); err != nil || payload != "" || state != secrets.Activation {
+		t.Errorf("Expected an active key without payload, received %s, %d, %+v", payload, state, err)
+	}
+
+	if _, _, _, err := s.GetPayloadVersion(context.Background(), id, definitions.CurrentVersion); err != ErrNotExtractable {
+		t.Errorf("Expected %s, received %+v", ErrNotExtractable, err)
+	}
+
+	ciphertext, err := wrapper.WrapKey(context.Background(), id, []byte("data-encryption-key"), []byte("test-aad"))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	version, err := s.RotateSecret(context.Background(), id, secret, &tx)
+	if err != nil || version != 2 {
+		t.Fatalf("Expected version 2, received %d, %+v", version, err)
+	}
+
+	// The ciphertext of version 1 still unwraps after rotation
+	plaintext, err := wrapper.UnwrapKey(context.Background(), id, ciphertext, []byte("test-aad"))
+	if err != nil || string(plaintext) != "data-encryption-key" {
+		t.Errorf("Expected data-encryption-key, received %s, %+v", plaintext, err)
+	}
+
+	// Bad Path: wrong aad
+	if _, err := wrapper.UnwrapKey(context.Background(), id, ciphertext, []byte("other-aad")); err != keywrap.ErrInvalidCiphertext {
+		t.Errorf("Expected %s, received %+v", keywrap.ErrInvalidCiphertext, err)
+	}
+
+	if err := s.DeleteSecret(context.Background(), id, &tx); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := s.CheckSecret(context.Background(), id); err != ErrNotFound {
+		t.Errorf("Expected %s, received %+v", ErrNotFound, err)
+	}
+}
+
+func TestTransitStandardKey(t *testing.T) {
+	server := newFakeVault()
+	defer server.Close()
+	s := newTestKeystore(t, server.URL, testHeaders)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	secret := secrets.NewSecret()
+	secret.AlgorithmMetadata = map[string]string{"bitLength": "128"}
+	id, err := s.CreateSecret(context.Background(), secret, &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	payload, _, err := s.GetPayload(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if material, _ := base64.StdEncoding.DecodeString(payload); len(material) == 0 {
+		t.Errorf("Expected the exported key material, received %s", payload)
+	}
+}
+
+func TestStoredPayload(t *testing.T) {
+	server := newFakeVault()
+	defer server.Close()
+	s := newTestKeystore(t, server.URL, testHeaders)
+	wrapper := s.(definitions.KeyWrapper)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	// Imported root keys are kept in the KV engine, and wrap in the service
+	secret := rootKey()
+	secret.Payload = base64.StdEncoding.EncodeToString(newMaterial())
+	id, err := s.CreateSecret(context.Background(), secret, &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if payload, _, err := s.GetPayload(context.Background(), id); err != nil || payload != secret.Payload {
+		t.Errorf("Expected %s, received %s, %+v", secret.Payload, payload, err)
+	}
+
+	ciphertext, err := wrapper.WrapKey(context.Background(), id, []byte("data-encryption-key"), nil)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if version, err := s.RotateSecret(context.Background(), id, secrets.NewSecret(), &tx); err != nil || version != 2 {
+		t.Fatalf("Expected version 2, received %d, %+v", version, err)
+	}
+
+	if plaintext, err := wrapper.UnwrapKey(context.Background(), id, ciphertext, nil); err != nil || string(plaintext) != "data-encryption-key" {
+		t.Errorf("Expected data-encryption-key, received %s, %+v", plaintext, err)
+	}
+
+	// Another space or org cannot find it
+	others := []*communications.Headers{
+		{BluemixSpace: "other-space", BluemixOrg: "test-org"},
+		{BluemixSpace: "test-space", BluemixOrg: "other-org"},
+	}
+	for _, headers := range others {
+		if _, err := newTestKeystore(t, server.URL, headers).CheckSecret(context.Background(), id); err != ErrNotFound {
+			t.Errorf("Expected %s, received %+v", ErrNotFound, err)
+		}
+	}
+}
+
+func TestCreateRollback(t *testing.T) {
+	server := newFakeVault()
+	defer server.Close()
+	s := newTestKeystore(t, server.URL, testHeaders)
+
+	tx := transactions.NewTransaction()
+	id, err := s.CreateSecret(context.Background(), rootKey(), &tx)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if err := tx.Clean(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := s.CheckSecret(context.Background(), id); err != ErrNotFound {
+		t.Errorf("Expected %s, received %+v", ErrNotFound, err)
+	}
+}
+
+func TestImportVersion(t *testing.T) {
+	server := newFakeVault()
+	defer server.Close()
+	s := newTestKeystore(t, server.URL, testHeaders)
+	importer := s.(definitions.KeyImporter)
+
+	tx := transactions.NewTransaction()
+	defer tx.Complete()
+
+	// Keys are imported under the ID they have in another keystore
+	id := uuid.NewV4().String()
+	payloads := []string{base64.StdEncoding.EncodeToString(newMaterial()), base64.StdEncoding.EncodeToString(newMaterial())}
+	for version, payload := range payloads {
+		secret := rootKey()
+		secret.Payload = payload
+		if err := importer.ImportVersion(context.Background(), id, version+1, secret, &tx); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	}
+
+	if payload, version, _, err := s.GetPayloadVersion(context.Background(), id, definitions.CurrentVersion); err != nil || version != 2 || payload != payloads[1] {
+		t.Errorf("Expected the second version, received %s, %d, %+v", payload, version, err)
+	}
+
+	// Bad Path: versions must follow the latest one, and carry material
+	secret := rootKey()
+	secret.Payload = payloads[0]
+	for _, version := range []int{1, 2, 4} {
+		if err := importer.ImportVersion(context.Background(), id, version, secret, &tx); err != definitions.ErrVersionConflict {
+			t.Errorf("Expected %s for version %d, received %+v", definitions.ErrVersionConflict, version, err)
+		}
+	}
+	if err := importer.ImportVersion(context.Background(), id, 3, rootKey(), &tx); err != definitions.ErrNoPayload {
+		t.Errorf("Expected %s, received %+v", definitions.ErrNoPayload, err)
+	}
+
+	// Rolling back the import of a new key removes it
+	rollbackID := uuid.NewV4().String()
+	rollbackTx := transactions.NewTransaction()
+	if err := importer.ImportVersion(context.Background(), rollbackID, 1, secret, &rollbackTx); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := rollbackTx.Clean(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := s.CheckSecret(context.Background(), rollbackID); err != ErrNotFound {
+		t.Errorf("Expected %s, received %+v", ErrNotFound, err)
+	}
+}
+
+func TestServiceClient(t *testing.T) {
+	defer func() { sharedClient = nil }()
+	sharedClient = nil
+
+	errOpen := errors.New("vault unavailable")
+	opened := 0
+	vaultClient, err := client.NewClient(client.Config{Address: "http://127.0.0.1:8200", KVMount: "secret", TransitMount: "transit", Token: testToken})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// Bad Path: a client that fails to open is not kept
+	if _, err := serviceClient(func() (client.Client, error) { opened++; return nil, errOpen }); err != errOpen {
+		t.Errorf("Expected %s, received %+v", errOpen, err)
+	}
+
+	// the next request opens it again, and the client is then shared
+	for i := 0; i < 2; i++ {
+		received, err := serviceClient(func() (client.Client, error) { opened++; return vaultClient, nil })
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		if received != vaultClient {
+			t.Errorf("Expected %+v, received %+v", vaultClient, received)
+		}
+	}
+
+	if opened != 2 {
+		t.Errorf("Expected the client to be opened 2 times, opened %d", opened)
+	}
+}
@@ -0,0 +1,177 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package keywrap
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// DataKeyLength is the length in bytes of data encryption keys generated by the service
+const DataKeyLength = 32
+
+// Every ciphertext starts with a header made of the format version followed by the
+// version of the root key that created it, as a big endian uint32.
+const (
+	formatVersion byte = 1
+	headerLength       = 5
+)
+
+var (
+	// ErrInvalidKey is returned when the root key material cannot be used as an AES key
+	ErrInvalidKey = errors.New(http.StatusText(http.StatusInternalServerError) + ": Root key material is not a valid AES key")
+
+	// ErrInvalidKeyVersion is returned when wrapping with a root key version that cannot be recorded in the header
+	ErrInvalidKeyVersion = errors.New(http.StatusText(http.StatusInternalServerError) + ": Invalid root key version")
+
+	// ErrInvalidCiphertext is returned when a ciphertext is malformed, was not created by the given
+	// root key or the additional authenticated data does not match.
+	ErrInvalidCiphertext = errors.New(http.StatusText(http.StatusBadRequest) + ": Unable to unwrap ciphertext")
+)
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// additionalData authenticates the header along with the caller's aad so the key version cannot be altered
+func additionalData(header, aad []byte) []byte {
+	data := make([]byte, 0, len(header)+len(aad))
+	data = append(data, header...)
+	return append(data, aad...)
+}
+
+// NewDataKey generates a random 256-bit data encryption key
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, DataKeyLength)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// KeyVersion returns the version of the root key that was used to create the ciphertext
+func KeyVersion(ciphertext []byte) (int, error) {
+	if len(ciphertext) < headerLength || ciphertext[0] != formatVersion {
+		return 0, ErrInvalidCiphertext
+	}
+
+	version := binary.BigEndian.Uint32(ciphertext[1:headerLength])
+	if version == 0 {
+		return 0, ErrInvalidCiphertext
+	}
+
+	return int(version), nil
+}
+
+// Sealer seals and opens with AES-GCM under a single version of a root key. It lets keystores whose
+// material never leaves them, such as HSMs, create the same ciphertexts as Wrap.
+type Sealer interface {
+	NonceSize() int
+	Seal(nonce, plaintext, additionalData []byte) ([]byte, error)
+	Open(nonce, ciphertext, additionalData []byte) ([]byte, error)
+}
+
+// gcmSealer seals with root key material held by the service
+type gcmSealer struct {
+	gcm cipher.AEAD
+}
+
+func (s gcmSealer) NonceSize() int {
+	return s.gcm.NonceSize()
+}
+
+func (s gcmSealer) Seal(nonce, plaintext, additionalData []byte) ([]byte, error) {
+	return s.gcm.Seal(nil, nonce, plaintext, additionalData), nil
+}
+
+func (s gcmSealer) Open(nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	return s.gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// Wrap seals plaintext with AES-GCM under the given version of a root key. aad is authenticated but not encrypted
+// and must be passed to Unwrap unchanged. The returned ciphertext is the header, the nonce and then the sealed data.
+func Wrap(key []byte, version int, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return WrapWith(gcmSealer{gcm}, version, plaintext, aad)
+}
+
+// WrapWith is Wrap for a root key version held by the sealer
+func WrapWith(sealer Sealer, version int, plaintext, aad []byte) ([]byte, error) {
+	if version < 1 || int64(version) > int64(^uint32(0)) {
+		return nil, ErrInvalidKeyVersion
+	}
+
+	ciphertext := make([]byte, headerLength+sealer.NonceSize())
+	ciphertext[0] = formatVersion
+	binary.BigEndian.PutUint32(ciphertext[1:headerLength], uint32(version))
+
+	nonce := ciphertext[headerLength:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	sealed, err := sealer.Seal(nonce, plaintext, additionalData(ciphertext[:headerLength], aad))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(ciphertext, sealed...), nil
+}
+
+// Unwrap opens a ciphertext created by Wrap with the same root key version and aad.
+func Unwrap(key, ciphertext, aad []byte) ([]byte, error) {
+	if _, err := KeyVersion(ciphertext); err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < headerLength+gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrInvalidCiphertext
+	}
+
+	return UnwrapWith(gcmSealer{gcm}, ciphertext, aad)
+}
+
+// UnwrapWith is Unwrap for the root key version held by the sealer, which must be the version recorded in the ciphertext
+func UnwrapWith(sealer Sealer, ciphertext, aad []byte) ([]byte, error) {
+	if _, err := KeyVersion(ciphertext); err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) <= headerLength+sealer.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+
+	nonce := ciphertext[headerLength : headerLength+sealer.NonceSize()]
+	sealed := ciphertext[headerLength+sealer.NonceSize():]
+	plaintext, err := sealer.Open(nonce, sealed, additionalData(ciphertext[:headerLength], aad))
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,180 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package keywrap
+
+import (
+	"bytes"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, 32)
+
+func TestWrapUnwrap(t *testing.T) {
+	plaintext := []byte("data-encryption-key")
+	aad := []byte("test-aad")
+
+	ciphertext, err := Wrap(testKey, 1, plaintext, aad)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Error("Ciphertext contains the plaintext")
+	}
+
+	unwrapped, err := Unwrap(testKey, ciphertext, aad)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if !bytes.Equal(plaintext, unwrapped) {
+		t.Errorf("Expected %s, received %s", plaintext, unwrapped)
+	}
+}
+
+func TestKeyVersion(t *testing.T) {
+	ciphertext, err := Wrap(testKey, 7, []byte("data-encryption-key"), nil)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	version, err := KeyVersion(ciphertext)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if version != 7 {
+		t.Errorf("Expected %d, received %d", 7, version)
+	}
+
+	// Bad Path: tampering with the version breaks authentication
+	ciphertext[headerLength-1] = 8
+	if _, err := Unwrap(testKey, ciphertext, nil); err != ErrInvalidCiphertext {
+		t.Errorf("Expected %s, received %+v", ErrInvalidCiphertext, err)
+	}
+
+	// Bad Path: unknown format
+	ciphertext[0] = formatVersion + 1
+	if _, err := KeyVersion(ciphertext); err != ErrInvalidCiphertext {
+		t.Errorf("Expected %s, received %+v", ErrInvalidCiphertext, err)
+	}
+
+	if _, err := Wrap(testKey, 0, []byte("data-encryption-key"), nil); err != ErrInvalidKeyVersion {
+		t.Errorf("Expected %s, received %+v", ErrInvalidKeyVersion, err)
+	}
+}
+
+func TestUnwrapWrongAAD(t *testing.T) {
+	ciphertext, err := Wrap(testKey, 1, []byte("data-encryption-key"), []byte("test-aad"))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := Unwrap(testKey, ciphertext, []byte("other-aad")); err != ErrInvalidCiphertext {
+		t.Errorf("Expected %s, received %+v", ErrInvalidCiphertext, err)
+	}
+
+	if _, err := Unwrap(testKey, ciphertext, nil); err != ErrInvalidCiphertext {
+		t.Errorf("Expected %s, received %+v", ErrInvalidCiphertext, err)
+	}
+}
+
+func TestUnwrapWrongKey(t *testing.T) {
+	ciphertext, err := Wrap(testKey, 1, []byte("data-encryption-key"), nil)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	otherKey := bytes.Repeat([]byte{0x24}, 32)
+	if _, err := Unwrap(otherKey, ciphertext, nil); err != ErrInvalidCiphertext {
+		t.Errorf("Expected %s, received %+v", ErrInvalidCiphertext, err)
+	}
+}
+
+func TestUnwrapMalformedCiphertext(t *testing.T) {
+	if _, err := Unwrap(testKey, []byte("short"), nil); err != ErrInvalidCiphertext {
+		t.Errorf("Expected %s, received %+v", ErrInvalidCiphertext, err)
+	}
+
+	if _, err := Unwrap(testKey, []byte{formatVersion, 0, 0, 0, 1, 0}, nil); err != ErrInvalidCiphertext {
+		t.Errorf("Expected %s, received %+v", ErrInvalidCiphertext, err)
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	if _, err := Wrap([]byte("too-short"), 1, []byte("data-encryption-key"), nil); err != ErrInvalidKey {
+		t.Errorf("Expected %s, received %+v", ErrInvalidKey, err)
+	}
+
+	ciphertext, err := Wrap(testKey, 1, []byte("data-encryption-key"), nil)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := Unwrap([]byte("too-short"), ciphertext, nil); err != ErrInvalidKey {
+		t.Errorf("Expected %s, received %+v", ErrInvalidKey, err)
+	}
+}
+
+func TestNewDataKey(t *testing.T) {
+	key, err := NewDataKey()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if len(key) != DataKeyLength {
+		t.Errorf("Expected %d, received %d", DataKeyLength, len(key))
+	}
+
+	otherKey, err := NewDataKey()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if bytes.Equal(key, otherKey) {
+		t.Error("Expected unique data keys")
+	}
+}
+
+// failingSealer stands in for a keystore that cannot seal, such as an HSM that is unavailable
+type failingSealer struct{}
+
+func (failingSealer) NonceSize() int { return 12 }
+
+func (failingSealer) Seal(nonce, plaintext, additionalData []byte) ([]byte, error) {
+	return nil, ErrInvalidKey
+}
+
+func (failingSealer) Open(nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	return nil, ErrInvalidKey
+}
+
+func TestWrapWithSealer(t *testing.T) {
+	gcm, err := newGCM(testKey)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// Ciphertexts of a sealer are interchangeable with those of the material it holds
+	ciphertext, err := WrapWith(gcmSealer{gcm}, 2, []byte("data-encryption-key"), []byte("test-aad"))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if version, err := KeyVersion(ciphertext); err != nil || version != 2 {
+		t.Errorf("Expected version 2, received %d, %+v", version, err)
+	}
+
+	if unwrapped, err := Unwrap(testKey, ciphertext, []byte("test-aad")); err != nil || string(unwrapped) != "data-encryption-key" {
+		t.Errorf("Expected data-encryption-key, received %s, %+v", unwrapped, err)
+	}
+
+	// Bad Path: the sealer fails
+	if _, err := WrapWith(failingSealer{}, 1, []byte("data-encryption-key"), nil); err != ErrInvalidKey {
+		t.Errorf("Expected %s, received %+v", ErrInvalidKey, err)
+	}
+
+	if _, err := UnwrapWith(failingSealer{}, ciphertext, nil); err != ErrInvalidCiphertext {
+		t.Errorf("Expected %s, received %+v", ErrInvalidCiphertext, err)
+	}
+}
@@ -0,0 +1,64 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package migration
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// Checkpoint records the KP IDs of migrated keys in a file, one per line, so a migration can resume where it stopped.
+// A nil Checkpoint records nothing.
+type Checkpoint struct {
+	file *os.File
+	done map[string]bool
+}
+
+// OpenCheckpoint reads the keys recorded in the file at path, creating it if needed, and appends to it
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if kpID := strings.TrimSpace(scanner.Text()); kpID != "" {
+			done[kpID] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &Checkpoint{file: file, done: done}, nil
+}
+
+// Done reports whether the key was recorded as migrated
+func (c *Checkpoint) Done(kpID string) bool {
+	return c != nil && c.done[kpID]
+}
+
+// Record adds the key to the checkpoint, syncing the file so the record survives a crash
+func (c *Checkpoint) Record(kpID string) error {
+	if c == nil {
+		return nil
+	}
+
+	if _, err := c.file.WriteString(kpID + "\n"); err != nil {
+		return err
+	}
+	c.done[kpID] = true
+	return c.file.Sync()
+}
+
+// Close closes the file of the checkpoint
+func (c *Checkpoint) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.file.Close()
+}
@@ -0,0 +1,300 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+// Package migration copies the key material of a space from one keystore backend to another. Keys keep their KP
+// ID. Their ID translation rows are left to the source until the space is cut over to the target, as the source
+// still serves the keys until then, and are then updated to name the backend now holding them.
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+// Status of a key in the report of a migration
+const (
+	// StatusMigrated keys are held by the target, and still served by the source
+	StatusMigrated = "migrated"
+
+	// StatusCutOver keys are held by the target, and their translation rows name it
+	StatusCutOver = "cut-over"
+
+	// StatusSkipped keys were migrated, or cut over, by an earlier run
+	StatusSkipped = "skipped"
+
+	// StatusDryRun keys could be read from the source, and would be migrated
+	StatusDryRun = "dry-run"
+
+	// StatusFailed keys are left as they were, in the source only
+	StatusFailed = "failed"
+)
+
+// ErrNotImporter notifies callers when the target keystore cannot hold keys under their KP ID
+var ErrNotImporter = errors.New(http.StatusText(http.StatusBadRequest) + ": Target keystore cannot import keys")
+
+// ErrNotMigrated notifies callers when a key cannot be cut over, as the target does not hold every version of it
+var ErrNotMigrated = errors.New(http.StatusText(http.StatusConflict) + ": Key has versions missing from the target")
+
+// Result is the line of the report for a key
+type Result struct {
+	Space    string `json:"space"`
+	KpID     string `json:"kp_id"`
+	Status   string `json:"status"`
+	Versions int    `json:"versions"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Summary counts the keys of a migration by status
+type Summary map[string]int
+
+// Migrator copies keys from a source keystore to a target keystore, which must be able to import keys
+type Migrator struct {
+	source     definitions.Keystore
+	target     definitions.Keystore
+	importer   definitions.KeyImporter
+	targetName string
+	database   db.DB
+	logger     log.Logger
+
+	// DryRun reads every key from the source without writing to the target or the translation table
+	DryRun bool
+}
+
+// NewMigrator returns a Migrator to the target keystore, registered as the backend targetName
+func NewMigrator(source, target definitions.Keystore, targetName string, database db.DB, logger log.Logger) (*Migrator, error) {
+	importer, ok := target.(definitions.KeyImporter)
+	if !ok {
+		return nil, ErrNotImporter
+	}
+
+	return &Migrator{
+		source:     source,
+		target:     target,
+		importer:   importer,
+		targetName: targetName,
+		database:   database,
+		logger:     logger,
+	}, nil
+}
+
+// isNotFound reports whether the error of a keystore is a Not Found
+func isNotFound(err error) bool {
+	return strings.HasPrefix(err.Error(), http.StatusText(http.StatusNotFound))
+}
+
+// Run migrates every key of the space in the translation table, writing a line of JSON to the report for each.
+// Keys recorded in the checkpoint, or whose rows already name the target, are skipped, so a failed run can be
+// resumed by running it again. The checkpoint may be nil. The translation rows are left as they are, see CutOver.
+func (m *Migrator) Run(ctx context.Context, space, org string, checkpoint *Checkpoint, report io.Writer) (Summary, error) {
+	keys, err := m.database.ListKeys(space, org)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].KpID < keys[j].KpID })
+
+	encoder := json.NewEncoder(report)
+	summary := make(Summary)
+	for _, refs := range keys {
+		result := Result{Space: space, KpID: refs.KpID}
+		switch {
+		case checkpoint.Done(refs.KpID) || refs.Keystore() == m.targetName:
+			result.Status = StatusSkipped
+		default:
+			result.Versions, err = m.migrateKey(ctx, refs.KpID)
+			switch {
+			case err != nil:
+				result.Status = StatusFailed
+				result.Error = err.Error()
+				m.logger.Log("err", err.Error(), "kp_id", refs.KpID)
+			case m.DryRun:
+				result.Status = StatusDryRun
+			default:
+				result.Status = StatusMigrated
+				if err := checkpoint.Record(refs.KpID); err != nil {
+					return summary, err
+				}
+			}
+		}
+
+		summary[result.Status]++
+		if err := encoder.Encode(&result); err != nil {
+			return summary, err
+		}
+	}
+
+	if failed := summary[StatusFailed]; failed > 0 {
+		return summary, fmt.Errorf("%d of %d keys failed to migrate", failed, len(keys))
+	}
+	return summary, nil
+}
+
+// CutOver updates the translation rows of every migrated key of the space to name the target, writing a line of
+// JSON to the report for each. It is run once the space is routed to the target, as the source cannot serve keys
+// whose rows name another backend. Keys the target does not hold every version of are left to the source and
+// reported as failed, so they can be migrated again before a second run.
+func (m *Migrator) CutOver(ctx context.Context, space, org string, report io.Writer) (Summary, error) {
+	keys, err := m.database.ListKeys(space, org)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].KpID < keys[j].KpID })
+
+	encoder := json.NewEncoder(report)
+	summary := make(Summary)
+	for _, refs := range keys {
+		result := Result{Space: space, KpID: refs.KpID}
+		if refs.Keystore() == m.targetName {
+			result.Status = StatusSkipped
+		} else if result.Versions, err = m.cutOverKey(ctx, space, org, refs.KpID); err != nil {
+			result.Status = StatusFailed
+			result.Error = err.Error()
+			m.logger.Log("err", err.Error(), "kp_id", refs.KpID)
+		} else {
+			result.Status = StatusCutOver
+		}
+
+		summary[result.Status]++
+		if err := encoder.Encode(&result); err != nil {
+			return summary, err
+		}
+	}
+
+	if failed := summary[StatusFailed]; failed > 0 {
+		return summary, fmt.Errorf("%d of %d keys failed to cut over", failed, len(keys))
+	}
+	return summary, nil
+}
+
+// migrateKey imports the versions of the key the target is missing and verifies the material of every version.
+// The imports are rolled back if the verification fails.
+func (m *Migrator) migrateKey(ctx context.Context, kpID string) (int, error) {
+	payloads, err := m.readSource(ctx, kpID)
+	if err != nil {
+		return 0, err
+	}
+	if m.DryRun {
+		return len(payloads), nil
+	}
+
+	_, synced, _, err := m.target.GetPayloadVersion(ctx, kpID, definitions.CurrentVersion)
+	if err != nil {
+		if !isNotFound(err) {
+			return 0, err
+		}
+		synced = 0
+	}
+	if synced > len(payloads) {
+		return 0, errors.New(http.StatusText(http.StatusConflict) + ": Target holds more versions than the source")
+	}
+
+	migrateTx := transactions.NewTransaction()
+	if err := m.writeTarget(ctx, kpID, payloads, synced, &migrateTx); err != nil {
+		if cleanErr := migrateTx.Clean(); cleanErr != nil {
+			m.logger.Log("err", cleanErr.Error(), "kp_id", kpID)
+		}
+		return 0, err
+	}
+	migrateTx.Complete()
+
+	return len(payloads), nil
+}
+
+// readSource returns the payload of every version of the key, from the first to the latest
+func (m *Migrator) readSource(ctx context.Context, kpID string) ([]string, error) {
+	_, latest, _, err := m.source.GetPayloadVersion(ctx, kpID, definitions.CurrentVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	payloads := make([]string, latest)
+	for version := 1; version <= latest; version++ {
+		payload, _, _, err := m.source.GetPayloadVersion(ctx, kpID, version)
+		if err != nil {
+			return nil, err
+		}
+		if len(payload) == 0 {
+			return nil, errors.New(http.StatusText(http.StatusConflict) + ": Version " + strconv.Itoa(version) + " has no material yet")
+		}
+		payloads[version-1] = payload
+	}
+	return payloads, nil
+}
+
+func (m *Migrator) writeTarget(ctx context.Context, kpID string, payloads []string, synced int, migrateTx *transactions.Transaction) error {
+	for version := synced + 1; version <= len(payloads); version++ {
+		imported := secrets.NewSecret()
+		imported.Payload = payloads[version-1]
+		if err := m.importer.ImportVersion(ctx, kpID, version, imported, migrateTx); err != nil {
+			return err
+		}
+	}
+	return m.verifyTarget(ctx, kpID, payloads)
+}
+
+// verifyTarget checks that the target holds the material of every version of the key
+func (m *Migrator) verifyTarget(ctx context.Context, kpID string, payloads []string) error {
+	for version, payload := range payloads {
+		copied, _, _, err := m.target.GetPayloadVersion(ctx, kpID, version+1)
+		if err != nil {
+			return err
+		}
+		if copied != payload {
+			return errors.New(http.StatusText(http.StatusConflict) + ": Version " + strconv.Itoa(version+1) + " differs in the target")
+		}
+	}
+	return nil
+}
+
+// cutOverKey updates the translation rows of the key to name the target, once the target is verified to hold
+// every version of it. Rows already updated are restored if a later one fails.
+func (m *Migrator) cutOverKey(ctx context.Context, space, org, kpID string) (int, error) {
+	rows, err := m.database.ListVersions(space, org, kpID)
+	if err != nil {
+		return 0, err
+	}
+
+	payloads, err := m.readSource(ctx, kpID)
+	if err != nil {
+		return 0, err
+	}
+	if len(payloads) != len(rows) {
+		return 0, ErrNotMigrated
+	}
+	if err := m.verifyTarget(ctx, kpID, payloads); err != nil {
+		if isNotFound(err) {
+			return 0, ErrNotMigrated
+		}
+		return 0, err
+	}
+
+	cutOverTx := transactions.NewTransaction()
+	for _, original := range rows {
+		if err := m.database.Update(space, org, db.MigratedRefs(kpID, original.Version, m.targetName)); err != nil {
+			if cleanErr := cutOverTx.Clean(); cleanErr != nil {
+				m.logger.Log("err", cleanErr.Error(), "kp_id", kpID)
+			}
+			return 0, err
+		}
+
+		rbRestoreRefs := transactions.NewKeyIDRollback(func(kpID string, space string, org string, i interface{}) error {
+			return m.database.Update(space, org, i.(*db.BarbicanRefs))
+		}, kpID, space, org, original)
+		cutOverTx.Add(rbRestoreRefs)
+	}
+	cutOverTx.Complete()
+
+	return len(rows), nil
+}
@@ -0,0 +1,298 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package migration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+const (
+	testSpace  = "test-space"
+	testOrg    = "test-org"
+	testTarget = "test-target"
+)
+
+var (
+	errTestNotFound = errors.New(http.StatusText(http.StatusNotFound) + ": Unable to find secret")
+	errTestFailure  = errors.New(http.StatusText(http.StatusServiceUnavailable) + ": Injected failure")
+)
+
+// testKeystore keeps versions in memory
+type testKeystore struct {
+	definitions.Keystore
+	keys map[string][]string
+}
+
+func newTestKeystore() *testKeystore {
+	return &testKeystore{keys: make(map[string][]string)}
+}
+
+func (s *testKeystore) remove(id string) error {
+	delete(s.keys, id)
+	return nil
+}
+
+func (s *testKeystore) GetPayloadVersion(ctx context.Context, id string, version int) (string, int, secrets.KeyStates, error) {
+	versions, ok := s.keys[id]
+	if !ok {
+		return "", version, secrets.Destroyed, errTestNotFound
+	}
+	if version == definitions.CurrentVersion {
+		version = len(versions)
+	}
+	if version < 1 || version > len(versions) {
+		return "", version, secrets.Destroyed, errTestNotFound
+	}
+	return versions[version-1], version, secrets.Activation, nil
+}
+
+func (s *testKeystore) ImportVersion(ctx context.Context, id string, version int, secret *secrets.Secret, tx *transactions.Transaction) error {
+	if version != len(s.keys[id])+1 {
+		return errors.New(http.StatusText(http.StatusConflict) + ": Version conflict")
+	}
+	s.keys[id] = append(s.keys[id], secret.Payload)
+	if version == 1 {
+		tx.Add(transactions.NewHsmCreateSecretRollback(s.remove, id))
+	}
+	return nil
+}
+
+// testDB keeps the translation rows of a single space in memory
+type testDB struct {
+	db.DB
+	rows map[string][]*db.BarbicanRefs
+
+	// failVersion is the version whose row fails to be updated to name another backend
+	failVersion int
+}
+
+func (d *testDB) add(kpID string, versions int) {
+	for version := 1; version <= versions; version++ {
+		d.rows[kpID] = append(d.rows[kpID], &db.BarbicanRefs{KpID: kpID, SecretID: kpID + "-secret", Version: version})
+	}
+}
+
+func (d *testDB) ListKeys(space string, org string) ([]*db.BarbicanRefs, error) {
+	keys := []*db.BarbicanRefs{}
+	for _, rows := range d.rows {
+		keys = append(keys, rows[0])
+	}
+	return keys, nil
+}
+
+func (d *testDB) ListVersions(space string, org string, kpID string) ([]*db.BarbicanRefs, error) {
+	rows := []*db.BarbicanRefs{}
+	for _, refs := range d.rows[kpID] {
+		copied := *refs
+		rows = append(rows, &copied)
+	}
+	return rows, nil
+}
+
+func (d *testDB) Update(space string, org string, refs *db.BarbicanRefs) error {
+	if refs.Keystore() != "" && refs.Version == d.failVersion {
+		return errTestFailure
+	}
+	copied := *refs
+	d.rows[refs.KpID][refs.Version-1] = &copied
+	return nil
+}
+
+func newTestMigration(t *testing.T) (*Migrator, *testKeystore, *testKeystore, *testDB) {
+	source, target := newTestKeystore(), newTestKeystore()
+	database := &testDB{rows: make(map[string][]*db.BarbicanRefs)}
+
+	source.keys["key-1"] = []string{"key-1-v1"}
+	source.keys["key-2"] = []string{"key-2-v1", "key-2-v2"}
+	database.add("key-1", 1)
+	database.add("key-2", 2)
+
+	migrator, err := NewMigrator(source, target, testTarget, database, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return migrator, source, target, database
+}
+
+func readReport(t *testing.T, report *bytes.Buffer) map[string]Result {
+	results := make(map[string]Result)
+	decoder := json.NewDecoder(report)
+	for decoder.More() {
+		var result Result
+		if err := decoder.Decode(&result); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		results[result.KpID] = result
+	}
+	return results
+}
+
+func TestMigrate(t *testing.T) {
+	migrator, source, target, database := newTestMigration(t)
+
+	report := new(bytes.Buffer)
+	summary, err := migrator.Run(context.Background(), testSpace, testOrg, nil, report)
+	if err != nil || summary[StatusMigrated] != 2 {
+		t.Fatalf("Expected two migrated keys, received %v, %+v", summary, err)
+	}
+
+	results := readReport(t, report)
+	if results["key-2"].Status != StatusMigrated || results["key-2"].Versions != 2 {
+		t.Errorf("Expected key-2 to be migrated with two versions, received %+v", results["key-2"])
+	}
+
+	// The source still serves the keys, so their rows are left to it
+	for id, versions := range source.keys {
+		for i, payload := range versions {
+			if target.keys[id][i] != payload {
+				t.Errorf("Expected %s as version %d of %s in the target, received %v", payload, i+1, id, target.keys[id])
+			}
+			if backend := database.rows[id][i].Keystore(); backend != "" {
+				t.Errorf("Expected the row of version %d of %s to be left to the source, received %s", i+1, id, backend)
+			}
+		}
+	}
+
+	summary, err = migrator.CutOver(context.Background(), testSpace, testOrg, new(bytes.Buffer))
+	if err != nil || summary[StatusCutOver] != 2 {
+		t.Fatalf("Expected two keys cut over, received %v, %+v", summary, err)
+	}
+	for id, versions := range source.keys {
+		for i := range versions {
+			if backend := database.rows[id][i].Keystore(); backend != testTarget {
+				t.Errorf("Expected the row of version %d of %s to name %s, received %s", i+1, id, testTarget, backend)
+			}
+		}
+	}
+
+	// Later runs skip the keys whose rows already name the target
+	summary, err = migrator.Run(context.Background(), testSpace, testOrg, nil, new(bytes.Buffer))
+	if err != nil || summary[StatusSkipped] != 2 {
+		t.Errorf("Expected two skipped keys, received %v, %+v", summary, err)
+	}
+	summary, err = migrator.CutOver(context.Background(), testSpace, testOrg, new(bytes.Buffer))
+	if err != nil || summary[StatusSkipped] != 2 {
+		t.Errorf("Expected two skipped keys, received %v, %+v", summary, err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	migrator, _, target, database := newTestMigration(t)
+	migrator.DryRun = true
+
+	summary, err := migrator.Run(context.Background(), testSpace, testOrg, nil, new(bytes.Buffer))
+	if err != nil || summary[StatusDryRun] != 2 {
+		t.Fatalf("Expected two dry-run keys, received %v, %+v", summary, err)
+	}
+
+	if len(target.keys) != 0 || database.rows["key-1"][0].Keystore() != "" {
+		t.Errorf("Expected nothing to be written, received %v", target.keys)
+	}
+}
+
+func TestFailedKey(t *testing.T) {
+	migrator, source, _, database := newTestMigration(t)
+
+	// Bad Path: a key missing from the source fails without stopping the others
+	delete(source.keys, "key-1")
+	report := new(bytes.Buffer)
+	summary, err := migrator.Run(context.Background(), testSpace, testOrg, nil, report)
+	if err == nil || summary[StatusFailed] != 1 || summary[StatusMigrated] != 1 {
+		t.Fatalf("Expected one failed and one migrated key, received %v, %+v", summary, err)
+	}
+	if result := readReport(t, report)["key-1"]; result.Error != errTestNotFound.Error() {
+		t.Errorf("Expected %s in the report, received %+v", errTestNotFound, result)
+	}
+
+	// Bad Path: keys the target does not hold every version of are left to the source on cut over, whether they
+	// failed to migrate or were rotated since
+	source.keys["key-1"] = []string{"key-1-v1"}
+	source.keys["key-2"] = append(source.keys["key-2"], "key-2-v3")
+	database.rows["key-2"] = append(database.rows["key-2"], &db.BarbicanRefs{KpID: "key-2", SecretID: "key-2-secret", Version: 3})
+
+	report = new(bytes.Buffer)
+	summary, err = migrator.CutOver(context.Background(), testSpace, testOrg, report)
+	if err == nil || summary[StatusFailed] != 2 {
+		t.Fatalf("Expected two failed keys, received %v, %+v", summary, err)
+	}
+	for id, result := range readReport(t, report) {
+		if result.Error != ErrNotMigrated.Error() {
+			t.Errorf("Expected %s in the report, received %+v", ErrNotMigrated, result)
+		}
+		if database.rows[id][0].Keystore() != "" {
+			t.Errorf("Expected the rows to be left to the source, received %+v", database.rows[id][0])
+		}
+	}
+}
+
+func TestCutOverFailure(t *testing.T) {
+	migrator, _, _, database := newTestMigration(t)
+	if _, err := migrator.Run(context.Background(), testSpace, testOrg, nil, new(bytes.Buffer)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// Bad Path: failing to update the row of the second version of key-2 restores the row of its first
+	database.failVersion = 2
+	summary, err := migrator.CutOver(context.Background(), testSpace, testOrg, new(bytes.Buffer))
+	if err == nil || summary[StatusFailed] != 1 || summary[StatusCutOver] != 1 {
+		t.Fatalf("Expected one failed key and one cut over, received %v, %+v", summary, err)
+	}
+	for _, row := range database.rows["key-2"] {
+		if row.SecretID != "key-2-secret" {
+			t.Errorf("Expected the row to be restored, received %+v", row)
+		}
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migration")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint")
+
+	if err := ioutil.WriteFile(path, []byte("key-1\n"), 0600); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	checkpoint, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	migrator, _, target, _ := newTestMigration(t)
+	summary, err := migrator.Run(context.Background(), testSpace, testOrg, checkpoint, new(bytes.Buffer))
+	checkpoint.Close()
+	if err != nil || summary[StatusSkipped] != 1 || summary[StatusMigrated] != 1 {
+		t.Fatalf("Expected one skipped and one migrated key, received %v, %+v", summary, err)
+	}
+	if _, ok := target.keys["key-1"]; ok {
+		t.Error("Expected key-1 to be skipped")
+	}
+
+	// The migrated key is recorded for the next run
+	resumed, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer resumed.Close()
+	if !resumed.Done("key-1") || !resumed.Done("key-2") {
+		t.Errorf("Expected both keys in the checkpoint, received %v", resumed.done)
+	}
+}
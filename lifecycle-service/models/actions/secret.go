@@ -2,10 +2,45 @@
 
 package actions
 
+// Supported actions on a secret, passed by the action query parameter
+const (
+	Wrap    = "wrap"
+	Unwrap  = "unwrap"
+	Rewrap  = "rewrap"
+	Rotate  = "rotate"
+	Policy  = "policy"
+	Disable = "disable"
+	Enable  = "enable"
+	Sign    = "sign"
+	Verify  = "verify"
+)
+
 // SecretAction is the main struct used for all secret actions
-// wrap, unwrap
+// wrap, unwrap, rewrap, rotate, policy, disable, enable, sign, verify
 type SecretAction struct {
-	Plaintext  string `json:"plaintext,omitempty"`
-	Ciphertext string `json:"ciphertext,omitempty"`
-	AAD        string `json:"aad,omitempty"`
+	Plaintext      string          `json:"plaintext,omitempty"`
+	Ciphertext     string          `json:"ciphertext,omitempty"`
+	AAD            string          `json:"aad,omitempty"`
+	RotationPolicy *RotationPolicy `json:"rotationPolicy,omitempty"`
+	DualAuthDelete *DualAuthDelete `json:"dualAuthDelete,omitempty"`
+
+	// Digest is the base64 encoded SHA-256 digest that is signed or verified
+	Digest    string `json:"digest,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	// SignatureAlgorithm and Verified are only returned by the service
+	SignatureAlgorithm string `json:"signatureAlgorithm,omitempty"`
+	Verified           *bool  `json:"verified,omitempty"`
+}
+
+// RotationPolicy sets how often the service rotates a root key. An interval of zero removes the policy.
+// The rotation dates are RFC3339 and only returned by the service.
+type RotationPolicy struct {
+	IntervalDays     int    `json:"intervalDays"`
+	LastRotationDate string `json:"lastRotationDate,omitempty"`
+	NextRotationDate string `json:"nextRotationDate,omitempty"`
+}
+
+// DualAuthDelete sets whether deleting a key must be authorized by two different users
+type DualAuthDelete struct {
+	Enabled bool `json:"enabled"`
 }
@@ -11,7 +11,8 @@ import (
 type SecretActionRequest struct {
 	*communications.BaseRequest
 	*actions.SecretAction
-	ID string
+	ID     string
+	Action string
 }
 
 // NewSecretActionRequest creates a new SecretActionRequest
@@ -2,14 +2,36 @@
 
 package communications
 
-import "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/actions"
+import (
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/actions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/tokens"
+)
 
 // SecretActionResponse are used for all responses for actions on secrets
 type SecretActionResponse struct {
 	*actions.SecretAction
 }
 
+// NewSecretActionResponse creates a new SecretActionResponse
+func NewSecretActionResponse() *SecretActionResponse {
+	response := new(SecretActionResponse)
+	response.SecretAction = new(actions.SecretAction)
+	return response
+}
+
 // GetBody is a method get the response body as an empty interface
 func (response *SecretActionRequest) GetBody() interface{} {
 	return response.SecretAction
 }
+
+// ImportTokenResponse is used for responses to the creation of an import token
+type ImportTokenResponse struct {
+	*tokens.ImportToken
+}
+
+// NewImportTokenResponse creates a new ImportTokenResponse
+func NewImportTokenResponse() *ImportTokenResponse {
+	response := new(ImportTokenResponse)
+	response.ImportToken = new(tokens.ImportToken)
+	return response
+}
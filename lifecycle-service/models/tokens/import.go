@@ -0,0 +1,11 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package tokens
+
+// ImportToken is returned when an import token is created. Key material to import is encrypted
+// under the base64 encoded DER public key, with the encryption algorithm, before the token expires.
+type ImportToken struct {
+	PublicKey           string `json:"publicKey"`
+	EncryptionAlgorithm string `json:"encryptionAlgorithm"`
+	ExpirationDate      string `json:"expirationDate"`
+}
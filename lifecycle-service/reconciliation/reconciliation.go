@@ -0,0 +1,167 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+// Package reconciliation rebuilds the metadata database from the copy a keystore mirrors next to the key
+// material. Keys of a space in the ID translation table with no metadata are restored under their KP ID.
+package reconciliation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+
+	dbDef "github.ibm.com/Alchemy-Key-Protect/go-db-service/services/metadata/service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+// Status of a key in the report of a reconciliation
+const (
+	// StatusPresent keys already have metadata, which is left as it is
+	StatusPresent = "present"
+
+	// StatusRestored keys had no metadata, and were given the metadata mirrored in the keystore
+	StatusRestored = "restored"
+
+	// StatusSkipped keys were migrated to another backend, which mirrors no metadata
+	StatusSkipped = "skipped"
+
+	// StatusDryRun keys have no metadata, and would be restored
+	StatusDryRun = "dry-run"
+
+	// StatusFailed keys have no metadata, and could not be restored
+	StatusFailed = "failed"
+)
+
+// ErrNotMirror notifies callers when the keystore keeps no copy of the metadata of its keys
+var ErrNotMirror = errors.New(http.StatusText(http.StatusBadRequest) + ": Keystore does not mirror key metadata")
+
+// Result is the line of the report for a key
+type Result struct {
+	KpID   string `json:"kp_id"`
+	Status string `json:"status"`
+	Name   string `json:"name,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Summary counts the keys of a reconciliation by status
+type Summary map[string]int
+
+// Reconciler restores the metadata of keys from the keystore holding them
+type Reconciler struct {
+	mirror   definitions.MetadataMirror
+	database db.DB
+	metadata dbDef.Service
+	headers  *communications.Headers
+	logger   log.Logger
+
+	// DryRun reads the mirrored metadata of every missing key without writing to the metadata database
+	DryRun bool
+}
+
+// NewReconciler returns a Reconciler restoring metadata from the keystore into the metadata database, with the
+// headers of the space being reconciled
+func NewReconciler(keystore definitions.Keystore, database db.DB, metadata dbDef.Service, headers *communications.Headers, logger log.Logger) (*Reconciler, error) {
+	mirror, ok := keystore.(definitions.MetadataMirror)
+	if !ok {
+		return nil, ErrNotMirror
+	}
+
+	return &Reconciler{
+		mirror:   mirror,
+		database: database,
+		metadata: metadata,
+		headers:  headers,
+		logger:   logger,
+	}, nil
+}
+
+// isNotFound reports whether the error of the metadata database is a Not Found
+func isNotFound(err error) bool {
+	return strings.HasPrefix(err.Error(), http.StatusText(http.StatusNotFound))
+}
+
+// Run restores the metadata of every key of the space in the translation table that has none, writing a line of
+// JSON to the report for each. Keys with metadata are never overwritten, so a failed run can be run again.
+func (r *Reconciler) Run(ctx context.Context, space, org string, report io.Writer) (Summary, error) {
+	keys, err := r.database.ListKeys(space, org)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].KpID < keys[j].KpID })
+
+	encoder := json.NewEncoder(report)
+	summary := make(Summary)
+	for _, refs := range keys {
+		result := Result{KpID: refs.KpID}
+		if refs.Keystore() != "" {
+			result.Status = StatusSkipped
+		} else {
+			var restored *secrets.Secret
+			restored, result.Status, err = r.reconcileKey(ctx, refs.KpID)
+			if restored != nil {
+				result.Name = restored.Name
+			}
+			if err != nil {
+				result.Status = StatusFailed
+				result.Error = err.Error()
+				r.logger.Log("err", err.Error(), "kp_id", refs.KpID)
+			}
+		}
+
+		summary[result.Status]++
+		if err := encoder.Encode(&result); err != nil {
+			return summary, err
+		}
+	}
+
+	if failed := summary[StatusFailed]; failed > 0 {
+		return summary, fmt.Errorf("%d of %d keys failed to reconcile", failed, len(keys))
+	}
+	return summary, nil
+}
+
+// reconcileKey restores the metadata of the key if the metadata database has none, returning the restored metadata
+func (r *Reconciler) reconcileKey(ctx context.Context, kpID string) (*secrets.Secret, string, error) {
+	getRequest := communications.NewIDRequest()
+	getRequest.SetHeaders(r.headers)
+	getRequest.SetID(kpID)
+
+	response, err := r.metadata.Get(ctx, getRequest)
+	switch {
+	case err != nil && !isNotFound(err):
+		return nil, StatusFailed, err
+	case err == nil && len(response.Secrets) > 0 && response.Secrets[0] != nil:
+		return nil, StatusPresent, nil
+	}
+
+	// Keys are restored with the state and extractability they were mirrored with, never a guess, so a
+	// suspended key is not enabled and a root key is not made extractable
+	restored, err := r.mirror.GetMetadata(ctx, kpID)
+	if err != nil {
+		return nil, StatusFailed, err
+	}
+	if restored.Extractable == nil {
+		return nil, StatusFailed, definitions.ErrMetadataIncomplete
+	}
+
+	if r.DryRun {
+		return restored, StatusDryRun, nil
+	}
+
+	createRequest := communications.NewSecretRequest()
+	createRequest.SetHeaders(r.headers)
+	createRequest.SetSecret(restored)
+	if _, err := r.metadata.Create(ctx, createRequest); err != nil {
+		return restored, StatusFailed, err
+	}
+	return restored, StatusRestored, nil
+}
@@ -0,0 +1,219 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package reconciliation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+
+	"github.ibm.com/Alchemy-Key-Protect/go-db-service/services/metadata/service/definitions"
+	keystoreDef "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+const (
+	testSpace = "test-space"
+	testOrg   = "test-org"
+)
+
+var (
+	errTestNotFound = errors.New(http.StatusText(http.StatusNotFound) + ": Unable to find secret")
+	errTestFailure  = errors.New(http.StatusText(http.StatusServiceUnavailable) + ": Injected failure")
+)
+
+// testKeystore mirrors the metadata of its keys in memory
+type testKeystore struct {
+	keystoreDef.Keystore
+	mirrored map[string]*secrets.Secret
+}
+
+func (s *testKeystore) PutMetadata(ctx context.Context, id string, secret *secrets.Secret) error {
+	s.mirrored[id] = secret
+	return nil
+}
+
+func (s *testKeystore) GetMetadata(ctx context.Context, id string) (*secrets.Secret, error) {
+	secret, ok := s.mirrored[id]
+	if !ok {
+		return nil, errTestNotFound
+	}
+	return secret, nil
+}
+
+// testDB keeps the translation rows of a single space in memory
+type testDB struct {
+	db.DB
+	keys []*db.BarbicanRefs
+}
+
+func (d *testDB) ListKeys(space string, org string) ([]*db.BarbicanRefs, error) {
+	return d.keys, nil
+}
+
+// testMetadata is a metadata database of a single space
+type testMetadata struct {
+	definitions.Service
+	secrets map[string]*secrets.Secret
+	getErr  error
+}
+
+func (m *testMetadata) Get(ctx context.Context, request *communications.IDRequest) (*communications.SecretsResponse, error) {
+	if m.getErr != nil {
+		return nil, m.getErr
+	}
+	secret, ok := m.secrets[request.ID]
+	if !ok {
+		return nil, errTestNotFound
+	}
+	return &communications.SecretsResponse{Secrets: []*secrets.Secret{secret}}, nil
+}
+
+func (m *testMetadata) Create(ctx context.Context, request *communications.SecretRequest) (*communications.SecretsResponse, error) {
+	m.secrets[request.Secret.ID] = request.Secret
+	return &communications.SecretsResponse{Secrets: []*secrets.Secret{request.Secret}}, nil
+}
+
+func newTestSecret(id string, state secrets.KeyStates) *secrets.Secret {
+	extractable := false
+	secret := secrets.NewSecret()
+	secret.SetID(id)
+	secret.SetState(state)
+	secret.Extractable = &extractable
+	return secret
+}
+
+func newTestReconciler(t *testing.T) (*Reconciler, *testKeystore, *testMetadata) {
+	keystore := &testKeystore{mirrored: map[string]*secrets.Secret{
+		"lost-key":     newTestSecret("lost-key", secrets.Preactivation),
+		"present-key":  newTestSecret("present-key", secrets.Preactivation),
+		"disabled-key": newTestSecret("disabled-key", secrets.Suspended),
+	}}
+	keystore.mirrored["lost-key"].Name = "lost"
+
+	database := &testDB{keys: []*db.BarbicanRefs{
+		{KpID: "present-key", SecretID: "present-secret"},
+		{KpID: "lost-key", SecretID: "lost-secret"},
+		{KpID: "disabled-key", SecretID: "disabled-secret"},
+		db.MigratedRefs("migrated-key", db.FirstVersion, "local"),
+	}}
+
+	metadata := &testMetadata{secrets: map[string]*secrets.Secret{"present-key": newTestSecret("present-key", secrets.Activation)}}
+
+	headers := &communications.Headers{BluemixSpace: testSpace, BluemixOrg: testOrg}
+	reconciler, err := NewReconciler(keystore, database, metadata, headers, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return reconciler, keystore, metadata
+}
+
+func decodeReport(t *testing.T, report *bytes.Buffer) map[string]Result {
+	results := make(map[string]Result)
+	decoder := json.NewDecoder(report)
+	for decoder.More() {
+		var result Result
+		if err := decoder.Decode(&result); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		results[result.KpID] = result
+	}
+	return results
+}
+
+func TestRun(t *testing.T) {
+	reconciler, _, metadata := newTestReconciler(t)
+
+	report := new(bytes.Buffer)
+	summary, err := reconciler.Run(context.Background(), testSpace, testOrg, report)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if summary[StatusRestored] != 2 || summary[StatusPresent] != 1 || summary[StatusSkipped] != 1 {
+		t.Errorf("Expected 2 restored, 1 present and 1 skipped, received %v", summary)
+	}
+
+	results := decodeReport(t, report)
+	if results["lost-key"].Status != StatusRestored || results["lost-key"].Name != "lost" {
+		t.Errorf("Expected lost-key to be restored, received %+v", results["lost-key"])
+	}
+
+	// Keys are restored with the state and extractability they were mirrored with
+	if restored := metadata.secrets["lost-key"]; restored == nil || restored.State != secrets.Preactivation || *restored.Extractable {
+		t.Errorf("Expected lost-key to stay a pending root key, received %+v", restored)
+	}
+	if restored := metadata.secrets["disabled-key"]; restored == nil || restored.State != secrets.Suspended {
+		t.Errorf("Expected disabled-key to stay suspended, received %+v", restored)
+	}
+
+	// A second run finds every key present
+	summary, err = reconciler.Run(context.Background(), testSpace, testOrg, new(bytes.Buffer))
+	if err != nil || summary[StatusPresent] != 3 {
+		t.Errorf("Expected 3 present, received %v, %+v", summary, err)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	reconciler, _, metadata := newTestReconciler(t)
+	reconciler.DryRun = true
+
+	summary, err := reconciler.Run(context.Background(), testSpace, testOrg, new(bytes.Buffer))
+	if err != nil || summary[StatusDryRun] != 2 {
+		t.Errorf("Expected 2 dry-run, received %v, %+v", summary, err)
+	}
+	if len(metadata.secrets) != 1 {
+		t.Errorf("Expected nothing to be written, received %d keys", len(metadata.secrets))
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	reconciler, keystore, metadata := newTestReconciler(t)
+	delete(keystore.mirrored, "lost-key")
+
+	report := new(bytes.Buffer)
+	summary, err := reconciler.Run(context.Background(), testSpace, testOrg, report)
+	if err == nil || summary[StatusFailed] != 1 || summary[StatusRestored] != 1 {
+		t.Errorf("Expected 1 failed and 1 restored, received %v, %+v", summary, err)
+	}
+	if result := decodeReport(t, report)["lost-key"]; result.Error != errTestNotFound.Error() {
+		t.Errorf("Expected %s, received %+v", errTestNotFound, result)
+	}
+
+	// Bad Path: the metadata database cannot be read, so nothing is restored
+	metadata.getErr = errTestFailure
+	summary, err = reconciler.Run(context.Background(), testSpace, testOrg, new(bytes.Buffer))
+	if err == nil || summary[StatusFailed] != 3 {
+		t.Errorf("Expected 3 failed, received %v, %+v", summary, err)
+	}
+}
+
+func TestRunIncomplete(t *testing.T) {
+	reconciler, keystore, metadata := newTestReconciler(t)
+
+	// Bad Path: a key mirrored without its extractability is not restored
+	keystore.mirrored["disabled-key"].Extractable = nil
+	report := new(bytes.Buffer)
+	summary, err := reconciler.Run(context.Background(), testSpace, testOrg, report)
+	if err == nil || summary[StatusFailed] != 1 || summary[StatusRestored] != 1 {
+		t.Errorf("Expected 1 failed and 1 restored, received %v, %+v", summary, err)
+	}
+	if result := decodeReport(t, report)["disabled-key"]; result.Error != keystoreDef.ErrMetadataIncomplete.Error() {
+		t.Errorf("Expected %s, received %+v", keystoreDef.ErrMetadataIncomplete, result)
+	}
+	if _, ok := metadata.secrets["disabled-key"]; ok {
+		t.Error("Expected disabled-key not to be restored")
+	}
+}
+
+func TestNewReconcilerNotMirror(t *testing.T) {
+	if _, err := NewReconciler(struct{ keystoreDef.Keystore }{}, nil, nil, nil, log.NewNopLogger()); err != ErrNotMirror {
+		t.Errorf("Expected %s, received %+v", ErrNotMirror, err)
+	}
+}
@@ -79,6 +79,59 @@ func (analyticsMiddleWare *analyticsService) Post(ctx context.Context, request *
 	return analyticsMiddleWare.Service.Post(ctx, request)
 }
 
+func (analyticsMiddleWare *analyticsService) Import(ctx context.Context, request *communications.SecretRequest) (*communications.SecretsResponse, error) {
+	headers := request.GetHeaders()
+
+	userGUID := headers.UserID
+	if userGUID == "" {
+		return nil, errors.New(http.StatusText(http.StatusBadRequest) + ": Request requires User ID")
+	}
+
+	analyticsMiddleWare.segmentClient.Identify(&segmentio.Identify{
+		UserId: userGUID,
+	})
+
+	eventDescription := configuration.Get().GetString("analytics.prefix") + "Created Secret"
+	analyticsMiddleWare.segmentClient.Track(&segmentio.Track{
+		Event:  eventDescription,
+		UserId: userGUID,
+		Properties: map[string]interface{}{
+			"Environment": analyticsMiddleWare.environment,
+			"Region":      analyticsMiddleWare.region,
+			"Space":       headers.BluemixSpace,
+			"Operation":   "imported",
+		},
+	})
+
+	return analyticsMiddleWare.Service.Import(ctx, request)
+}
+
+func (analyticsMiddleWare *analyticsService) ImportToken(ctx context.Context, request *communications.BaseRequest) (*corecomms.ImportTokenResponse, error) {
+	headers := request.GetHeaders()
+
+	userGUID := headers.UserID
+	if userGUID == "" {
+		return nil, errors.New(http.StatusText(http.StatusBadRequest) + ": Request requires User ID")
+	}
+
+	analyticsMiddleWare.segmentClient.Identify(&segmentio.Identify{
+		UserId: userGUID,
+	})
+
+	eventDescription := configuration.Get().GetString("analytics.prefix") + "Created Import Token"
+	analyticsMiddleWare.segmentClient.Track(&segmentio.Track{
+		Event:  eventDescription,
+		UserId: userGUID,
+		Properties: map[string]interface{}{
+			"Environment": analyticsMiddleWare.environment,
+			"Region":      analyticsMiddleWare.region,
+			"Space":       headers.BluemixSpace,
+		},
+	})
+
+	return analyticsMiddleWare.Service.ImportToken(ctx, request)
+}
+
 func (analyticsMiddleWare *analyticsService) Actions(ctx context.Context, request *corecomms.SecretActionRequest) (*corecomms.SecretActionResponse, error) {
 	headers := request.GetHeaders()
 
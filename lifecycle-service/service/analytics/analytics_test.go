@@ -71,6 +71,38 @@ func TestPost(t *testing.T) {
 	}
 }
 
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+
+	request := communications.NewSecretRequest()
+	_, err := testService.Import(ctx, request)
+	if err == nil {
+		t.Fail()
+	}
+
+	request.Headers.UserID = "test-user-id"
+	_, err = testService.Import(ctx, request)
+	if err != nil {
+		t.Fail()
+	}
+}
+
+func TestImportToken(t *testing.T) {
+	ctx := context.Background()
+
+	request := communications.NewBaseRequest()
+	_, err := testService.ImportToken(ctx, request)
+	if err == nil {
+		t.Fail()
+	}
+
+	request.Headers.UserID = "test-user-id"
+	_, err = testService.ImportToken(ctx, request)
+	if err != nil {
+		t.Fail()
+	}
+}
+
 func TestActions(t *testing.T) {
 	ctx := context.Background()
 
@@ -0,0 +1,448 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package basic
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"context"
+
+	"github.com/go-kit/kit/log"
+	"google.golang.org/grpc"
+
+	"github.ibm.com/Alchemy-Key-Protect/go-db-service/services/metadata/client"
+	dbDef "github.ibm.com/Alchemy-Key-Protect/go-db-service/services/metadata/service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keywrap"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/actions"
+	corecomms "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+/* Helper functions for actions on root keys
+ */
+
+// rootKey holds what an action needs to operate on an active root key, including the context of the request,
+// which cancels the calls an action makes to the keystore
+type rootKey struct {
+	ctx      context.Context
+	id       string
+	metadata *secrets.Secret
+	keystore definitions.Keystore
+	headers  *communications.Headers
+}
+
+// errKeySuspended is returned when a suspended key is used or its payload is requested
+var errKeySuspended = errors.New(http.StatusText(http.StatusConflict) + ": Key is suspended")
+
+// errDeletionPolicyEnabled is returned when disabling the dual authorization policy of a key that has it
+var errDeletionPolicyEnabled = errors.New(http.StatusText(http.StatusConflict) + ": Dual authorization for deletion cannot be disabled once enabled")
+
+// actionHandler performs an action on the request body with the versions of a root key held by the keystore
+type actionHandler func(key *rootKey, action *actions.SecretAction) (*corecomms.SecretActionResponse, error)
+
+// getMetadata looks up the metadata for the given key
+func getMetadata(ctx context.Context, client dbDef.Service, headers *communications.Headers, id string) (*secrets.Secret, error) {
+	getRequest := communications.NewIDRequest()
+	getRequest.SetHeaders(headers)
+	getRequest.SetID(id)
+
+	dbResponse, errDbResponse := client.Get(ctx, getRequest)
+	if errDbResponse != nil {
+		return nil, errDbResponse
+	}
+
+	if dbResponse.Secrets == nil || len(dbResponse.Secrets) == 0 || dbResponse.Secrets[0] == nil {
+		return nil, errors.New(http.StatusText(http.StatusNotFound) + ": Unable to find secret with given ID")
+	}
+
+	return dbResponse.Secrets[0], nil
+}
+
+// getRootKey looks up the metadata for the given key and ensures it is an active root key
+// before returning the keystore that holds its material.
+func (svc *basicService) getRootKey(ctx context.Context, headers *communications.Headers, id string) (*rootKey, error) {
+	conn, err := grpc.Dial(dbServerPath, grpc.WithInsecure(), grpc.WithTimeout(time.Second*time.Duration(timeout)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	metadata, err := getMetadata(ctx, client.NewClient(conn, log.NewNopLogger()), headers, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata.Extractable == nil || *metadata.Extractable != false {
+		return nil, errors.New(http.StatusText(http.StatusBadRequest) + ": Actions are only supported on root keys")
+	}
+
+	if metadata.State == secrets.Suspended {
+		return nil, errKeySuspended
+	}
+
+	if metadata.State != secrets.Activation {
+		return nil, errors.New(http.StatusText(http.StatusConflict) + ": Key is not in the Activation state")
+	}
+
+	expired, err := handleExpirationTime(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if expired {
+		return nil, errors.New(http.StatusText(http.StatusConflict) + ": Key is expired")
+	}
+
+	secretService, errNewStrat := svc.keystores.NewKeystore(headers, svc.logger)
+	if errNewStrat != nil {
+		return nil, errNewStrat
+	}
+
+	return &rootKey{ctx: ctx, id: id, metadata: metadata, keystore: secretService, headers: headers}, nil
+}
+
+// getKeyMaterial returns the raw material of a version of the root key along with that version, which is resolved
+// to the latest one when definitions.CurrentVersion is requested. The material must never be returned to the caller.
+func getKeyMaterial(key *rootKey, version int) ([]byte, int, error) {
+	payload, version, state, errPayload := key.keystore.GetPayloadVersion(key.ctx, key.id, version)
+	if errPayload != nil {
+		return nil, version, errPayload
+	}
+
+	if state != secrets.Activation {
+		return nil, version, errors.New(http.StatusText(http.StatusConflict) + ": Key is not in the Activation state")
+	}
+
+	material, errDecode := base64.StdEncoding.DecodeString(payload)
+	if errDecode != nil {
+		return nil, version, keywrap.ErrInvalidKey
+	}
+
+	return material, version, nil
+}
+
+// decodeActionField decodes a base64 field of an action request body
+func decodeActionField(name, value string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New(http.StatusText(http.StatusBadRequest) + ": " + name + " must be base64 encoded")
+	}
+	return decoded, nil
+}
+
+// wrapWithKey encrypts the plaintext under the current version of the root key, inside the keystore
+// when it is a definitions.KeyWrapper
+func wrapWithKey(key *rootKey, plaintext, aad []byte) ([]byte, error) {
+	if wrapper, ok := key.keystore.(definitions.KeyWrapper); ok {
+		return wrapper.WrapKey(key.ctx, key.id, plaintext, aad)
+	}
+
+	material, version, err := getKeyMaterial(key, definitions.CurrentVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return keywrap.Wrap(material, version, plaintext, aad)
+}
+
+// wrapAction encrypts the plaintext of the request under the current version of the root key.
+// If no plaintext is provided, a new data encryption key is generated and returned along with its ciphertext.
+func wrapAction(key *rootKey, action *actions.SecretAction) (*corecomms.SecretActionResponse, error) {
+	var plaintext []byte
+	var err error
+	generated := len(action.Plaintext) == 0
+	if generated {
+		plaintext, err = keywrap.NewDataKey()
+	} else {
+		plaintext, err = decodeActionField("Plaintext", action.Plaintext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := wrapWithKey(key, plaintext, []byte(action.AAD))
+	if err != nil {
+		return nil, err
+	}
+
+	response := corecomms.NewSecretActionResponse()
+	response.Ciphertext = base64.StdEncoding.EncodeToString(ciphertext)
+	if generated {
+		response.Plaintext = base64.StdEncoding.EncodeToString(plaintext)
+	}
+	return response, nil
+}
+
+// unwrapCiphertext decrypts the ciphertext of the request with the version of the root key recorded in its header,
+// inside the keystore when it is a definitions.KeyWrapper
+func unwrapCiphertext(key *rootKey, action *actions.SecretAction) ([]byte, error) {
+	ciphertext, err := decodeActionField("Ciphertext", action.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	if wrapper, ok := key.keystore.(definitions.KeyWrapper); ok {
+		return wrapper.UnwrapKey(key.ctx, key.id, ciphertext, []byte(action.AAD))
+	}
+
+	version, err := keywrap.KeyVersion(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	material, _, err := getKeyMaterial(key, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return keywrap.Unwrap(material, ciphertext, []byte(action.AAD))
+}
+
+// unwrapAction decrypts the ciphertext of the request with the version of the root key that created it
+func unwrapAction(key *rootKey, action *actions.SecretAction) (*corecomms.SecretActionResponse, error) {
+	plaintext, err := unwrapCiphertext(key, action)
+	if err != nil {
+		return nil, err
+	}
+
+	response := corecomms.NewSecretActionResponse()
+	response.Plaintext = base64.StdEncoding.EncodeToString(plaintext)
+	return response, nil
+}
+
+// rewrapAction decrypts the ciphertext of the request and encrypts it again under the current version of the root key.
+// The plaintext is never returned to the caller.
+func rewrapAction(key *rootKey, action *actions.SecretAction) (*corecomms.SecretActionResponse, error) {
+	plaintext, err := unwrapCiphertext(key, action)
+	if err != nil {
+		return nil, err
+	}
+
+	rewrapped, err := wrapWithKey(key, plaintext, []byte(action.AAD))
+	if err != nil {
+		return nil, err
+	}
+
+	response := corecomms.NewSecretActionResponse()
+	response.Ciphertext = base64.StdEncoding.EncodeToString(rewrapped)
+	return response, nil
+}
+
+// rotateAction creates new material for the root key as its next version. Earlier versions stay
+// available to unwrap the ciphertexts they created. The response has no body.
+func (svc *basicService) rotateAction(key *rootKey, action *actions.SecretAction) (*corecomms.SecretActionResponse, error) {
+	rotateTransaction := transactions.NewTransaction()
+	defer rotateTransaction.Complete()
+
+	version, errRotate := key.keystore.RotateSecret(key.ctx, key.id, key.metadata, &rotateTransaction)
+	if errRotate != nil {
+		svc.cleanupFailure(&rotateTransaction, key.headers.CorrelationID)
+		return nil, errRotate
+	}
+
+	svc.logger.Log("msg", "Rotated root key", "version", version, "correlation_id", key.headers.CorrelationID)
+	return new(corecomms.SecretActionResponse), nil
+}
+
+// policyAction sets the policies of the root key. Either policy may be left out of the request to keep it as is.
+// Both policies are validated before either is set, so a request that is refused changes neither.
+// The response has the policies that were set, or no body if the only change removed the rotation policy.
+func (svc *basicService) policyAction(key *rootKey, action *actions.SecretAction) (*corecomms.SecretActionResponse, error) {
+	if action.RotationPolicy == nil && action.DualAuthDelete == nil {
+		return nil, errors.New(http.StatusText(http.StatusBadRequest) + ": Requires rotation or dual authorization policy")
+	}
+
+	if action.RotationPolicy != nil {
+		interval := action.RotationPolicy.IntervalDays
+		if interval < 0 || interval > MaxRotationIntervalDays {
+			return nil, fmt.Errorf(http.StatusText(http.StatusBadRequest)+": Rotation interval must be between 0 and %d days", MaxRotationIntervalDays)
+		}
+	}
+
+	policies, err := svc.keystores.NewPolicyDB()
+	if err != nil {
+		return nil, err
+	}
+
+	addDeletionPolicy := false
+	if action.DualAuthDelete != nil {
+		if addDeletionPolicy, err = checkDeletionPolicy(policies, key, action.DualAuthDelete.Enabled); err != nil {
+			return nil, err
+		}
+	}
+
+	response := corecomms.NewSecretActionResponse()
+	if action.RotationPolicy != nil {
+		if response.RotationPolicy, err = svc.setRotationPolicy(policies, key, action.RotationPolicy.IntervalDays); err != nil {
+			return nil, err
+		}
+	}
+
+	if action.DualAuthDelete != nil {
+		if addDeletionPolicy {
+			if err := svc.addDeletionPolicy(policies, key); err != nil {
+				return nil, err
+			}
+		}
+		response.DualAuthDelete = action.DualAuthDelete
+	}
+
+	if response.RotationPolicy == nil && response.DualAuthDelete == nil {
+		return new(corecomms.SecretActionResponse), nil
+	}
+	return response, nil
+}
+
+// setRotationPolicy sets how often the rotation scheduler rotates the root key. The next rotation is counted from the
+// last scheduled rotation, or from now if there has not been one. An interval of zero removes the policy and returns nil.
+func (svc *basicService) setRotationPolicy(policies db.PolicyDB, key *rootKey, interval int) (*actions.RotationPolicy, error) {
+	if interval == 0 {
+		if err := policies.DeletePolicy(key.headers.BluemixSpace, key.headers.BluemixOrg, key.id); err != nil {
+			return nil, err
+		}
+		svc.logger.Log("msg", "Removed rotation policy", "correlation_id", key.headers.CorrelationID)
+		return nil, nil
+	}
+
+	policy, err := policies.GetPolicy(key.headers.BluemixSpace, key.headers.BluemixOrg, key.id)
+	if err == db.ErrNotFound {
+		policy = &db.RotationPolicy{Space: key.headers.BluemixSpace, Org: key.headers.BluemixOrg, KpID: key.id}
+	} else if err != nil {
+		return nil, err
+	}
+
+	policy.IntervalDays = interval
+	if policy.LastRotation.IsZero() {
+		policy.Schedule(time.Now().UTC())
+	} else {
+		policy.Schedule(policy.LastRotation)
+	}
+
+	if err := policies.SetPolicy(policy); err != nil {
+		return nil, err
+	}
+
+	svc.logger.Log("msg", "Set rotation policy", "interval_days", interval, "correlation_id", key.headers.CorrelationID)
+	return toActionPolicy(policy), nil
+}
+
+// checkDeletionPolicy validates whether deleting the root key must be authorized by two different users, and reports
+// whether the policy needs to be added. Enabling the policy again keeps a deletion that is already pending. Once
+// enabled the policy cannot be disabled, as a single user could otherwise disable it and delete the key alone.
+func checkDeletionPolicy(policies db.PolicyDB, key *rootKey, enabled bool) (bool, error) {
+	_, err := policies.GetDeletionPolicy(key.headers.BluemixSpace, key.headers.BluemixOrg, key.id)
+	if err == nil {
+		if !enabled {
+			return false, errDeletionPolicyEnabled
+		}
+		return false, nil
+	}
+	if err != db.ErrNotFound {
+		return false, err
+	}
+	return enabled, nil
+}
+
+// addDeletionPolicy requires deleting the root key to be authorized by two different users
+func (svc *basicService) addDeletionPolicy(policies db.PolicyDB, key *rootKey) error {
+	if err := policies.SetDeletionPolicy(&db.DeletionPolicy{Space: key.headers.BluemixSpace, Org: key.headers.BluemixOrg, KpID: key.id}); err != nil {
+		return err
+	}
+	svc.logger.Log("msg", "Enabled dual authorization for deletion", "correlation_id", key.headers.CorrelationID)
+	return nil
+}
+
+// toActionPolicy converts a stored rotation policy to the body returned by the policy action
+func toActionPolicy(policy *db.RotationPolicy) *actions.RotationPolicy {
+	actionPolicy := &actions.RotationPolicy{
+		IntervalDays:     policy.IntervalDays,
+		NextRotationDate: policy.NextRotation.Format(time.RFC3339),
+	}
+	if !policy.LastRotation.IsZero() {
+		actionPolicy.LastRotationDate = policy.LastRotation.Format(time.RFC3339)
+	}
+	return actionPolicy
+}
+
+// stateAction disables a key by moving it from the Activation to the Suspended state, or enables it by moving it back.
+// It applies to any key, not only root keys, and the new state is persisted through the metadata service.
+// A suspended key rejects actions and the retrieval of its payload until it is enabled. The response has no body.
+func (svc *basicService) stateAction(ctx context.Context, headers *communications.Headers, id string, action string) (*corecomms.SecretActionResponse, error) {
+	from, to := secrets.Activation, secrets.Suspended
+	if action == actions.Enable {
+		from, to = secrets.Suspended, secrets.Activation
+	}
+
+	conn, err := grpc.Dial(dbServerPath, grpc.WithInsecure(), grpc.WithTimeout(time.Second*time.Duration(timeout)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	client := client.NewClient(conn, log.NewNopLogger())
+	metadata, err := getMetadata(ctx, client, headers, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkStateTransition(metadata, from); err != nil {
+		return nil, err
+	}
+
+	updateRequest := communications.NewUpdateRequest()
+	updateRequest.SetHeaders(headers)
+	updateRequest.SetID(id)
+	updateRequest.SetUpdates(map[string]string{"state": strconv.Itoa(int(to))})
+
+	if _, err := client.Update(ctx, updateRequest); err != nil {
+		return nil, err
+	}
+
+	// The state is already changed, so failing to mirror it into the keystore only leaves the copy behind
+	metadata.SetState(to)
+	if errSync := svc.syncKeystoreMetadata(ctx, headers, metadata); errSync != nil {
+		svc.logger.Log("err", errSync.Error(), "msg", "Unable to mirror metadata into the keystore", "correlation_id", headers.CorrelationID)
+	}
+
+	svc.logger.Log("msg", "Changed key state", "action", action, "state", int(to), "correlation_id", headers.CorrelationID)
+	return new(corecomms.SecretActionResponse), nil
+}
+
+// syncKeystoreMetadata mirrors the updated metadata of a key into the keystore it is routed to
+func (svc *basicService) syncKeystoreMetadata(ctx context.Context, headers *communications.Headers, metadata *secrets.Secret) error {
+	secretService, err := svc.keystores.NewKeystore(headers, svc.logger)
+	if err != nil {
+		return err
+	}
+	return syncMetadata(ctx, secretService, metadata)
+}
+
+// checkStateTransition ensures the key is in the state a disable or enable action moves it from.
+// Expired keys cannot be enabled again.
+func checkStateTransition(metadata *secrets.Secret, from secrets.KeyStates) error {
+	if metadata.State != from {
+		if from == secrets.Suspended {
+			return errors.New(http.StatusText(http.StatusConflict) + ": Key is not suspended")
+		}
+		return errors.New(http.StatusText(http.StatusConflict) + ": Key is not in the Activation state")
+	}
+
+	expired, err := handleExpirationTime(metadata)
+	if err != nil {
+		return err
+	}
+
+	if expired {
+		return errors.New(http.StatusText(http.StatusConflict) + ": Key is expired")
+	}
+	return nil
+}
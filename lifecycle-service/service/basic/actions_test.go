@@ -0,0 +1,396 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package basic
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"context"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keywrap"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/actions"
+	corecomms "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+// newTestRootKey creates a root key in the mock keystore
+func newTestRootKey(t *testing.T) *rootKey {
+	headers := &communications.Headers{BluemixSpace: "space-1234", BluemixOrg: "org-1234"}
+	secretService, err := keystore.NewKeystore(keystore.Mock, headers, logger)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	metadata := secrets.NewSecret()
+	id, err := secretService.CreateSecret(context.Background(), metadata, nil)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	return &rootKey{ctx: context.Background(), id: id, metadata: metadata, keystore: secretService, headers: headers}
+}
+
+// ciphertextVersion returns the root key version recorded in a base64 ciphertext
+func ciphertextVersion(t *testing.T, ciphertext string) int {
+	decoded, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	version, err := keywrap.KeyVersion(decoded)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return version
+}
+
+func TestWrapUnwrapAction(t *testing.T) {
+	key := newTestRootKey(t)
+	dataKey := base64.StdEncoding.EncodeToString([]byte("data-encryption-key"))
+
+	wrapResponse, err := wrapAction(key, &actions.SecretAction{Plaintext: dataKey, AAD: "test-aad"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if wrapResponse.Ciphertext == "" || wrapResponse.Plaintext != "" {
+		t.Errorf("Expected only ciphertext, received %+v", wrapResponse.SecretAction)
+	}
+
+	unwrapResponse, err := unwrapAction(key, &actions.SecretAction{Ciphertext: wrapResponse.Ciphertext, AAD: "test-aad"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if unwrapResponse.Plaintext != dataKey {
+		t.Errorf("Expected %s, received %s", dataKey, unwrapResponse.Plaintext)
+	}
+
+	// Bad Path: AAD does not match
+	if _, err := unwrapAction(key, &actions.SecretAction{Ciphertext: wrapResponse.Ciphertext}); err != keywrap.ErrInvalidCiphertext {
+		t.Errorf("Expected %s, received %+v", keywrap.ErrInvalidCiphertext, err)
+	}
+}
+
+func TestRewrapAction(t *testing.T) {
+	key := newTestRootKey(t)
+	dataKey := base64.StdEncoding.EncodeToString([]byte("data-encryption-key"))
+
+	wrapResponse, err := wrapAction(key, &actions.SecretAction{Plaintext: dataKey, AAD: "test-aad"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	rewrapResponse, err := rewrapAction(key, &actions.SecretAction{Ciphertext: wrapResponse.Ciphertext, AAD: "test-aad"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if rewrapResponse.Plaintext != "" {
+		t.Errorf("Expected no plaintext, received %s", rewrapResponse.Plaintext)
+	}
+
+	if rewrapResponse.Ciphertext == wrapResponse.Ciphertext {
+		t.Error("Expected a new ciphertext")
+	}
+
+	unwrapResponse, err := unwrapAction(key, &actions.SecretAction{Ciphertext: rewrapResponse.Ciphertext, AAD: "test-aad"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if unwrapResponse.Plaintext != dataKey {
+		t.Errorf("Expected %s, received %s", dataKey, unwrapResponse.Plaintext)
+	}
+
+	// Bad Path: AAD does not match
+	if _, err := rewrapAction(key, &actions.SecretAction{Ciphertext: wrapResponse.Ciphertext}); err != keywrap.ErrInvalidCiphertext {
+		t.Errorf("Expected %s, received %+v", keywrap.ErrInvalidCiphertext, err)
+	}
+}
+
+// wrappingKeystore is a keystore that wraps and unwraps itself, as an HSM keystore does
+type wrappingKeystore struct {
+	definitions.Keystore
+	wrapped   int
+	unwrapped int
+}
+
+var testWrappingKey = bytes.Repeat([]byte{0x24}, 32)
+
+func (k *wrappingKeystore) WrapKey(ctx context.Context, keyprotectID string, plaintext, aad []byte) ([]byte, error) {
+	k.wrapped++
+	return keywrap.Wrap(testWrappingKey, 1, plaintext, aad)
+}
+
+func (k *wrappingKeystore) UnwrapKey(ctx context.Context, keyprotectID string, ciphertext, aad []byte) ([]byte, error) {
+	k.unwrapped++
+	return keywrap.Unwrap(testWrappingKey, ciphertext, aad)
+}
+
+func TestActionsWithKeyWrapper(t *testing.T) {
+	key := newTestRootKey(t)
+	wrapper := &wrappingKeystore{Keystore: key.keystore}
+	key.keystore = wrapper
+	dataKey := base64.StdEncoding.EncodeToString([]byte("data-encryption-key"))
+
+	wrapResponse, err := wrapAction(key, &actions.SecretAction{Plaintext: dataKey})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	rewrapResponse, err := rewrapAction(key, &actions.SecretAction{Ciphertext: wrapResponse.Ciphertext})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	unwrapResponse, err := unwrapAction(key, &actions.SecretAction{Ciphertext: rewrapResponse.Ciphertext})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if unwrapResponse.Plaintext != dataKey {
+		t.Errorf("Expected %s, received %s", dataKey, unwrapResponse.Plaintext)
+	}
+
+	if wrapper.wrapped != 2 || wrapper.unwrapped != 2 {
+		t.Errorf("Expected the keystore to wrap and unwrap twice, received %d and %d", wrapper.wrapped, wrapper.unwrapped)
+	}
+}
+
+func TestRotateAction(t *testing.T) {
+	key := newTestRootKey(t)
+	svc := &basicService{logger: logger, keystores: backEndStrategy}
+	dataKey := base64.StdEncoding.EncodeToString([]byte("data-encryption-key"))
+
+	wrapResponse, err := wrapAction(key, &actions.SecretAction{Plaintext: dataKey, AAD: "test-aad"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if version := ciphertextVersion(t, wrapResponse.Ciphertext); version != 1 {
+		t.Errorf("Expected version %d, received %d", 1, version)
+	}
+
+	rotateResponse, err := svc.rotateAction(key, new(actions.SecretAction))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if rotateResponse.SecretAction != nil {
+		t.Errorf("Expected no response body, received %+v", rotateResponse.SecretAction)
+	}
+
+	// ciphertexts from before the rotation still unwrap
+	unwrapResponse, err := unwrapAction(key, &actions.SecretAction{Ciphertext: wrapResponse.Ciphertext, AAD: "test-aad"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if unwrapResponse.Plaintext != dataKey {
+		t.Errorf("Expected %s, received %s", dataKey, unwrapResponse.Plaintext)
+	}
+
+	// rewrap moves ciphertexts to the new version
+	rewrapResponse, err := rewrapAction(key, &actions.SecretAction{Ciphertext: wrapResponse.Ciphertext, AAD: "test-aad"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if version := ciphertextVersion(t, rewrapResponse.Ciphertext); version != 2 {
+		t.Errorf("Expected version %d, received %d", 2, version)
+	}
+
+	unwrapResponse, err = unwrapAction(key, &actions.SecretAction{Ciphertext: rewrapResponse.Ciphertext, AAD: "test-aad"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if unwrapResponse.Plaintext != dataKey {
+		t.Errorf("Expected %s, received %s", dataKey, unwrapResponse.Plaintext)
+	}
+}
+
+func TestWrapActionGeneratesDataKey(t *testing.T) {
+	key := newTestRootKey(t)
+
+	wrapResponse, err := wrapAction(key, &actions.SecretAction{AAD: "test-aad"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	dataKey, err := base64.StdEncoding.DecodeString(wrapResponse.Plaintext)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if len(dataKey) != keywrap.DataKeyLength {
+		t.Errorf("Expected %d, received %d", keywrap.DataKeyLength, len(dataKey))
+	}
+
+	unwrapResponse, err := unwrapAction(key, &actions.SecretAction{Ciphertext: wrapResponse.Ciphertext, AAD: "test-aad"})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if unwrapResponse.Plaintext != wrapResponse.Plaintext {
+		t.Errorf("Expected %s, received %s", wrapResponse.Plaintext, unwrapResponse.Plaintext)
+	}
+}
+
+func TestWrapActionBadPlaintext(t *testing.T) {
+	key := newTestRootKey(t)
+
+	if _, err := wrapAction(key, &actions.SecretAction{Plaintext: "not-base64!"}); err == nil {
+		t.Error("Expected Error")
+	}
+}
+
+func TestUnwrapActionBadCiphertext(t *testing.T) {
+	key := newTestRootKey(t)
+
+	if _, err := unwrapAction(key, &actions.SecretAction{Ciphertext: "not-base64!"}); err == nil {
+		t.Error("Expected Error")
+	}
+
+	ciphertext := base64.StdEncoding.EncodeToString([]byte("short"))
+	if _, err := unwrapAction(key, &actions.SecretAction{Ciphertext: ciphertext}); err != keywrap.ErrInvalidCiphertext {
+		t.Errorf("Expected %s, received %+v", keywrap.ErrInvalidCiphertext, err)
+	}
+
+	// Bad Path: version of the key does not exist
+	wrapped, err := keywrap.Wrap(bytes.Repeat([]byte{0x42}, 32), 5, []byte("data-encryption-key"), nil)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	ciphertext = base64.StdEncoding.EncodeToString(wrapped)
+	if _, err := unwrapAction(key, &actions.SecretAction{Ciphertext: ciphertext}); err == nil {
+		t.Error("Expected Error")
+	}
+}
+
+func TestActionsValidation(t *testing.T) {
+	svc := Service(logger, backEndStrategy, nil)
+	ctx := context.Background()
+
+	// Bad Path: No Headers
+	testRequest := corecomms.NewSecretActionRequest()
+	testRequest.Headers = nil
+	if _, err := svc.Actions(ctx, testRequest); err == nil {
+		t.Error("Expected Error")
+	}
+
+	// Bad Path: No ID
+	testRequest = corecomms.NewSecretActionRequest()
+	testRequest.SetHeaders(&communications.Headers{BluemixSpace: "space-1234", BluemixOrg: "org-1234"})
+	testRequest.Action = actions.Wrap
+	if _, err := svc.Actions(ctx, testRequest); err == nil {
+		t.Error("Expected Error")
+	}
+
+	// Bad Path: Unsupported action
+	testRequest.ID = "test-id"
+	testRequest.Action = "test-action"
+	if _, err := svc.Actions(ctx, testRequest); err == nil {
+		t.Error("Expected Error")
+	}
+}
+
+func TestPolicyAction(t *testing.T) {
+	key := newTestRootKey(t)
+	svc := &basicService{logger: logger, keystores: backEndStrategy}
+	policies, err := backEndStrategy.NewPolicyDB()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	policyResponse, err := svc.policyAction(key, &actions.SecretAction{RotationPolicy: &actions.RotationPolicy{IntervalDays: 30}})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if policyResponse.RotationPolicy == nil || policyResponse.RotationPolicy.IntervalDays != 30 {
+		t.Fatalf("Expected an interval of %d days, received %+v", 30, policyResponse.RotationPolicy)
+	}
+
+	if policyResponse.RotationPolicy.NextRotationDate == "" || policyResponse.RotationPolicy.LastRotationDate != "" {
+		t.Errorf("Expected only a next rotation date, received %+v", policyResponse.RotationPolicy)
+	}
+
+	policy, err := policies.GetPolicy(key.headers.BluemixSpace, key.headers.BluemixOrg, key.id)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if days := policy.NextRotation.Sub(time.Now()).Hours() / 24; days < 29 || days > 30 {
+		t.Errorf("Expected the next rotation in %d days, received %f", 30, days)
+	}
+
+	// Bad Path: interval is too long
+	if _, err := svc.policyAction(key, &actions.SecretAction{RotationPolicy: &actions.RotationPolicy{IntervalDays: MaxRotationIntervalDays + 1}}); err == nil {
+		t.Error("Expected Error")
+	}
+
+	// an interval of zero removes the policy
+	removeResponse, err := svc.policyAction(key, &actions.SecretAction{RotationPolicy: &actions.RotationPolicy{}})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if removeResponse.SecretAction != nil {
+		t.Errorf("Expected no response body, received %+v", removeResponse.SecretAction)
+	}
+
+	if _, err := policies.GetPolicy(key.headers.BluemixSpace, key.headers.BluemixOrg, key.id); err != db.ErrNotFound {
+		t.Errorf("Expected %s, received %+v", db.ErrNotFound, err)
+	}
+}
+
+func TestCheckStateTransition(t *testing.T) {
+	metadata := secrets.NewSecret()
+	metadata.SetState(secrets.Activation)
+
+	if err := checkStateTransition(metadata, secrets.Activation); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	// Bad Path: only suspended keys can be enabled
+	if err := checkStateTransition(metadata, secrets.Suspended); err == nil {
+		t.Error("Expected Error")
+	}
+
+	metadata.SetState(secrets.Suspended)
+	if err := checkStateTransition(metadata, secrets.Suspended); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	// Bad Path: expired keys cannot be enabled
+	metadata.ExpirationDate = time.Now().Add(-time.Hour).Format(time.RFC3339)
+	if err := checkStateTransition(metadata, secrets.Suspended); err == nil {
+		t.Error("Expected Error")
+	}
+}
+
+func TestAdjustSecretStateKeepsSuspension(t *testing.T) {
+	metadata := secrets.NewSecret()
+	metadata.SetState(secrets.Suspended)
+
+	adjustSecretState(metadata, false, true)
+	if metadata.State != secrets.Suspended {
+		t.Errorf("Expected %d, received %d", secrets.Suspended, metadata.State)
+	}
+
+	// suspended keys still expire
+	adjustSecretState(metadata, true, true)
+	if metadata.State != secrets.Deactivated {
+		t.Errorf("Expected %d, received %d", secrets.Deactivated, metadata.State)
+	}
+}
@@ -16,8 +16,12 @@ import (
 	"context"
 
 	"github.ibm.com/Alchemy-Key-Protect/go-db-service/services/metadata/client"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/algorithms"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/certificates"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keysign"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/actions"
 	corecomms "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/communications"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transactions"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-config"
@@ -45,12 +49,22 @@ const (
 	// MaxPayloadLength specifies the how many characters a secret payload may contain
 	MaxPayloadLength = 10000
 
+	// MaxCertificateLength specifies how many characters the PEM bundle of a certificate may contain, which is
+	// longer than other payloads to fit the chain
+	MaxCertificateLength = 30000
+
 	// MaxMetadataAllowed specifies how many key, value pairs are allowed in Metadata field
 	MaxMetadataAllowed = 30
 
 	// MaxCharLenForMetadata specifies how many chars are allowd in key, value pairs in Metadata field
 	MaxCharLenForMetadata = 130
 
+	// MaxRotationIntervalDays specifies the longest interval allowed between the scheduled rotations of a root key
+	MaxRotationIntervalDays = 365
+
+	// DualAuthDeletionExpirationHours specifies how long a deletion authorized by one user waits for a second user
+	DualAuthDeletionExpirationHours = 24
+
 	// ContainsReservedCharacterMessage defines a human-readable message for disallowed characters
 	ContainsReservedCharacterMessage = "contains a reserved character (angled bracket, colon, ampersand, or vertical pipe)"
 )
@@ -69,17 +83,20 @@ func init() {
 	timeout = config.GetInt("timeouts.grpcTimeout")
 }
 
-// Service will return a service based on the Service definition
-func Service(logger log.Logger, backEndKeystore keystore.Type) definitions.Service {
+// Service will return a service based on the Service definition. Generated keys are activated in the background
+// by orders, which may be nil.
+func Service(logger log.Logger, keystores *keystore.Router, orders *OrderPoller) definitions.Service {
 	return &basicService{
-		logger:          logger,
-		backEndKeystore: backEndKeystore,
+		logger:    logger,
+		keystores: keystores,
+		orders:    orders,
 	}
 }
 
 type basicService struct {
-	logger          log.Logger
-	backEndKeystore keystore.Type
+	logger    log.Logger
+	keystores *keystore.Router
+	orders    *OrderPoller
 }
 
 func (svc basicService) cleanupFailure(tx *transactions.Transaction, id string) {
@@ -108,7 +125,8 @@ func containsReservedCharacter(checkString string) bool {
 
 // validateSecret ensures metadata provided for secret creation meets criteria as follows:
 // - There must be a secret
-// - Payload must be <= 10000 characters
+// - Payload must be <= 10000 characters, or 30000 for certificates
+// - Certificates must have a PEM bundle of a certificate and its private key, and be extractable
 // - Expiration date must be RFC3339
 // - Description must be <= 230 characters
 // - Name must be <= 230 characters
@@ -116,6 +134,7 @@ func containsReservedCharacter(checkString string) bool {
 // - Tags cannot contain these characters <, >, &, :, |
 // - AlgorithmMetadata must not have more than 30 key, value pairs
 // - AlgorithmMetadata key, value pairs <= 130 characters
+// - Keys generated by the keystore must have a supported algorithm, mode and bitLength
 // - UserMetadata must not have more than 30 key, value pairs
 // - UserMetadata key, value pairs <= 130 characters
 // - UserMetadata cannot contain these characters <, >, &, :, |
@@ -125,11 +144,26 @@ func validateSecret(secret *secrets.Secret) error {
 		return badRequest
 	}
 
-	if len(secret.Payload) > MaxPayloadLength {
+	maxPayloadLength := MaxPayloadLength
+	if certificates.IsCertificate(secret.SecretType) {
+		maxPayloadLength = MaxCertificateLength
+	}
+
+	if len(secret.Payload) > maxPayloadLength {
 		badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": Payload too long")
 		return badRequest
 	}
 
+	if certificates.IsCertificate(secret.SecretType) {
+		if _, err := certificates.Parse(secret.Payload); err != nil {
+			return err
+		}
+		if secret.Extractable != nil && *secret.Extractable == false {
+			badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": Certificates must be extractable")
+			return badRequest
+		}
+	}
+
 	if secret.ExpirationDate != "" {
 		if err := isValidDate(secret.ExpirationDate); err != nil {
 			badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": Requires Valid RFC3339 expiration date")
@@ -180,6 +214,13 @@ func validateSecret(secret *secrets.Secret) error {
 		}
 	}
 
+	// validate the algorithm of keys the keystore generates. Signing keys are generated by the service.
+	if len(secret.Payload) == 0 && !keysign.IsSigningAlgorithm(secret.AlgorithmType) {
+		if _, err := algorithms.Resolve(secret.AlgorithmType, secret.AlgorithmMetadata); err != nil {
+			return err
+		}
+	}
+
 	// validate UserMetadata
 	if len(secret.UserMetadata) > MaxMetadataAllowed {
 		badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": Too many user metadata key value pairs")
@@ -206,10 +247,12 @@ func validateSecret(secret *secrets.Secret) error {
 
 // Post performs steps to create a secret. It implements Service.
 // We need to do the following:
-// 1.  Create the HSM backed secret.  If a payload exists, use Barbian /v1/secrets.
-// 2.  Store the secret
+//  1. Create the HSM backed secret.  If a payload exists, use Barbian /v1/secrets.
+//     RSA and EC keys are generated by the service, and their private key is stored as the payload.
+//  2. Store the secret
+//
 // For any errors on storage, we need to delete the secret created in step 1 and return with 5xx status error.
-func (svc *basicService) Post(_ context.Context, request *communications.SecretRequest) (*communications.SecretsResponse, error) {
+func (svc *basicService) Post(ctx context.Context, request *communications.SecretRequest) (*communications.SecretsResponse, error) {
 	headers := request.Headers
 	if headers == nil {
 		badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": Requires Headers")
@@ -225,6 +268,15 @@ func (svc *basicService) Post(_ context.Context, request *communications.SecretR
 		return nil, validationErr
 	}
 
+	// The keypair of a signing key is generated here, and its private key is then stored like any other payload.
+	// Certificates bring their own key.
+	if keysign.IsSigningAlgorithm(secret.AlgorithmType) && !certificates.IsCertificate(secret.SecretType) {
+		if errGenerate := generateSigningKey(secret); errGenerate != nil {
+			svc.logger.Log("err", errGenerate.Error(), "correlation_id", headers.CorrelationID)
+			return nil, errGenerate
+		}
+	}
+
 	var includeResource bool
 	parameters := request.Parameters
 	if parameters != nil {
@@ -234,12 +286,12 @@ func (svc *basicService) Post(_ context.Context, request *communications.SecretR
 	createTransaction := transactions.NewTransaction()
 	defer createTransaction.Complete()
 
-	secretService, errNewStrat := keystore.NewKeystore(svc.backEndKeystore, headers, svc.logger)
+	secretService, errNewStrat := svc.keystores.NewKeystore(headers, svc.logger)
 	if errNewStrat != nil {
 		return nil, errNewStrat
 	}
 
-	id, errCreate := secretService.CreateSecret(secret, &createTransaction)
+	id, errCreate := secretService.CreateSecret(ctx, secret, &createTransaction)
 	if errCreate != nil {
 		svc.cleanupFailure(&createTransaction, headers.CorrelationID)
 		return nil, errCreate
@@ -283,6 +335,14 @@ func (svc *basicService) Post(_ context.Context, request *communications.SecretR
 		returnedSecret.SetPayload(payload)
 	}
 
+	// Generated keys are activated as soon as their order completes, even if they are never read.
+	// Their metadata is mirrored into the keystore once they are.
+	if returnedSecret.State == secrets.Preactivation {
+		svc.orders.Track(headers, returnedSecret.ID)
+	} else if errSync := syncMetadata(ctx, secretService, returnedSecret); errSync != nil {
+		svc.logger.Log("err", errSync.Error(), "msg", "Unable to mirror metadata into the keystore", "correlation_id", headers.CorrelationID)
+	}
+
 	createResponse := communications.NewSecretsResponse()
 
 	if !includeResource {
@@ -300,8 +360,84 @@ func (svc *basicService) Post(_ context.Context, request *communications.SecretR
 }
 
 // Actions performs steps to actions by a secret.
-func (svc *basicService) Actions(_ context.Context, request *corecomms.SecretActionRequest) (*corecomms.SecretActionResponse, error) {
-	return nil, errors.New(http.StatusText(http.StatusNotImplemented) + ": Action by secret not implemented")
+// Actions use the versions of an active root key, whose material never leaves the service:
+//  1. Check the metadata to ensure the key is a root key in the Activation state. Suspended keys are rejected.
+//  2. Retrieve the key material for the version in use from the keystore, or create a new version on rotate.
+//  3. AES-GCM encrypt or decrypt the request body, authenticated with the AAD if provided.
+//     RSA and EC keys instead sign or verify the digest of the request body with their private key.
+//
+// Disable and enable instead move any key between the Activation and Suspended states.
+func (svc *basicService) Actions(ctx context.Context, request *corecomms.SecretActionRequest) (*corecomms.SecretActionResponse, error) {
+	headers := request.Headers
+	if headers == nil {
+		badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": Requires Headers")
+		svc.logger.Log("err", badRequest.Error())
+		return nil, badRequest
+	}
+
+	id := request.ID
+	if id == "" {
+		badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": Requires ID")
+		svc.logger.Log("err", badRequest.Error(), "correlation_id", headers.CorrelationID)
+		return nil, badRequest
+	}
+
+	if request.SecretAction == nil {
+		badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": Requires action body")
+		svc.logger.Log("err", badRequest.Error(), "correlation_id", headers.CorrelationID)
+		return nil, badRequest
+	}
+
+	// disable and enable change the state of any key, so they do not require an active root key
+	if request.Action == actions.Disable || request.Action == actions.Enable {
+		response, errState := svc.stateAction(ctx, headers, id, request.Action)
+		if errState != nil {
+			svc.logger.Log("err", errState.Error(), "correlation_id", headers.CorrelationID)
+			return nil, errState
+		}
+		return response, nil
+	}
+
+	var handler actionHandler
+	switch request.Action {
+	case actions.Wrap:
+		handler = wrapAction
+	case actions.Unwrap:
+		handler = unwrapAction
+	case actions.Rewrap:
+		handler = rewrapAction
+	case actions.Rotate:
+		handler = svc.rotateAction
+	case actions.Policy:
+		handler = svc.policyAction
+	case actions.Sign:
+		handler = signAction
+	case actions.Verify:
+		handler = verifyAction
+	default:
+		badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": " + request.Action + " is not a supported action")
+		svc.logger.Log("err", badRequest.Error(), "correlation_id", headers.CorrelationID)
+		return nil, badRequest
+	}
+
+	key, errRootKey := svc.getRootKey(ctx, headers, id)
+	if errRootKey != nil {
+		svc.logger.Log("err", errRootKey.Error(), "correlation_id", headers.CorrelationID)
+		return nil, errRootKey
+	}
+
+	if errAlgorithm := checkActionAlgorithm(key.metadata, request.Action, request.SecretAction); errAlgorithm != nil {
+		svc.logger.Log("err", errAlgorithm.Error(), "correlation_id", headers.CorrelationID)
+		return nil, errAlgorithm
+	}
+
+	response, errAction := handler(key, request.SecretAction)
+	if errAction != nil {
+		svc.logger.Log("err", errAction.Error(), "correlation_id", headers.CorrelationID)
+		return nil, errAction
+	}
+
+	return response, nil
 }
 
 func (svc *basicService) Get(ctx context.Context, request *communications.IDRequest) (*communications.SecretsResponse, error) {
@@ -344,7 +480,12 @@ func (svc *basicService) Get(ctx context.Context, request *communications.IDRequ
 		return nil, notFoundErr
 	}
 
-	secretService, errNewStrat := keystore.NewKeystore(svc.backEndKeystore, headers, svc.logger)
+	if dbResponse.Secrets[0].State == secrets.Suspended {
+		svc.logger.Log("err", errKeySuspended.Error(), "correlation_id", headers.CorrelationID)
+		return nil, errKeySuspended
+	}
+
+	secretService, errNewStrat := svc.keystores.NewKeystore(headers, svc.logger)
 	if errNewStrat != nil {
 		return nil, errNewStrat
 	}
@@ -352,7 +493,7 @@ func (svc *basicService) Get(ctx context.Context, request *communications.IDRequ
 	var payload string
 	var barbicanState secrets.KeyStates
 	var errPayload error
-	payload, barbicanState, errPayload = secretService.GetPayload(id)
+	payload, barbicanState, errPayload = secretService.GetPayload(ctx, id)
 
 	//Check if the secret has already been marked an error.
 	metadata := dbResponse.Secrets[0]
@@ -391,6 +532,10 @@ func (svc *basicService) Get(ctx context.Context, request *communications.IDRequ
 				return nil, err
 			}
 			dbResponse.Secrets[0].State = barbicanState
+
+			if errSync := syncMetadata(ctx, secretService, dbResponse.Secrets[0]); errSync != nil {
+				svc.logger.Log("err", errSync.Error(), "msg", "Unable to mirror metadata into the keystore", "correlation_id", headers.CorrelationID)
+			}
 		}
 	}
 
@@ -479,19 +624,19 @@ func (svc *basicService) List(ctx context.Context, request *communications.BaseR
 		updateRequest := communications.NewUpdateRequest()
 		updateRequest.SetHeaders(headers)
 
-		secretService, errNewStrat := keystore.NewKeystore(svc.backEndKeystore, headers, svc.logger)
+		secretService, errNewStrat := svc.keystores.NewKeystore(headers, svc.logger)
 		if errNewStrat != nil {
 			return nil, errNewStrat
 		}
 
-		checkStatus(inactives, client, updateRequest, secretService)
+		checkStatus(ctx, inactives, client, updateRequest, secretService)
 		dbResponse.Secrets = filterErroredKeys(dbResponse.Secrets)
 	}
 
 	return dbResponse, nil
 }
 
-func (svc *basicService) Delete(_ context.Context, request *communications.IDRequest) (*communications.SecretsResponse, error) {
+func (svc *basicService) Delete(ctx context.Context, request *communications.IDRequest) (*communications.SecretsResponse, error) {
 	headers := request.Headers
 	if headers == nil {
 		badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": Requires Headers")
@@ -512,7 +657,7 @@ func (svc *basicService) Delete(_ context.Context, request *communications.IDReq
 		includeResource = parameters.IncludeResource
 	}
 
-	secretService, errNewStrat := keystore.NewKeystore(svc.backEndKeystore, headers, svc.logger)
+	secretService, errNewStrat := svc.keystores.NewKeystore(headers, svc.logger)
 	if errNewStrat != nil {
 		return nil, errNewStrat
 	}
@@ -530,13 +675,28 @@ func (svc *basicService) Delete(_ context.Context, request *communications.IDReq
 	}
 	defer conn.Close()
 
+	// Clients for requests
+	client := client.NewClient(conn, log.NewNopLogger())
+
 	//Fetch the payload - just incase the user wants the whole secret returned.
 	var payload string
 	var state secrets.KeyStates
 	var errPayload error
 	if includeResource {
+		//The payload of a suspended key is not returned, even as it is deleted.
+		metadata, errMetadata := getMetadata(ctx, client, headers, id)
+		if errMetadata != nil {
+			svc.logger.Log("err", errMetadata.Error(), "correlation_id", headers.CorrelationID)
+			return nil, errMetadata
+		}
+
+		if metadata.State == secrets.Suspended {
+			svc.logger.Log("err", errKeySuspended.Error(), "correlation_id", headers.CorrelationID)
+			return nil, errKeySuspended
+		}
+
 		for i := 0; i < MaxRetries; i++ {
-			payload, state, errPayload = secretService.GetPayload(id)
+			payload, state, errPayload = secretService.GetPayload(ctx, id)
 			if errPayload == nil && state != secrets.Preactivation {
 				break
 			}
@@ -554,16 +714,20 @@ func (svc *basicService) Delete(_ context.Context, request *communications.IDReq
 		}
 	}
 
+	//Keys with a dual authorization policy are only deleted once a second user authorizes it.
+	if errAuthorize := svc.authorizeDeletion(headers, id); errAuthorize != nil {
+		svc.logger.Log("err", errAuthorize.Error(), "correlation_id", headers.CorrelationID)
+		return nil, errAuthorize
+	}
+
 	//Step 1 - Delete secret material
-	errDelete := secretService.DeleteSecret(id, &createTransaction)
+	errDelete := secretService.DeleteSecret(ctx, id, &createTransaction)
 	if errDelete != nil {
 		//svc.cleanupFailure(&createTransaction, headers.CorrelationID)
 		svc.logger.Log("err", errDelete.Error(), "correlation_id", headers.CorrelationID)
 		return nil, errDelete
 	}
 
-	// Clients for requests
-	client := client.NewClient(conn, log.NewNopLogger())
 	idRequest := communications.NewIDRequest()
 	idRequest.SetHeaders(headers)
 	idRequest.SetID(id)
@@ -576,6 +740,16 @@ func (svc *basicService) Delete(_ context.Context, request *communications.IDReq
 		return nil, errDbDeleteResponse
 	}
 
+	//Step 3 - Stop scheduled rotations and remove the deletion policy. The key is already deleted, so a failure is only logged.
+	if policies, errPolicies := svc.keystores.NewPolicyDB(); errPolicies == nil {
+		if errPolicy := policies.DeletePolicy(headers.BluemixSpace, headers.BluemixOrg, id); errPolicy != nil {
+			svc.logger.Log("err", errPolicy.Error(), "correlation_id", headers.CorrelationID)
+		}
+		if errPolicy := policies.DeleteDeletionPolicy(headers.BluemixSpace, headers.BluemixOrg, id); errPolicy != nil {
+			svc.logger.Log("err", errPolicy.Error(), "correlation_id", headers.CorrelationID)
+		}
+	}
+
 	deleteResponse := communications.NewSecretsResponse()
 
 	var returnSecret *secrets.Secret
@@ -3,8 +3,15 @@
 package basic
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"math/big"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
@@ -20,6 +27,7 @@ import (
 	"context"
 
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+	_ "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/mock"
 	configuration "github.ibm.com/Alchemy-Key-Protect/kp-go-config"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
@@ -39,12 +47,12 @@ const (
 )
 
 var (
-	backEndStrategy keystore.Type
+	backEndStrategy *keystore.Router
 	logger          log.Logger
 )
 
 func init() {
-	backEndStrategy = keystore.Mock
+	backEndStrategy = keystore.NewRouter(keystore.Mock)
 
 	logger = log.NewLogfmtLogger(os.Stderr)
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC,
@@ -77,7 +85,7 @@ func TestCreateSecret(t *testing.T) {
 	t.SkipNow()
 	// requires db-server running in test mode to pass.
 
-	svc := Service(logger, backEndStrategy)
+	svc := Service(logger, backEndStrategy, nil)
 
 	testRequest := communications.NewSecretRequest()
 	headers := &communications.Headers{
@@ -747,11 +755,80 @@ func TestCreateValidation(t *testing.T) {
 	}
 }
 
+func TestCreateValidationAlgorithm(t *testing.T) {
+	// Good Path: generated keys with a supported algorithm, or no algorithm at all
+	for _, algorithmMetadata := range []map[string]string{nil, {"mode": "CTR", "bitLength": "128"}} {
+		dummySecret := secrets.NewSecret()
+		dummySecret.Name = "generated-key"
+		dummySecret.AlgorithmType = "AES"
+		dummySecret.AlgorithmMetadata = algorithmMetadata
+		if validationErr := validateSecret(dummySecret); validationErr != nil {
+			t.Error(validationErr)
+		}
+	}
+
+	// Bad Path: unsupported bit length for a generated key
+	dummySecret := secrets.NewSecret()
+	dummySecret.Name = "generated-key"
+	dummySecret.AlgorithmType = "AES"
+	dummySecret.AlgorithmMetadata = map[string]string{"bitLength": "64"}
+	if validationErr := validateSecret(dummySecret); validationErr == nil {
+		t.Error("Expected Error")
+	}
+
+	// payloads are stored as given, so their algorithm metadata is not checked
+	dummySecret.Payload = "my secret payload"
+	if validationErr := validateSecret(dummySecret); validationErr != nil {
+		t.Error(validationErr)
+	}
+}
+
+// newTestBundle returns the PEM bundle of a self-signed certificate and its private key
+func newTestBundle(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test.example.com"}, NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(cryptorand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})) + string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestCreateValidationCertificate(t *testing.T) {
+	dummySecret := secrets.NewSecret()
+	dummySecret.Name = "test-certificate"
+	dummySecret.SecretType = "certificate"
+	dummySecret.Payload = newTestBundle(t)
+	if validationErr := validateSecret(dummySecret); validationErr != nil {
+		t.Error(validationErr)
+	}
+
+	// Bad Path: certificates must be extractable
+	extractable := false
+	dummySecret.Extractable = &extractable
+	if validationErr := validateSecret(dummySecret); validationErr == nil {
+		t.Error("Expected Error")
+	}
+
+	// Bad Path: a key is not a certificate
+	dummySecret.Extractable = nil
+	dummySecret.Payload = "my secret payload"
+	if validationErr := validateSecret(dummySecret); validationErr == nil {
+		t.Error("Expected Error")
+	}
+}
+
 func TestCreateSecretIncludeResource(t *testing.T) {
 	t.SkipNow()
 	// requires db-server running in test mode to pass.
 
-	svc := Service(logger, backEndStrategy)
+	svc := Service(logger, backEndStrategy, nil)
 
 	testRequest := communications.NewSecretRequest()
 	headers := &communications.Headers{
@@ -783,7 +860,7 @@ func TestDeleteSecret(t *testing.T) {
 	t.SkipNow()
 	// requires db-server running in test mode to pass.
 
-	svc := Service(logger, backEndStrategy)
+	svc := Service(logger, backEndStrategy, nil)
 
 	testRequestCreate := communications.NewSecretRequest()
 	headers := &communications.Headers{
@@ -824,7 +901,7 @@ func TestDeleteSecretIncludeResource(t *testing.T) {
 	t.SkipNow()
 	// This one is failing because it needs to return the secret payload, which requires the core service
 
-	svc := Service(logger, backEndStrategy)
+	svc := Service(logger, backEndStrategy, nil)
 
 	testRequestCreate := communications.NewSecretRequest()
 	headers := &communications.Headers{
@@ -871,7 +948,7 @@ func TestCreateFailOnBarbicanSecretCreate(t *testing.T) {
 	//Skip this test as it requires a db set up
 	t.SkipNow()
 
-	svc := Service(logger, backEndStrategy)
+	svc := Service(logger, backEndStrategy, nil)
 
 	// how to delete
 	testRequest := communications.NewSecretRequest()
@@ -923,7 +1000,7 @@ func TestCreateFailOnMetadataCreate(t *testing.T) {
 	configuration.Get().Set("openstack.barbican.url", backendURL)
 	///END SETUP BARBICAN BACKEND
 
-	svc := Service(logger, backEndStrategy)
+	svc := Service(logger, backEndStrategy, nil)
 
 	// how to delete
 	testRequest := communications.NewSecretRequest()
@@ -995,7 +1072,7 @@ func TestCreatePasses(t *testing.T) {
 
 	///END SETUP DB BACKEND
 
-	svc := Service(logger, backEndStrategy)
+	svc := Service(logger, backEndStrategy, nil)
 
 	// how to delete
 	testRequest := communications.NewSecretRequest()
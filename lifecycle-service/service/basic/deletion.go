@@ -0,0 +1,54 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package basic
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+)
+
+// authorizeDeletion enforces the dual authorization policy of a key before it is deleted. Deletes are only routed
+// for Manager users, so both users taking part hold that role. The first user's delete records a pending deletion
+// and is rejected. A delete from a different user before the pending deletion expires may go ahead.
+func (svc *basicService) authorizeDeletion(headers *communications.Headers, id string) error {
+	policies, err := svc.keystores.NewPolicyDB()
+	if err != nil {
+		return err
+	}
+
+	policy, err := policies.GetDeletionPolicy(headers.BluemixSpace, headers.BluemixOrg, id)
+	if err == db.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if headers.UserID == "" {
+		return errors.New(http.StatusText(http.StatusBadRequest) + ": Requires a user to delete a key with dual authorization")
+	}
+
+	now := time.Now().UTC()
+	if policy.IsPending(now) {
+		if policy.PendingUserID == headers.UserID {
+			return errors.New(http.StatusText(http.StatusConflict) + ": Deletion has been authorized by this user and must be completed by a second user")
+		}
+		svc.logger.Log("msg", "Deletion authorized by a second user", "correlation_id", headers.CorrelationID)
+		return nil
+	}
+
+	policy.PendingUserID = headers.UserID
+	policy.PendingExpiration = now.Add(time.Hour * DualAuthDeletionExpirationHours)
+	if err := policies.SetDeletionPolicy(policy); err != nil {
+		return err
+	}
+
+	svc.logger.Log("msg", "Deletion authorized, awaiting a second user", "correlation_id", headers.CorrelationID)
+	return fmt.Errorf(http.StatusText(http.StatusConflict)+": Deletion requires authorization by a second user before %s",
+		policy.PendingExpiration.Format(time.RFC3339))
+}
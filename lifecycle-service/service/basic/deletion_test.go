@@ -0,0 +1,135 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package basic
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/actions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+)
+
+func TestAuthorizeDeletion(t *testing.T) {
+	key := newTestRootKey(t)
+	svc := &basicService{logger: logger, keystores: backEndStrategy}
+	policies, err := backEndStrategy.NewPolicyDB()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	headersFor := func(user string) *communications.Headers {
+		return &communications.Headers{BluemixSpace: key.headers.BluemixSpace, BluemixOrg: key.headers.BluemixOrg, UserID: user}
+	}
+
+	// keys without the policy are deleted by a single user
+	if err := svc.authorizeDeletion(headersFor("user-1"), key.id); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := svc.policyAction(key, &actions.SecretAction{DualAuthDelete: &actions.DualAuthDelete{Enabled: true}}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer policies.DeleteDeletionPolicy(key.headers.BluemixSpace, key.headers.BluemixOrg, key.id)
+
+	// Bad Path: the users must be known
+	if err := svc.authorizeDeletion(headersFor(""), key.id); err == nil || !strings.HasPrefix(err.Error(), "Bad Request") {
+		t.Errorf("Expected a bad request, received %+v", err)
+	}
+
+	// the first user's delete is held until a second user authorizes it
+	if err := svc.authorizeDeletion(headersFor("user-1"), key.id); err == nil || !strings.HasPrefix(err.Error(), "Conflict") {
+		t.Errorf("Expected a conflict, received %+v", err)
+	}
+
+	policy, err := policies.GetDeletionPolicy(key.headers.BluemixSpace, key.headers.BluemixOrg, key.id)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if !policy.IsPending(time.Now()) || policy.PendingUserID != "user-1" {
+		t.Errorf("Expected a deletion pending for %s, received %+v", "user-1", policy)
+	}
+
+	// Bad Path: the same user cannot authorize twice
+	if err := svc.authorizeDeletion(headersFor("user-1"), key.id); err == nil || !strings.HasPrefix(err.Error(), "Conflict") {
+		t.Errorf("Expected a conflict, received %+v", err)
+	}
+
+	if err := svc.authorizeDeletion(headersFor("user-2"), key.id); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	// an expired authorization is replaced by the next user's
+	policy.PendingExpiration = time.Now().Add(-time.Minute)
+	if err := policies.SetDeletionPolicy(policy); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if err := svc.authorizeDeletion(headersFor("user-2"), key.id); err == nil || !strings.HasPrefix(err.Error(), "Conflict") {
+		t.Errorf("Expected a conflict, received %+v", err)
+	}
+
+	if policy, _ := policies.GetDeletionPolicy(key.headers.BluemixSpace, key.headers.BluemixOrg, key.id); policy.PendingUserID != "user-2" {
+		t.Errorf("Expected a deletion pending for %s, received %+v", "user-2", policy)
+	}
+
+	// Bad Path: a single user cannot disable the policy and then delete the key alone
+	expired := &db.DeletionPolicy{Space: key.headers.BluemixSpace, Org: key.headers.BluemixOrg, KpID: key.id}
+	if err := policies.SetDeletionPolicy(expired); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	key.headers.UserID = "user-1"
+	if _, err := svc.policyAction(key, &actions.SecretAction{DualAuthDelete: &actions.DualAuthDelete{}}); err != errDeletionPolicyEnabled {
+		t.Errorf("Expected %s, received %+v", errDeletionPolicyEnabled, err)
+	}
+
+	if err := svc.authorizeDeletion(headersFor("user-1"), key.id); err == nil || !strings.HasPrefix(err.Error(), "Conflict") {
+		t.Errorf("Expected a conflict, received %+v", err)
+	}
+
+	if _, err := policies.GetDeletionPolicy(key.headers.BluemixSpace, key.headers.BluemixOrg, key.id); err != nil {
+		t.Errorf("Expected the policy to be kept, received %+v", err)
+	}
+}
+
+func TestDisableDeletionPolicy(t *testing.T) {
+	key := newTestRootKey(t)
+	svc := &basicService{logger: logger, keystores: backEndStrategy}
+
+	// disabling a policy the key does not have leaves it without one
+	if _, err := svc.policyAction(key, &actions.SecretAction{DualAuthDelete: &actions.DualAuthDelete{}}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if err := svc.authorizeDeletion(&communications.Headers{BluemixSpace: key.headers.BluemixSpace, BluemixOrg: key.headers.BluemixOrg, UserID: "user-1"}, key.id); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+}
+
+func TestPolicyActionRefusedDeletionPolicy(t *testing.T) {
+	key := newTestRootKey(t)
+	svc := &basicService{logger: logger, keystores: backEndStrategy}
+
+	enable := &actions.SecretAction{DualAuthDelete: &actions.DualAuthDelete{Enabled: true}}
+	if _, err := svc.policyAction(key, enable); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// Bad Path: disabling the deletion policy is refused, and so is the rotation policy of the same request
+	action := &actions.SecretAction{RotationPolicy: &actions.RotationPolicy{IntervalDays: 30}, DualAuthDelete: &actions.DualAuthDelete{}}
+	if _, err := svc.policyAction(key, action); err != errDeletionPolicyEnabled {
+		t.Fatalf("Expected %s, received %+v", errDeletionPolicyEnabled, err)
+	}
+
+	policies, err := svc.keystores.NewPolicyDB()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := policies.GetPolicy(key.headers.BluemixSpace, key.headers.BluemixOrg, key.id); err != db.ErrNotFound {
+		t.Errorf("Expected the rotation policy to be left unset, received %+v", err)
+	}
+}
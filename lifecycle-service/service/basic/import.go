@@ -0,0 +1,122 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package basic
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"context"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/importtoken"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	corecomms "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/tokens"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+)
+
+const (
+	// DefaultImportTokenExpiration is the lifetime of an import token in seconds when none is configured
+	DefaultImportTokenExpiration = 600
+
+	// DefaultImportTokenKeyBits is the size of the RSA keypair of an import token when none is configured
+	DefaultImportTokenKeyBits = 4096
+)
+
+// importTokens holds the import keypair of each space, in the database shared by the replicas of the service
+var importTokens *importtoken.Store
+
+func init() {
+	expiration := config.GetInt("importToken.expirationSeconds")
+	if expiration <= 0 {
+		expiration = DefaultImportTokenExpiration
+	}
+
+	bits := config.GetInt("importToken.keyBits")
+	if bits <= 0 {
+		bits = DefaultImportTokenKeyBits
+	}
+
+	importTokens = importtoken.NewStore(db.NewImportTokenDBInstance, importtoken.LoadSealKey, time.Second*time.Duration(expiration), bits)
+}
+
+// validImportKeyLengths are the AES key sizes, in bytes, accepted as imported root key material
+var validImportKeyLengths = map[int]bool{16: true, 24: true, 32: true}
+
+// ImportToken returns the import token of the space, creating one if the space has no token that is unused and unexpired.
+// The response holds the base64 encoded public key that key material must be encrypted under before it is imported.
+func (svc *basicService) ImportToken(_ context.Context, request *communications.BaseRequest) (*corecomms.ImportTokenResponse, error) {
+	headers := request.Headers
+	if headers == nil {
+		badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": Requires Headers")
+		svc.logger.Log("err", badRequest.Error())
+		return nil, badRequest
+	}
+
+	if headers.BluemixSpace == "" {
+		badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": Requires Bluemix-Space header")
+		svc.logger.Log("err", badRequest.Error(), "correlation_id", headers.CorrelationID)
+		return nil, badRequest
+	}
+
+	token, err := importTokens.Create(headers.BluemixSpace, headers.BluemixOrg)
+	if err != nil {
+		svc.logger.Log("err", err.Error(), "correlation_id", headers.CorrelationID)
+		return nil, err
+	}
+
+	response := corecomms.NewImportTokenResponse()
+	response.ImportToken = &tokens.ImportToken{
+		PublicKey:           base64.StdEncoding.EncodeToString(token.PublicKey),
+		EncryptionAlgorithm: importtoken.EncryptionAlgorithm,
+		ExpirationDate:      token.ExpirationDate.Format(time.RFC3339),
+	}
+	return response, nil
+}
+
+// Import performs steps to create a root key from key material encrypted under the import token of the space.
+// 1.  Decrypt the base64 encoded payload with the private key of the import token, which is then used up.
+// 2.  Check the key material is an AES key.
+// 3.  Create the key as with Post. Imported keys are never extractable, so the material is not returned.
+func (svc *basicService) Import(ctx context.Context, request *communications.SecretRequest) (*communications.SecretsResponse, error) {
+	headers := request.Headers
+	if headers == nil {
+		badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": Requires Headers")
+		svc.logger.Log("err", badRequest.Error())
+		return nil, badRequest
+	}
+
+	secret := request.Secret
+	if secret == nil || secret.Payload == "" {
+		badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": Import requires a payload encrypted under the import token")
+		svc.logger.Log("err", badRequest.Error(), "correlation_id", headers.CorrelationID)
+		return nil, badRequest
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(secret.Payload)
+	if err != nil {
+		badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": Payload must be base64 encoded")
+		svc.logger.Log("err", badRequest.Error(), "correlation_id", headers.CorrelationID)
+		return nil, badRequest
+	}
+
+	material, err := importTokens.Decrypt(headers.BluemixSpace, headers.BluemixOrg, ciphertext)
+	if err != nil {
+		svc.logger.Log("err", err.Error(), "correlation_id", headers.CorrelationID)
+		return nil, err
+	}
+
+	if !validImportKeyLengths[len(material)] {
+		badRequest := errors.New(http.StatusText(http.StatusBadRequest) + ": Imported key material must be a 128, 192 or 256 bit AES key")
+		svc.logger.Log("err", badRequest.Error(), "correlation_id", headers.CorrelationID)
+		return nil, badRequest
+	}
+
+	extractable := false
+	secret.Extractable = &extractable
+	secret.Payload = base64.StdEncoding.EncodeToString(material)
+
+	return svc.Post(ctx, request)
+}
@@ -0,0 +1,182 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package basic
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"context"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/importtoken"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+// importPayload encrypts material under the base64 encoded public key of an import token the way a client would
+func importPayload(t *testing.T, publicKey string, material []byte) string {
+	der, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key.(*rsa.PublicKey), material, nil)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext)
+}
+
+// newTestImportTokens returns a Store keeping import tokens in a database of their own
+func newTestImportTokens() *importtoken.Store {
+	database := db.NewMemoryDB()
+	return importtoken.NewStore(
+		func() (db.ImportTokenDB, error) { return database, nil },
+		func() ([]byte, error) { return bytes.Repeat([]byte{0x24}, importtoken.SealKeyLength), nil },
+		time.Minute, 1024)
+}
+
+func TestImportToken(t *testing.T) {
+	importTokens = newTestImportTokens()
+	svc := Service(logger, backEndStrategy, nil)
+
+	testRequest := communications.NewBaseRequest()
+	testRequest.SetHeaders(&communications.Headers{BluemixSpace: "space-1234", BluemixOrg: "org-1234"})
+
+	response, err := svc.ImportToken(context.Background(), testRequest)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if response.EncryptionAlgorithm != importtoken.EncryptionAlgorithm {
+		t.Errorf("Expected %s, received %s", importtoken.EncryptionAlgorithm, response.EncryptionAlgorithm)
+	}
+
+	if err := isValidDate(response.ExpirationDate); err != nil {
+		t.Errorf("Expected an RFC3339 expiration date, received %s", response.ExpirationDate)
+	}
+
+	// the public key of the response opens the space's token
+	material := bytes.Repeat([]byte{0x42}, 32)
+	ciphertext, _ := base64.StdEncoding.DecodeString(importPayload(t, response.PublicKey, material))
+	decrypted, err := importTokens.Decrypt("space-1234", "org-1234", ciphertext)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if !bytes.Equal(material, decrypted) {
+		t.Errorf("Expected %x, received %x", material, decrypted)
+	}
+
+	// Bad Path: the token belongs to a space
+	testRequest.SetHeaders(&communications.Headers{BluemixOrg: "org-1234"})
+	if _, err := svc.ImportToken(context.Background(), testRequest); err == nil || !strings.HasPrefix(err.Error(), "Bad Request") {
+		t.Errorf("Expected a bad request, received %+v", err)
+	}
+}
+
+func TestImportValidation(t *testing.T) {
+	importTokens = newTestImportTokens()
+	svc := Service(logger, backEndStrategy, nil)
+	headers := &communications.Headers{BluemixSpace: "space-1234", BluemixOrg: "org-1234"}
+
+	tests := []struct {
+		name    string
+		space   string
+		payload func(publicKey string) string
+		err     error
+	}{
+		{name: "missing payload", space: "space-1234", payload: func(string) string { return "" }},
+		{name: "payload not base64", space: "space-1234", payload: func(string) string { return "not base64!" }},
+		{name: "not an AES key", space: "space-1234", payload: func(publicKey string) string { return importPayload(t, publicKey, []byte("short")) }},
+		{name: "no token for space", space: "space-5678", payload: func(publicKey string) string {
+			return importPayload(t, publicKey, bytes.Repeat([]byte{0x42}, 32))
+		}, err: importtoken.ErrTokenNotFound},
+		{name: "not encrypted under token", space: "space-1234", payload: func(string) string {
+			return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0x42}, 32))
+		}, err: importtoken.ErrInvalidPayload},
+	}
+
+	for _, test := range tests {
+		// a token is used by every payload it decrypts, so each test gets the token of the space anew
+		tokenRequest := communications.NewBaseRequest()
+		tokenRequest.SetHeaders(headers)
+		token, err := svc.ImportToken(context.Background(), tokenRequest)
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+
+		secret := secrets.NewSecret()
+		secret.Name = "imported-key"
+		secret.Payload = test.payload(token.PublicKey)
+
+		testRequest := communications.NewSecretRequest()
+		testRequest.SetHeaders(&communications.Headers{BluemixSpace: test.space, BluemixOrg: "org-1234"})
+		testRequest.SetSecret(secret)
+
+		_, err = svc.Import(context.Background(), testRequest)
+		if test.err != nil && err != test.err {
+			t.Errorf("%s: Expected %s, received %+v", test.name, test.err, err)
+		}
+
+		if test.err == nil && (err == nil || !strings.HasPrefix(err.Error(), "Bad Request")) {
+			t.Errorf("%s: Expected a bad request, received %+v", test.name, err)
+		}
+	}
+}
+
+func TestImportTokenSingleUse(t *testing.T) {
+	importTokens = newTestImportTokens()
+	svc := Service(logger, backEndStrategy, nil)
+	headers := &communications.Headers{BluemixSpace: "space-1234", BluemixOrg: "org-1234"}
+
+	tokenRequest := communications.NewBaseRequest()
+	tokenRequest.SetHeaders(headers)
+	token, err := svc.ImportToken(context.Background(), tokenRequest)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// the space keeps its keypair while the token is valid
+	again, err := svc.ImportToken(context.Background(), tokenRequest)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if again.PublicKey != token.PublicKey {
+		t.Error("Expected the import token of the space to be returned again")
+	}
+
+	payload := importPayload(t, token.PublicKey, []byte("short"))
+	for attempt := 0; attempt < 2; attempt++ {
+		secret := secrets.NewSecret()
+		secret.Name = "imported-key"
+		secret.Payload = payload
+
+		testRequest := communications.NewSecretRequest()
+		testRequest.SetHeaders(headers)
+		testRequest.SetSecret(secret)
+
+		_, err := svc.Import(context.Background(), testRequest)
+		if attempt == 0 && (err == nil || !strings.HasPrefix(err.Error(), "Bad Request")) {
+			t.Errorf("Expected a bad request, received %+v", err)
+		}
+
+		// Bad Path: the payload cannot be imported again under the same token
+		if attempt == 1 && err != importtoken.ErrTokenNotFound {
+			t.Errorf("Expected %s, received %+v", importtoken.ErrTokenNotFound, err)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package basic
+
+import (
+	"sync"
+	"time"
+
+	"context"
+
+	"github.com/go-kit/kit/log"
+	"google.golang.org/grpc"
+
+	"github.ibm.com/Alchemy-Key-Protect/go-db-service/services/metadata/client"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+const (
+	// OrderPollMaxDelay caps the delay between two polls of the same order
+	OrderPollMaxDelay = time.Minute
+
+	// OrderPollMaxAge is how long an order is polled before the poller gives up on it. The key is still
+	// activated by the next Get or List.
+	OrderPollMaxAge = time.Hour
+)
+
+// pendingOrder is a generated key waiting for its material
+type pendingOrder struct {
+	id       string
+	headers  *communications.Headers
+	tracked  time.Time
+	nextPoll time.Time
+	polls    uint
+}
+
+// OrderPoller activates generated keys in the background, so they leave the Preactivation state without a
+// Get or List. Keys are tracked in memory, so keys pending when the service stops are activated by their next
+// Get or List instead. Orders are polled without the token of the user that generated the key, so only keys
+// routed to keystores that authenticate as the service are tracked.
+type OrderPoller struct {
+	logger    log.Logger
+	interval  time.Duration
+	keystores *keystore.Router
+
+	// activate checks the order of a key and updates its metadata, returning the state of the key
+	activate func(ctx context.Context, order *pendingOrder) (secrets.KeyStates, error)
+
+	lock    sync.Mutex
+	pending map[string]*pendingOrder
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewOrderPoller returns a poller that checks for due orders every interval, through the keystore each key is
+// routed to. Orders are first polled after interval, then at doubling delays. An interval of zero returns a nil
+// poller, which tracks nothing.
+func NewOrderPoller(logger log.Logger, keystores *keystore.Router, interval time.Duration) *OrderPoller {
+	if interval <= 0 {
+		return nil
+	}
+
+	svc := &basicService{
+		logger:    logger,
+		keystores: keystores,
+	}
+
+	return &OrderPoller{
+		logger:    logger,
+		interval:  interval,
+		keystores: keystores,
+		activate:  svc.activateOrder,
+		pending:   make(map[string]*pendingOrder),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Track polls the order of a key generated with the headers until the key leaves the Preactivation state. The
+// token of the user is not kept, so keys routed to a keystore that requires it are left to their next Get or List.
+func (p *OrderPoller) Track(headers *communications.Headers, id string) {
+	if p == nil {
+		return
+	}
+
+	if err := keystore.CheckServiceAuth(p.keystores.Backend(headers.BluemixSpace, headers.BluemixOrg)); err != nil {
+		return
+	}
+
+	now := time.Now()
+	tracked := *headers
+	tracked.Authorization = ""
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.pending[id] = &pendingOrder{id: id, headers: &tracked, tracked: now, nextPoll: now.Add(p.interval)}
+}
+
+// CheckServiceAuth reports an error if keys may be routed to a keystore that cannot be called without the token of a
+// user, as the poller cannot track them. A nil poller tracks nothing, and reports no error.
+func (p *OrderPoller) CheckServiceAuth() error {
+	if p == nil {
+		return nil
+	}
+	return p.keystores.CheckServiceAuth()
+}
+
+// Pending returns the number of keys being tracked
+func (p *OrderPoller) Pending() int {
+	if p == nil {
+		return 0
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.pending)
+}
+
+// Start checks for due orders every interval until Stop is called
+func (p *OrderPoller) Start() {
+	if p == nil {
+		return
+	}
+
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.PollDue(context.Background(), time.Now())
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the poller and waits for polls in progress to finish
+func (p *OrderPoller) Stop() {
+	if p == nil {
+		return
+	}
+
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		<-p.done
+	})
+}
+
+// PollDue polls every order due at now and returns how many keys left the Preactivation state. Orders still
+// pending, or that could not be checked, are polled again after a longer delay.
+func (p *OrderPoller) PollDue(ctx context.Context, now time.Time) int {
+	var due []*pendingOrder
+	p.lock.Lock()
+	for _, order := range p.pending {
+		if !order.nextPoll.After(now) {
+			due = append(due, order)
+		}
+	}
+	p.lock.Unlock()
+
+	resolved := 0
+	for _, order := range due {
+		state, err := p.activate(ctx, order)
+		if err != nil {
+			p.logger.Log("err", err.Error(), "msg", "Unable to check order", "id", order.id, "correlation_id", order.headers.CorrelationID)
+		}
+
+		p.lock.Lock()
+		switch {
+		case err == nil && state != secrets.Preactivation:
+			delete(p.pending, order.id)
+			resolved++
+			p.logger.Log("msg", "Generated key left Preactivation", "id", order.id, "state", state, "correlation_id", order.headers.CorrelationID)
+		case now.Sub(order.tracked) >= OrderPollMaxAge:
+			delete(p.pending, order.id)
+			p.logger.Log("msg", "Gave up polling order", "id", order.id, "correlation_id", order.headers.CorrelationID)
+		default:
+			order.polls++
+			delay := p.interval << order.polls
+			if delay <= 0 || delay > OrderPollMaxDelay {
+				delay = OrderPollMaxDelay
+			}
+			order.nextPoll = now.Add(delay)
+		}
+		p.lock.Unlock()
+	}
+	return resolved
+}
+
+// activateOrder checks the order of a generated key, promoting its material and updating or cleaning up its
+// metadata as Get and List do
+func (svc *basicService) activateOrder(ctx context.Context, order *pendingOrder) (secrets.KeyStates, error) {
+	secretService, err := svc.keystores.NewKeystore(order.headers, svc.logger)
+	if err != nil {
+		return secrets.Preactivation, err
+	}
+
+	conn, err := grpc.Dial(dbServerPath, grpc.WithInsecure(), grpc.WithTimeout(time.Second*time.Duration(timeout)))
+	if err != nil {
+		return secrets.Preactivation, err
+	}
+	defer conn.Close()
+
+	dbClient := client.NewClient(conn, log.NewNopLogger())
+	updateRequest := communications.NewUpdateRequest()
+	updateRequest.SetHeaders(order.headers)
+	updateRequest.SetID(order.id)
+
+	// The metadata of the key is read first, so it can be mirrored into the keystore once the key is activated
+	metadata, err := getMetadata(ctx, dbClient, order.headers, order.id)
+	if err != nil {
+		return secrets.Preactivation, err
+	}
+	if metadata.State != secrets.Preactivation {
+		return metadata.State, nil
+	}
+
+	checkStatus(ctx, []*secrets.Secret{metadata}, dbClient, updateRequest, secretService)
+	return metadata.State, nil
+}
@@ -0,0 +1,165 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package basic
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"context"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+var testOrderHeaders = &communications.Headers{BluemixSpace: "space-orders", BluemixOrg: "org-1234", CorrelationID: "correlation-id"}
+
+// testUserAuthKeystore is the mock keystore, registered as a backend that requires the token of a user
+const testUserAuthKeystore = "test-user-auth"
+
+func init() {
+	keystore.Register(testUserAuthKeystore, keystore.Backend{
+		NewKeystore: keystore.NewRouter(keystore.Mock).NewKeystore,
+		NewPolicyDB: func() (db.PolicyDB, error) { return keystore.NewPolicyDB(keystore.Mock) },
+		PolicyStore: keystore.MockPolicyStore,
+		ServiceAuth: func() error {
+			return errors.New(http.StatusText(http.StatusInternalServerError) + ": Test keystore requires the token of a user")
+		},
+	})
+}
+
+func newTestOrderPoller(t *testing.T, states map[string]secrets.KeyStates) (*OrderPoller, map[string]int) {
+	poller := NewOrderPoller(logger, backEndStrategy, time.Second)
+	if poller == nil {
+		t.Fatal("Expected a poller")
+	}
+
+	polled := make(map[string]int)
+	poller.activate = func(_ context.Context, order *pendingOrder) (secrets.KeyStates, error) {
+		polled[order.id]++
+		return states[order.id], nil
+	}
+	return poller, polled
+}
+
+func TestPollDue(t *testing.T) {
+	states := map[string]secrets.KeyStates{"active-key": secrets.Activation, "pending-key": secrets.Preactivation}
+	poller, polled := newTestOrderPoller(t, states)
+	for id := range states {
+		poller.Track(testOrderHeaders, id)
+	}
+	now := time.Now()
+
+	// orders are first polled after the interval
+	if resolved := poller.PollDue(context.Background(), now); resolved != 0 || len(polled) != 0 {
+		t.Errorf("Expected no polls, received %v", polled)
+	}
+
+	now = now.Add(time.Second)
+	if resolved := poller.PollDue(context.Background(), now); resolved != 1 {
+		t.Errorf("Expected %d, received %d", 1, resolved)
+	}
+	if pending := poller.Pending(); pending != 1 {
+		t.Errorf("Expected only the pending key to be tracked, received %d keys", pending)
+	}
+
+	// the pending key is polled again after twice the interval
+	poller.PollDue(context.Background(), now.Add(time.Second))
+	if polled["pending-key"] != 1 {
+		t.Errorf("Expected the pending key to back off, received %d polls", polled["pending-key"])
+	}
+
+	states["pending-key"] = secrets.Destroyed
+	if resolved := poller.PollDue(context.Background(), now.Add(2*time.Second)); resolved != 1 {
+		t.Errorf("Expected %d, received %d", 1, resolved)
+	}
+	if polled["active-key"] != 1 || polled["pending-key"] != 2 || poller.Pending() != 0 {
+		t.Errorf("Expected both keys to be resolved, received %v", polled)
+	}
+}
+
+func TestPollDueFailure(t *testing.T) {
+	poller, _ := newTestOrderPoller(t, nil)
+	errCheck := errors.New(http.StatusText(http.StatusServiceUnavailable) + ": Barbican is unavailable. Please try again later.")
+	poller.activate = func(_ context.Context, order *pendingOrder) (secrets.KeyStates, error) {
+		return secrets.Preactivation, errCheck
+	}
+
+	poller.Track(testOrderHeaders, "failing-key")
+	now := time.Now()
+	if resolved := poller.PollDue(context.Background(), now.Add(time.Second)); resolved != 0 || poller.Pending() != 1 {
+		t.Errorf("Expected the key to be polled again, received %d resolved and %d pending", resolved, poller.Pending())
+	}
+
+	// the poller gives up once the order is too old, leaving the key to Get and List
+	if resolved := poller.PollDue(context.Background(), now.Add(OrderPollMaxAge)); resolved != 0 || poller.Pending() != 0 {
+		t.Errorf("Expected the key to be dropped, received %d resolved and %d pending", resolved, poller.Pending())
+	}
+}
+
+func TestTrackServiceAuth(t *testing.T) {
+	poller := NewOrderPoller(logger, keystore.NewRouter(keystore.Mock).RouteSpace("space-user-auth", testUserAuthKeystore), time.Second)
+
+	// the keystore that cannot be polled is reported
+	if err := poller.CheckServiceAuth(); err == nil {
+		t.Error("Expected an error for the keystore requiring the token of a user")
+	}
+	if err := NewOrderPoller(logger, keystore.NewRouter(keystore.Mock), time.Second).CheckServiceAuth(); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	// the token of the user is not kept while the order is polled
+	headers := *testOrderHeaders
+	headers.Authorization = "Bearer user-token"
+	poller.Track(&headers, "service-key")
+	if order := poller.pending["service-key"]; order == nil || order.headers.Authorization != "" {
+		t.Errorf("Expected the key to be tracked without the token, received %+v", order)
+	}
+
+	// keys routed to a keystore that requires the token are left to Get and List
+	headers.BluemixSpace = "space-user-auth"
+	poller.Track(&headers, "user-key")
+	if pending := poller.Pending(); pending != 1 {
+		t.Errorf("Expected only the first key to be tracked, received %d keys", pending)
+	}
+}
+
+func TestDisabledOrderPoller(t *testing.T) {
+	poller := NewOrderPoller(logger, backEndStrategy, 0)
+	if poller != nil {
+		t.Fatal("Expected a nil poller")
+	}
+
+	if err := poller.CheckServiceAuth(); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	poller.Track(testOrderHeaders, "untracked-key")
+	poller.Start()
+	poller.Stop()
+	if pending := poller.Pending(); pending != 0 {
+		t.Errorf("Expected %d, received %d", 0, pending)
+	}
+}
+
+func TestOrderPollerStop(t *testing.T) {
+	poller, _ := newTestOrderPoller(t, nil)
+	poller.Start()
+
+	stopped := make(chan struct{})
+	go func() {
+		poller.Stop()
+		poller.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("Expected the poller to stop")
+	}
+}
@@ -0,0 +1,203 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package basic
+
+import (
+	"sync"
+	"time"
+
+	"context"
+
+	"github.com/go-kit/kit/log"
+	uuid "github.com/satori/go.uuid"
+	"google.golang.org/grpc"
+
+	"github.ibm.com/Alchemy-Key-Protect/go-db-service/services/metadata/client"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/actions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
+)
+
+// rotationSchedulerUser is recorded as the user of the requests made by the rotation scheduler
+const rotationSchedulerUser = "rotation-scheduler"
+
+// RotationScheduler periodically rotates the root keys whose rotation policy is due
+type RotationScheduler struct {
+	logger   log.Logger
+	policies db.PolicyDB
+	interval time.Duration
+
+	// rotate rotates the key of a due policy, countRotation reports the outcome of every rotation
+	rotate        func(ctx context.Context, policy *db.RotationPolicy, rotated time.Time) error
+	countRotation func(err error)
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewRotationScheduler returns a scheduler that checks for due keys every interval and rotates them
+// through the keystore each key is routed to. countRotation is called with the result of each rotation.
+// The scheduler rotates keys without the token of a user, so every keystore must authenticate as the service.
+func NewRotationScheduler(logger log.Logger, keystores *keystore.Router, interval time.Duration, countRotation func(err error)) (*RotationScheduler, error) {
+	if err := keystores.CheckServiceAuth(); err != nil {
+		return nil, err
+	}
+
+	policies, err := keystores.NewPolicyDB()
+	if err != nil {
+		return nil, err
+	}
+
+	svc := &basicService{
+		logger:    logger,
+		keystores: keystores,
+	}
+
+	return &RotationScheduler{
+		logger:        logger,
+		policies:      policies,
+		interval:      interval,
+		rotate:        svc.rotateOnSchedule,
+		countRotation: countRotation,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}, nil
+}
+
+// Start checks for due keys every interval until Stop is called
+func (s *RotationScheduler) Start() {
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.RotateDueKeys(context.Background(), time.Now().UTC())
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the scheduler and waits for rotations in progress to finish
+func (s *RotationScheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+	})
+}
+
+// RotateDueKeys rotates every key whose policy is due at now and returns how many were rotated.
+// A key that fails to rotate keeps its schedule, so it is tried again on the next check.
+//
+// Every replica of the service runs a scheduler, so a due policy is first claimed by moving its next rotation
+// to the next check, and only the replica whose claim succeeds rotates the key. Should that replica stop
+// before rotating, the claim lapses and the key is due again on the next check.
+func (s *RotationScheduler) RotateDueKeys(ctx context.Context, now time.Time) int {
+	due, err := s.policies.ListDuePolicies(now)
+	if err != nil {
+		s.logger.Log("err", err.Error(), "msg", "Unable to list due rotation policies")
+		return 0
+	}
+
+	// Policies are stored to the second, so the claim is too
+	claim := now.Add(s.interval).Truncate(time.Second)
+
+	rotated := 0
+	for _, policy := range due {
+		scheduled := policy.NextRotation
+		claimed, err := s.policies.ClaimPolicy(policy, claim)
+		if err != nil {
+			s.logger.Log("err", err.Error(), "msg", "Unable to claim rotation policy", "id", policy.KpID, "space", policy.Space)
+			continue
+		}
+		if !claimed {
+			// Another replica is rotating the key, or its policy changed since it was listed
+			continue
+		}
+		policy.NextRotation = claim
+
+		errRotate := s.rotate(ctx, policy, now)
+		if errRotate != nil {
+			if _, errRelease := s.policies.ClaimPolicy(policy, scheduled); errRelease != nil {
+				s.logger.Log("err", errRelease.Error(), "msg", "Unable to release rotation policy", "id", policy.KpID, "space", policy.Space)
+			}
+		}
+
+		if s.countRotation != nil {
+			s.countRotation(errRotate)
+		}
+
+		if errRotate != nil {
+			s.logger.Log("err", errRotate.Error(), "msg", "Scheduled rotation failed", "id", policy.KpID, "space", policy.Space)
+			continue
+		}
+
+		// The key has been rotated, so failing to record it must not count as a failure. The claim is moved to
+		// the next rotation instead, or else stays in place, so the key is not rotated again before the next check.
+		policy.LastRotation = now
+		if errSet := s.policies.SetPolicy(policy.Schedule(now)); errSet != nil {
+			s.logger.Log("err", errSet.Error(), "msg", "Unable to record scheduled rotation", "id", policy.KpID, "space", policy.Space)
+
+			claimed := *policy
+			claimed.NextRotation = claim
+			if _, errClaim := s.policies.ClaimPolicy(&claimed, policy.NextRotation); errClaim != nil {
+				s.logger.Log("err", errClaim.Error(), "msg", "Unable to move rotation policy to its next rotation", "id", policy.KpID, "space", policy.Space)
+			}
+		}
+
+		s.logger.Log("msg", "Rotated root key on schedule", "id", policy.KpID, "space", policy.Space,
+			"next_rotation", policy.NextRotation.Format(time.RFC3339))
+		rotated++
+	}
+	return rotated
+}
+
+// rotateOnSchedule rotates the root key of a due policy and records the rotation date in the key's metadata
+func (svc *basicService) rotateOnSchedule(ctx context.Context, policy *db.RotationPolicy, rotated time.Time) error {
+	headers := &communications.Headers{
+		BluemixSpace:  policy.Space,
+		BluemixOrg:    policy.Org,
+		CorrelationID: uuid.NewV4().String(),
+		UserID:        rotationSchedulerUser,
+	}
+
+	key, err := svc.getRootKey(ctx, headers, policy.KpID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := svc.rotateAction(key, new(actions.SecretAction)); err != nil {
+		return err
+	}
+
+	// The key has been rotated, so failing to record the date must not cause it to be rotated again
+	if err := recordRotation(ctx, headers, policy.KpID, rotated); err != nil {
+		svc.logger.Log("err", err.Error(), "msg", "Unable to record rotation date", "correlation_id", headers.CorrelationID)
+	}
+	return nil
+}
+
+// recordRotation sets the last rotation date in the metadata of the key
+func recordRotation(ctx context.Context, headers *communications.Headers, id string, rotated time.Time) error {
+	conn, err := grpc.Dial(dbServerPath, grpc.WithInsecure(), grpc.WithTimeout(time.Second*time.Duration(timeout)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	client := client.NewClient(conn, log.NewNopLogger())
+	updateRequest := communications.NewUpdateRequest()
+	updateRequest.SetHeaders(headers)
+	updateRequest.SetID(id)
+	updateRequest.SetUpdates(map[string]string{"last_rotate_date": rotated.Format(time.RFC3339)})
+
+	_, err = client.Update(ctx, updateRequest)
+	return err
+}
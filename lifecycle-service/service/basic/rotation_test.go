@@ -0,0 +1,230 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package basic
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"context"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/db"
+)
+
+func newTestRotationScheduler(t *testing.T) *RotationScheduler {
+	scheduler, err := NewRotationScheduler(logger, backEndStrategy, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return scheduler
+}
+
+func TestRotateDueKeys(t *testing.T) {
+	scheduler := newTestRotationScheduler(t)
+	now := time.Date(2017, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	due := (&db.RotationPolicy{Space: "space-rotation", Org: "org-1234", KpID: "due-key", IntervalDays: 30}).Schedule(now.AddDate(0, 0, -30))
+	notDue := (&db.RotationPolicy{Space: "space-rotation", Org: "org-1234", KpID: "not-due-key", IntervalDays: 30}).Schedule(now.AddDate(0, 0, -1))
+	for _, policy := range []*db.RotationPolicy{due, notDue} {
+		if err := scheduler.policies.SetPolicy(policy); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		defer scheduler.policies.DeletePolicy(policy.Space, policy.Org, policy.KpID)
+	}
+
+	var rotatedIDs []string
+	scheduler.rotate = func(_ context.Context, policy *db.RotationPolicy, rotated time.Time) error {
+		rotatedIDs = append(rotatedIDs, policy.KpID)
+		return nil
+	}
+
+	var counted []error
+	scheduler.countRotation = func(err error) {
+		counted = append(counted, err)
+	}
+
+	if rotated := scheduler.RotateDueKeys(context.Background(), now); rotated != 1 {
+		t.Errorf("Expected %d, received %d", 1, rotated)
+	}
+
+	if len(rotatedIDs) != 1 || rotatedIDs[0] != due.KpID {
+		t.Errorf("Expected only %s to rotate, received %v", due.KpID, rotatedIDs)
+	}
+
+	if len(counted) != 1 || counted[0] != nil {
+		t.Errorf("Expected one successful rotation to be counted, received %v", counted)
+	}
+
+	policy, err := scheduler.policies.GetPolicy(due.Space, due.Org, due.KpID)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if !policy.LastRotation.Equal(now) {
+		t.Errorf("Expected last rotation %s, received %s", now, policy.LastRotation)
+	}
+
+	if expected := now.AddDate(0, 0, 30); !policy.NextRotation.Equal(expected) {
+		t.Errorf("Expected next rotation %s, received %s", expected, policy.NextRotation)
+	}
+
+	// the rotated key is not due again until its next rotation
+	if rotated := scheduler.RotateDueKeys(context.Background(), now.Add(time.Hour)); rotated != 0 {
+		t.Errorf("Expected %d, received %d", 0, rotated)
+	}
+}
+
+func TestRotateDueKeysFailure(t *testing.T) {
+	scheduler := newTestRotationScheduler(t)
+	now := time.Date(2017, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	due := (&db.RotationPolicy{Space: "space-rotation-failure", Org: "org-1234", KpID: "due-key", IntervalDays: 7}).Schedule(now.AddDate(0, 0, -7))
+	if err := scheduler.policies.SetPolicy(due); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer scheduler.policies.DeletePolicy(due.Space, due.Org, due.KpID)
+
+	errRotate := errors.New(http.StatusText(http.StatusConflict) + ": Key is not in the Activation state")
+	scheduler.rotate = func(_ context.Context, policy *db.RotationPolicy, rotated time.Time) error {
+		return errRotate
+	}
+
+	var counted []error
+	scheduler.countRotation = func(err error) {
+		counted = append(counted, err)
+	}
+
+	if rotated := scheduler.RotateDueKeys(context.Background(), now); rotated != 0 {
+		t.Errorf("Expected %d, received %d", 0, rotated)
+	}
+
+	if len(counted) != 1 || counted[0] != errRotate {
+		t.Errorf("Expected the failed rotation to be counted, received %v", counted)
+	}
+
+	// the key keeps its schedule so it is retried on the next check
+	policy, err := scheduler.policies.GetPolicy(due.Space, due.Org, due.KpID)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if !policy.NextRotation.Equal(due.NextRotation) || !policy.LastRotation.IsZero() {
+		t.Errorf("Expected the schedule to be unchanged, received %+v", policy)
+	}
+}
+
+// unsetPolicyDB fails to set policies, as a database does that becomes unavailable after a key is rotated
+type unsetPolicyDB struct {
+	db.PolicyDB
+}
+
+func (p *unsetPolicyDB) SetPolicy(policy *db.RotationPolicy) error {
+	return errors.New(http.StatusText(http.StatusServiceUnavailable) + ": Database unavailable")
+}
+
+func TestRotateDueKeysUnrecorded(t *testing.T) {
+	scheduler := newTestRotationScheduler(t)
+	now := time.Date(2017, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	due := (&db.RotationPolicy{Space: "space-rotation-unrecorded", Org: "org-1234", KpID: "due-key", IntervalDays: 7}).Schedule(now.AddDate(0, 0, -7))
+	if err := scheduler.policies.SetPolicy(due); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer scheduler.policies.DeletePolicy(due.Space, due.Org, due.KpID)
+	scheduler.policies = &unsetPolicyDB{PolicyDB: scheduler.policies}
+
+	rotations := 0
+	scheduler.rotate = func(_ context.Context, policy *db.RotationPolicy, rotated time.Time) error {
+		rotations++
+		return nil
+	}
+
+	var counted []error
+	scheduler.countRotation = func(err error) {
+		counted = append(counted, err)
+	}
+
+	// the key was rotated, even though its policy could not be updated
+	if rotated := scheduler.RotateDueKeys(context.Background(), now); rotated != 1 {
+		t.Errorf("Expected %d, received %d", 1, rotated)
+	}
+	if len(counted) != 1 || counted[0] != nil {
+		t.Errorf("Expected one successful rotation to be counted, received %v", counted)
+	}
+
+	// the claim is moved to the next rotation, so the key is not rotated again on the next check
+	policy, err := scheduler.policies.GetPolicy(due.Space, due.Org, due.KpID)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if expected := now.AddDate(0, 0, 7); !policy.NextRotation.Equal(expected) {
+		t.Errorf("Expected next rotation %s, received %s", expected, policy.NextRotation)
+	}
+	if rotated := scheduler.RotateDueKeys(context.Background(), now.Add(time.Hour)); rotated != 0 || rotations != 1 {
+		t.Errorf("Expected the key to be rotated once, received %d rotations", rotations)
+	}
+}
+
+// listedPolicyDB returns the policies listed before, as a replica does that lists due policies at the same time as another
+type listedPolicyDB struct {
+	db.PolicyDB
+	due []*db.RotationPolicy
+}
+
+func (p *listedPolicyDB) ListDuePolicies(now time.Time) ([]*db.RotationPolicy, error) {
+	return p.due, nil
+}
+
+func TestRotateDueKeysClaimed(t *testing.T) {
+	scheduler := newTestRotationScheduler(t)
+	now := time.Date(2017, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	due := (&db.RotationPolicy{Space: "space-rotation-claimed", Org: "org-1234", KpID: "due-key", IntervalDays: 7}).Schedule(now.AddDate(0, 0, -7))
+	if err := scheduler.policies.SetPolicy(due); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer scheduler.policies.DeletePolicy(due.Space, due.Org, due.KpID)
+
+	listed, err := scheduler.policies.ListDuePolicies(now)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	replica := newTestRotationScheduler(t)
+	replica.policies = &listedPolicyDB{PolicyDB: replica.policies, due: listed}
+
+	rotations := 0
+	scheduler.rotate = func(_ context.Context, policy *db.RotationPolicy, rotated time.Time) error {
+		rotations++
+		return nil
+	}
+	replica.rotate = scheduler.rotate
+
+	if rotated := scheduler.RotateDueKeys(context.Background(), now); rotated != 1 {
+		t.Errorf("Expected %d, received %d", 1, rotated)
+	}
+
+	// the replica listed the key as due too, but loses the claim
+	if rotated := replica.RotateDueKeys(context.Background(), now); rotated != 0 || rotations != 1 {
+		t.Errorf("Expected the key to be rotated once, received %d rotations and %d by the replica", rotations, rotated)
+	}
+}
+
+func TestRotationSchedulerStop(t *testing.T) {
+	scheduler := newTestRotationScheduler(t)
+	scheduler.Start()
+
+	stopped := make(chan struct{})
+	go func() {
+		scheduler.Stop()
+		scheduler.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("Expected the scheduler to stop")
+	}
+}
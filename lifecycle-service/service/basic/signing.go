@@ -0,0 +1,136 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package basic
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/algorithms"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keysign"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/actions"
+	corecomms "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/communications"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+// generateSigningKey generates the private key of an RSA or EC key as the payload of the secret, so any keystore
+// that holds payloads can hold the keypair. Signing keys are root keys, so the private key is never returned.
+func generateSigningKey(secret *secrets.Secret) error {
+	if len(secret.Payload) != 0 {
+		return errors.New(http.StatusText(http.StatusBadRequest) + ": Signing keys are generated by the service and cannot have a payload")
+	}
+
+	algorithmType := strings.ToUpper(secret.AlgorithmType)
+	bitLength := keysign.DefaultBitLength(algorithmType)
+	if value, ok := secret.AlgorithmMetadata["bitLength"]; ok {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return keysign.ErrInvalidBitLength
+		}
+		bitLength = parsed
+	}
+
+	material, err := keysign.GenerateKey(algorithmType, bitLength)
+	if err != nil {
+		return err
+	}
+
+	if secret.AlgorithmMetadata == nil {
+		secret.AlgorithmMetadata = make(map[string]string)
+	}
+
+	extractable := false
+	secret.AlgorithmType = algorithmType
+	secret.AlgorithmMetadata["bitLength"] = strconv.Itoa(bitLength)
+	secret.Extractable = &extractable
+	secret.Payload = base64.StdEncoding.EncodeToString(material)
+	return nil
+}
+
+// checkActionAlgorithm ensures the action can be performed with the algorithm of the key. Signing keys only sign
+// and verify, while only AES-GCM root keys wrap, unwrap, rewrap and rotate, as wrapping always uses AES-GCM.
+// Signing keys have no versions, so they are not rotated.
+func checkActionAlgorithm(metadata *secrets.Secret, name string, action *actions.SecretAction) error {
+	signingKey := keysign.IsSigningAlgorithm(metadata.AlgorithmType)
+	wrappingKey := algorithms.WrapsKeys(metadata.AlgorithmType, metadata.AlgorithmMetadata)
+
+	switch name {
+	case actions.Sign, actions.Verify:
+		if !signingKey {
+			return errors.New(http.StatusText(http.StatusBadRequest) + ": " + name + " is only supported on RSA and EC keys")
+		}
+	case actions.Wrap, actions.Unwrap, actions.Rewrap, actions.Rotate:
+		if !wrappingKey {
+			return errors.New(http.StatusText(http.StatusBadRequest) + ": " + name + " is only supported on AES-GCM keys")
+		}
+	case actions.Policy:
+		if !wrappingKey && action.RotationPolicy != nil && action.RotationPolicy.IntervalDays != 0 {
+			return errors.New(http.StatusText(http.StatusBadRequest) + ": Rotation policies are only supported on AES-GCM keys")
+		}
+	default:
+		if signingKey {
+			return errors.New(http.StatusText(http.StatusBadRequest) + ": " + name + " is not supported on signing keys")
+		}
+	}
+	return nil
+}
+
+// signAction signs the SHA-256 digest of the request with the private key of the signing key
+func signAction(key *rootKey, action *actions.SecretAction) (*corecomms.SecretActionResponse, error) {
+	digest, err := decodeActionField("Digest", action.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	material, _, err := getKeyMaterial(key, definitions.CurrentVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := keysign.Sign(material, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, err := keysign.SignatureAlgorithm(material)
+	if err != nil {
+		return nil, err
+	}
+
+	response := corecomms.NewSecretActionResponse()
+	response.Signature = base64.StdEncoding.EncodeToString(signature)
+	response.SignatureAlgorithm = algorithm
+	return response, nil
+}
+
+// verifyAction checks the signature of the request was made over its SHA-256 digest by the signing key.
+// A signature that does not verify is not an error, the response reports whether it verified.
+func verifyAction(key *rootKey, action *actions.SecretAction) (*corecomms.SecretActionResponse, error) {
+	digest, err := decodeActionField("Digest", action.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := decodeActionField("Signature", action.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	material, _, err := getKeyMaterial(key, definitions.CurrentVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	verified, err := keysign.Verify(material, digest, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	response := corecomms.NewSecretActionResponse()
+	response.Verified = &verified
+	return response, nil
+}
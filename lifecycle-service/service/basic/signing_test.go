@@ -0,0 +1,158 @@
+// © Copyright 2017 IBM Corp. Licensed Materials – Property of IBM.
+
+package basic
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/algorithms"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keysign"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/actions"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
+)
+
+// newTestSigningKey creates a signing key of the algorithm type in the mock keystore
+func newTestSigningKey(t *testing.T, algorithmType string) *rootKey {
+	key := newTestRootKey(t)
+
+	metadata := secrets.NewSecret()
+	metadata.AlgorithmType = algorithmType
+	if err := generateSigningKey(metadata); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	id, err := key.keystore.CreateSecret(context.Background(), metadata, nil)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	key.id = id
+	key.metadata = metadata
+	return key
+}
+
+func TestSignVerifyAction(t *testing.T) {
+	digest := sha256.Sum256([]byte("artifact"))
+	encodedDigest := base64.StdEncoding.EncodeToString(digest[:])
+
+	for _, algorithmType := range []string{"rsa", keysign.EC} {
+		key := newTestSigningKey(t, algorithmType)
+
+		if key.metadata.Extractable == nil || *key.metadata.Extractable {
+			t.Errorf("Expected the %s key not to be extractable", algorithmType)
+		}
+
+		signResponse, err := signAction(key, &actions.SecretAction{Digest: encodedDigest})
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+
+		if signResponse.Signature == "" || signResponse.SignatureAlgorithm == "" {
+			t.Errorf("Expected a signature and its algorithm, received %+v", signResponse.SecretAction)
+		}
+
+		verifyResponse, err := verifyAction(key, &actions.SecretAction{Digest: encodedDigest, Signature: signResponse.Signature})
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+
+		if verifyResponse.Verified == nil || !*verifyResponse.Verified {
+			t.Errorf("Expected the %s signature to verify, received %+v", algorithmType, verifyResponse.SecretAction)
+		}
+
+		otherDigest := sha256.Sum256([]byte("other-artifact"))
+		verifyResponse, err = verifyAction(key, &actions.SecretAction{Digest: base64.StdEncoding.EncodeToString(otherDigest[:]), Signature: signResponse.Signature})
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+
+		if verifyResponse.Verified == nil || *verifyResponse.Verified {
+			t.Errorf("Expected the %s signature not to verify another digest, received %+v", algorithmType, verifyResponse.SecretAction)
+		}
+
+		// Bad Path: digest is not base64
+		if _, err := signAction(key, &actions.SecretAction{Digest: "not base64!"}); err == nil {
+			t.Error("Expected Error")
+		}
+	}
+}
+
+func TestGenerateSigningKeyValidation(t *testing.T) {
+	// Bad Path: signing keys cannot be imported as a payload
+	secret := secrets.NewSecret()
+	secret.AlgorithmType = keysign.RSA
+	secret.Payload = "test-payload"
+	if err := generateSigningKey(secret); err == nil {
+		t.Error("Expected Error")
+	}
+
+	// Bad Path: unsupported size
+	secret = secrets.NewSecret()
+	secret.AlgorithmType = keysign.EC
+	secret.AlgorithmMetadata = map[string]string{"bitLength": "128"}
+	if err := generateSigningKey(secret); err != keysign.ErrInvalidBitLength {
+		t.Errorf("Expected %s, received %+v", keysign.ErrInvalidBitLength, err)
+	}
+}
+
+func TestCheckActionAlgorithm(t *testing.T) {
+	aesKey := secrets.NewSecret()
+	aesKey.AlgorithmType = "AES"
+
+	signingKey := secrets.NewSecret()
+	signingKey.AlgorithmType = keysign.EC
+
+	hmacKey := secrets.NewSecret()
+	hmacKey.AlgorithmType = algorithms.HMACSHA256
+	hmacKey.AlgorithmMetadata = map[string]string{algorithms.BitLengthKey: "256"}
+
+	cbcKey := secrets.NewSecret()
+	cbcKey.AlgorithmType = algorithms.AES
+	cbcKey.AlgorithmMetadata = map[string]string{algorithms.BitLengthKey: "256", algorithms.ModeKey: "CBC"}
+
+	gcmKey := secrets.NewSecret()
+	gcmKey.AlgorithmType = algorithms.AES
+	gcmKey.AlgorithmMetadata = map[string]string{algorithms.BitLengthKey: "256", algorithms.ModeKey: "GCM"}
+
+	tests := []struct {
+		metadata *secrets.Secret
+		name     string
+		action   *actions.SecretAction
+		pass     bool
+	}{
+		{metadata: aesKey, name: actions.Wrap, action: new(actions.SecretAction), pass: true},
+		{metadata: aesKey, name: actions.Sign, action: new(actions.SecretAction), pass: false},
+		{metadata: signingKey, name: actions.Sign, action: new(actions.SecretAction), pass: true},
+		{metadata: signingKey, name: actions.Verify, action: new(actions.SecretAction), pass: true},
+		{metadata: signingKey, name: actions.Unwrap, action: new(actions.SecretAction), pass: false},
+		{metadata: signingKey, name: actions.Rotate, action: new(actions.SecretAction), pass: false},
+		{metadata: signingKey, name: actions.Policy, action: &actions.SecretAction{RotationPolicy: &actions.RotationPolicy{IntervalDays: 30}}, pass: false},
+		{metadata: signingKey, name: actions.Policy, action: &actions.SecretAction{DualAuthDelete: &actions.DualAuthDelete{Enabled: true}}, pass: true},
+		{metadata: gcmKey, name: actions.Rewrap, action: new(actions.SecretAction), pass: true},
+		{metadata: gcmKey, name: actions.Rotate, action: new(actions.SecretAction), pass: true},
+		{metadata: hmacKey, name: actions.Wrap, action: new(actions.SecretAction), pass: false},
+		{metadata: hmacKey, name: actions.Unwrap, action: new(actions.SecretAction), pass: false},
+		{metadata: hmacKey, name: actions.Rewrap, action: new(actions.SecretAction), pass: false},
+		{metadata: hmacKey, name: actions.Rotate, action: new(actions.SecretAction), pass: false},
+		{metadata: hmacKey, name: actions.Policy, action: &actions.SecretAction{RotationPolicy: &actions.RotationPolicy{IntervalDays: 30}}, pass: false},
+		{metadata: hmacKey, name: actions.Disable, action: new(actions.SecretAction), pass: true},
+		{metadata: cbcKey, name: actions.Wrap, action: new(actions.SecretAction), pass: false},
+		{metadata: cbcKey, name: actions.Unwrap, action: new(actions.SecretAction), pass: false},
+		{metadata: cbcKey, name: actions.Rewrap, action: new(actions.SecretAction), pass: false},
+		{metadata: cbcKey, name: actions.Rotate, action: new(actions.SecretAction), pass: false},
+		{metadata: cbcKey, name: actions.Policy, action: &actions.SecretAction{DualAuthDelete: &actions.DualAuthDelete{Enabled: true}}, pass: true},
+	}
+
+	for _, test := range tests {
+		err := checkActionAlgorithm(test.metadata, test.name, test.action)
+		if test.pass && err != nil {
+			t.Errorf("%s on %s: Unexpected Error: %s", test.name, test.metadata.AlgorithmType, err)
+		}
+		if !test.pass && err == nil {
+			t.Errorf("%s on %s: Expected Error", test.name, test.metadata.AlgorithmType)
+		}
+	}
+}
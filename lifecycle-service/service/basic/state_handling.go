@@ -58,14 +58,14 @@ func getInactiveKeys(metadataArr []*secrets.Secret) []*secrets.Secret {
 	return inactives
 }
 
-func checkStatus(inactives []*secrets.Secret, client dbDef.Service, updateRequest *communications.UpdateRequest, strategy definitions.Keystore) {
+func checkStatus(ctx context.Context, inactives []*secrets.Secret, client dbDef.Service, updateRequest *communications.UpdateRequest, strategy definitions.Keystore) {
 	if len(inactives) == 0 {
 		return
 	}
 	wg := sync.WaitGroup{}
 	wg.Add(len(inactives))
 	for _, inactiveSecret := range inactives {
-		state, err := strategy.CheckSecret(inactiveSecret.ID)
+		state, err := strategy.CheckSecret(ctx, inactiveSecret.ID)
 		if err == nil {
 			// Check for generation error
 			if state == secrets.Destroyed {
@@ -77,6 +77,9 @@ func checkStatus(inactives []*secrets.Secret, client dbDef.Service, updateReques
 
 				client.Update(context.Background(), updateRequest)
 				inactiveSecret.SetState(state)
+
+				// The key has a secret in the keystore now, so its metadata can be mirrored
+				syncMetadata(ctx, strategy, inactiveSecret)
 			}
 		}
 		wg.Done()
@@ -84,6 +87,15 @@ func checkStatus(inactives []*secrets.Secret, client dbDef.Service, updateReques
 	wg.Wait()
 }
 
+// syncMetadata mirrors the metadata of the key into the keystore, if the keystore keeps a copy of it
+func syncMetadata(ctx context.Context, strategy definitions.Keystore, metadata *secrets.Secret) error {
+	mirror, ok := strategy.(definitions.MetadataMirror)
+	if !ok {
+		return nil
+	}
+	return mirror.PutMetadata(ctx, metadata.ID, metadata)
+}
+
 //Returns if the secret is active secretsd on the secret's activation date.
 func handleActivationTime(metadata *secrets.Secret) (bool, error) {
 	if len(metadata.ActivationDate) == 0 {
@@ -201,13 +213,13 @@ func handleStateChange(metadata *secrets.Secret, updateSecret bool, client dbDef
 	return nil
 }
 
-func handleStateChangeForRange(metadataArr []*secrets.Secret, client dbDef.Service,
+func handleStateChangeForRange(ctx context.Context, metadataArr []*secrets.Secret, client dbDef.Service,
 	updateRequest *communications.UpdateRequest, strategy definitions.Keystore) error {
 	for _, metadata := range metadataArr {
 		activeInBarbican := true
 		oldState := metadata.State
 		if metadata.State == secrets.Preactivation {
-			barbicanState, err := strategy.CheckSecret(metadata.ID)
+			barbicanState, err := strategy.CheckSecret(ctx, metadata.ID)
 			if err != nil {
 				return err
 			}
@@ -267,9 +279,11 @@ func adjustSecretState(metadata *secrets.Secret, expired, active bool) {
 		metadata.SetState(secrets.Deactivated)
 		metadata.NonactiveReason = secrets.Expired
 	} else if active {
-		//active
-		metadata.SetState(secrets.Activation)
-		metadata.NonactiveReason = secrets.KeyActive
+		//active, unless the key was suspended since only the enable action moves it back
+		if metadata.State != secrets.Suspended {
+			metadata.SetState(secrets.Activation)
+			metadata.NonactiveReason = secrets.KeyActive
+		}
 	} else {
 		//preactive
 		metadata.SetState(secrets.Preactivation)
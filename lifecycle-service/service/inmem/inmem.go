@@ -60,6 +60,14 @@ func (svc *inmemService) Post(ctx context.Context, request *communications.Secre
 	return response, nil
 }
 
+func (svc *inmemService) Import(ctx context.Context, request *communications.SecretRequest) (*communications.SecretsResponse, error) {
+	return nil, nil
+}
+
+func (svc *inmemService) ImportToken(ctx context.Context, request *communications.BaseRequest) (*corecomms.ImportTokenResponse, error) {
+	return nil, nil
+}
+
 func (svc *inmemService) Actions(ctx context.Context, request *corecomms.SecretActionRequest) (*corecomms.SecretActionResponse, error) {
 	return nil, nil
 }
@@ -3,6 +3,7 @@
 package instrumenting
 
 import (
+	"net/http"
 	"strconv"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/go-kit/kit/metrics/statsd"
 
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/client"
 	corecomms "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/communications"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/errors"
@@ -74,11 +76,37 @@ func instrumentMethod(instrumentingMiddleWare *instrumentingService, begin time.
 	}
 }
 
+// CountScheduledRotation counts a rotation run by the rotation scheduler under its status code
+func CountScheduledRotation(err error) {
+	statusCode := http.StatusOK
+	if err != nil {
+		statusCode = int((httperrors.ConvertError(err)).StatusCode)
+	}
+	statusCount := statsdReporter.NewCounter("ScheduledRotation."+strconv.Itoa(statusCode), reportInterval)
+	statusCount.Add(1)
+}
+
+// ReportBarbicanBreaker reports the state of the circuit breaker of a Barbican host as a gauge: 0 closed,
+// 1 half open and 2 open
+func ReportBarbicanBreaker(host string, state client.BreakerState) {
+	statsdReporter.NewGauge("Barbican.CircuitBreaker").Set(float64(state))
+}
+
 func (instrumentingMiddleWare *instrumentingService) Post(ctx context.Context, request *communications.SecretRequest) (response *communications.SecretsResponse, err error) {
 	defer func(begin time.Time) { instrumentMethod(instrumentingMiddleWare, begin, "Post", err) }(time.Now())
 	return instrumentingMiddleWare.Service.Post(ctx, request)
 }
 
+func (instrumentingMiddleWare *instrumentingService) Import(ctx context.Context, request *communications.SecretRequest) (response *communications.SecretsResponse, err error) {
+	defer func(begin time.Time) { instrumentMethod(instrumentingMiddleWare, begin, "Import", err) }(time.Now())
+	return instrumentingMiddleWare.Service.Import(ctx, request)
+}
+
+func (instrumentingMiddleWare *instrumentingService) ImportToken(ctx context.Context, request *communications.BaseRequest) (response *corecomms.ImportTokenResponse, err error) {
+	defer func(begin time.Time) { instrumentMethod(instrumentingMiddleWare, begin, "ImportToken", err) }(time.Now())
+	return instrumentingMiddleWare.Service.ImportToken(ctx, request)
+}
+
 func (instrumentingMiddleWare *instrumentingService) Actions(ctx context.Context, request *corecomms.SecretActionRequest) (response *corecomms.SecretActionResponse, err error) {
 	defer func(begin time.Time) { instrumentMethod(instrumentingMiddleWare, begin, "Actions", err) }(time.Now())
 	return instrumentingMiddleWare.Service.Actions(ctx, request)
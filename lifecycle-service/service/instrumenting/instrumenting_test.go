@@ -13,6 +13,7 @@ import (
 	"testing"
 	"time"
 
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/client"
 	corecomms "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/communications"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/service/tester"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
@@ -134,6 +135,56 @@ func TestPost(t *testing.T) {
 	}
 }
 
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	fService.InjectError(errors.New(http.StatusText(http.StatusForbidden)))
+
+	method := "Import"
+
+	prefix, name := "lifecycle-service.", method
+	regex := `^` + prefix + name + `\..+\|c|ms$`
+
+	_, err := testService.Import(ctx, communications.NewSecretRequest())
+	fService.RemoveError()
+	if err == nil {
+		t.Fail()
+	}
+
+	sum, err := stats(statsdReporter, regex)
+	if err != nil {
+		t.Errorf("Unexpected Error %s", err)
+	}
+
+	if sum != 2 {
+		t.Errorf("Expected 2, received %v", sum)
+	}
+}
+
+func TestImportToken(t *testing.T) {
+	ctx := context.Background()
+	fService.InjectError(errors.New(http.StatusText(http.StatusForbidden)))
+
+	method := "ImportToken"
+
+	prefix, name := "lifecycle-service.", method
+	regex := `^` + prefix + name + `\..+\|c|ms$`
+
+	_, err := testService.ImportToken(ctx, communications.NewBaseRequest())
+	fService.RemoveError()
+	if err == nil {
+		t.Fail()
+	}
+
+	sum, err := stats(statsdReporter, regex)
+	if err != nil {
+		t.Errorf("Unexpected Error %s", err)
+	}
+
+	if sum != 2 {
+		t.Errorf("Expected 2, received %v", sum)
+	}
+}
+
 func TestActions(t *testing.T) {
 	ctx := context.Background()
 	fService.InjectError(errors.New(http.StatusText(http.StatusForbidden)))
@@ -257,3 +308,34 @@ func TestDelete(t *testing.T) {
 		t.Errorf("Expected 2, received %v", sum)
 	}
 }
+
+func TestCountScheduledRotation(t *testing.T) {
+	prefix, name := "lifecycle-service.", "ScheduledRotation"
+	regex := `^` + prefix + name + `\.(200|500):.+\|c$`
+	CountScheduledRotation(nil)
+	CountScheduledRotation(errors.New(http.StatusText(http.StatusInternalServerError) + ": test-error"))
+
+	sum, err := stats(statsdReporter, regex)
+	if err != nil {
+		t.Errorf("Unexpected Error %s", err)
+	}
+
+	if sum != 2 {
+		t.Errorf("Expected 2, received %v", sum)
+	}
+}
+
+func TestReportBarbicanBreaker(t *testing.T) {
+	prefix, name := "lifecycle-service.", "Barbican.CircuitBreaker"
+	regex := `^` + prefix + name + `:2\.0+\|g$`
+	ReportBarbicanBreaker("https://barbican", client.BreakerOpen)
+
+	sum, err := stats(statsdReporter, regex)
+	if err != nil {
+		t.Errorf("Unexpected Error %s", err)
+	}
+
+	if sum != 1 {
+		t.Errorf("Expected 1, received %v", sum)
+	}
+}
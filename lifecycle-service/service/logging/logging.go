@@ -64,6 +64,16 @@ func (loggingMiddleWare *loggingService) Post(ctx context.Context, request *comm
 	return loggingMiddleWare.Service.Post(ctx, request)
 }
 
+func (loggingMiddleWare *loggingService) Import(ctx context.Context, request *communications.SecretRequest) (response *communications.SecretsResponse, err error) {
+	defer func(begin time.Time) { logMethod(loggingMiddleWare, begin, "Import", request, err) }(time.Now())
+	return loggingMiddleWare.Service.Import(ctx, request)
+}
+
+func (loggingMiddleWare *loggingService) ImportToken(ctx context.Context, request *communications.BaseRequest) (response *corecomms.ImportTokenResponse, err error) {
+	defer func(begin time.Time) { logMethod(loggingMiddleWare, begin, "ImportToken", request, err) }(time.Now())
+	return loggingMiddleWare.Service.ImportToken(ctx, request)
+}
+
 func (loggingMiddleWare *loggingService) Actions(ctx context.Context, request *corecomms.SecretActionRequest) (response *corecomms.SecretActionResponse, err error) {
 	defer func(begin time.Time) { logMethod(loggingMiddleWare, begin, "Actions", request, err) }(time.Now())
 	return loggingMiddleWare.Service.Actions(ctx, request)
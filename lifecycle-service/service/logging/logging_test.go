@@ -24,12 +24,14 @@ var fService tester.ServiceTester
 
 // Methods used by service
 var (
-	post    = "post"
-	actions = "actions"
-	head    = "head"
-	get     = "get"
-	list    = "list"
-	delete  = "delete"
+	post        = "post"
+	importKey   = "import"
+	importToken = "importToken"
+	actions     = "actions"
+	head        = "head"
+	get         = "get"
+	list        = "list"
+	delete      = "delete"
 )
 
 type fakeLogger struct {
@@ -180,6 +182,10 @@ func testMethod(t *testing.T, method string, request communications.Request, exp
 	switch method {
 	case post:
 		_, err = testService.Post(ctx, request.(*communications.SecretRequest))
+	case importKey:
+		_, err = testService.Import(ctx, request.(*communications.SecretRequest))
+	case importToken:
+		_, err = testService.ImportToken(ctx, request.(*communications.BaseRequest))
 	case actions:
 		_, err = testService.Actions(ctx, request.(*corecomms.SecretActionRequest))
 	case get:
@@ -227,6 +233,44 @@ func TestPost(t *testing.T) {
 	cleanLogs()
 }
 
+func TestImport(t *testing.T) {
+	request := communications.NewSecretRequest()
+
+	testMethod(t, importKey, request, nil)
+
+	testError := errors.New("test-error")
+	fService.InjectError(testError)
+
+	testMethod(t, importKey, request, testError)
+	fService.RemoveError()
+
+	logs := reviewLogs()
+	if logs["err"] != testError.Error() {
+		t.Fail()
+	}
+
+	cleanLogs()
+}
+
+func TestImportToken(t *testing.T) {
+	request := communications.NewBaseRequest()
+
+	testMethod(t, importToken, request, nil)
+
+	testError := errors.New("test-error")
+	fService.InjectError(testError)
+
+	testMethod(t, importToken, request, testError)
+	fService.RemoveError()
+
+	logs := reviewLogs()
+	if logs["err"] != testError.Error() {
+		t.Fail()
+	}
+
+	cleanLogs()
+}
+
 func TestActions(t *testing.T) {
 	request := corecomms.NewSecretActionRequest()
 
@@ -4,28 +4,51 @@ package service
 
 import (
 	"os"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/definitions"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore"
+	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/barbican/client"
+	// Registers the keystore backends the service is built with
+	_ "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/keystore/backends"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/service/analytics"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/service/basic"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/service/inmem"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/service/instrumenting"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/service/logging"
+	"github.ibm.com/Alchemy-Key-Protect/kp-go-config"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-consts"
 )
 
-var backEndStrategy keystore.Type
+// KeystoreEnv names the default keystore backend of the deployment, overriding keystore.backend. Tenants may still
+// be routed to other backends by keystore.spaces and keystore.orgs.
+const KeystoreEnv = "KP_KEYSTORE"
+
+var backEndStrategy *keystore.Router
 
 func init() {
-	mock, _ := os.LookupEnv(constants.MockEnv)
+	client.ObserveBreakers(instrumenting.ReportBarbicanBreaker)
 
+	mock, _ := os.LookupEnv(constants.MockEnv)
 	if mock == constants.TestMock {
-		backEndStrategy = keystore.Mock
-	} else {
-		backEndStrategy = keystore.Barbican
+		backEndStrategy = keystore.NewRouter(keystore.Mock)
+		return
+	}
+
+	backend := configuration.Get().GetString("keystore.backend")
+	if keystoreType, ok := os.LookupEnv(KeystoreEnv); ok && keystoreType != "" {
+		backend = keystoreType
+	}
+	if backend == "" {
+		backend = keystore.Barbican
+	}
+
+	router, err := keystore.NewRouterFromConfig(backend)
+	if err != nil {
+		panic(err.Error())
 	}
+	backEndStrategy = router
 }
 
 // NewInmemService creates a new service that uses an in memory db
@@ -33,15 +56,16 @@ func NewInmemService() definitions.Service {
 	return inmem.Service()
 }
 
-// NewBasicService returns a naïve, stateless implementation of Service.
-func NewBasicService() definitions.Service {
+// NewBasicService returns a naïve, stateless implementation of Service. Generated keys are activated in the
+// background by orders, which may be nil.
+func NewBasicService(orders *basic.OrderPoller) definitions.Service {
 	var logger log.Logger
 	logger = log.NewLogfmtLogger(os.Stderr)
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC,
 		"service", "HTTP Lifecycle BasicService",
 		"caller", log.DefaultCaller)
 
-	return basic.Service(logger, backEndStrategy)
+	return basic.Service(logger, backEndStrategy, orders)
 }
 
 // NewInstrumentingService returns an instance of an instrumenting Service.
@@ -58,3 +82,25 @@ func NewLoggingService(logger log.Logger, service definitions.Service) definitio
 func NewAnalyticsService(env string, region string, proxy string, service definitions.Service) definitions.Service {
 	return analytics.Service(env, region, proxy, service)
 }
+
+// NewOrderPoller returns a poller that activates generated keys once their order completes, checking every interval.
+// An interval of zero returns a nil poller, which tracks nothing.
+func NewOrderPoller(logger log.Logger, interval time.Duration) *basic.OrderPoller {
+	return basic.NewOrderPoller(logger, backEndStrategy, interval)
+}
+
+// Backend returns the keystore backend the requests of the space are routed to
+func Backend(space, org string) string {
+	return backEndStrategy.Backend(space, org)
+}
+
+// CheckServiceAuth reports an error if any keystore cannot be called without the token of a user, which the
+// rotation scheduler does not have.
+func CheckServiceAuth() error {
+	return backEndStrategy.CheckServiceAuth()
+}
+
+// NewRotationScheduler returns a scheduler that rotates the keys whose rotation policy is due, checking every interval.
+func NewRotationScheduler(logger log.Logger, interval time.Duration) (*basic.RotationScheduler, error) {
+	return basic.NewRotationScheduler(logger, backEndStrategy, interval, instrumenting.CountScheduledRotation)
+}
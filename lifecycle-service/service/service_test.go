@@ -4,6 +4,7 @@ package service
 
 import (
 	"testing"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/service/tester"
@@ -22,11 +23,21 @@ func TestNewInmemService(t *testing.T) {
 }
 
 func TestNewBasicService(t *testing.T) {
-	if svc := NewBasicService(); svc == nil {
+	if svc := NewBasicService(nil); svc == nil {
 		t.Fail()
 	}
 }
 
+func TestNewOrderPoller(t *testing.T) {
+	if poller := NewOrderPoller(log.NewNopLogger(), time.Second); poller == nil {
+		t.Fail()
+	}
+
+	if poller := NewOrderPoller(log.NewNopLogger(), 0); poller != nil {
+		t.Error("Expected a zero interval to disable the poller")
+	}
+}
+
 func TestNewInstrumentingService(t *testing.T) {
 	if svc := NewInstrumentingService(fService); svc == nil {
 		t.Fail()
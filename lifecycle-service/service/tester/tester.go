@@ -36,6 +36,14 @@ func (svc *testerService) Post(ctx context.Context, request *communications.Secr
 	return nil, svc.e
 }
 
+func (svc *testerService) Import(ctx context.Context, request *communications.SecretRequest) (*communications.SecretsResponse, error) {
+	return nil, svc.e
+}
+
+func (svc *testerService) ImportToken(ctx context.Context, request *communications.BaseRequest) (*corecomms.ImportTokenResponse, error) {
+	return nil, svc.e
+}
+
 func (svc *testerService) Actions(ctx context.Context, request *corecomms.SecretActionRequest) (*corecomms.SecretActionResponse, error) {
 	return nil, svc.e
 }
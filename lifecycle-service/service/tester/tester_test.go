@@ -30,6 +30,14 @@ func TestTesterService(t *testing.T) {
 		t.Fail()
 	}
 
+	if _, err := testService.Import(ctx, communications.NewSecretRequest()); err.Error() != testError.Error() {
+		t.Fail()
+	}
+
+	if _, err := testService.ImportToken(ctx, communications.NewBaseRequest()); err.Error() != testError.Error() {
+		t.Fail()
+	}
+
 	if _, err := testService.Actions(ctx, corecomms.NewSecretActionRequest()); err.Error() != testError.Error() {
 		t.Fail()
 	}
@@ -57,6 +65,14 @@ func TestTesterService(t *testing.T) {
 		t.Fail()
 	}
 
+	if _, err := testService.Import(ctx, communications.NewSecretRequest()); err != nil {
+		t.Fail()
+	}
+
+	if _, err := testService.ImportToken(ctx, communications.NewBaseRequest()); err != nil {
+		t.Fail()
+	}
+
 	if _, err := testService.Actions(ctx, corecomms.NewSecretActionRequest()); err != nil {
 		t.Fail()
 	}
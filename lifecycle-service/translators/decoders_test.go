@@ -15,6 +15,7 @@ import (
 	"context"
 
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/actions"
+	corecomms "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/communications"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-consts"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/collections"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
@@ -463,9 +464,14 @@ func TestDecodeSecretActionRequestWrap(t *testing.T) {
 	}
 
 	goodPath := func(_ http.ResponseWriter, request *http.Request) {
-		_, err := DecodeSecretActionRequest(ctx, request)
+		decoded, err := DecodeSecretActionRequest(ctx, request)
 		if err != nil {
 			t.Errorf("Unexpected Error: %s", err)
+			return
+		}
+
+		if action := decoded.(*corecomms.SecretActionRequest).Action; action != actions.Wrap {
+			t.Errorf("Expected %s, received %s", actions.Wrap, action)
 		}
 	}
 
@@ -492,6 +498,43 @@ func TestDecodeSecretActionRequestWrap(t *testing.T) {
 	router.ServeHTTP(recorder, testRequest)
 }
 
+func TestDecodeSecretActionRequestWrapEmptyBody(t *testing.T) {
+	ctx := context.Background()
+
+	goodPath := func(_ http.ResponseWriter, request *http.Request) {
+		decoded, err := DecodeSecretActionRequest(ctx, request)
+		if err != nil {
+			t.Errorf("Unexpected Error: %s", err)
+			return
+		}
+
+		if plaintext := decoded.(*corecomms.SecretActionRequest).Plaintext; plaintext != "" {
+			t.Errorf("Expected empty plaintext, received %s", plaintext)
+		}
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/test/{id}", goodPath).Methods(http.MethodPost)
+
+	// create test request to pass to handler
+	testRequest, _ := http.NewRequest(http.MethodPost, "/test/"+uuid.NewV4().String()+"?action=wrap", nil)
+
+	testRequest.Header.Set(constants.BluemixUserRole, constants.RoleManager)
+
+	recorder := httptest.NewRecorder()
+
+	router.ServeHTTP(recorder, testRequest)
+
+	// create test request to pass to handler
+	testRequest, _ = http.NewRequest(http.MethodPost, "/test/"+uuid.NewV4().String()+"?action=wrap", bytes.NewBufferString("{}"))
+
+	testRequest.Header.Set(constants.BluemixUserRole, constants.RoleManager)
+
+	recorder = httptest.NewRecorder()
+
+	router.ServeHTTP(recorder, testRequest)
+}
+
 func TestDecodeSecretActionRequestUnwrap(t *testing.T) {
 	ctx := context.Background()
 
@@ -516,9 +559,14 @@ func TestDecodeSecretActionRequestUnwrap(t *testing.T) {
 	}
 
 	goodPath := func(_ http.ResponseWriter, request *http.Request) {
-		_, err := DecodeSecretActionRequest(ctx, request)
+		decoded, err := DecodeSecretActionRequest(ctx, request)
 		if err != nil {
 			t.Errorf("Unexpected Error: %s", err)
+			return
+		}
+
+		if action := decoded.(*corecomms.SecretActionRequest).Action; action != actions.Unwrap {
+			t.Errorf("Expected %s, received %s", actions.Unwrap, action)
 		}
 	}
 
@@ -544,3 +592,316 @@ func TestDecodeSecretActionRequestUnwrap(t *testing.T) {
 
 	router.ServeHTTP(recorder, testRequest)
 }
+
+func TestDecodeSecretActionRequestRewrap(t *testing.T) {
+	ctx := context.Background()
+
+	goodPath := func(_ http.ResponseWriter, request *http.Request) {
+		decoded, err := DecodeSecretActionRequest(ctx, request)
+		if err != nil {
+			t.Errorf("Unexpected Error: %s", err)
+			return
+		}
+
+		if action := decoded.(*corecomms.SecretActionRequest).Action; action != actions.Rewrap {
+			t.Errorf("Expected %s, received %s", actions.Rewrap, action)
+		}
+	}
+
+	badPath := func(_ http.ResponseWriter, request *http.Request) {
+		_, err := DecodeSecretActionRequest(ctx, request)
+		if err == nil {
+			t.Error("Error Expected")
+		}
+	}
+
+	testSecretAction := new(actions.SecretAction)
+	testSecretAction.Ciphertext = "super-secret-ciphertext"
+
+	buf, errMarshal := json.Marshal(testSecretAction)
+	if errMarshal != nil {
+		t.Errorf("Unexpected Error: %s", errMarshal)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/test/{id}", goodPath).Methods(http.MethodPost)
+
+	// create test request to pass to handler
+	testRequest, _ := http.NewRequest(http.MethodPost, "/test/"+uuid.NewV4().String()+"?action=rewrap", bytes.NewBuffer(buf))
+
+	testRequest.Header.Set(constants.BluemixUserRole, constants.RoleManager)
+
+	recorder := httptest.NewRecorder()
+
+	router.ServeHTTP(recorder, testRequest)
+
+	// Bad Path: Plaintext provided
+
+	testSecretAction.Plaintext = "super-secret-plaintext"
+
+	buf, errMarshal = json.Marshal(testSecretAction)
+	if errMarshal != nil {
+		t.Errorf("Unexpected Error: %s", errMarshal)
+	}
+
+	router = mux.NewRouter()
+	router.HandleFunc("/test/{id}", badPath).Methods(http.MethodPost)
+
+	// create test request to pass to handler
+	testRequest, _ = http.NewRequest(http.MethodPost, "/test/"+uuid.NewV4().String()+"?action=rewrap", bytes.NewBuffer(buf))
+
+	testRequest.Header.Set(constants.BluemixUserRole, constants.RoleManager)
+
+	recorder = httptest.NewRecorder()
+
+	router.ServeHTTP(recorder, testRequest)
+}
+
+func TestDecodeSecretActionRequestRotate(t *testing.T) {
+	ctx := context.Background()
+
+	goodPath := func(_ http.ResponseWriter, request *http.Request) {
+		decoded, err := DecodeSecretActionRequest(ctx, request)
+		if err != nil {
+			t.Errorf("Unexpected Error: %s", err)
+			return
+		}
+
+		if action := decoded.(*corecomms.SecretActionRequest).Action; action != actions.Rotate {
+			t.Errorf("Expected %s, received %s", actions.Rotate, action)
+		}
+	}
+
+	badPath := func(_ http.ResponseWriter, request *http.Request) {
+		_, err := DecodeSecretActionRequest(ctx, request)
+		if err == nil {
+			t.Error("Error Expected")
+		}
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/test/{id}", goodPath).Methods(http.MethodPost)
+
+	// create test request to pass to handler
+	testRequest, _ := http.NewRequest(http.MethodPost, "/test/"+uuid.NewV4().String()+"?action=rotate", nil)
+
+	testRequest.Header.Set(constants.BluemixUserRole, constants.RoleManager)
+
+	recorder := httptest.NewRecorder()
+
+	router.ServeHTTP(recorder, testRequest)
+
+	// Bad Path: Developers cannot rotate keys
+
+	router = mux.NewRouter()
+	router.HandleFunc("/test/{id}", badPath).Methods(http.MethodPost)
+
+	testRequest, _ = http.NewRequest(http.MethodPost, "/test/"+uuid.NewV4().String()+"?action=rotate", nil)
+
+	testRequest.Header.Set(constants.BluemixUserRole, constants.RoleDeveloper)
+
+	recorder = httptest.NewRecorder()
+
+	router.ServeHTTP(recorder, testRequest)
+
+	// Bad Path: Ciphertext provided
+
+	testSecretAction := new(actions.SecretAction)
+	testSecretAction.Ciphertext = "super-secret-ciphertext"
+
+	buf, errMarshal := json.Marshal(testSecretAction)
+	if errMarshal != nil {
+		t.Errorf("Unexpected Error: %s", errMarshal)
+	}
+
+	testRequest, _ = http.NewRequest(http.MethodPost, "/test/"+uuid.NewV4().String()+"?action=rotate", bytes.NewBuffer(buf))
+
+	testRequest.Header.Set(constants.BluemixUserRole, constants.RoleManager)
+
+	recorder = httptest.NewRecorder()
+
+	router.ServeHTTP(recorder, testRequest)
+}
+
+func TestDecodeSecretActionRequestPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	goodPath := func(_ http.ResponseWriter, request *http.Request) {
+		decoded, err := DecodeSecretActionRequest(ctx, request)
+		if err != nil {
+			t.Errorf("Unexpected Error: %s", err)
+			return
+		}
+
+		policy := decoded.(*corecomms.SecretActionRequest).RotationPolicy
+		if policy == nil || policy.IntervalDays != 90 {
+			t.Errorf("Expected an interval of %d days, received %+v", 90, policy)
+		}
+	}
+
+	badPath := func(_ http.ResponseWriter, request *http.Request) {
+		_, err := DecodeSecretActionRequest(ctx, request)
+		if err == nil {
+			t.Error("Error Expected")
+		}
+	}
+
+	newPolicyRequest := func(policy *actions.RotationPolicy, role string) *http.Request {
+		buf, errMarshal := json.Marshal(&actions.SecretAction{RotationPolicy: policy})
+		if errMarshal != nil {
+			t.Errorf("Unexpected Error: %s", errMarshal)
+		}
+
+		testRequest, _ := http.NewRequest(http.MethodPost, "/test/"+uuid.NewV4().String()+"?action=policy", bytes.NewBuffer(buf))
+		testRequest.Header.Set(constants.BluemixUserRole, role)
+		return testRequest
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/test/{id}", goodPath).Methods(http.MethodPost)
+
+	router.ServeHTTP(httptest.NewRecorder(), newPolicyRequest(&actions.RotationPolicy{IntervalDays: 90}, constants.RoleManager))
+
+	router = mux.NewRouter()
+	router.HandleFunc("/test/{id}", badPath).Methods(http.MethodPost)
+
+	// Bad Path: Developers cannot set policies
+	router.ServeHTTP(httptest.NewRecorder(), newPolicyRequest(&actions.RotationPolicy{IntervalDays: 90}, constants.RoleDeveloper))
+
+	// Bad Path: No policy provided
+	router.ServeHTTP(httptest.NewRecorder(), newPolicyRequest(nil, constants.RoleManager))
+
+	// Bad Path: Negative interval
+	router.ServeHTTP(httptest.NewRecorder(), newPolicyRequest(&actions.RotationPolicy{IntervalDays: -1}, constants.RoleManager))
+}
+
+func TestDecodeSecretActionRequestDualAuthDelete(t *testing.T) {
+	ctx := context.Background()
+
+	goodPath := func(_ http.ResponseWriter, request *http.Request) {
+		decoded, err := DecodeSecretActionRequest(ctx, request)
+		if err != nil {
+			t.Errorf("Unexpected Error: %s", err)
+			return
+		}
+
+		secretAction := decoded.(*corecomms.SecretActionRequest).SecretAction
+		if secretAction.DualAuthDelete == nil || !secretAction.DualAuthDelete.Enabled || secretAction.RotationPolicy != nil {
+			t.Errorf("Expected only dual authorization to be enabled, received %+v", secretAction)
+		}
+	}
+
+	buf, errMarshal := json.Marshal(&actions.SecretAction{DualAuthDelete: &actions.DualAuthDelete{Enabled: true}})
+	if errMarshal != nil {
+		t.Errorf("Unexpected Error: %s", errMarshal)
+	}
+
+	testRequest, _ := http.NewRequest(http.MethodPost, "/test/"+uuid.NewV4().String()+"?action=policy", bytes.NewBuffer(buf))
+	testRequest.Header.Set(constants.BluemixUserRole, constants.RoleManager)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/test/{id}", goodPath).Methods(http.MethodPost)
+	router.ServeHTTP(httptest.NewRecorder(), testRequest)
+}
+
+func TestDecodeSecretActionRequestDisableEnable(t *testing.T) {
+	ctx := context.Background()
+
+	for _, action := range []string{actions.Disable, actions.Enable} {
+		goodPath := func(_ http.ResponseWriter, request *http.Request) {
+			decoded, err := DecodeSecretActionRequest(ctx, request)
+			if err != nil {
+				t.Errorf("Unexpected Error: %s", err)
+				return
+			}
+
+			if decodedAction := decoded.(*corecomms.SecretActionRequest).Action; decodedAction != action {
+				t.Errorf("Expected %s, received %s", action, decodedAction)
+			}
+		}
+
+		badPath := func(_ http.ResponseWriter, request *http.Request) {
+			_, err := DecodeSecretActionRequest(ctx, request)
+			if err == nil {
+				t.Error("Error Expected")
+			}
+		}
+
+		router := mux.NewRouter()
+		router.HandleFunc("/test/{id}", goodPath).Methods(http.MethodPost)
+
+		testRequest, _ := http.NewRequest(http.MethodPost, "/test/"+uuid.NewV4().String()+"?action="+action, nil)
+		testRequest.Header.Set(constants.BluemixUserRole, constants.RoleManager)
+		router.ServeHTTP(httptest.NewRecorder(), testRequest)
+
+		router = mux.NewRouter()
+		router.HandleFunc("/test/{id}", badPath).Methods(http.MethodPost)
+
+		// Bad Path: Developers cannot change the state of keys
+		testRequest, _ = http.NewRequest(http.MethodPost, "/test/"+uuid.NewV4().String()+"?action="+action, nil)
+		testRequest.Header.Set(constants.BluemixUserRole, constants.RoleDeveloper)
+		router.ServeHTTP(httptest.NewRecorder(), testRequest)
+
+		// Bad Path: Plaintext provided
+		buf, errMarshal := json.Marshal(&actions.SecretAction{Plaintext: "super-secret-plaintext"})
+		if errMarshal != nil {
+			t.Errorf("Unexpected Error: %s", errMarshal)
+		}
+
+		testRequest, _ = http.NewRequest(http.MethodPost, "/test/"+uuid.NewV4().String()+"?action="+action, bytes.NewBuffer(buf))
+		testRequest.Header.Set(constants.BluemixUserRole, constants.RoleManager)
+		router.ServeHTTP(httptest.NewRecorder(), testRequest)
+	}
+}
+
+func TestDecodeSecretActionRequestSignVerify(t *testing.T) {
+	ctx := context.Background()
+
+	goodPath := func(_ http.ResponseWriter, request *http.Request) {
+		decoded, err := DecodeSecretActionRequest(ctx, request)
+		if err != nil {
+			t.Errorf("Unexpected Error: %s", err)
+			return
+		}
+
+		if digest := decoded.(*corecomms.SecretActionRequest).Digest; digest != "test-digest" {
+			t.Errorf("Expected %s, received %s", "test-digest", digest)
+		}
+	}
+
+	badPath := func(_ http.ResponseWriter, request *http.Request) {
+		_, err := DecodeSecretActionRequest(ctx, request)
+		if err == nil {
+			t.Error("Error Expected")
+		}
+	}
+
+	newRequest := func(action string, secretAction *actions.SecretAction) *http.Request {
+		buf, errMarshal := json.Marshal(secretAction)
+		if errMarshal != nil {
+			t.Errorf("Unexpected Error: %s", errMarshal)
+		}
+
+		testRequest, _ := http.NewRequest(http.MethodPost, "/test/"+uuid.NewV4().String()+"?action="+action, bytes.NewBuffer(buf))
+		testRequest.Header.Set(constants.BluemixUserRole, constants.RoleDeveloper)
+		return testRequest
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/test/{id}", goodPath).Methods(http.MethodPost)
+
+	router.ServeHTTP(httptest.NewRecorder(), newRequest(actions.Sign, &actions.SecretAction{Digest: "test-digest"}))
+	router.ServeHTTP(httptest.NewRecorder(), newRequest(actions.Verify, &actions.SecretAction{Digest: "test-digest", Signature: "test-signature"}))
+
+	router = mux.NewRouter()
+	router.HandleFunc("/test/{id}", badPath).Methods(http.MethodPost)
+
+	// Bad Path: No digest provided
+	router.ServeHTTP(httptest.NewRecorder(), newRequest(actions.Sign, &actions.SecretAction{}))
+
+	// Bad Path: Signature provided to sign
+	router.ServeHTTP(httptest.NewRecorder(), newRequest(actions.Sign, &actions.SecretAction{Digest: "test-digest", Signature: "test-signature"}))
+
+	// Bad Path: No signature provided to verify
+	router.ServeHTTP(httptest.NewRecorder(), newRequest(actions.Verify, &actions.SecretAction{Digest: "test-digest"}))
+}
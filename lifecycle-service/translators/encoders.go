@@ -26,6 +26,10 @@ func EncodeGenericResponse(ctx context.Context, respWriter http.ResponseWriter,
 	method := ctx.Value(kithttp.ContextKeyRequestMethod).(string)
 	path := ctx.Value(kithttp.ContextKeyRequestPath).(string)
 
+	// actions are posted to a resource path such as /api/v2/keys/<id>, so the path cannot
+	// be compared against the route template. The response type identifies them instead.
+	actionResponse, isActionResponse := response.(*corecomms.SecretActionResponse)
+
 	// TODO: In the future we want head and list to have the same return type
 	// and just check the method to determine what should be returned. This can be done now
 	// that we have more information in the context. TSC May 22, 2017
@@ -41,7 +45,30 @@ func EncodeGenericResponse(ctx context.Context, respWriter http.ResponseWriter,
 			return nil
 		}
 		return fmt.Errorf("Requires type *communications.NumberResponse, received %T", response)
-	case method == http.MethodGet || method == http.MethodDelete || (method == http.MethodPost && !strings.Contains(path, routes.APIv2SecretsID)):
+	case method == http.MethodPost && path == routes.APIv2ImportToken:
+		// used to encode responses for import token creation
+		if tokenResponse, ok := response.(*corecomms.ImportTokenResponse); ok {
+			respWriter.Header().Set(constants.ContentTypeHeader, constants.AppJSONMime+"; charset=utf-8")
+			respWriter.WriteHeader(http.StatusCreated)
+
+			return json.NewEncoder(respWriter).Encode(tokenResponse)
+		}
+		return fmt.Errorf("Requires type *corecomms.ImportTokenResponse, received %T", response)
+	case method == http.MethodPost && (isActionResponse || strings.Contains(path, routes.APIv2SecretsID)):
+		// used to encode responses for action
+		if isActionResponse {
+			// actions such as rotate have nothing to return
+			if actionResponse.SecretAction == nil {
+				respWriter.WriteHeader(http.StatusNoContent)
+				return nil
+			}
+
+			respWriter.Header().Set(constants.ContentTypeHeader, constants.AppJSONMime+"; charset=utf-8")
+
+			return json.NewEncoder(respWriter).Encode(actionResponse)
+		}
+		return fmt.Errorf("Requires type *corecomms.SecretActionResponse, received %T", response)
+	case method == http.MethodGet || method == http.MethodDelete || method == http.MethodPost:
 		// used encode responses for get, list, create and delete
 		if secretsResponse, ok := response.(*communications.SecretsResponse); ok {
 			respWriter.Header().Set(constants.ContentTypeHeader, constants.AppJSONMime+"; charset=utf-8")
@@ -68,14 +95,6 @@ func EncodeGenericResponse(ctx context.Context, respWriter http.ResponseWriter,
 			return json.NewEncoder(respWriter).Encode(collectionResponse)
 		}
 		return fmt.Errorf("Requires type *communications.SecretsResponse, received %T", response)
-	case method == http.MethodPost && strings.Contains(path, routes.APIv2SecretsID):
-		// used to encode responses for action
-		if actionResponse, ok := response.(*corecomms.SecretActionResponse); ok {
-			respWriter.Header().Set(constants.ContentTypeHeader, constants.AppJSONMime+"; charset=utf-8")
-
-			return json.NewEncoder(respWriter).Encode(actionResponse)
-		}
-		return fmt.Errorf("Requires type *corecomms.SecretActionResponse, received %T", response)
 	default:
 		return fmt.Errorf(http.StatusText(http.StatusBadRequest)+": Method %s is not supported", method)
 	}
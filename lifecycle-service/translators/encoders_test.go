@@ -5,12 +5,14 @@ package translators
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"context"
 
 	kithttp "github.com/go-kit/kit/transport/http"
 
+	corecomms "github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/models/communications"
 	"github.ibm.com/Alchemy-Key-Protect/key-management-core/lifecycle-service/transport/routes"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/communications"
 	"github.ibm.com/Alchemy-Key-Protect/kp-go-models/secrets"
@@ -112,6 +114,76 @@ func TestEncodePostResponse(t *testing.T) {
 	}
 }
 
+func TestEncodeActionResponse(t *testing.T) {
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, kithttp.ContextKeyRequestMethod, http.MethodPost)
+	ctx = context.WithValue(ctx, kithttp.ContextKeyRequestPath, routes.APIv2+"keys/"+validUser)
+
+	actionResponse := corecomms.NewSecretActionResponse()
+	actionResponse.Ciphertext = "test-ciphertext"
+
+	recorder := httptest.NewRecorder()
+
+	err := EncodeGenericResponse(ctx, recorder, actionResponse)
+	if err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected %d, recieved %d", http.StatusOK, recorder.Code)
+	}
+
+	if !strings.Contains(recorder.Body.String(), actionResponse.Ciphertext) {
+		t.Errorf("Expected body to contain %s, recieved %s", actionResponse.Ciphertext, recorder.Body.String())
+	}
+}
+
+func TestEncodeEmptyActionResponse(t *testing.T) {
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, kithttp.ContextKeyRequestMethod, http.MethodPost)
+	ctx = context.WithValue(ctx, kithttp.ContextKeyRequestPath, routes.APIv2+"keys/"+validUser)
+
+	recorder := httptest.NewRecorder()
+
+	err := EncodeGenericResponse(ctx, recorder, new(corecomms.SecretActionResponse))
+	if err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected %d, recieved %d", http.StatusNoContent, recorder.Code)
+	}
+}
+
+func TestEncodeImportTokenResponse(t *testing.T) {
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, kithttp.ContextKeyRequestMethod, http.MethodPost)
+	ctx = context.WithValue(ctx, kithttp.ContextKeyRequestPath, routes.APIv2ImportToken)
+
+	tokenResponse := corecomms.NewImportTokenResponse()
+	tokenResponse.PublicKey = "test-public-key"
+
+	recorder := httptest.NewRecorder()
+
+	err := EncodeGenericResponse(ctx, recorder, tokenResponse)
+	if err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+
+	if recorder.Code != http.StatusCreated {
+		t.Errorf("Expected %d, recieved %d", http.StatusCreated, recorder.Code)
+	}
+
+	if !strings.Contains(recorder.Body.String(), tokenResponse.PublicKey) {
+		t.Errorf("Expected body to contain %s, recieved %s", tokenResponse.PublicKey, recorder.Body.String())
+	}
+
+	// Bad Path: any other response to the import token route
+	if err := EncodeGenericResponse(ctx, httptest.NewRecorder(), communications.NewSecretsResponse()); err == nil {
+		t.Error("Expected an error for the wrong response type")
+	}
+}
+
 func TestEncodeError(t *testing.T) {
 	ctx := context.Background()
 
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -43,6 +44,14 @@ var (
 		http.MethodGet:    constants.RoleDeveloper,
 		http.MethodDelete: constants.RoleManager,
 	}
+
+	// Actions are posted, but some change the key itself and so require more than the POST method's role
+	actionToLeastRequiredRole = map[string]string{
+		actions.Rotate:  constants.RoleManager,
+		actions.Policy:  constants.RoleManager,
+		actions.Disable: constants.RoleManager,
+		actions.Enable:  constants.RoleManager,
+	}
 )
 
 func setRequestParameters(req *http.Request, request communications.Request) error {
@@ -93,7 +102,11 @@ func setRequestHeaders(req *http.Request, request communications.Request) {
 }
 
 func roleCheck(req *http.Request) error {
-	if roleWeight[req.Header.Get(constants.BluemixUserRole)] < roleWeight[methodToLeastRequiredRole[req.Method]] {
+	return requireRole(req, methodToLeastRequiredRole[req.Method])
+}
+
+func requireRole(req *http.Request, role string) error {
+	if roleWeight[req.Header.Get(constants.BluemixUserRole)] < roleWeight[role] {
 		return errors.New(http.StatusText(http.StatusForbidden) + ": User's role does not provide access to this resource")
 	}
 	return nil
@@ -113,7 +126,12 @@ func extractID(req *http.Request) (string, error) {
 func jsonDecodeSecretAction(req *http.Request) (*actions.SecretAction, error) {
 	secretAction := new(actions.SecretAction)
 
-	if err := json.NewDecoder(req.Body).Decode(secretAction); err != nil {
+	// An empty body is a valid action, such as a wrap that generates a data encryption key
+	if req.Body == nil {
+		return secretAction, nil
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(secretAction); err != nil && err != io.EOF {
 		return nil, fmt.Errorf(http.StatusText(http.StatusBadRequest)+": Request JSON Body: %s", err)
 	}
 
@@ -152,6 +170,110 @@ func validateUnwrapAction(req *http.Request, request *corecomms.SecretActionRequ
 	return nil
 }
 
+func validateRewrapAction(req *http.Request, request *corecomms.SecretActionRequest) error {
+	rewrapAction, errJSONDecode := jsonDecodeSecretAction(req)
+	if errJSONDecode != nil {
+		return errJSONDecode
+	}
+
+	if len(rewrapAction.Ciphertext) == 0 {
+		return errors.New(http.StatusText(http.StatusBadRequest) + ": Rewrap request requires ciphertext field")
+	}
+
+	if len(rewrapAction.Plaintext) != 0 {
+		return errors.New(http.StatusText(http.StatusBadRequest) + ": Rewrap request has no field, Plaintext")
+	}
+
+	request.SecretAction = rewrapAction
+	return nil
+}
+
+func validateRotateAction(req *http.Request, request *corecomms.SecretActionRequest) error {
+	rotateAction, errJSONDecode := jsonDecodeSecretAction(req)
+	if errJSONDecode != nil {
+		return errJSONDecode
+	}
+
+	if len(rotateAction.Plaintext) != 0 || len(rotateAction.Ciphertext) != 0 {
+		return errors.New(http.StatusText(http.StatusBadRequest) + ": Rotate request has no fields, Plaintext or Ciphertext")
+	}
+
+	request.SecretAction = rotateAction
+	return nil
+}
+
+func validatePolicyAction(req *http.Request, request *corecomms.SecretActionRequest) error {
+	policyAction, errJSONDecode := jsonDecodeSecretAction(req)
+	if errJSONDecode != nil {
+		return errJSONDecode
+	}
+
+	if policyAction.RotationPolicy == nil && policyAction.DualAuthDelete == nil {
+		return errors.New(http.StatusText(http.StatusBadRequest) + ": Policy request requires rotationPolicy or dualAuthDelete field")
+	}
+
+	if len(policyAction.Plaintext) != 0 || len(policyAction.Ciphertext) != 0 {
+		return errors.New(http.StatusText(http.StatusBadRequest) + ": Policy request has no fields, Plaintext or Ciphertext")
+	}
+
+	if policyAction.RotationPolicy != nil && policyAction.RotationPolicy.IntervalDays < 0 {
+		return errors.New(http.StatusText(http.StatusBadRequest) + ": Rotation interval must not be negative")
+	}
+
+	request.SecretAction = policyAction
+	return nil
+}
+
+func validateStateAction(req *http.Request, request *corecomms.SecretActionRequest) error {
+	stateAction, errJSONDecode := jsonDecodeSecretAction(req)
+	if errJSONDecode != nil {
+		return errJSONDecode
+	}
+
+	if len(stateAction.Plaintext) != 0 || len(stateAction.Ciphertext) != 0 || stateAction.RotationPolicy != nil || stateAction.DualAuthDelete != nil {
+		return fmt.Errorf(http.StatusText(http.StatusBadRequest)+": %s request has no fields", request.Action)
+	}
+
+	request.SecretAction = stateAction
+	return nil
+}
+
+func validateSignAction(req *http.Request, request *corecomms.SecretActionRequest) error {
+	signAction, errJSONDecode := jsonDecodeSecretAction(req)
+	if errJSONDecode != nil {
+		return errJSONDecode
+	}
+
+	if len(signAction.Digest) == 0 {
+		return errors.New(http.StatusText(http.StatusBadRequest) + ": Sign request requires digest field")
+	}
+
+	if len(signAction.Plaintext) != 0 || len(signAction.Ciphertext) != 0 || len(signAction.Signature) != 0 {
+		return errors.New(http.StatusText(http.StatusBadRequest) + ": Sign request has no fields, Plaintext, Ciphertext or Signature")
+	}
+
+	request.SecretAction = signAction
+	return nil
+}
+
+func validateVerifyAction(req *http.Request, request *corecomms.SecretActionRequest) error {
+	verifyAction, errJSONDecode := jsonDecodeSecretAction(req)
+	if errJSONDecode != nil {
+		return errJSONDecode
+	}
+
+	if len(verifyAction.Digest) == 0 || len(verifyAction.Signature) == 0 {
+		return errors.New(http.StatusText(http.StatusBadRequest) + ": Verify request requires digest and signature fields")
+	}
+
+	if len(verifyAction.Plaintext) != 0 || len(verifyAction.Ciphertext) != 0 {
+		return errors.New(http.StatusText(http.StatusBadRequest) + ": Verify request has no fields, Plaintext or Ciphertext")
+	}
+
+	request.SecretAction = verifyAction
+	return nil
+}
+
 func extractIDAndValidateAction(req *http.Request, request *corecomms.SecretActionRequest) error {
 	query := req.URL.Query()
 
@@ -163,14 +285,33 @@ func extractIDAndValidateAction(req *http.Request, request *corecomms.SecretActi
 	request.ID = id
 
 	action := strings.ToLower(query.Get("action"))
+	request.Action = action
+
+	if role, ok := actionToLeastRequiredRole[action]; ok {
+		if errRole := requireRole(req, role); errRole != nil {
+			return errRole
+		}
+	}
 
 	// TODO: In the future this, or parts of this, maybe should be moved somewhere else
 	// that owns actions. TSC May 22, 2017
 	switch action {
-	case "wrap":
+	case actions.Wrap:
 		return validateWrapAction(req, request)
-	case "unwrap":
+	case actions.Unwrap:
 		return validateUnwrapAction(req, request)
+	case actions.Rewrap:
+		return validateRewrapAction(req, request)
+	case actions.Rotate:
+		return validateRotateAction(req, request)
+	case actions.Policy:
+		return validatePolicyAction(req, request)
+	case actions.Disable, actions.Enable:
+		return validateStateAction(req, request)
+	case actions.Sign:
+		return validateSignAction(req, request)
+	case actions.Verify:
+		return validateVerifyAction(req, request)
 	default:
 		return fmt.Errorf(http.StatusText(http.StatusBadRequest)+": %s is not a supported action", action)
 	}
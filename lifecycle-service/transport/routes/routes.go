@@ -4,9 +4,13 @@ package routes
 
 // routes used for transport
 const (
-	APIv2          = "/api/v2/"
-	APIv2Secrets   = APIv2 + "secrets"
-	APIv2SecretsID = APIv2 + "secrets/{id}"
-	APIv2Keys      = APIv2 + "keys"
-	APIv2KeysID    = APIv2 + "keys/{id}"
+	APIv2            = "/api/v2/"
+	APIv2Secrets     = APIv2 + "secrets"
+	APIv2SecretsID   = APIv2 + "secrets/{id}"
+	APIv2Keys        = APIv2 + "keys"
+	APIv2KeysID      = APIv2 + "keys/{id}"
+	APIv2ImportToken = APIv2 + "import_token"
 )
+
+// ImportQuery marks a key creation as an import of key material encrypted under the space's import token
+const ImportQuery = "import"
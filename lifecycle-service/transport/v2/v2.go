@@ -58,13 +58,27 @@ func makeServerEndpoints(s definitions.Service, tracer stdopentracing.Tracer) *e
 		deleteEnd = opentracing.TraceServer(tracer, "Delete")(deleteEnd)
 	}
 
+	var importEnd endpoint.Endpoint
+	{
+		importEnd = endpoints.MakeImportEndpoint(s)
+		importEnd = opentracing.TraceServer(tracer, "Import")(importEnd)
+	}
+
+	var importTokenEnd endpoint.Endpoint
+	{
+		importTokenEnd = endpoints.MakeImportTokenEndpoint(s)
+		importTokenEnd = opentracing.TraceServer(tracer, "ImportToken")(importTokenEnd)
+	}
+
 	return &endpoints.Endpoints{
-		PostEndpoint:    postEnd,
-		ActionsEndpoint: actionsEnd,
-		GetEndpoint:     getEnd,
-		HeadEndpoint:    headEnd,
-		ListEndpoint:    listEnd,
-		DeleteEndpoint:  deleteEnd,
+		PostEndpoint:        postEnd,
+		ActionsEndpoint:     actionsEnd,
+		GetEndpoint:         getEnd,
+		HeadEndpoint:        headEnd,
+		ListEndpoint:        listEnd,
+		DeleteEndpoint:      deleteEnd,
+		ImportEndpoint:      importEnd,
+		ImportTokenEndpoint: importTokenEnd,
 	}
 }
 
@@ -107,6 +121,21 @@ func setSecretsEndpoints(router *mux.Router, endpoints *endpoints.Endpoints, opt
 }
 
 func setKeysEndpoints(router *mux.Router, endpoints *endpoints.Endpoints, options []kithttp.ServerOption) {
+	// Imports are posted to "/keys" with the import query parameter, so they must be matched before creation
+	router.Methods(http.MethodPost).Path(routes.APIv2Keys).Queries(routes.ImportQuery, "true").Handler(kithttp.NewServer(
+		endpoints.ImportEndpoint,
+		translators.DecodeSecretRequest,
+		translators.EncodeGenericResponse,
+		options...,
+	))
+
+	router.Methods(http.MethodPost).Path(routes.APIv2ImportToken).Handler(kithttp.NewServer(
+		endpoints.ImportTokenEndpoint,
+		translators.DecodeBaseRequest,
+		translators.EncodeGenericResponse,
+		options...,
+	))
+
 	router.Methods(http.MethodPost).Path(routes.APIv2Keys).Handler(kithttp.NewServer(
 		endpoints.PostEndpoint,
 		translators.DecodeSecretRequest,
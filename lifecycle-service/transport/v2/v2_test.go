@@ -53,6 +53,14 @@ func TestMakeServerEndpoints(t *testing.T) {
 	if serviceEndpoints.DeleteEndpoint == nil {
 		t.Fail()
 	}
+
+	if serviceEndpoints.ImportEndpoint == nil {
+		t.Fail()
+	}
+
+	if serviceEndpoints.ImportTokenEndpoint == nil {
+		t.Fail()
+	}
 }
 
 func TestMakeHandler(t *testing.T) {
@@ -81,4 +89,9 @@ func TestMakeHandler(t *testing.T) {
 	if want, have := http.StatusForbidden, respKeys.StatusCode; want != have {
 		t.Errorf("want %d, have %d", want, have)
 	}
+
+	respImportToken, _ := http.Post(testServer.URL+routes.APIv2ImportToken, "application/json", nil)
+	if want, have := http.StatusForbidden, respImportToken.StatusCode; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
 }
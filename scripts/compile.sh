@@ -4,8 +4,11 @@
 KP_SEMVER="99.99.99"
 KP_COMMIT="123456789"
 
+# backends that need cgo are built in with their build tag, e.g. KP_BUILD_TAGS=pkcs11
+KP_BUILD_TAGS="${KP_BUILD_TAGS:-}"
+
 # build for linux machines
 #env GOOS=linux GOARCH=amd64  go build -v -ldflags "-X main.semver=${KP_SEMVER} -X main.commit=${KP_COMMIT}
-env GOOS=linux GOARCH=amd64  go build -v -ldflags "-X main.semver=${KP_SEMVER} -X main.commit=${KP_COMMIT}"
+env GOOS=linux GOARCH=amd64  go build -v -tags "${KP_BUILD_TAGS}" -ldflags "-X main.semver=${KP_SEMVER} -X main.commit=${KP_COMMIT}"
 echo "executable for linux is in this directory"
 ls -al $(basename $(pwd))